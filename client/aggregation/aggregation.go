@@ -0,0 +1,240 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package aggregation combines sibling models (models of the same rank, trained from the same
+// algo on different datasets) into a single model: the building block of federated learning
+// rounds. Sibling model blobs are fetched from storage, combined by a pluggable Strategy (weight
+// averaging, or an aggregation container) and the aggregated model is uploaded back to storage.
+//
+//	siblings, err := aggregation.Siblings(peer, problem, algo, rank)
+//	aggregator := &aggregation.Aggregator{Storage: storage, Strategy: &aggregation.WeightedAverage{...}}
+//	model, err := aggregator.Aggregate(siblings)
+package aggregation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/client"
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/bufpool"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+)
+
+// DefaultFetchParallelism is the number of model blobs fetched at the same time when no
+// parallelism is specified
+const DefaultFetchParallelism = 4
+
+// Sibling is a model taking part in an aggregation
+type Sibling struct {
+	Model      uuid.UUID
+	Algo       uuid.UUID
+	Learnuplet string
+	// Weight of the model in the aggregation (e.g. the number of samples it was trained on)
+	Weight float64
+}
+
+// Input is a sibling model whose blob (a .tar.gz of the model volume) has been fetched to Path
+type Input struct {
+	Sibling
+	Path string
+}
+
+// Strategy combines the blobs of sibling models into the blob of the aggregated model, written to
+// dest
+type Strategy interface {
+	Aggregate(inputs []Input, dest string) error
+}
+
+// Siblings returns the models produced by the done learnuplets of a problem and algo at a given
+// rank. Their weight is the number of train datasets they were trained on.
+func Siblings(peer client.Peer, problem, algo uuid.UUID, rank int) ([]Sibling, error) {
+	data, err := peer.QueryStatusLearnuplet(common.TaskStatusDone)
+	if err != nil {
+		return nil, fmt.Errorf("[aggregation] Error querying done learnuplets: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var uplets []common.LearnupletChaincode
+	if err := json.Unmarshal(data, &uplets); err != nil {
+		return nil, errors.Newf(errors.Permanent, "[aggregation] Error un-marshaling learnuplets: %s", err)
+	}
+
+	var siblings []Sibling
+	for _, uplet := range uplets {
+		learnuplet, err := uplet.LearnupletFormat()
+		if err != nil {
+			return nil, errors.Newf(errors.Permanent, "[aggregation] Invalid learnuplet %s: %s", uplet.Key, err)
+		}
+		if learnuplet.Rank != rank || learnuplet.EvaluationOnly || uuid.Equal(uuid.Nil, learnuplet.ModelEnd) ||
+			!uuid.Equal(problem, learnuplet.Problem) || !uuid.Equal(algo, learnuplet.Algo) {
+			continue
+		}
+		siblings = append(siblings, Sibling{
+			Model:      learnuplet.ModelEnd,
+			Algo:       learnuplet.Algo,
+			Learnuplet: learnuplet.Key,
+			Weight:     float64(len(learnuplet.TrainData)),
+		})
+	}
+	return siblings, nil
+}
+
+// Aggregator fetches sibling models from storage, combines them with its strategy and uploads the
+// aggregated model
+type Aggregator struct {
+	Storage  client.Storage
+	Strategy Strategy
+	// WorkDir is where model blobs are fetched to (the system's temporary directory if empty)
+	WorkDir string
+	// Parallelism is the number of model blobs fetched at the same time
+	Parallelism int
+
+	Logger logging.Logger
+}
+
+func (a *Aggregator) logger() logging.Logger {
+	return logging.OrDefault(a.Logger).With(logging.Fields{logging.FieldComponent: "aggregation"})
+}
+
+// Aggregate combines sibling models (all trained from the same algo) and uploads the result as a
+// new model, along its lineage (see client.AggregatedLineage), which is returned
+func (a *Aggregator) Aggregate(siblings []Sibling) (*common.Model, error) {
+	if len(siblings) == 0 {
+		return nil, errors.Newf(errors.Validation, "[aggregation] No model to aggregate")
+	}
+	for _, s := range siblings[1:] {
+		if !uuid.Equal(s.Algo, siblings[0].Algo) {
+			return nil, errors.Newf(errors.Validation, "[aggregation] Models %s and %s have different algos", siblings[0].Model, s.Model)
+		}
+	}
+
+	workDir, err := ioutil.TempDir(a.WorkDir, "aggregation-")
+	if err != nil {
+		return nil, fmt.Errorf("[aggregation] Error creating work directory: %s", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	inputs, err := a.fetch(siblings, workDir)
+	if err != nil {
+		return nil, err
+	}
+	dest := filepath.Join(workDir, "aggregated")
+	if err := a.Strategy.Aggregate(inputs, dest); err != nil {
+		return nil, fmt.Errorf("[aggregation] Error aggregating %d models: %w", len(inputs), err)
+	}
+
+	file, err := os.Open(dest)
+	if err != nil {
+		return nil, fmt.Errorf("[aggregation] Error opening aggregated model: %s", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("[aggregation] Error stating aggregated model: %s", err)
+	}
+	model := common.NewModel(uuid.Nil, &common.Algo{ID: siblings[0].Algo})
+	models := make([]uuid.UUID, len(siblings))
+	for i, s := range siblings {
+		models[i] = s.Model
+	}
+	if model.Lineage, err = client.AggregatedLineage(a.Storage, model.Algo, models); err != nil {
+		return nil, fmt.Errorf("[aggregation] Error building the lineage of the aggregated model: %w", err)
+	}
+	model.Lineage.Model = model.ID
+	if err := a.Storage.PostModel(model, file, info.Size()); err != nil {
+		return nil, fmt.Errorf("[aggregation] Error uploading aggregated model %s: %w", model.ID, err)
+	}
+	a.logger().Infof("Aggregated %d models into model %s", len(inputs), model.ID)
+	return model, nil
+}
+
+// fetch downloads the blobs of the sibling models to workDir, up to Parallelism at a time
+func (a *Aggregator) fetch(siblings []Sibling, workDir string) ([]Input, error) {
+	parallelism := a.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultFetchParallelism
+	}
+
+	var (
+		wg        sync.WaitGroup
+		inputs    = make([]Input, len(siblings))
+		errs      = make([]error, len(siblings))
+		semaphore = make(chan struct{}, parallelism)
+	)
+	for i, sibling := range siblings {
+		inputs[i] = Input{Sibling: sibling, Path: filepath.Join(workDir, fmt.Sprintf("%03d-%s", i, sibling.Model))}
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			errs[i] = a.fetchModel(inputs[i].Model, inputs[i].Path)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inputs, nil
+}
+
+func (a *Aggregator) fetchModel(id uuid.UUID, dest string) error {
+	blob, err := a.Storage.GetModelBlob(id)
+	if err != nil {
+		return fmt.Errorf("[aggregation] Error fetching model %s: %w", id, err)
+	}
+	defer blob.Close()
+	file, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("[aggregation] Error creating file %s: %s", dest, err)
+	}
+	defer file.Close()
+	if _, err := bufpool.Copy(file, blob); err != nil {
+		return fmt.Errorf("[aggregation] Error writing model %s to %s: %w", id, dest, err)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package aggregation
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+)
+
+// Weights are the tensors of a model (flattened), by name
+type Weights map[string][]float64
+
+// Format reads and writes the weights file of a model
+type Format interface {
+	Decode(r io.Reader) (Weights, error)
+	Encode(w io.Writer, weights Weights) error
+}
+
+// JSONFormat is a JSON object holding flattened tensors by name (e.g. {"dense/kernel": [0.1, ...]})
+type JSONFormat struct{}
+
+// Decode reads JSON weights
+func (JSONFormat) Decode(r io.Reader) (weights Weights, err error) {
+	err = json.NewDecoder(r).Decode(&weights)
+	return weights, err
+}
+
+// Encode writes JSON weights
+func (JSONFormat) Encode(w io.Writer, weights Weights) error {
+	return json.NewEncoder(w).Encode(weights)
+}
+
+// Float32Format is a raw array of little-endian float32s: a single tensor, named ""
+type Float32Format struct{}
+
+// Decode reads a float32 array
+func (Float32Format) Decode(r io.Reader) (Weights, error) {
+	var tensor []float64
+	buf := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, buf); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("truncated float32 array: %s", err)
+		}
+		tensor = append(tensor, float64(math.Float32frombits(binary.LittleEndian.Uint32(buf))))
+	}
+	return Weights{"": tensor}, nil
+}
+
+// Encode writes a float32 array
+func (Float32Format) Encode(w io.Writer, weights Weights) error {
+	tensor, ok := weights[""]
+	if !ok || len(weights) != 1 {
+		return fmt.Errorf("float32 arrays hold a single tensor")
+	}
+	buf := make([]byte, 4*len(tensor))
+	for i, v := range tensor {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(float32(v)))
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// WeightedAverage averages the weights of sibling models (federated averaging), each model
+// contributing in proportion to its weight (equally if no model has a weight). The aggregated
+// model archive is the archive of the first model, its weights file replaced by the averaged
+// weights.
+type WeightedAverage struct {
+	// File is the path of the weights file in the model archives (e.g. "model/weights.json")
+	File   string
+	Format Format
+}
+
+// Aggregate averages the weights of the inputs
+func (s *WeightedAverage) Aggregate(inputs []Input, dest string) error {
+	var total float64
+	for _, input := range inputs {
+		total += input.Weight
+	}
+
+	var average Weights
+	for _, input := range inputs {
+		factor := 1 / float64(len(inputs))
+		if total > 0 {
+			factor = input.Weight / total
+		}
+		var weights Weights
+		err := s.walk(input.Path, func(header *tar.Header, r io.Reader) (err error) {
+			if s.isWeights(header) {
+				weights, err = s.Format.Decode(r)
+			}
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error reading the weights of model %s: %s", input.Model, err)
+		}
+		if weights == nil {
+			return fmt.Errorf("model %s has no weights file %s", input.Model, s.File)
+		}
+
+		if average == nil {
+			average = Weights{}
+			for name, tensor := range weights {
+				average[name] = make([]float64, len(tensor))
+			}
+		}
+		if len(weights) != len(average) {
+			return fmt.Errorf("model %s has %d tensors, %d expected", input.Model, len(weights), len(average))
+		}
+		for name, tensor := range weights {
+			sum, ok := average[name]
+			if !ok || len(sum) != len(tensor) {
+				return fmt.Errorf("tensor %s of model %s doesn't match the first model's", name, input.Model)
+			}
+			for i, v := range tensor {
+				sum[i] += factor * v
+			}
+		}
+	}
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	err = s.walk(inputs[0].Path, func(header *tar.Header, r io.Reader) error {
+		if !s.isWeights(header) {
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			_, err := io.Copy(tw, r)
+			return err
+		}
+		encoded := &bytes.Buffer{}
+		if err := s.Format.Encode(encoded, average); err != nil {
+			return fmt.Errorf("error encoding averaged weights: %s", err)
+		}
+		header.Size = int64(encoded.Len())
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := encoded.WriteTo(tw)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error writing aggregated model: %s", err)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (s *WeightedAverage) isWeights(header *tar.Header) bool {
+	return header.Typeflag == tar.TypeReg && path.Clean(header.Name) == path.Clean(s.File)
+}
+
+// walk calls fn on every entry of a .tar.gz archive
+func (s *WeightedAverage) walk(archive string, fn func(header *tar.Header, r io.Reader) error) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// Mount points of the aggregation container
+const (
+	// ContainerModelsDir holds the sibling model blobs, and models.json describing them
+	ContainerModelsDir = "/data/models"
+	// ContainerOutputDir is where the container writes the aggregated model blob, named "model"
+	ContainerOutputDir = "/data/aggregated"
+)
+
+// ContainerStrategy aggregates models by running an aggregation container. Sibling model blobs
+// are mounted in ContainerModelsDir along with models.json, which lists their file name, model
+// UUID and weight. The container writes the aggregated model blob to ContainerOutputDir/model.
+type ContainerStrategy struct {
+	Runtime common.ContainerRuntime
+	// Image is the aggregation image (already loaded into the runtime)
+	Image string
+	Args  []string
+}
+
+type containerInput struct {
+	File   string  `json:"file"`
+	Model  string  `json:"model"`
+	Weight float64 `json:"weight"`
+}
+
+// Aggregate runs the aggregation container on the inputs
+func (s *ContainerStrategy) Aggregate(inputs []Input, dest string) error {
+	dir := dest + ".d"
+	if err := os.MkdirAll(filepath.Join(dir, "out"), 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	mounts := map[string]string{filepath.Join(dir, "out"): ContainerOutputDir}
+	described := make([]containerInput, len(inputs))
+	for i, input := range inputs {
+		name := filepath.Base(input.Path)
+		mounts[input.Path] = path.Join(ContainerModelsDir, name)
+		described[i] = containerInput{File: name, Model: input.Model.String(), Weight: input.Weight}
+	}
+	description, err := json.Marshal(described)
+	if err != nil {
+		return err
+	}
+	descriptionPath := filepath.Join(dir, "models.json")
+	if err := ioutil.WriteFile(descriptionPath, description, 0644); err != nil {
+		return err
+	}
+	mounts[descriptionPath] = path.Join(ContainerModelsDir, "models.json")
+
+	if _, err := s.Runtime.RunImageInUntrustedContainer(s.Image, s.Args, mounts, true); err != nil {
+		return fmt.Errorf("error running aggregation container %s: %s", s.Image, err)
+	}
+	if err := os.Rename(filepath.Join(dir, "out", "model"), dest); err != nil {
+		return fmt.Errorf("aggregation container %s produced no model: %s", s.Image, err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/audit"
+)
+
+// AuditedPeer is a Peer recording its transactions (registrations, worker assignments, patches,
+// result reports, heartbeats...) in an audit trail. Queries aren't recorded.
+type AuditedPeer struct {
+	Peer
+	Audit *audit.Recorder
+}
+
+// NewAuditedPeer wraps a peer so that its transactions are recorded by recorder
+func NewAuditedPeer(peer Peer, recorder *audit.Recorder) *AuditedPeer {
+	return &AuditedPeer{Peer: peer, Audit: recorder}
+}
+
+// record records a transaction, and its ID if it went through
+func (p *AuditedPeer) record(action, resource, id string, details map[string]string, txID string, err error) {
+	if txID != "" {
+		if details == nil {
+			details = map[string]string{}
+		}
+		details["tx_id"] = txID
+	}
+	p.Audit.Record(audit.TargetOrchestrator, action, resource, id, details, err)
+}
+
+// Invoke performs and records a transaction. Its arguments may hold anything (performances,
+// payloads...): only their count and digest are recorded.
+func (p *AuditedPeer) Invoke(txFcn string, txArgs []string) (string, []byte, error) {
+	txID, nonce, err := p.Peer.Invoke(txFcn, txArgs)
+	p.record(audit.ActionInvoke, txFcn, "", map[string]string{"arg_count": strconv.Itoa(len(txArgs)), "args_sha256": argsDigest(txArgs)}, txID, err)
+	return txID, nonce, err
+}
+
+// argsDigest returns the hex SHA-256 digest of transaction arguments (of their JSON array, so that
+// arguments containing separators can't collide)
+func argsDigest(args []string) string {
+	data, _ := json.Marshal(args)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// RegisterItem registers and records an item
+func (p *AuditedPeer) RegisterItem(itemType, storageAddress string, problemKeys []string, itemName string) (string, []byte, error) {
+	txID, nonce, err := p.Peer.RegisterItem(itemType, storageAddress, problemKeys, itemName)
+	p.record(audit.ActionRegister, itemType, storageAddress, map[string]string{"name": itemName, "problems": strings.Join(problemKeys, ",")}, txID, err)
+	return txID, nonce, err
+}
+
+// RegisterProblem registers and records a problem
+func (p *AuditedPeer) RegisterProblem(storageAddress string, sizeTrainDataset int, testData []string) (string, []byte, error) {
+	txID, nonce, err := p.Peer.RegisterProblem(storageAddress, sizeTrainDataset, testData)
+	p.record(audit.ActionRegister, "problem", storageAddress, map[string]string{"test_data": strings.Join(testData, ",")}, txID, err)
+	return txID, nonce, err
+}
+
+// SetUpletWorker sets the worker of an uplet, and records it
+func (p *AuditedPeer) SetUpletWorker(upletKey, worker string) (string, []byte, error) {
+	txID, nonce, err := p.Peer.SetUpletWorker(upletKey, worker)
+	p.record(audit.ActionSetWorker, "uplet", upletKey, map[string]string{"worker": worker}, txID, err)
+	return txID, nonce, err
+}
+
+// PatchUplet partially updates an uplet, and records it
+func (p *AuditedPeer) PatchUplet(upletType, upletKey string, patch UpletPatch) (string, []byte, error) {
+	txID, nonce, err := p.Peer.PatchUplet(upletType, upletKey, patch)
+	details := map[string]string{}
+	if patch.Worker != nil {
+		details["worker"] = *patch.Worker
+	}
+	if patch.Progress != nil {
+		details["progress"] = strconv.FormatFloat(*patch.Progress, 'g', -1, 64)
+	}
+	p.record(audit.ActionPatch, upletType, upletKey, details, txID, err)
+	return txID, nonce, err
+}
+
+// ReportLearn reports the result of a learnuplet, and records it
+func (p *AuditedPeer) ReportLearn(upletKey, status string, perf float64, trainPerf, testPerf map[string]float64) (string, []byte, error) {
+	txID, nonce, err := p.Peer.ReportLearn(upletKey, status, perf, trainPerf, testPerf)
+	p.record(audit.ActionReport, common.TypeLearnuplet, upletKey, map[string]string{"status": status, "perf": common.FormatPerf(perf, 0)}, txID, err)
+	return txID, nonce, err
+}
+
+// ReportEvaluation reports the result of an evaluation only learnuplet, and records it
+func (p *AuditedPeer) ReportEvaluation(upletKey, status string, perf float64, testPerf map[string]float64) (string, []byte, error) {
+	txID, nonce, err := p.Peer.ReportEvaluation(upletKey, status, perf, testPerf)
+	p.record(audit.ActionReport, common.TypeLearnuplet, upletKey, map[string]string{"status": status, "perf": common.FormatPerf(perf, 0), "evaluation_only": "true"}, txID, err)
+	return txID, nonce, err
+}
+
+// RegisterWorker registers a worker, and records it
+func (p *AuditedPeer) RegisterWorker(worker common.Worker) (string, []byte, error) {
+	txID, nonce, err := p.Peer.RegisterWorker(worker)
+	p.record(audit.ActionRegister, "worker", worker.ID.String(), nil, txID, err)
+	return txID, nonce, err
+}
+
+// WorkerHeartbeat sends a worker heartbeat, and records it
+func (p *AuditedPeer) WorkerHeartbeat(workerID string) (string, []byte, error) {
+	txID, nonce, err := p.Peer.WorkerHeartbeat(workerID)
+	p.record(audit.ActionHeartbeat, "worker", workerID, nil, txID, err)
+	return txID, nonce, err
+}
+
+// AuditedStorage is a Storage recording its uploads in an audit trail, for Storage implementations
+// that don't (StorageAPI does, see its Audit field)
+type AuditedStorage struct {
+	Storage
+	Audit *audit.Recorder
+}
+
+// WithContext returns a view of the storage whose requests are interrupted once ctx is done, still
+// recording its uploads
+func (s *AuditedStorage) WithContext(ctx context.Context) Storage {
+	return &AuditedStorage{Storage: StorageWithContext(ctx, s.Storage), Audit: s.Audit}
+}
+
+// PostModel uploads a model, and records it
+func (s *AuditedStorage) PostModel(model *common.Model, modelReader io.Reader, size int64) error {
+	err := s.Storage.PostModel(model, modelReader, size)
+	details := uploadDetails(size)
+	if model.Lineage != nil {
+		details["learnuplet"] = model.Lineage.Learnuplet
+	}
+	s.Audit.Record(audit.TargetStorage, audit.ActionUpload, StorageModelRoute, model.ID.String(), details, err)
+	return err
+}
+
+// PostPrediction uploads a prediction, and records it
+func (s *AuditedStorage) PostPrediction(prediction *common.Prediction, predReader io.Reader, size int64) error {
+	err := s.Storage.PostPrediction(prediction, predReader, size)
+	s.Audit.Record(audit.TargetStorage, audit.ActionUpload, "prediction", prediction.ID.String(), uploadDetails(size), err)
+	return err
+}
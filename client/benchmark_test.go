@@ -0,0 +1,155 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/client"
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/features"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpapi"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+)
+
+// The benchmarks below measure the hot request paths of a worker (status updates, result posting
+// and blob transfers) against a local server that discards what it receives. See BENCHMARKS.md
+// for their results.
+
+const (
+	benchmarkModelSize    = 4 << 20
+	benchmarkFragmentSize = 4 << 20
+	benchmarkFragments    = 4
+)
+
+// benchmarkServer answers like storage and compute would, without keeping what it receives
+func benchmarkServer(b *testing.B) *httptest.Server {
+	blob := bytes.Repeat([]byte("morpheo "), benchmarkFragmentSize/8)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		switch {
+		case r.Method == http.MethodPost:
+			httpapi.WriteJSON(w, http.StatusCreated, map[string]string{"status": "created"})
+		case len(parts) == 3 && parts[2] == client.BlobSuffix:
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Length", fmt.Sprint(len(blob)))
+			w.Write(blob)
+		case len(parts) == 2 && parts[0] == client.StorageAlgoRoute:
+			httpapi.WriteJSON(w, http.StatusOK, common.Algo{ID: uuid.FromStringOrNil(parts[1]), Name: "benchmark"})
+		default:
+			httpapi.WriteError(w, http.StatusNotFound, fmt.Errorf("No route %s %s", r.Method, r.URL.Path))
+		}
+	}))
+	b.Cleanup(server.Close)
+	return server
+}
+
+// BenchmarkStatusUpdate posts uplet status events, gzip-compressed as workers send them
+func BenchmarkStatusUpdate(b *testing.B) {
+	server := benchmarkServer(b)
+	c := httpclient.New("compute-api", server.URL)
+	c.Features = features.New(features.Compression)
+	event := common.StatusEvent{
+		UpletType: common.TypeLearnuplet,
+		UpletKey:  uuid.NewV4().String(),
+		Status:    common.TaskStatusPending,
+		Message:   "training",
+		Time:      time.Now(),
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.PostJSON("status", event, http.StatusCreated); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkPostModel posts trained models to storage, raw and gzip-compressed
+func BenchmarkPostModel(b *testing.B) {
+	model := bytes.Repeat([]byte("weights "), benchmarkModelSize/8)
+	for _, compressed := range []bool{false, true} {
+		b.Run(fmt.Sprintf("compressed=%t", compressed), func(b *testing.B) {
+			server := benchmarkServer(b)
+			storage := &client.StorageAPI{HTTPClient: httpclient.New("storage-api", server.URL)}
+			if compressed {
+				storage.HTTPClient.Features = features.New(features.Compression)
+			}
+			m := &common.Model{ID: uuid.NewV4(), Algo: uuid.NewV4()}
+
+			b.SetBytes(int64(len(model)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := storage.PostModel(m, bytes.NewReader(model), int64(len(model))); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDownloadDataset downloads the fragments of a dataset to disk
+func BenchmarkDownloadDataset(b *testing.B) {
+	server := benchmarkServer(b)
+	storage := &client.StorageAPI{HTTPClient: httpclient.New("storage-api", server.URL)}
+	manifest := &common.DatasetManifest{Dataset: uuid.NewV4()}
+	for i := 0; i < benchmarkFragments; i++ {
+		manifest.Fragments = append(manifest.Fragments, common.DatasetFragment{ID: uuid.NewV4(), Size: benchmarkFragmentSize})
+	}
+	dir := b.TempDir()
+
+	b.SetBytes(benchmarkFragments * benchmarkFragmentSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.DownloadDataset(storage, manifest, dir, benchmarkFragments, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/bufpool"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpapi"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/mtls"
+)
+
+// BlobPeersRoute is the route under which PeerBlobCache.Handler advertises and serves its blobs:
+//   - GET /blobs lists the cached data blobs ([]BlobAdvert)
+//   - GET /blobs/<id> returns a cached data blob
+const BlobPeersRoute = "blobs"
+
+// Default PeerBlobCache settings
+const (
+	DefaultBlobPeersRefresh  = 30 * time.Second
+	DefaultBlobCacheMaxBytes = 50 << 30
+)
+
+// BlobAdvert advertises a data blob cached by a worker
+type BlobAdvert struct {
+	ID     uuid.UUID `json:"uuid"`
+	Size   int64     `json:"size"`
+	SHA256 string    `json:"sha256"`
+	// CachedAt is when the blob was downloaded (its last use, actually)
+	CachedAt time.Time `json:"cached_at"`
+}
+
+// PeerBlobCache is a Storage caching data blobs on disk and sharing them with co-located workers:
+// each worker serves its cache (see Handler) and fetches the data blobs it lacks from the peers
+// advertising them before falling back to central storage, which cuts WAN traffic when many
+// workers train on the same dataset. Other calls go to central storage.
+//
+// Peers aren't trusted: a blob is only fetched from peers once the manifest of its dataset was read
+// (see GetDatasetManifest), and is checked against the digest central storage lists for it there.
+// Blobs whose digest isn't known are downloaded from central storage. Likewise, Handler only serves
+// authorized peers. The least recently used blobs are evicted beyond MaxBytes.
+type PeerBlobCache struct {
+	Storage
+
+	Dir string
+	// Peers are the base URLs of the Handlers of co-located workers (e.g. "http://10.0.0.12:8090")
+	Peers []string
+	// RefreshInterval is how often the adverts of peers are fetched again
+	RefreshInterval time.Duration
+	MaxBytes        int64
+	HTTPClient      *http.Client
+	Logger          logging.Logger
+	Clock           clock.Clock
+	// Authorize, if set, tells whether a peer may list and download the cached blobs. Otherwise,
+	// only the peers presenting a verified client certificate may (see mtls.ServerConfig).
+	Authorize func(r *http.Request) error
+
+	lock        sync.Mutex
+	digests     map[uuid.UUID]string
+	local       map[uuid.UUID]BlobAdvert
+	remote      map[uuid.UUID][]BlobPeer
+	refreshedAt time.Time
+}
+
+// BlobPeer is a peer advertising a blob
+type BlobPeer struct {
+	BaseURL string
+	Advert  BlobAdvert
+}
+
+// NewPeerBlobCache creates a blob cache in dir (indexing the blobs it already holds), sharing it
+// with peers
+func NewPeerBlobCache(storage Storage, dir string, peers ...string) (*PeerBlobCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("[blob-peers] Error creating cache directory %s: %s", dir, err)
+	}
+	c := &PeerBlobCache{Storage: storage, Dir: dir, Peers: peers, digests: map[uuid.UUID]string{}, local: map[uuid.UUID]BlobAdvert{}}
+
+	sidecars, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("[blob-peers] Error listing cache directory %s: %s", dir, err)
+	}
+	for _, path := range sidecars {
+		var advert BlobAdvert
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(data, &advert)
+		}
+		if _, statErr := os.Stat(c.blobPath(advert.ID)); err != nil || statErr != nil {
+			c.logger().Warnf("Dropping invalid cache entry %s", path)
+			os.Remove(path)
+			continue
+		}
+		c.local[advert.ID] = advert
+	}
+	return c, nil
+}
+
+func (c *PeerBlobCache) logger() logging.Logger {
+	return logging.OrDefault(c.Logger).With(logging.Fields{logging.FieldComponent: "blob-peers"})
+}
+
+func (c *PeerBlobCache) blobPath(id uuid.UUID) string {
+	return filepath.Join(c.Dir, id.String())
+}
+
+// GetDatasetManifest returns the manifest of a dataset from central storage, and records the
+// digests of its fragments: the fragments whose digest is listed may then be fetched from peers
+func (c *PeerBlobCache) GetDatasetManifest(id uuid.UUID) (*common.DatasetManifest, error) {
+	manifest, err := c.Storage.GetDatasetManifest(id)
+	if err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, fragment := range manifest.Fragments {
+		if fragment.SHA256 != "" {
+			c.digests[fragment.ID] = fragment.SHA256
+		}
+	}
+	return manifest, nil
+}
+
+// digest returns the digest central storage lists for a blob, if known
+func (c *PeerBlobCache) digest(id uuid.UUID) string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.digests[id]
+}
+
+// GetDataBlob returns a data blob from the cache, from a peer or from central storage, in this
+// order of preference. Peers are only asked for the blobs whose digest is known.
+func (c *PeerBlobCache) GetDataBlob(id uuid.UUID) (io.ReadCloser, error) {
+	if blob, ok := c.open(id); ok {
+		return blob, nil
+	}
+
+	expected := c.digest(id)
+	if expected != "" {
+		for _, peer := range c.peersWith(id) {
+			err := c.fetch(id, peer, expected)
+			if err == nil {
+				if blob, ok := c.open(id); ok {
+					return blob, nil
+				}
+			}
+			c.logger().Warnf("Error fetching data blob %s from peer %s: %s", id, peer.BaseURL, err)
+		}
+	}
+
+	blob, err := c.Storage.GetDataBlob(id)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	if err := c.store(id, blob, expected); err != nil {
+		return nil, err
+	}
+	if blob, ok := c.open(id); ok {
+		return blob, nil
+	}
+	return nil, fmt.Errorf("[blob-peers] Data blob %s was evicted as soon as it was cached", id)
+}
+
+// open opens a cached blob, marking it as recently used
+func (c *PeerBlobCache) open(id uuid.UUID) (io.ReadCloser, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	advert, ok := c.local[id]
+	if !ok {
+		return nil, false
+	}
+	file, err := os.Open(c.blobPath(id))
+	if err != nil {
+		delete(c.local, id)
+		return nil, false
+	}
+	advert.CachedAt = clock.OrReal(c.Clock).Now()
+	c.local[id] = advert
+	return file, true
+}
+
+// fetch copies a blob from a peer into the cache, checking it against the expected digest
+func (c *PeerBlobCache) fetch(id uuid.UUID, peer BlobPeer, expected string) error {
+	if peer.Advert.SHA256 != expected {
+		return fmt.Errorf("[blob-peers] Data blob %s is advertised with digest %s, %s expected", id, peer.Advert.SHA256, expected)
+	}
+	resp, err := c.peerClient(peer.BaseURL).Do(&httpclient.Request{
+		Method: http.MethodGet,
+		Route:  fmt.Sprintf("%s/%s", BlobPeersRoute, id),
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return c.store(id, resp.Body, expected)
+}
+
+// store writes a blob to the cache, checking its digest if expected isn't empty
+func (c *PeerBlobCache) store(id uuid.UUID, blob io.Reader, expected string) error {
+	file, err := ioutil.TempFile(c.Dir, id.String()+".part")
+	if err != nil {
+		return fmt.Errorf("[blob-peers] Error creating temporary file: %s", err)
+	}
+	defer os.Remove(file.Name())
+
+	digest := sha256.New()
+	size, err := bufpool.Copy(io.MultiWriter(file, digest), blob)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("[blob-peers] Error caching data blob %s: %s", id, err)
+	}
+	advert := BlobAdvert{ID: id, Size: size, SHA256: hex.EncodeToString(digest.Sum(nil)), CachedAt: clock.OrReal(c.Clock).Now()}
+	if expected != "" && advert.SHA256 != expected {
+		return errors.Newf(errors.Transient, "[blob-peers] Data blob %s has digest %s, %s expected", id, advert.SHA256, expected)
+	}
+
+	sidecar, err := json.Marshal(advert)
+	if err != nil {
+		return fmt.Errorf("[blob-peers] Error marshaling advert of %s: %s", id, err)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := os.Rename(file.Name(), c.blobPath(id)); err != nil {
+		return fmt.Errorf("[blob-peers] Error caching data blob %s: %s", id, err)
+	}
+	if err := ioutil.WriteFile(c.blobPath(id)+".json", sidecar, 0644); err != nil {
+		os.Remove(c.blobPath(id))
+		return fmt.Errorf("[blob-peers] Error caching data blob %s: %s", id, err)
+	}
+	c.local[id] = advert
+	c.evict()
+	return nil
+}
+
+// evict removes the least recently used blobs beyond MaxBytes (the lock has to be held)
+func (c *PeerBlobCache) evict() {
+	maxBytes := c.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultBlobCacheMaxBytes
+	}
+	adverts := make([]BlobAdvert, 0, len(c.local))
+	var total int64
+	for _, advert := range c.local {
+		adverts = append(adverts, advert)
+		total += advert.Size
+	}
+	sort.Slice(adverts, func(i, j int) bool { return adverts[i].CachedAt.Before(adverts[j].CachedAt) })
+	for _, advert := range adverts {
+		if total <= maxBytes {
+			return
+		}
+		os.Remove(c.blobPath(advert.ID) + ".json")
+		os.Remove(c.blobPath(advert.ID))
+		delete(c.local, advert.ID)
+		total -= advert.Size
+	}
+}
+
+// peersWith returns the peers advertising a blob, fetching the adverts of peers again if they are
+// stale
+func (c *PeerBlobCache) peersWith(id uuid.UUID) []BlobPeer {
+	interval := c.RefreshInterval
+	if interval <= 0 {
+		interval = DefaultBlobPeersRefresh
+	}
+	c.lock.Lock()
+	stale := clock.OrReal(c.Clock).Now().Sub(c.refreshedAt) >= interval
+	c.lock.Unlock()
+	if stale {
+		c.Refresh()
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.remote[id]
+}
+
+// Refresh fetches the adverts of every peer. Unreachable peers are skipped.
+func (c *PeerBlobCache) Refresh() {
+	remote := map[uuid.UUID][]BlobPeer{}
+	for _, baseURL := range c.Peers {
+		var adverts []BlobAdvert
+		err := c.peerClient(baseURL).DoJSON(&httpclient.Request{Method: http.MethodGet, Route: BlobPeersRoute}, &adverts)
+		if err != nil {
+			c.logger().Debugf("Error fetching the adverts of peer %s: %s", baseURL, err)
+			continue
+		}
+		for _, advert := range adverts {
+			remote[advert.ID] = append(remote[advert.ID], BlobPeer{BaseURL: baseURL, Advert: advert})
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.remote, c.refreshedAt = remote, clock.OrReal(c.Clock).Now()
+}
+
+func (c *PeerBlobCache) peerClient(baseURL string) *httpclient.Client {
+	client := httpclient.New("blob-peer", baseURL)
+	client.HTTPClient = c.HTTPClient
+	client.Logger = c.Logger
+	client.Clock = c.Clock
+	// Peers are an optimization: central storage is the fallback, not retries
+	client.Retry = nil
+	return client
+}
+
+// authorize tells whether a peer may list and download the cached blobs
+func (c *PeerBlobCache) authorize(r *http.Request) error {
+	if c.Authorize != nil {
+		return c.Authorize(r)
+	}
+	if mtls.PeerIdentity(r) == "" {
+		return errors.Newf(errors.Unauthorized, "A verified client certificate is required")
+	}
+	return nil
+}
+
+// Handler serves the adverts and the blobs of the cache to authorized peers (see Authorize)
+func (c *PeerBlobCache) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := c.authorize(r); err != nil {
+			httpapi.WriteError(w, http.StatusUnauthorized, err)
+			return
+		}
+		route := strings.Trim(r.URL.Path, "/")
+		if r.Method != http.MethodGet || !strings.HasPrefix(route, BlobPeersRoute) {
+			httpapi.WriteError(w, http.StatusNotFound, fmt.Errorf("No route %s %s", r.Method, r.URL.Path))
+			return
+		}
+		if route == BlobPeersRoute {
+			c.lock.Lock()
+			adverts := make([]BlobAdvert, 0, len(c.local))
+			for _, advert := range c.local {
+				adverts = append(adverts, advert)
+			}
+			c.lock.Unlock()
+			httpapi.WriteJSON(w, http.StatusOK, adverts)
+			return
+		}
+
+		id, err := uuid.FromString(strings.TrimPrefix(route, BlobPeersRoute+"/"))
+		if err != nil {
+			httpapi.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid blob ID: %s", err))
+			return
+		}
+		blob, ok := c.open(id)
+		if !ok {
+			httpapi.WriteError(w, http.StatusNotFound, fmt.Errorf("Data blob %s isn't cached", id))
+			return
+		}
+		defer blob.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		io.Copy(w, blob)
+	})
+}
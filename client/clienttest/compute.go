@@ -0,0 +1,146 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package clienttest
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/client"
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpapi"
+)
+
+// ComputeServer fakes the compute HTTP API: POST /learn and POST /pred accept valid uplets (202)
+// and reject invalid ones (400, listing their invalid fields). Uplets are decoded as the compute API
+// does (see httpapi.DecodeSubmission): JSON, MessagePack or CBOR bodies, or multipart submissions
+// carrying an inline payload. Responses to a given uplet can be canned with Respond(uplet key).
+type ComputeServer struct {
+	*Server
+
+	lock        sync.Mutex
+	learnuplets []common.Learnuplet
+	preduplets  []common.Preduplet
+	payloads    map[string]*httpapi.InlinePayload
+}
+
+// NewComputeServer starts a fake compute API. It has to be closed by the caller.
+func NewComputeServer() *ComputeServer {
+	s := &ComputeServer{payloads: map[string]*httpapi.InlinePayload{}}
+	s.Server = newServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Client returns a compute client talking to the fake server
+func (s *ComputeServer) Client() *client.ComputeAPI {
+	return &client.ComputeAPI{HTTPClient: s.HTTPClient("compute-api")}
+}
+
+// Learnuplets returns the learnuplets accepted so far
+func (s *ComputeServer) Learnuplets() []common.Learnuplet {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]common.Learnuplet(nil), s.learnuplets...)
+}
+
+// Preduplets returns the preduplets accepted so far
+func (s *ComputeServer) Preduplets() []common.Preduplet {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]common.Preduplet(nil), s.preduplets...)
+}
+
+// InlinePayload returns the payload submitted along an uplet (given its key, or the UUID of a
+// preduplet), nil if it had none
+func (s *ComputeServer) InlinePayload(key string) *httpapi.InlinePayload {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.payloads[key]
+}
+
+func (s *ComputeServer) serve(w http.ResponseWriter, r *http.Request) {
+	route := strings.Trim(r.URL.Path, "/")
+	if r.Method != http.MethodPost || (route != client.ComputeLearnupletRoute && route != client.ComputePredupletRoute) {
+		writeError(w, http.StatusNotFound, "No route %s %s", r.Method, r.URL.Path)
+		return
+	}
+
+	switch encoding := r.Header.Get("Content-Encoding"); encoding {
+	case "":
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Error decompressing body: %s", err)
+			return
+		}
+		defer gz.Close()
+		r.Body = gz
+	default:
+		// Like older compute APIs, only gzip is supported (clients fall back to it)
+		w.Header().Set("Accept-Encoding", "gzip")
+		writeError(w, http.StatusUnsupportedMediaType, "Unsupported content encoding %s", encoding)
+		return
+	}
+
+	if route == client.ComputeLearnupletRoute {
+		var learnuplet common.Learnuplet
+		payload, err := httpapi.DecodeSubmission(r, &learnuplet, httpapi.SubmissionLimits{})
+		if err != nil {
+			httpapi.WriteError(w, httpapi.SubmissionErrorStatus(err), err)
+			return
+		}
+		s.lock.Lock()
+		s.learnuplets = append(s.learnuplets, learnuplet)
+		s.payloads[learnuplet.Key] = payload
+		s.lock.Unlock()
+		writeJSON(w, http.StatusAccepted, learnuplet)
+		return
+	}
+
+	var preduplet common.Preduplet
+	payload, err := httpapi.DecodeSubmission(r, &preduplet, httpapi.SubmissionLimits{})
+	if err != nil {
+		httpapi.WriteError(w, httpapi.SubmissionErrorStatus(err), err)
+		return
+	}
+	s.lock.Lock()
+	s.preduplets = append(s.preduplets, preduplet)
+	s.payloads[preduplet.ID.String()] = payload
+	s.lock.Unlock()
+	writeJSON(w, http.StatusAccepted, preduplet)
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package clienttest
+
+import (
+	"bytes"
+	stderrors "errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/client"
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/satori/go.uuid"
+)
+
+// PutAll stores an object of every kind (problem, algo, model and data) and its blob (a .tar.gz
+// archive) under id, as well as a manifest of the data, so that id can be used as the known ID of
+// RunStorageConformance
+func (s *StorageServer) PutAll(id uuid.UUID) {
+	blob, err := client.TargzedMock()
+	if err != nil {
+		panic(err)
+	}
+	data, _ := ioutil.ReadAll(blob)
+
+	problem := common.NewProblem()
+	problem.ID, problem.Name, problem.Description = id, "problem", "conformance problem"
+	algo := common.NewAlgo()
+	algo.ID, algo.Name = id, "algo"
+	dataset := common.NewData()
+	dataset.ID = id
+
+	s.Put(client.StorageProblemWorkflowRoute, id, problem, data)
+	s.Put(client.StorageAlgoRoute, id, algo, data)
+	s.Put(client.StorageModelRoute, id, common.NewModel(id, algo), data)
+	s.Put(client.StorageDataRoute, id, dataset, data)
+	s.PutManifest(common.DatasetManifest{Dataset: id, Fragments: []common.DatasetFragment{{ID: id, Size: int64(len(data))}}})
+}
+
+// RunStorageConformance checks that a client.Storage implementation behaves as the storage API
+// does, given the ID of objects it knows of (of every kind) and the ID of objects it doesn't know
+// of. Run it against both the real client (backed by a fake server) and the mock, so that the mock
+// can't drift from the real semantics:
+//
+//	func TestStorageConformance(t *testing.T) {
+//		srv := clienttest.NewStorageServer()
+//		defer srv.Close()
+//		known := uuid.NewV4()
+//		srv.PutAll(known)
+//		t.Run("api", func(t *testing.T) {
+//			clienttest.RunStorageConformance(t, srv.Client(), known, uuid.NewV4())
+//		})
+//
+//		mock, _ := client.NewStorageAPIMock()
+//		t.Run("mock", func(t *testing.T) {
+//			clienttest.RunStorageConformance(t, mock, uuid.NewV4(), uuid.FromStringOrNil(mock.EvilUUID))
+//		})
+//	}
+func RunStorageConformance(t *testing.T, impl client.Storage, known, missing uuid.UUID) {
+	t.Run("GetResources", func(t *testing.T) {
+		if problem, err := impl.GetProblemWorkflow(known); err != nil || problem == nil {
+			t.Errorf("GetProblemWorkflow(%s) = %v, %v; want a problem", known, problem, err)
+		}
+		if algo, err := impl.GetAlgo(known); err != nil || algo == nil {
+			t.Errorf("GetAlgo(%s) = %v, %v; want an algo", known, algo, err)
+		}
+		if model, err := impl.GetModel(known); err != nil || model == nil {
+			t.Errorf("GetModel(%s) = %v, %v; want a model", known, model, err)
+		}
+		if data, err := impl.GetData(known); err != nil || data == nil {
+			t.Errorf("GetData(%s) = %v, %v; want data", known, data, err)
+		}
+		if manifest, err := impl.GetDatasetManifest(known); err != nil || manifest == nil || manifest.Check() != nil {
+			t.Errorf("GetDatasetManifest(%s) = %v, %v; want a valid manifest", known, manifest, err)
+		}
+	})
+
+	t.Run("GetMissingResources", func(t *testing.T) {
+		gets := map[string]func(uuid.UUID) error{
+			"GetProblemWorkflow": func(id uuid.UUID) error { _, err := impl.GetProblemWorkflow(id); return err },
+			"GetAlgo":            func(id uuid.UUID) error { _, err := impl.GetAlgo(id); return err },
+			"GetModel":           func(id uuid.UUID) error { _, err := impl.GetModel(id); return err },
+			"GetData":            func(id uuid.UUID) error { _, err := impl.GetData(id); return err },
+			"GetDataBlob":        func(id uuid.UUID) error { _, err := impl.GetDataBlob(id); return err },
+			"GetDataBlobSize":    func(id uuid.UUID) error { _, err := impl.GetDataBlobSize(id); return err },
+			"GetDatasetManifest": func(id uuid.UUID) error { _, err := impl.GetDatasetManifest(id); return err },
+			"GetAlgoBlob":        func(id uuid.UUID) error { _, err := impl.GetAlgoBlob(id); return err },
+			"GetModelBlob":       func(id uuid.UUID) error { _, err := impl.GetModelBlob(id); return err },
+			"GetProblemWorkflowBlob": func(id uuid.UUID) error {
+				_, err := impl.GetProblemWorkflowBlob(id)
+				return err
+			},
+		}
+		for name, get := range gets {
+			if err := get(missing); !stderrors.Is(err, errors.ErrNotFound) {
+				t.Errorf("%s(%s) = %v; want a not found error", name, missing, err)
+			}
+		}
+	})
+
+	t.Run("GetBlobs", func(t *testing.T) {
+		blobs := map[string]func(uuid.UUID) ([]byte, error){
+			"GetProblemWorkflowBlob": readBlob(impl.GetProblemWorkflowBlob),
+			"GetAlgoBlob":            readBlob(impl.GetAlgoBlob),
+			"GetModelBlob":           readBlob(impl.GetModelBlob),
+			"GetDataBlob":            readBlob(impl.GetDataBlob),
+		}
+		for name, get := range blobs {
+			blob, err := get(known)
+			if err != nil || len(blob) == 0 {
+				t.Errorf("%s(%s) = %d bytes, %v; want a blob", name, known, len(blob), err)
+			}
+		}
+		if size, err := impl.GetDataBlobSize(known); err != nil || size <= 0 {
+			t.Errorf("GetDataBlobSize(%s) = %d, %v; want a positive size", known, size, err)
+		}
+	})
+
+	t.Run("PostModel", func(t *testing.T) {
+		model := common.NewModel(uuid.Nil, &common.Algo{ID: known})
+		if err := impl.PostModel(model, bytes.NewReader([]byte("model")), 5); err != nil {
+			t.Errorf("PostModel(model of algo %s) = %v; want nil", known, err)
+		}
+		model = common.NewModel(uuid.Nil, &common.Algo{ID: missing})
+		if err := impl.PostModel(model, bytes.NewReader([]byte("model")), 5); !stderrors.Is(err, errors.ErrNotFound) {
+			t.Errorf("PostModel(model of algo %s) = %v; want a not found error", missing, err)
+		}
+	})
+
+	t.Run("PostPrediction", func(t *testing.T) {
+		if err := impl.PostPrediction(common.NewPrediction(), bytes.NewReader([]byte("pred")), 4); err != nil {
+			t.Errorf("PostPrediction(valid prediction) = %v; want nil", err)
+		}
+		if err := impl.PostPrediction(&common.Prediction{}, bytes.NewReader([]byte("pred")), 4); !stderrors.Is(err, errors.ErrValidation) {
+			t.Errorf("PostPrediction(prediction without ID) = %v; want a validation error", err)
+		}
+	})
+}
+
+func readBlob(get func(uuid.UUID) (io.ReadCloser, error)) func(uuid.UUID) ([]byte, error) {
+	return func(id uuid.UUID) ([]byte, error) {
+		blob, err := get(id)
+		if err != nil {
+			return nil, err
+		}
+		defer blob.Close()
+		return ioutil.ReadAll(blob)
+	}
+}
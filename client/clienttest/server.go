@@ -0,0 +1,232 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package clienttest provides httptest-backed fakes of the Morpheo HTTP APIs, so that tests exercise
+// the real HTTP clients of the client package (request building, retries, error decoding...)
+// rather than their in-memory mocks.
+//
+// Note that the orchestrator is reached through the Fabric peer (see client.Peer) and has no HTTP
+// API to fake: the fakes cover the storage and compute APIs.
+package clienttest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/chaos"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+)
+
+// Request is a request received by a fake server
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+	Time   time.Time
+}
+
+// Response is a canned response of a fake server
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// JSONResponse builds a canned response with a JSON body
+func JSONResponse(status int, body interface{}) Response {
+	data, err := json.Marshal(body)
+	if err != nil {
+		panic(fmt.Sprintf("[clienttest] Error marshaling canned response: %s", err))
+	}
+	return Response{Status: status, Header: http.Header{"Content-Type": []string{"application/json"}}, Body: data}
+}
+
+// ErrorResponse builds a canned error response, formatted as the Morpheo APIs format their errors
+func ErrorResponse(status int, message string) Response {
+	return JSONResponse(status, common.APIError{Message: message, Status: status})
+}
+
+// Server is an httptest server recording the requests it receives. Requests whose path contains an
+// ID with a canned response (see Respond) get this response; the others are handled by the fake
+// API routes.
+//
+// Chaos, if set, delays requests, fails some of them with a 503 and cuts some responses short (the
+// connection is closed before the end of the body).
+type Server struct {
+	*httptest.Server
+	Chaos *chaos.Chaos
+
+	lock     sync.Mutex
+	requests []Request
+	canned   map[string][]Response
+	routes   http.Handler
+}
+
+func newServer(routes http.Handler) *Server {
+	s := &Server{canned: map[string][]Response{}, routes: routes}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Respond cans the responses to the requests whose path contains id (an uplet or resource ID).
+// Responses are served in order; the last one is then repeated.
+func (s *Server) Respond(id string, responses ...Response) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.canned[id] = responses
+}
+
+// Reset forgets the recorded requests and canned responses
+func (s *Server) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests = nil
+	s.canned = map[string][]Response{}
+}
+
+// Requests returns the requests received so far
+func (s *Server) Requests() []Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// HTTPClient returns an httpclient.Client pointing at the server, to be set as the HTTPClient of
+// a client.StorageAPI or client.ComputeAPI. Retries wait at most a millisecond (Retry-After headers
+// included).
+func (s *Server) HTTPClient(name string) *httpclient.Client {
+	c := httpclient.New(name, s.URL)
+	c.Retry.BaseDelay, c.Retry.MaxDelay = 0, time.Millisecond
+	return c
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	s.lock.Lock()
+	s.requests = append(s.requests, Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Body:   body,
+		Time:   time.Now(),
+	})
+	resp, ok := s.cannedResponse(r)
+	chaotic := s.Chaos
+	s.lock.Unlock()
+
+	if chaotic == nil {
+		s.respond(w, r, resp, ok)
+		return
+	}
+	op := r.Method + " " + r.URL.Path
+	if err := chaotic.Call(op); err != nil {
+		writeError(w, http.StatusServiceUnavailable, "%s", err)
+		return
+	}
+	recorder := httptest.NewRecorder()
+	s.respond(recorder, r, resp, ok)
+	full := recorder.Body.Bytes()
+	partial, _ := ioutil.ReadAll(chaotic.Reader(op, bytes.NewReader(full), int64(len(full))))
+	for k, v := range recorder.Header() {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Length", fmt.Sprint(len(full)))
+	w.WriteHeader(recorder.Code)
+	w.Write(partial)
+	if len(partial) < len(full) {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// respond writes a canned response if there is one (ok), the response of the fake API routes
+// otherwise
+func (s *Server) respond(w http.ResponseWriter, r *http.Request, resp Response, ok bool) {
+	if ok {
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		status := resp.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+		w.Write(resp.Body)
+		return
+	}
+	s.routes.ServeHTTP(w, r)
+}
+
+// cannedResponse returns the next canned response matching a request (with the lock held)
+func (s *Server) cannedResponse(r *http.Request) (Response, bool) {
+	for id, responses := range s.canned {
+		if len(responses) == 0 || !(strings.Contains(r.URL.Path, id) || strings.Contains(r.URL.RawQuery, id)) {
+			continue
+		}
+		resp := responses[0]
+		if len(responses) > 1 {
+			s.canned[id] = responses[1:]
+		}
+		return resp, true
+	}
+	return Response{}, false
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, common.APIError{Message: fmt.Sprintf(format, args...), Status: status})
+}
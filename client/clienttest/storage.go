@@ -0,0 +1,198 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package clienttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/client"
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/satori/go.uuid"
+)
+
+// StorageServer fakes the storage HTTP API:
+//   - GET /<problem|algo|model|data>/<id> returns the metadata of an object
+//   - GET|HEAD /<problem|algo|model|data>/<id>/blob returns its blob
+//   - GET /data/<id>/manifest returns the manifest of a dataset
+//   - POST /<problem|algo|model|data|prediction> stores an object from a multipart form (and the
+//     lineage of models, the metrics and test datasets of problems, JSON fields)
+//
+// Objects are added with Put; unknown objects are 404s.
+type StorageServer struct {
+	*Server
+
+	lock    sync.Mutex
+	objects map[string]interface{} // by "<route>/<id>"
+	blobs   map[string][]byte      // by "<route>/<id>"
+}
+
+// NewStorageServer starts a fake storage API without objects. It has to be closed by the caller.
+func NewStorageServer() *StorageServer {
+	s := &StorageServer{objects: map[string]interface{}{}, blobs: map[string][]byte{}}
+	s.Server = newServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Client returns a storage client talking to the fake server
+func (s *StorageServer) Client() *client.StorageAPI {
+	return &client.StorageAPI{HTTPClient: s.HTTPClient("storage-api")}
+}
+
+// Put stores an object and its blob under a route (client.StorageAlgoRoute...)
+func (s *StorageServer) Put(route string, id uuid.UUID, object interface{}, blob []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := route + "/" + id.String()
+	s.objects[key] = object
+	s.blobs[key] = blob
+}
+
+// PutManifest stores the manifest of a dataset (its fragments have to be Put as data)
+func (s *StorageServer) PutManifest(manifest common.DatasetManifest) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.objects[client.StorageDataRoute+"/"+manifest.Dataset.String()+"/"+client.ManifestSuffix] = manifest
+}
+
+// Blob returns the blob stored under a route, if any (posted models, predictions...)
+func (s *StorageServer) Blob(route string, id uuid.UUID) ([]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	blob, ok := s.blobs[route+"/"+id.String()]
+	return blob, ok
+}
+
+func (s *StorageServer) serve(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 1:
+		s.post(w, r, parts[0])
+	case r.Method == http.MethodGet && (len(parts) == 2 || len(parts) == 3 && parts[2] == client.ManifestSuffix):
+		s.lock.Lock()
+		object, ok := s.objects[strings.Join(parts, "/")]
+		s.lock.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "%s not found", strings.Join(parts, " "))
+			return
+		}
+		writeJSON(w, http.StatusOK, object)
+	case (r.Method == http.MethodGet || r.Method == http.MethodHead) && len(parts) == 3 && parts[2] == client.BlobSuffix:
+		s.lock.Lock()
+		blob, ok := s.blobs[parts[0]+"/"+parts[1]]
+		s.lock.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "%s blob %s not found", parts[0], parts[1])
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(blob)
+		}
+	default:
+		writeError(w, http.StatusNotFound, "No route %s %s", r.Method, r.URL.Path)
+	}
+}
+
+// jsonFields lists the form fields holding JSON values, by route
+var jsonFields = map[string][]string{
+	client.StorageModelRoute:           {"lineage"},
+	client.StorageProblemWorkflowRoute: {"metrics", "test_data"},
+}
+
+func (s *StorageServer) post(w http.ResponseWriter, r *http.Request, route string) {
+	var id uuid.UUID
+	var blob []byte
+	var err error
+
+	if err = r.ParseMultipartForm(32 << 20); err != nil {
+		writeError(w, http.StatusBadRequest, "Error parsing multipart form: %s", err)
+		return
+	}
+	if id, err = uuid.FromString(r.FormValue("uuid")); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid %s uuid: %s", route, err)
+		return
+	}
+	file, _, err := r.FormFile("blob")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "No blob in %s form: %s", route, err)
+		return
+	}
+	defer file.Close()
+	if blob, err = ioutil.ReadAll(file); err != nil {
+		writeError(w, http.StatusBadRequest, "Error reading %s blob: %s", route, err)
+		return
+	}
+	fields := map[string]interface{}{}
+	for k, v := range r.MultipartForm.Value {
+		fields[k] = v[0]
+	}
+	// The lineage of a model, and the metrics and test datasets of a problem, are JSON values, kept
+	// as such for GetModel and GetProblemWorkflow
+	for _, name := range jsonFields[route] {
+		value, ok := r.MultipartForm.Value[name]
+		if !ok {
+			continue
+		}
+		if !json.Valid([]byte(value[0])) {
+			writeError(w, http.StatusBadRequest, "Invalid %s %s: %s", route, name, value[0])
+			return
+		}
+		fields[name] = json.RawMessage(value[0])
+	}
+
+	s.lock.Lock()
+	s.objects[route+"/"+id.String()] = json.RawMessage(mustMarshal(fields))
+	s.blobs[route+"/"+id.String()] = blob
+	s.lock.Unlock()
+	writeJSON(w, http.StatusCreated, fields)
+}
+
+func mustMarshal(v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("[clienttest] Error marshaling %v: %s", v, err))
+	}
+	return data
+}
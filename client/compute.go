@@ -37,12 +37,27 @@ package client
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"sync"
+	"time"
 
 	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/audit"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/auth"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/codec"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/features"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpapi"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/signing"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/tracing"
 )
 
 // Compute HTTP API routes
@@ -65,43 +80,190 @@ type ComputeAPI struct {
 	Port     int
 	// User     string
 	// Password string
+	Logger   logging.Logger
+	Features *features.Set
+	// Compression selects the encoding (gzip or zstd) and level of compressed request bodies, when
+	// the features.Compression flag is enabled
+	Compression *httpclient.Compression
+	// TLS, if set, switches to HTTPS with this configuration (see the mtls package)
+	TLS *tls.Config
+	// Transport, if set, tunes the connections to compute (the shared transport is used otherwise, see
+	// httpclient.SharedTransport)
+	Transport *httpclient.TransportConfig
+	// Signer, if set, signs the body of every request with HMAC-SHA256
+	Signer *signing.Signer
+	// DedupWindow, if positive, suppresses the requests identical to a request that succeeded less
+	// than DedupWindow ago (see httpclient.DedupWindow)
+	DedupWindow time.Duration
+	// Balancer, if set, spreads requests across several backends (e.g. the addresses Hostname
+	// resolves to, see httpclient.DNSBalancer) instead of the first one Hostname resolves to
+	Balancer httpclient.Balancer
+	// Scope attributes every request to a tenant (X-Morpheo-Org and X-Morpheo-Project headers)
+	Scope httpclient.Scope
+	// APIKey, if set, authenticates requests against the compute API (see the auth package)
+	APIKey string
+	// Codec, if set, encodes uplets with MessagePack or CBOR instead of JSON (see the codec
+	// package). Servers not supporting it are sent JSON.
+	Codec codec.Codec
+	// MaxInlinePayloadSize bounds the payloads sent along uplets (httpapi.DefaultMaxInlinePayloadSize
+	// if zero): larger ones are rejected with a *common.PayloadTooLargeError
+	MaxInlinePayloadSize int64
+	// Audit, if set, records the uplet submissions in an audit trail
+	Audit *audit.Recorder
+	// Tokens, if set, authenticates every request with a bearer token granting the scope it
+	// requires (uplet:create for submissions, see auth.StaticTokens)
+	Tokens httpclient.TokenSource
+
+	// HTTPClient performs the requests against compute. It is built from the fields above on first
+	// use (with every request traced, see tracing.Transport), unless set beforehand.
+	HTTPClient *httpclient.Client
+	lock       sync.Mutex
 }
 
 // PostLearnuplet forwards a JSON-formatted learn result to the compute HTTP API
 func (s *ComputeAPI) PostLearnuplet(learnuplet common.Learnuplet) error {
-	return s.postJSONData(ComputeLearnupletRoute, learnuplet)
+	err := s.postJSONData(ComputeLearnupletRoute, learnuplet)
+	s.Audit.Record(audit.TargetCompute, audit.ActionSubmit, common.TypeLearnuplet, learnuplet.Key, nil, err)
+	return err
 }
 
 // PostPreduplet forwards a JSON-formatted pred result to the compute HTTP API
 func (s *ComputeAPI) PostPreduplet(preduplet common.Preduplet) error {
-	return s.postJSONData(ComputePredupletRoute, preduplet)
+	err := s.postJSONData(ComputePredupletRoute, preduplet)
+	s.Audit.Record(audit.TargetCompute, audit.ActionSubmit, common.TypePredUplet, preduplet.ID.String(), nil, err)
+	return err
 }
 
-func (s *ComputeAPI) postJSONData(route string, resource interface{}) error {
-	url := fmt.Sprintf("http://%s:%d/%s", s.Hostname, s.Port, route)
+// PostLearnupletWithPayload forwards a learnuplet to the compute HTTP API along a small inline
+// payload (its input data), in a multipart/form-data submission (see httpapi.DecodeSubmission).
+// size is the size of the payload, or -1 if unknown.
+func (s *ComputeAPI) PostLearnupletWithPayload(learnuplet common.Learnuplet, fileName string, payload io.Reader, size int64) error {
+	err := s.postMultipartData(ComputeLearnupletRoute, learnuplet, fileName, payload, size)
+	s.Audit.Record(audit.TargetCompute, audit.ActionSubmit, common.TypeLearnuplet, learnuplet.Key, uploadDetails(size), err)
+	return err
+}
 
-	dataBytes, err := json.Marshal(resource)
-	if err != nil {
-		return fmt.Errorf("[compute-api] Error building POST request against %s: Error marshaling to JSON: %+v", url, resource)
+// PostPredupletWithPayload forwards a preduplet to the compute HTTP API along a small inline payload
+// (the data to predict on), in a multipart/form-data submission (see httpapi.DecodeSubmission).
+// size is the size of the payload, or -1 if unknown.
+func (s *ComputeAPI) PostPredupletWithPayload(preduplet common.Preduplet, fileName string, payload io.Reader, size int64) error {
+	err := s.postMultipartData(ComputePredupletRoute, preduplet, fileName, payload, size)
+	s.Audit.Record(audit.TargetCompute, audit.ActionSubmit, common.TypePredUplet, preduplet.ID.String(), uploadDetails(size), err)
+	return err
+}
+
+func (s *ComputeAPI) client() *httpclient.Client {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.HTTPClient == nil {
+		scheme := "http"
+		if s.TLS != nil {
+			scheme = "https"
+		}
+		s.HTTPClient = httpclient.New("compute-api", fmt.Sprintf("%s://%s:%d", scheme, s.Hostname, s.Port))
+		httpClient := httpclient.NewHTTPClient(s.Transport, s.TLS)
+		httpClient.Transport = tracing.Transport(httpClient.Transport)
+		s.HTTPClient.HTTPClient = httpClient
+		s.HTTPClient.Logger = s.Logger
+		s.HTTPClient.Features = s.Features
+		s.HTTPClient.Compression = s.Compression
+		s.HTTPClient.Balancer = s.Balancer
+		s.HTTPClient.Scope = s.Scope
+		s.HTTPClient.Codec = s.Codec
+		s.HTTPClient.Tokens = s.Tokens
+		if s.DedupWindow > 0 {
+			s.HTTPClient.Dedup = httpclient.NewDedupWindow(s.DedupWindow)
+		}
+		if s.APIKey != "" {
+			apiKey := s.APIKey
+			s.HTTPClient.RequestEditors = append(s.HTTPClient.RequestEditors, func(req *http.Request) error {
+				req.Header.Set(auth.APIKeyHeader, apiKey)
+				return nil
+			})
+		}
+		if s.Signer != nil {
+			s.HTTPClient.RequestEditors = append(s.HTTPClient.RequestEditors, s.Signer.RequestEditor())
+		}
 	}
-	data := bytes.NewReader(dataBytes)
+	return s.HTTPClient
+}
+
+// WithScope returns a client performing its requests on behalf of another tenant (the non-empty
+// fields of scope override Scope), sharing this client's connections, e.g.:
+//
+//	compute.WithScope(httpclient.Scope{Project: "mnist"})
+func (s *ComputeAPI) WithScope(scope httpclient.Scope) *ComputeAPI {
+	return &ComputeAPI{Logger: s.Logger, MaxInlinePayloadSize: s.MaxInlinePayloadSize, Audit: s.Audit, HTTPClient: s.client().WithScope(scope)}
+}
+
+// WithHeaders returns a client sending additional headers with its requests (correlation IDs,
+// debug flags...), sharing this client's connections, e.g.:
+//
+//	compute.WithHeaders(http.Header{"X-Correlation-Id": []string{id}}).PostLearnuplet(learnuplet)
+func (s *ComputeAPI) WithHeaders(headers http.Header) *ComputeAPI {
+	return &ComputeAPI{Logger: s.Logger, MaxInlinePayloadSize: s.MaxInlinePayloadSize, Audit: s.Audit, HTTPClient: s.client().WithHeaders(headers)}
+}
 
-	req, err := http.NewRequest(http.MethodPost, url, data)
+// WithContext returns a client whose requests are interrupted once ctx is done, sharing this
+// client's connections, e.g.:
+//
+//	compute.WithContext(ctx).PostLearnuplet(learnuplet)
+func (s *ComputeAPI) WithContext(ctx context.Context) *ComputeAPI {
+	return &ComputeAPI{Logger: s.Logger, MaxInlinePayloadSize: s.MaxInlinePayloadSize, Audit: s.Audit, HTTPClient: s.client().WithContext(ctx)}
+}
+
+func (s *ComputeAPI) postJSONData(route string, resource interface{}) error {
+	return s.client().PostJSONScoped(route, resource, []string{auth.ScopeUpletCreate}, http.StatusOK, http.StatusAccepted)
+}
+
+// postMultipartData sends an uplet descriptor (always JSON: the payload can't be sent again with
+// another codec) followed by its payload. Only the descriptor and the multipart boundaries are held
+// in memory, the payload is streamed.
+func (s *ComputeAPI) postMultipartData(route string, resource interface{}, fileName string, payload io.Reader, size int64) error {
+	limit := s.MaxInlinePayloadSize
+	if limit <= 0 {
+		limit = httpapi.DefaultMaxInlinePayloadSize
+	}
+	if err := common.CheckPayloadSize(size, limit); err != nil {
+		return fmt.Errorf("Error posting %s payload %s: %w", route, fileName, err)
+	}
+	descriptor, err := json.Marshal(resource)
 	if err != nil {
-		return fmt.Errorf("[compute-api] Error building result POST request against %s: %s", url, err)
+		return fmt.Errorf("Error marshaling %s descriptor: %s", route, err)
 	}
-	// req.SetBasicAuth(s.User, s.Password)
-	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	envelope := &bytes.Buffer{}
+	writer := multipart.NewWriter(envelope)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, httpapi.SubmissionDescriptorField))
+	header.Set("Content-Type", codec.ContentTypeJSON)
+	part, err := writer.CreatePart(header)
+	if err == nil {
+		_, err = part.Write(descriptor)
+	}
 	if err != nil {
-		return fmt.Errorf("[compute-api] Error performing result POST request against %s: %s", url, err)
+		return fmt.Errorf("Error writing %s descriptor in multipart writer: %s", route, err)
+	}
+	if _, err := writer.CreateFormFile(httpapi.SubmissionPayloadField, fileName); err != nil {
+		return fmt.Errorf("Error writing %s payload in multipart writer: %s", route, err)
+	}
+	headLength := envelope.Len()
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("Error closing %s multipart writer: %s", route, err)
 	}
+	head, tail := envelope.Bytes()[:headLength], envelope.Bytes()[headLength:]
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		defer resp.Body.Close()
-		body, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("[compute-api] Unexpected status code (%s): result POST request against %s, \nBody: %s", resp.Status, url, string(body))
+	var contentLength int64
+	if size >= 0 {
+		contentLength = int64(len(head)) + size + int64(len(tail))
 	}
-	return nil
+	return s.client().DoJSON(&httpclient.Request{
+		Method:         http.MethodPost,
+		Route:          route,
+		Body:           io.MultiReader(bytes.NewReader(head), common.LimitPayload(payload, limit), bytes.NewReader(tail)),
+		ContentLength:  contentLength,
+		Header:         http.Header{"Content-Type": []string{writer.FormDataContentType()}},
+		ExpectedStatus: []int{http.StatusOK, http.StatusAccepted},
+		Scopes:         []string{auth.ScopeUpletCreate},
+	}, nil)
 }
@@ -0,0 +1,232 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/bufpool"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+)
+
+// DefaultDownloadParallelism is the number of data blobs fetched at the same time when no
+// parallelism is specified
+const DefaultDownloadParallelism = 4
+
+// DefaultFragmentAttempts is the number of times DownloadDataset tries to download a fragment
+const DefaultFragmentAttempts = 3
+
+// DownloadProgress is called each time a data blob has been fetched (successfully or not), with the
+// number of bytes written to disk and the number of blobs processed so far out of the total.
+type DownloadProgress func(id uuid.UUID, written int64, err error, done, total int)
+
+// DownloadError aggregates the errors that occurred while downloading several data blobs
+type DownloadError struct {
+	Errors map[uuid.UUID]error
+}
+
+// Kind returns errors.Transient if at least one of the downloads may succeed if retried,
+// errors.Permanent otherwise
+func (e *DownloadError) Kind() errors.Kind {
+	for _, err := range e.Errors {
+		if errors.KindOf(err) == errors.Transient || errors.KindOf(err) == errors.Unknown {
+			return errors.Transient
+		}
+	}
+	return errors.Permanent
+}
+
+// Is makes errors.Is(err, errors.ErrTransient) (and alike) work on download errors
+func (e *DownloadError) Is(target error) bool {
+	return errors.MatchKind(e.Kind(), target)
+}
+
+func (e *DownloadError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for id, err := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%s: %s", id, err))
+	}
+	sort.Strings(messages)
+	return fmt.Sprintf("%d data blob(s) failed to download: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+// DownloadDataBlobs fetches data blobs from storage into destDir (each blob is written in a file
+// named after its UUID), up to parallelism blobs at a time. A failed download doesn't stop the
+// others: all errors are returned at once as a *DownloadError.
+func DownloadDataBlobs(s Storage, ids []uuid.UUID, destDir string, parallelism int, progress DownloadProgress) error {
+	return downloadAll(ids, destDir, parallelism, progress, func(_ int, id uuid.UUID) (int64, error) {
+		return downloadDataBlob(s, id, filepath.Join(destDir, id.String()), nil)
+	})
+}
+
+// DatasetFragmentPath returns the path DownloadDataset writes a fragment to: fragments are named
+// after their position in the manifest and their UUID, so that they are listed in order.
+func DatasetFragmentPath(destDir string, index int, id uuid.UUID) string {
+	return filepath.Join(destDir, fmt.Sprintf("%06d-%s", index, id))
+}
+
+// DownloadDataset fetches the fragments of a dataset from storage into destDir (see
+// DatasetFragmentPath), up to parallelism fragments at a time, and returns their paths in the order
+// of the manifest. The size and digest of fragments are checked when the manifest has them.
+//
+// A fragment failing with a transient error or a corrupted download is downloaded again, up to
+// DefaultFragmentAttempts times. A failed fragment doesn't stop the others: all errors are
+// returned at once as a *DownloadError.
+func DownloadDataset(s Storage, manifest *common.DatasetManifest, destDir string, parallelism int, progress DownloadProgress) ([]string, error) {
+	if err := manifest.Check(); err != nil {
+		return nil, errors.Newf(errors.Validation, "Invalid manifest for dataset %s: %s", manifest.Dataset, err)
+	}
+	ids := make([]uuid.UUID, len(manifest.Fragments))
+	paths := make([]string, len(manifest.Fragments))
+	for i, f := range manifest.Fragments {
+		ids[i], paths[i] = f.ID, DatasetFragmentPath(destDir, i, f.ID)
+	}
+
+	err := downloadAll(ids, destDir, parallelism, progress, func(i int, id uuid.UUID) (written int64, err error) {
+		verify := verifyFragment(manifest.Fragments[i])
+		for attempt := 1; attempt <= DefaultFragmentAttempts; attempt++ {
+			written, err = downloadDataBlob(s, id, paths[i], verify)
+			if err == nil || errors.KindOf(err) != errors.Transient {
+				break
+			}
+		}
+		return written, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// verifyFragment checks the size and digest of a downloaded fragment against the manifest.
+// Mismatches are transient errors: the download was most likely corrupted.
+func verifyFragment(f common.DatasetFragment) func(int64, []byte) error {
+	return func(written int64, digest []byte) error {
+		if f.Size > 0 && written != f.Size {
+			return errors.Newf(errors.Transient, "fragment %s is %d bytes long, %d expected", f.ID, written, f.Size)
+		}
+		if f.SHA256 != "" && hex.EncodeToString(digest) != strings.ToLower(f.SHA256) {
+			return errors.Newf(errors.Transient, "fragment %s has SHA-256 digest %x, %s expected", f.ID, digest, f.SHA256)
+		}
+		return nil
+	}
+}
+
+// downloadAll calls download for every ID, up to parallelism at a time, reporting progress
+func downloadAll(ids []uuid.UUID, destDir string, parallelism int, progress DownloadProgress, download func(i int, id uuid.UUID) (int64, error)) error {
+	if parallelism <= 0 {
+		parallelism = DefaultDownloadParallelism
+	}
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("Error creating download directory %s: %s", destDir, err)
+	}
+
+	var (
+		lock      sync.Mutex
+		wg        sync.WaitGroup
+		done      int
+		errs      = map[uuid.UUID]error{}
+		semaphore = make(chan struct{}, parallelism)
+	)
+	for i, id := range ids {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, id uuid.UUID) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			written, err := download(i, id)
+
+			lock.Lock()
+			defer lock.Unlock()
+			done++
+			if err != nil {
+				errs[id] = err
+			}
+			if progress != nil {
+				progress(id, written, err, done, len(ids))
+			}
+		}(i, id)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return &DownloadError{Errors: errs}
+	}
+	return nil
+}
+
+// downloadDataBlob writes a data blob to a temporary file, renamed to dest once complete (and
+// verified, if verify isn't nil) so that a partial download never ends up under its final name.
+func downloadDataBlob(s Storage, id uuid.UUID, dest string, verify func(written int64, digest []byte) error) (written int64, err error) {
+	blob, err := s.GetDataBlob(id)
+	if err != nil {
+		return 0, err
+	}
+	defer blob.Close()
+
+	tmpPath := dest + ".part"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, fmt.Errorf("Error creating file %s: %s", tmpPath, err)
+	}
+	digest := sha256.New()
+	written, err = bufpool.Copy(io.MultiWriter(file, digest), blob)
+	file.Close()
+	if err == nil && verify != nil {
+		err = verify(written, digest.Sum(nil))
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return written, fmt.Errorf("Error writing %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, dest); err != nil {
+		os.Remove(tmpPath)
+		return written, fmt.Errorf("Error renaming %s to %s: %s", tmpPath, dest, err)
+	}
+	return written, nil
+}
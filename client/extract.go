@@ -0,0 +1,182 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+)
+
+// Default ExtractLimits
+const (
+	DefaultMaxExtractedBytes = 100 << 30
+	DefaultMaxExtractedFiles = 1000000
+)
+
+// ExtractLimits bounds what extracting an archive may write to disk (defaults apply to zero fields)
+type ExtractLimits struct {
+	// MaxBytes is the maximum total size of the extracted files
+	MaxBytes int64
+	// MaxFiles is the maximum number of extracted files and directories
+	MaxFiles int
+}
+
+func (l ExtractLimits) withDefaults() ExtractLimits {
+	if l.MaxBytes <= 0 {
+		l.MaxBytes = DefaultMaxExtractedBytes
+	}
+	if l.MaxFiles <= 0 {
+		l.MaxFiles = DefaultMaxExtractedFiles
+	}
+	return l
+}
+
+// ExtractTarGz extracts a .tar.gz archive read from r into destDir, as it is read (the archive
+// itself is never written to disk). Entries escaping destDir (absolute paths, "..") and links are
+// rejected, as well as archives exceeding the limits. It returns the number of bytes extracted.
+//
+// destDir may hold a partial extraction when an error is returned.
+func ExtractTarGz(r io.Reader, destDir string, limits ExtractLimits) (written int64, err error) {
+	limits = limits.withDefaults()
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, errors.Newf(errors.Permanent, "Error reading gzipped archive: %s", err)
+	}
+	defer gz.Close()
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return 0, fmt.Errorf("Error creating extraction directory %s: %s", destDir, err)
+	}
+
+	archive := tar.NewReader(gz)
+	for files := 1; ; files++ {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, errors.Newf(errors.Permanent, "Error reading archive: %s", err)
+		}
+		if files > limits.MaxFiles {
+			return written, errors.Newf(errors.Permanent, "Archive holds more than %d files", limits.MaxFiles)
+		}
+
+		path, err := extractPath(destDir, header.Name)
+		if err != nil {
+			return written, err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return written, fmt.Errorf("Error creating directory %s: %s", path, err)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			n, err := extractFile(archive, path, header.FileInfo().Mode().Perm(), limits.MaxBytes-written)
+			written += n
+			if err != nil {
+				return written, err
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			return written, errors.Newf(errors.Permanent, "Archive entry %s is a link, which isn't supported", header.Name)
+		default:
+			// Devices, FIFOs, extended headers... aren't dataset content
+		}
+	}
+}
+
+// extractPath returns where an archive entry is extracted, making sure it doesn't escape destDir
+func extractPath(destDir, name string) (string, error) {
+	path := filepath.Join(destDir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(destDir, path)
+	if err != nil || filepath.IsAbs(name) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Newf(errors.Permanent, "Archive entry %s escapes the extraction directory", name)
+	}
+	return path, nil
+}
+
+// extractFile writes an archive entry to path, failing if it is larger than budget bytes
+func extractFile(r io.Reader, path string, perm os.FileMode, budget int64) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, fmt.Errorf("Error creating directory %s: %s", filepath.Dir(path), err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm|0600)
+	if err != nil {
+		return 0, fmt.Errorf("Error creating file %s: %s", path, err)
+	}
+	defer file.Close()
+
+	written, err := io.Copy(file, io.LimitReader(r, budget+1))
+	if err != nil {
+		return written, fmt.Errorf("Error writing %s: %s", path, err)
+	}
+	if written > budget {
+		return written, errors.Newf(errors.Permanent, "Archive exceeds the extraction size limit")
+	}
+	return written, nil
+}
+
+// DownloadAndExtractDataBlob streams a data blob (a .tar.gz archive) from storage into its
+// extraction in dest, without writing the archive to disk. The archive is extracted in a temporary
+// directory renamed to dest once complete, so that a partial extraction never ends up under its
+// final name.
+func DownloadAndExtractDataBlob(s Storage, id uuid.UUID, dest string, limits ExtractLimits) (written int64, err error) {
+	blob, err := s.GetDataBlob(id)
+	if err != nil {
+		return 0, err
+	}
+	defer blob.Close()
+
+	tmpDir := dest + ".part"
+	os.RemoveAll(tmpDir)
+	written, err = ExtractTarGz(blob, tmpDir, limits)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return written, fmt.Errorf("Error extracting data blob %s: %w", id, err)
+	}
+	if err := os.Rename(tmpDir, dest); err != nil {
+		os.RemoveAll(tmpDir)
+		return written, fmt.Errorf("Error renaming %s to %s: %s", tmpDir, dest, err)
+	}
+	return written, nil
+}
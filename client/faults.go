@@ -0,0 +1,214 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/chaos"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+)
+
+// AnyMethod matches every method of a mock in fault rules
+const AnyMethod = "*"
+
+// Faults injects failures and latency into the calls of a mock (PeerMock, StorageAPIMock...), so
+// that retry and error handling logic can be tested. Rules may be changed at any time, including
+// while the mock is in use. A nil *Faults injects nothing.
+type Faults struct {
+	// Clock waits out the injected latency (the wall clock if nil)
+	Clock common.Clock
+	// Chaos, if set, injects seeded random latency, failures and partial uploads on top of the rules
+	// (see the chaos package)
+	Chaos *chaos.Chaos
+
+	lock    sync.Mutex
+	calls   map[string]int
+	errs    map[string]error
+	nth     map[string]map[int]error
+	latency map[string]time.Duration
+	down    error
+}
+
+// NewFaults creates a fault injector without rules
+func NewFaults() *Faults {
+	return &Faults{}
+}
+
+// WithChaos sets the chaos injected on top of the rules
+func (f *Faults) WithChaos(c *chaos.Chaos) *Faults {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.Chaos = c
+	return f
+}
+
+// Fail makes every call to a method (or to AnyMethod) fail with err, until Clear is called
+func (f *Faults) Fail(method string, err error) *Faults {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.errs == nil {
+		f.errs = map[string]error{}
+	}
+	f.errs[method] = err
+	return f
+}
+
+// FailNth makes the nth call (starting at 1) to a method (or to AnyMethod) fail with err
+func (f *Faults) FailNth(method string, n int, err error) *Faults {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.nth == nil {
+		f.nth = map[string]map[int]error{}
+	}
+	if f.nth[method] == nil {
+		f.nth[method] = map[int]error{}
+	}
+	f.nth[method][n] = err
+	return f
+}
+
+// Delay adds latency to every call to a method (or to AnyMethod)
+func (f *Faults) Delay(method string, latency time.Duration) *Faults {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.latency == nil {
+		f.latency = map[string]time.Duration{}
+	}
+	f.latency[method] = latency
+	return f
+}
+
+// Down makes every call fail with err (a transient "service unavailable" error if nil), until Up
+// is called. Other rules are kept.
+func (f *Faults) Down(err error) {
+	if err == nil {
+		err = StatusFault(http.StatusServiceUnavailable)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.down = err
+}
+
+// Up cancels Down
+func (f *Faults) Up() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.down = nil
+}
+
+// Clear removes every rule and resets the call counters (Chaos is kept)
+func (f *Faults) Clear() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls, f.errs, f.nth, f.latency, f.down = nil, nil, nil, nil, nil
+}
+
+// CallCount returns the number of calls made to a method (or to any method, for AnyMethod)
+func (f *Faults) CallCount(method string) int {
+	if f == nil {
+		return 0
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.calls[method]
+}
+
+// Inject counts a call to a method, waits out its latency and returns the error it should fail
+// with (nil if it shouldn't fail)
+func (f *Faults) Inject(method string) error {
+	if f == nil {
+		return nil
+	}
+	f.lock.Lock()
+	if f.calls == nil {
+		f.calls = map[string]int{}
+	}
+	f.calls[method]++
+	f.calls[AnyMethod]++
+	latency := f.latency[AnyMethod] + f.latency[method]
+	err := f.down
+	if err == nil {
+		err = f.nth[method][f.calls[method]]
+	}
+	if err == nil {
+		err = f.nth[AnyMethod][f.calls[AnyMethod]]
+	}
+	if err == nil {
+		err = f.errs[method]
+	}
+	if err == nil {
+		err = f.errs[AnyMethod]
+	}
+	chaotic := f.Chaos
+	f.lock.Unlock()
+
+	if latency > 0 {
+		clock.OrReal(f.Clock).Sleep(latency)
+	}
+	if err == nil {
+		err = chaotic.Call(method)
+	}
+	return err
+}
+
+// Reader returns a reader on the data uploaded by a call to a method, which Chaos may cut short
+// (see chaos.Chaos.Reader)
+func (f *Faults) Reader(method string, r io.Reader, size int64) io.Reader {
+	if f == nil {
+		return r
+	}
+	f.lock.Lock()
+	chaotic := f.Chaos
+	f.lock.Unlock()
+	return chaotic.Reader(method, r, size)
+}
+
+// StatusFault returns the error an API client returns when the API answers with an HTTP status
+// code, classified accordingly (errors.Transient for a 503...)
+func StatusFault(statusCode int) error {
+	return &httpclient.StatusError{
+		API:        "mock",
+		StatusCode: statusCode,
+		Status:     http.StatusText(statusCode),
+		Message:    "injected fault",
+	}
+}
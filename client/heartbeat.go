@@ -0,0 +1,71 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+)
+
+// RegisterAndHeartbeat registers a worker to the orchestrator, then sends a heartbeat every
+// interval until stop is closed, so that the orchestrator can detect dead workers and reassign their
+// uplets. Only registration errors are returned: failed heartbeats are logged and retried at the
+// next tick. It blocks, and is meant to be run in its own goroutine. A nil clock stands for the
+// wall clock.
+func RegisterAndHeartbeat(peer Peer, worker common.Worker, interval time.Duration, stop <-chan struct{}, logger logging.Logger, clk common.Clock) error {
+	logger = logging.OrDefault(logger).With(logging.Fields{logging.FieldComponent: "heartbeat", "worker": worker.ID})
+
+	if _, _, err := peer.RegisterWorker(worker); err != nil {
+		return fmt.Errorf("Error registering worker %s: %s", worker.ID, err)
+	}
+	logger.Infof("Worker registered, sending heartbeats every %s", interval)
+
+	clk = clock.OrReal(clk)
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-clk.After(interval):
+			if _, _, err := peer.WorkerHeartbeat(worker.ID.String()); err != nil {
+				logger.Errorf("Error sending heartbeat: %s", err)
+			}
+		}
+	}
+}
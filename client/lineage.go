@@ -0,0 +1,196 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/bufpool"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+)
+
+// MaxAncestryDepth bounds the number of generations ModelAncestry walks through
+const MaxAncestryDepth = 1000
+
+// ModelAncestry walks the ancestry of a model through the learnuplets that produced it and its
+// ancestors, from the model itself to the model trained from scratch (or to the first model that
+// wasn't produced by a learnuplet). If storage isn't nil, the algo and data digests recorded with
+// the models are added to their lineage, and the ancestry ends with the lineage recorded with the
+// first model that wasn't produced by a learnuplet (e.g. an aggregated model), if any.
+func ModelAncestry(peer Peer, storage Storage, model uuid.UUID) ([]common.ModelLineage, error) {
+	var ancestry []common.ModelLineage
+	seen := map[uuid.UUID]bool{}
+	for !uuid.Equal(uuid.Nil, model) {
+		if seen[model] {
+			return ancestry, errors.Newf(errors.Permanent, "Model %s is its own ancestor", model)
+		}
+		if len(ancestry) >= MaxAncestryDepth {
+			return ancestry, errors.Newf(errors.Permanent, "Ancestry of model %s is deeper than %d generations", ancestry[0].Model, MaxAncestryDepth)
+		}
+		seen[model] = true
+
+		data, err := peer.QueryModelLearnuplet(model.String())
+		if err != nil {
+			return ancestry, fmt.Errorf("Error querying the learnuplet of model %s: %w", model, err)
+		}
+		if len(data) == 0 {
+			if storage != nil {
+				stored, err := storage.GetModel(model)
+				if err != nil {
+					return ancestry, fmt.Errorf("Error fetching model %s: %w", model, err)
+				}
+				if stored.Lineage != nil {
+					ancestry = append(ancestry, *stored.Lineage)
+				}
+			}
+			break
+		}
+		var learnuplet common.LearnupletChaincode
+		if err := json.Unmarshal(data, &learnuplet); err != nil {
+			return ancestry, errors.Newf(errors.Permanent, "Error un-marshaling the learnuplet of model %s: %s", model, err)
+		}
+		lineage, err := learnuplet.Lineage()
+		if err != nil {
+			return ancestry, errors.Newf(errors.Permanent, "Invalid learnuplet for model %s: %s", model, err)
+		}
+
+		if storage != nil {
+			stored, err := storage.GetModel(model)
+			if err != nil {
+				return ancestry, fmt.Errorf("Error fetching model %s: %w", model, err)
+			}
+			if stored.Lineage != nil {
+				lineage.AlgoDigest, lineage.DataDigests = stored.Lineage.AlgoDigest, stored.Lineage.DataDigests
+			}
+		}
+		ancestry = append(ancestry, lineage)
+		model = lineage.Parent
+	}
+	return ancestry, nil
+}
+
+// NewModelLineage returns the lineage of the model a learnuplet produces, to be posted along the
+// model (see Model.Lineage), with its digests: the SHA-256 digest of the algo blob, and those of the
+// train data fragments. The digests of the fragments come from the dataset manifests of storage;
+// the blobs of the datasets without manifest, and of the fragments without digest, are hashed.
+func NewModelLineage(storage Storage, learnuplet common.Learnuplet) (*common.ModelLineage, error) {
+	lineage, err := learnuplet.Lineage()
+	if err != nil {
+		return nil, errors.Newf(errors.Validation, "Error building model lineage: %s", err)
+	}
+	if lineage.AlgoDigest, err = blobDigest(storage.GetAlgoBlob, learnuplet.Algo); err != nil {
+		return nil, fmt.Errorf("Error hashing algo %s: %w", learnuplet.Algo, err)
+	}
+	if lineage.DataDigests, err = DataDigests(storage, learnuplet.TrainData); err != nil {
+		return nil, err
+	}
+	return &lineage, nil
+}
+
+// AggregatedLineage returns the lineage of a model aggregating sibling models trained from algo:
+// the digest of the algo blob, and the data digests recorded with the siblings (see
+// aggregation.Aggregator). Its model is left unset.
+func AggregatedLineage(storage Storage, algo uuid.UUID, siblings []uuid.UUID) (*common.ModelLineage, error) {
+	lineage := &common.ModelLineage{Algo: algo, Siblings: siblings, DataDigests: map[string]string{}}
+	var err error
+	if lineage.AlgoDigest, err = blobDigest(storage.GetAlgoBlob, algo); err != nil {
+		return nil, fmt.Errorf("Error hashing algo %s: %w", algo, err)
+	}
+	for _, id := range siblings {
+		model, err := storage.GetModel(id)
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching model %s: %w", id, err)
+		}
+		if model.Lineage == nil {
+			continue
+		}
+		for data, digest := range model.Lineage.DataDigests {
+			lineage.DataDigests[data] = digest
+		}
+		if model.Lineage.Rank > lineage.Rank {
+			lineage.Rank = model.Lineage.Rank
+		}
+	}
+	return lineage, nil
+}
+
+// DataDigests returns the SHA-256 digests of the fragments of datasets, by fragment UUID (a
+// dataset without manifest being its own single fragment)
+func DataDigests(storage Storage, datasets []uuid.UUID) (map[string]string, error) {
+	digests := map[string]string{}
+	for _, id := range datasets {
+		manifest, err := storage.GetDatasetManifest(id)
+		if stderrors.Is(err, errors.ErrNotFound) {
+			manifest, err = &common.DatasetManifest{Dataset: id, Fragments: []common.DatasetFragment{{ID: id}}}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching the manifest of dataset %s: %w", id, err)
+		}
+		for _, f := range manifest.Fragments {
+			digest := strings.ToLower(f.SHA256)
+			if digest == "" {
+				if digest, err = blobDigest(storage.GetDataBlob, f.ID); err != nil {
+					return nil, fmt.Errorf("Error hashing data %s: %w", f.ID, err)
+				}
+			}
+			digests[f.ID.String()] = digest
+		}
+	}
+	return digests, nil
+}
+
+// blobDigest returns the hex-encoded SHA-256 digest of a blob, streamed from storage
+func blobDigest(get func(uuid.UUID) (io.ReadCloser, error), id uuid.UUID) (string, error) {
+	blob, err := get(id)
+	if err != nil {
+		return "", err
+	}
+	defer blob.Close()
+	digest := sha256.New()
+	if _, err := bufpool.Copy(digest, blob); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
@@ -0,0 +1,388 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/bufpool"
+)
+
+// ModelArtifact is a model blob (a .tar.gz of the model volume) about to be uploaded, spooled to
+// Path
+type ModelArtifact struct {
+	Model *common.Model
+	Path  string
+	Size  int64
+}
+
+// Entries calls fn on every entry of the model archive
+func (a *ModelArtifact) Entries(fn func(header *tar.Header, content io.Reader) error) error {
+	file, err := os.Open(a.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("the model isn't a .tar.gz archive: %s", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("the model isn't a valid .tar.gz archive: %s", err)
+		}
+		if err := fn(header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// ModelValidator checks a model artifact before it is uploaded. Invalid artifacts are reported
+// with a *common.ValidationError (other errors are reported as is).
+type ModelValidator interface {
+	ValidateModel(artifact *ModelArtifact) error
+}
+
+// ModelValidation is a ModelHook running validators on model blobs (spooled to a temporary file in
+// Dir), so that a broken model fails its uplet instead of being shipped downstream. Invalid models
+// are reported with a *common.ValidationError listing every validation failure.
+type ModelValidation struct {
+	Validators []ModelValidator
+	// Dir is where models are spooled to (the system's temporary directory if empty)
+	Dir string
+	// MaxSpoolSize, if positive, bounds the size of spooled models: larger ones are rejected with a
+	// *common.PayloadTooLargeError as soon as the bound is crossed
+	MaxSpoolSize int64
+}
+
+// NewModelValidation creates a hook running validators on model blobs
+func NewModelValidation(validators ...ModelValidator) *ModelValidation {
+	return &ModelValidation{Validators: validators}
+}
+
+// ProcessModel validates a model blob and returns it
+func (v *ModelValidation) ProcessModel(model *common.Model, blob io.Reader, size int64) (io.Reader, int64, error) {
+	file, err := ioutil.TempFile(v.Dir, "model-")
+	if err != nil {
+		return nil, 0, fmt.Errorf("[model-validation] Error creating temporary file: %s", err)
+	}
+	artifact := &ModelArtifact{Model: model, Path: file.Name()}
+	artifact.Size, err = bufpool.Copy(file, common.LimitPayload(blob, v.MaxSpoolSize))
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		err = v.validate(artifact)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, 0, err
+	}
+	return &spooledReader{file: file}, artifact.Size, nil
+}
+
+func (v *ModelValidation) validate(artifact *ModelArtifact) error {
+	verr := &common.ValidationError{}
+	for _, validator := range v.Validators {
+		err := validator.ValidateModel(artifact)
+		var invalid *common.ValidationError
+		switch {
+		case err == nil:
+		case stderrors.As(err, &invalid):
+			verr.Fields = append(verr.Fields, invalid.Fields...)
+		default:
+			return fmt.Errorf("[model-validation] Error validating model %s: %w", artifact.Model.ID, err)
+		}
+	}
+	return verr.OrNil()
+}
+
+// spooledReader reads a spooled model, removing it once read
+type spooledReader struct {
+	file *os.File
+}
+
+func (r *spooledReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	if err != nil {
+		r.file.Close()
+		os.Remove(r.file.Name())
+	}
+	return n, err
+}
+
+// MaxModelSize rejects models whose blob is larger than Bytes
+type MaxModelSize struct {
+	Bytes int64
+}
+
+// ValidateModel checks the size of the model blob
+func (v *MaxModelSize) ValidateModel(artifact *ModelArtifact) error {
+	verr := &common.ValidationError{}
+	if artifact.Size > v.Bytes {
+		verr.Add("size", "model is %d bytes long, at most %d bytes allowed", artifact.Size, v.Bytes)
+	}
+	return verr.OrNil()
+}
+
+// ForbiddenFiles rejects models holding files whose name matches one of Patterns (path.Match
+// patterns, e.g. "*.exe" or "*.so"), and models holding links or special files
+type ForbiddenFiles struct {
+	Patterns []string
+}
+
+// ValidateModel checks the files of the model archive
+func (v *ForbiddenFiles) ValidateModel(artifact *ModelArtifact) error {
+	verr := &common.ValidationError{}
+	err := artifact.Entries(func(header *tar.Header, _ io.Reader) error {
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeRegA, tar.TypeDir:
+		default:
+			verr.Add(header.Name, "%s is a link or a special file", header.Name)
+			return nil
+		}
+		for _, pattern := range v.Patterns {
+			if matched, _ := path.Match(pattern, path.Base(header.Name)); matched {
+				verr.Add(header.Name, "%s is a forbidden file (matches %s)", header.Name, pattern)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		verr.Add("archive", "%s", err)
+	}
+	return verr.OrNil()
+}
+
+// ONNXOpset checks the ONNX models (files ending with .onnx) of a model archive: they have to be
+// valid ONNX files importing the default operator set at a version between MinOpset and MaxOpset
+// (unbounded if zero). If Required is set, the archive has to hold at least one ONNX model.
+type ONNXOpset struct {
+	MinOpset, MaxOpset int64
+	Required           bool
+}
+
+// ValidateModel checks the ONNX models of the archive
+func (v *ONNXOpset) ValidateModel(artifact *ModelArtifact) error {
+	verr := &common.ValidationError{}
+	found := false
+	err := artifact.Entries(func(header *tar.Header, content io.Reader) error {
+		if header.Typeflag != tar.TypeReg || !strings.HasSuffix(strings.ToLower(header.Name), ".onnx") {
+			return nil
+		}
+		found = true
+		opset, err := onnxOpset(bufio.NewReader(content))
+		switch {
+		case err != nil:
+			verr.Add(header.Name, "%s isn't a valid ONNX model: %s", header.Name, err)
+		case v.MinOpset > 0 && opset < v.MinOpset:
+			verr.Add(header.Name, "%s uses opset %d, at least %d required", header.Name, opset, v.MinOpset)
+		case v.MaxOpset > 0 && opset > v.MaxOpset:
+			verr.Add(header.Name, "%s uses opset %d, at most %d supported", header.Name, opset, v.MaxOpset)
+		}
+		return nil
+	})
+	if err != nil {
+		verr.Add("archive", "%s", err)
+	} else if v.Required && !found {
+		verr.Add("onnx", "the model holds no ONNX file")
+	}
+	return verr.OrNil()
+}
+
+// maxONNXOperatorSetSize bounds the size of the operator set imports read from ONNX models
+const maxONNXOperatorSetSize = 1 << 16
+
+// onnxOpset reads the version of the default operator set imported by an ONNX model (a ModelProto
+// protobuf message: ir_version is field 1, opset_import field 8), skipping over the graph
+func onnxOpset(r *bufio.Reader) (opset int64, err error) {
+	irVersion := false
+	opset = -1
+	for {
+		field, wireType, err := protoKey(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case field == 1 && wireType == 0:
+			if _, err := protoVarint(r); err != nil {
+				return 0, err
+			}
+			irVersion = true
+		case field == 8 && wireType == 2:
+			length, err := protoVarint(r)
+			if err != nil {
+				return 0, err
+			}
+			if length > maxONNXOperatorSetSize {
+				return 0, fmt.Errorf("%d bytes long operator set import", length)
+			}
+			message := make([]byte, length)
+			if _, err := io.ReadFull(r, message); err != nil {
+				return 0, err
+			}
+			domain, version, err := onnxOperatorSet(message)
+			if err != nil {
+				return 0, err
+			}
+			if domain == "" || domain == "ai.onnx" {
+				opset = version
+			}
+		default:
+			if err := protoSkip(r, wireType); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if !irVersion {
+		return 0, fmt.Errorf("no IR version")
+	}
+	if opset < 0 {
+		return 0, fmt.Errorf("no default operator set import")
+	}
+	return opset, nil
+}
+
+// onnxOperatorSet decodes an OperatorSetIdProto message (domain is field 1, version field 2)
+func onnxOperatorSet(message []byte) (domain string, version int64, err error) {
+	r := bufio.NewReader(bytes.NewReader(message))
+	for {
+		field, wireType, err := protoKey(r)
+		if err == io.EOF {
+			return domain, version, nil
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		switch {
+		case field == 1 && wireType == 2:
+			length, err := protoVarint(r)
+			if err != nil {
+				return "", 0, err
+			}
+			if length > uint64(len(message)) {
+				return "", 0, io.ErrUnexpectedEOF
+			}
+			buf := make([]byte, length)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return "", 0, err
+			}
+			domain = string(buf)
+		case field == 2 && wireType == 0:
+			v, err := protoVarint(r)
+			if err != nil {
+				return "", 0, err
+			}
+			version = int64(v)
+		default:
+			if err := protoSkip(r, wireType); err != nil {
+				return "", 0, err
+			}
+		}
+	}
+}
+
+func protoKey(r *bufio.Reader) (field uint64, wireType uint64, err error) {
+	key, err := protoVarint(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	return key >> 3, key & 7, nil
+}
+
+func protoVarint(r *bufio.Reader) (uint64, error) {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := r.ReadByte()
+		if err == io.EOF && shift > 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("varint overflow")
+}
+
+func protoSkip(r *bufio.Reader, wireType uint64) error {
+	var n int64
+	switch wireType {
+	case 0:
+		_, err := protoVarint(r)
+		return err
+	case 1:
+		n = 8
+	case 2:
+		length, err := protoVarint(r)
+		if err != nil {
+			return err
+		}
+		n = int64(length)
+	case 5:
+		n = 4
+	default:
+		return fmt.Errorf("unsupported protobuf wire type %d", wireType)
+	}
+	if skipped, err := io.CopyN(ioutil.Discard, r, n); err != nil || skipped != n {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
@@ -37,11 +37,16 @@ package client
 
 import (
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
-	// "github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
 
 	"github.com/hyperledger/fabric-sdk-go/api/apitxn"
 	"github.com/hyperledger/fabric-sdk-go/def/fabapi"
@@ -55,8 +60,15 @@ type Peer interface {
 	RegisterItem(itemType, storageAddress string, problemKeys []string, itemName string) (string, []byte, error)
 	RegisterProblem(storageAddress string, sizeTrainDataset int, testData []string) (string, []byte, error)
 	SetUpletWorker(upletKey, worker string) (string, []byte, error)
+	PatchUplet(upletType, upletKey string, patch UpletPatch) (string, []byte, error)
 	QueryStatusLearnuplet(status string) ([]byte, error)
+	QueryProblem(problemKey string) ([]byte, error)
+	QueryModelLearnuplet(modelKey string) ([]byte, error)
 	ReportLearn(upletKey, status string, perf float64, trainPerf, testPerf map[string]float64) (string, []byte, error)
+	ReportEvaluation(upletKey, status string, perf float64, testPerf map[string]float64) (string, []byte, error)
+
+	RegisterWorker(worker common.Worker) (string, []byte, error)
+	WorkerHeartbeat(workerID string) (string, []byte, error)
 }
 
 // ============================================================================
@@ -73,6 +85,16 @@ type PeerAPI struct {
 	ChaincodeID string
 
 	ConnectEventHub bool
+
+	Logger logging.Logger
+	// Clock timestamps heartbeats (the wall clock if nil)
+	Clock common.Clock
+	// PerfPrecision is the number of significant digits of the performance values reported to the
+	// orchestrator (exact values if zero, see common.FormatPerf)
+	PerfPrecision int
+	// Headers are attached to every transaction, as its transient data (which the chaincode reads
+	// with GetTransient, and which isn't written to the ledger). Queries can't carry them.
+	Headers map[string]string
 }
 
 // NewPeerAPI create a new PeerAPI object
@@ -99,19 +121,56 @@ func NewPeerAPI(configFile, orgID, channelID, chaincodeID string) (*PeerAPI, err
 		ChannelID:       channelID,
 		ChaincodeID:     chaincodeID,
 		ConnectEventHub: true,
+		Logger:          logging.Default(),
 	}, nil
 }
 
+// WithHeaders returns a peer client attaching additional headers (correlation IDs, tenant hints,
+// debug flags...) to its transactions, sharing this client's SDK, e.g.:
+//
+//	peer.WithHeaders(map[string]string{"correlation-id": id}).ReportLearn(...)
+func (s *PeerAPI) WithHeaders(headers map[string]string) *PeerAPI {
+	withHeaders := *s
+	withHeaders.Headers = map[string]string{}
+	for key, value := range s.Headers {
+		withHeaders.Headers[key] = value
+	}
+	for key, value := range headers {
+		withHeaders.Headers[key] = value
+	}
+	return &withHeaders
+}
+
 // ============================================================================
 // Basic Functions: Query and Invoke
 // ============================================================================
 
+// notSubmittedError is an invoke error that occurred before the transaction was sent to the peer
+type notSubmittedError struct {
+	error
+}
+
+func (e notSubmittedError) Unwrap() error {
+	return e.error
+}
+
+// NotSubmitted tells whether an invoke failed before its transaction was sent to the peer, in which
+// case it may safely be sent again (to another peer, for instance)
+func NotSubmitted(err error) bool {
+	var notSubmitted notSubmittedError
+	return stderrors.As(err, &notSubmitted)
+}
+
+func (s *PeerAPI) logger(fcn string) logging.Logger {
+	return logging.OrDefault(s.Logger).With(logging.Fields{logging.FieldComponent: "peer-api", logging.FieldRoute: fcn})
+}
+
 // Query performs a query on the Fabric Peer
 func (s *PeerAPI) Query(fcn string, args []string) ([]byte, error) {
 	// Create Channel Client
 	chClient, err := s.sdk.NewChannelClient(s.ChannelID, "Admin")
 	if err != nil {
-		return nil, fmt.Errorf("[peer-api] Failed to create new channel client: %s", err)
+		return nil, errors.Newf(errors.Transient, "[peer-api] Failed to create new channel client: %s", err)
 	}
 	defer chClient.Close()
 
@@ -122,6 +181,7 @@ func (s *PeerAPI) Query(fcn string, args []string) ([]byte, error) {
 	}
 
 	// Make query
+	s.logger(fcn).Debugf("Querying chaincode %s", s.ChaincodeID)
 	query, err := chClient.Query(apitxn.QueryRequest{ChaincodeID: s.ChaincodeID, Fcn: fcn, Args: argsBytes})
 	if err != nil {
 		return nil, fmt.Errorf("[peer-api] Failed to Query (Fcn: %s, Args: %s): %s", fcn, args, err)
@@ -134,7 +194,7 @@ func (s *PeerAPI) Invoke(fcn string, args []string) (string, []byte, error) {
 	// Create Channel Client
 	chClient, err := s.sdk.NewChannelClient(s.ChannelID, "Admin")
 	if err != nil {
-		return "", nil, fmt.Errorf("[peer-api] Failed to create new channel client: %s", err)
+		return "", nil, notSubmittedError{errors.Newf(errors.Transient, "[peer-api] Failed to create new channel client: %s", err)}
 	}
 	defer chClient.Close()
 
@@ -145,7 +205,15 @@ func (s *PeerAPI) Invoke(fcn string, args []string) (string, []byte, error) {
 	}
 
 	// Make query
-	txID, err := chClient.ExecuteTx(apitxn.ExecuteTxRequest{ChaincodeID: s.ChaincodeID, Fcn: fcn, Args: argsBytes})
+	s.logger(fcn).Debugf("Invoking chaincode %s", s.ChaincodeID)
+	var transient map[string][]byte
+	if len(s.Headers) > 0 {
+		transient = make(map[string][]byte, len(s.Headers))
+		for key, value := range s.Headers {
+			transient[key] = []byte(value)
+		}
+	}
+	txID, err := chClient.ExecuteTx(apitxn.ExecuteTxRequest{ChaincodeID: s.ChaincodeID, Fcn: fcn, Args: argsBytes, TransientMap: transient})
 	if err != nil {
 		return "", nil, fmt.Errorf("[peer-api] Failed to Execute transaction (Fcn: %s, Args: %s): %s", fcn, args, err)
 	}
@@ -176,67 +244,246 @@ func (s *PeerAPI) QueryStatusLearnuplet(status string) ([]byte, error) {
 	return s.Query("queryStatusLearnuplet", []string{status})
 }
 
+// QueryProblem queries a problem (its storage address, test data...)
+func (s *PeerAPI) QueryProblem(problemKey string) ([]byte, error) {
+	return s.Query("queryProblem", []string{problemKey})
+}
+
+// QueryModelLearnuplet queries the learnuplet that produced a model (empty if the model wasn't
+// trained by a learnuplet)
+func (s *PeerAPI) QueryModelLearnuplet(modelKey string) ([]byte, error) {
+	return s.Query("queryModelLearnuplet", []string{modelKey})
+}
+
 // SetUpletWorker invokes the function setUpletWorker
 func (s *PeerAPI) SetUpletWorker(upletKey, worker string) (string, []byte, error) {
 	return s.Invoke("setUpletWorker", []string{upletKey, worker})
 }
 
+// UpletPatch lists the fields of an uplet to update without a status transition. Nil fields are
+// left untouched.
+type UpletPatch struct {
+	Worker *string `json:"worker,omitempty"`
+	// Progress is the completion percentage of the task (0 to 100)
+	Progress *float64   `json:"progress,omitempty"`
+	ETA      *time.Time `json:"eta,omitempty"`
+}
+
+// Check returns nil if the patch is valid, an explicit error otherwise
+func (p *UpletPatch) Check() error {
+	if p.Worker == nil && p.Progress == nil && p.ETA == nil {
+		return fmt.Errorf("patch is empty")
+	}
+	if p.Worker != nil && *p.Worker == "" {
+		return fmt.Errorf("worker field is empty")
+	}
+	if p.Progress != nil && (*p.Progress < 0 || *p.Progress > 100) {
+		return fmt.Errorf("progress field should be between 0 and 100 (provided: %g)", *p.Progress)
+	}
+	return nil
+}
+
+// PatchUplet updates some fields of an uplet (worker assignment, progress, ETA), leaving its status
+// untouched
+func (s *PeerAPI) PatchUplet(upletType, upletKey string, patch UpletPatch) (string, []byte, error) {
+	if _, ok := common.ValidUplets[upletType]; !ok {
+		return "", nil, errors.Newf(errors.Validation, "[peer-api] Invalid uplet type %s", upletType)
+	}
+	if err := patch.Check(); err != nil {
+		return "", nil, errors.Newf(errors.Validation, "[peer-api] Invalid patch of %s %s: %s", upletType, upletKey, err)
+	}
+	patchArg, err := json.Marshal(patch)
+	if err != nil {
+		return "", nil, fmt.Errorf("[peer-api] Failed to marshal patch: %s", err)
+	}
+	return s.Invoke("patchUplet", []string{upletType, upletKey, string(patchArg)})
+}
+
 // ReportLearn reports the output of a learning task
 func (s *PeerAPI) ReportLearn(upletKey, status string, perf float64, trainPerf, testPerf map[string]float64) (string, []byte, error) {
 	// Format Args
-	perfArg := strconv.FormatFloat(perf, 'e', -1, 32)
-	trainPerfArg, err := json.Marshal(trainPerf)
+	perfArg, err := common.PerfNumber(perf, s.PerfPrecision)
+	if err != nil {
+		return "", nil, fmt.Errorf("[peer-api] Failed to format perf: %s", err)
+	}
+	trainPerfArg, err := s.marshalPerfs(trainPerf)
 	if err != nil {
 		return "", nil, fmt.Errorf("[peer-api] Failed to marshal trainPerf: %s", err)
 	}
-	testPerfArg, err := json.Marshal(testPerf)
+	testPerfArg, err := s.marshalPerfs(testPerf)
 	if err != nil {
 		return "", nil, fmt.Errorf("[peer-api] Failed to marshal testPerf: %s", err)
 	}
 
 	// Execute Transaction
-	return s.Invoke("reportLearn", []string{upletKey, status, perfArg, string(trainPerfArg), string(testPerfArg)})
+	return s.Invoke("reportLearn", []string{upletKey, status, string(perfArg), string(trainPerfArg), string(testPerfArg)})
+}
+
+// ReportEvaluation reports the output of an evaluation only learnuplet (its performance on the test
+// data, no model nor train performance)
+func (s *PeerAPI) ReportEvaluation(upletKey, status string, perf float64, testPerf map[string]float64) (string, []byte, error) {
+	perfArg, err := common.PerfNumber(perf, s.PerfPrecision)
+	if err != nil {
+		return "", nil, fmt.Errorf("[peer-api] Failed to format perf: %s", err)
+	}
+	testPerfArg, err := s.marshalPerfs(testPerf)
+	if err != nil {
+		return "", nil, fmt.Errorf("[peer-api] Failed to marshal testPerf: %s", err)
+	}
+	return s.Invoke("reportEvaluation", []string{upletKey, status, string(perfArg), string(testPerfArg)})
+}
+
+// marshalPerfs marshals a performance map with the PerfPrecision of the peer
+func (s *PeerAPI) marshalPerfs(perfs map[string]float64) ([]byte, error) {
+	numbers, err := common.PerfNumbers(perfs, s.PerfPrecision)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(numbers)
+}
+
+// ============================================================================
+// Worker Functions
+// ============================================================================
+
+// RegisterWorker registers a worker and its capabilities
+func (s *PeerAPI) RegisterWorker(worker common.Worker) (string, []byte, error) {
+	if err := worker.Check(); err != nil {
+		return "", nil, errors.Newf(errors.Validation, "[peer-api] Invalid worker: %s", err)
+	}
+	workerArg, err := json.Marshal(worker)
+	if err != nil {
+		return "", nil, fmt.Errorf("[peer-api] Failed to marshal worker: %s", err)
+	}
+	return s.Invoke("registerWorker", []string{worker.ID.String(), string(workerArg)})
+}
+
+// WorkerHeartbeat signals the worker is still alive, so that its uplets aren't reassigned
+func (s *PeerAPI) WorkerHeartbeat(workerID string) (string, []byte, error) {
+	return s.Invoke("workerHeartbeat", []string{workerID, strconv.FormatInt(clock.OrReal(s.Clock).Now().Unix(), 10)})
 }
 
 // ============================================================================
 // Peer MOCK
 // ============================================================================
 
-// PeerMock describes a mock implementation of Peer
+// LearnResult is the output of a learning task, as reported to the peer
+type LearnResult struct {
+	UpletKey  string             `json:"uplet_key"`
+	Status    string             `json:"status"`
+	Perf      float64            `json:"perf"`
+	TrainPerf map[string]float64 `json:"train_perf"`
+	TestPerf  map[string]float64 `json:"test_perf"`
+	// EvaluationOnly is true for the results of evaluation only learnuplets (reported with
+	// ReportEvaluation)
+	EvaluationOnly bool `json:"evaluation_only,omitempty"`
+}
+
+// PeerMock describes a mock implementation of Peer. It records its invocations (see Calls).
 type PeerMock struct {
+	common.CallRecorder
+
+	// Faults, if set, injects failures and latency into the calls
+	Faults *Faults
+}
+
+func (s *PeerMock) call(method, id string, payload interface{}) error {
+	err := s.Faults.Inject(method)
+	s.Record(method, id, mockPayload(payload), err)
+	return err
+}
+
+// LastLearnResult returns the last learn (or evaluation) result reported for an uplet
+func (s *PeerMock) LastLearnResult(upletKey string) (LearnResult, bool) {
+	call, ok := s.LastCall("ReportLearn", upletKey)
+	if evaluation, evaluated := s.LastCall("ReportEvaluation", upletKey); evaluated && (!ok || evaluation.Time.After(call.Time)) {
+		call, ok = evaluation, true
+	}
+	if !ok {
+		return LearnResult{}, false
+	}
+	var result LearnResult
+	if err := json.Unmarshal(call.Payload, &result); err != nil {
+		return LearnResult{}, false
+	}
+	return result, true
 }
 
 // Query performs a query
 func (s *PeerMock) Query(queryFcn string, queryArgs []string) ([]byte, error) {
-	return nil, nil
+	return nil, s.call("Query", queryFcn, queryArgs)
 }
 
 // Invoke performs an invoke
 func (s *PeerMock) Invoke(txFcn string, txArgs []string) (string, []byte, error) {
-	return "", nil, nil
+	return "", nil, s.call("Invoke", txFcn, txArgs)
 }
 
 // RegisterItem registers an item
 func (s *PeerMock) RegisterItem(itemType, storageAddress string, problemKeys []string, itemName string) (string, []byte, error) {
-	return "", nil, nil
+	return "", nil, s.call("RegisterItem", "", []interface{}{itemType, storageAddress, problemKeys, itemName})
 }
 
 // RegisterProblem registers a problem
 func (s *PeerMock) RegisterProblem(storageAddress string, sizeTrainDataset int, testData []string) (string, []byte, error) {
-	return "", nil, nil
+	return "", nil, s.call("RegisterProblem", "", []interface{}{storageAddress, sizeTrainDataset, testData})
 }
 
 // SetUpletWorker invokes the function setUpletWorker
 func (s *PeerMock) SetUpletWorker(upletKey, worker string) (string, []byte, error) {
-	return "", nil, nil
+	return "", nil, s.call("SetUpletWorker", upletKey, worker)
+}
+
+// PatchUplet updates some fields of an uplet
+func (s *PeerMock) PatchUplet(upletType, upletKey string, patch UpletPatch) (string, []byte, error) {
+	return "", nil, s.call("PatchUplet", upletKey, patch)
 }
 
 // QueryStatusLearnuplet queries the learnuplet by status
 func (s *PeerMock) QueryStatusLearnuplet(status string) ([]byte, error) {
-	return nil, nil
+	return nil, s.call("QueryStatusLearnuplet", "", status)
+}
+
+// QueryProblem queries a problem
+func (s *PeerMock) QueryProblem(problemKey string) ([]byte, error) {
+	return nil, s.call("QueryProblem", problemKey, nil)
+}
+
+// QueryModelLearnuplet queries the learnuplet that produced a model
+func (s *PeerMock) QueryModelLearnuplet(modelKey string) ([]byte, error) {
+	return nil, s.call("QueryModelLearnuplet", modelKey, nil)
 }
 
 // ReportLearn reports the output of a learning task
 func (s *PeerMock) ReportLearn(upletKey, status string, perf float64, trainPerf, testPerf map[string]float64) (string, []byte, error) {
-	return "", nil, nil
+	result := LearnResult{UpletKey: upletKey, Status: status, Perf: perf, TrainPerf: trainPerf, TestPerf: testPerf}
+	return "", nil, s.call("ReportLearn", upletKey, result)
+}
+
+// ReportEvaluation reports the output of an evaluation only learnuplet
+func (s *PeerMock) ReportEvaluation(upletKey, status string, perf float64, testPerf map[string]float64) (string, []byte, error) {
+	result := LearnResult{UpletKey: upletKey, Status: status, Perf: perf, TestPerf: testPerf, EvaluationOnly: true}
+	return "", nil, s.call("ReportEvaluation", upletKey, result)
+}
+
+// RegisterWorker registers a worker and its capabilities
+func (s *PeerMock) RegisterWorker(worker common.Worker) (string, []byte, error) {
+	return "", nil, s.call("RegisterWorker", worker.ID.String(), worker)
+}
+
+// WorkerHeartbeat signals the worker is still alive
+func (s *PeerMock) WorkerHeartbeat(workerID string) (string, []byte, error) {
+	return "", nil, s.call("WorkerHeartbeat", workerID, nil)
+}
+
+// mockPayload JSON-encodes the arguments of a mock call (nil for no arguments)
+func mockPayload(v interface{}) []byte {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return []byte(fmt.Sprintf("%v", v))
+	}
+	return data
 }
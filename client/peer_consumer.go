@@ -0,0 +1,280 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/tracing"
+)
+
+// DefaultPeerPollInterval is the interval between two polls of the peer for new uplets
+const DefaultPeerPollInterval = 10 * time.Second
+
+// PeerConsumer is a broker-free common.Consumer, for small deployments that don't run NSQ: it polls
+// the peer for the learnuplets to do assigned to a worker, and hands them (as the broker would, JSON
+// encoded) to the handler of the TrainTopic.
+//
+// An uplet is handed to the handler once; it is handed again if the handler fails with a
+// non-fatal error or times out while the uplet is still to do. Other topics aren't supported. Each
+// uplet is handled within its root span (see tracing.StartUplet).
+type PeerConsumer struct {
+	Peer     Peer
+	WorkerID string
+	Interval time.Duration
+	Logger   logging.Logger
+	Clock    common.Clock
+
+	lock    sync.Mutex
+	handler common.ContextHandler
+	slots   chan struct{}
+	timeout time.Duration
+	// seen holds the uplets handed to the handler (true while being handled)
+	seen     map[string]bool
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewPeerConsumer creates a consumer polling the peer for the uplets assigned to a worker
+func NewPeerConsumer(peer Peer, workerID string, interval time.Duration) *PeerConsumer {
+	return &PeerConsumer{Peer: peer, WorkerID: workerID, Interval: interval}
+}
+
+func (c *PeerConsumer) logger() logging.Logger {
+	return logging.OrDefault(c.Logger).With(logging.Fields{logging.FieldComponent: "peer-consumer"})
+}
+
+// AddHandler sets the handler of the TrainTopic, up to concurrency uplets being handled in parallel
+func (c *PeerConsumer) AddHandler(topic string, handler common.Handler, concurrency int, timeout time.Duration) error {
+	return c.AddContextHandler(topic, func(_ context.Context, message []byte) error { return handler(message) }, concurrency, timeout)
+}
+
+// AddContextHandler sets the handler of the TrainTopic, as AddHandler does. The handler receives a
+// context holding the span of the uplet, cancelled once the handler times out.
+func (c *PeerConsumer) AddContextHandler(topic string, handler common.ContextHandler, concurrency int, timeout time.Duration) error {
+	if topic != common.TrainTopic {
+		return fmt.Errorf("[peer-consumer] Topic %s isn't supported (only %s is)", topic, common.TrainTopic)
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.handler = handler
+	c.slots = make(chan struct{}, concurrency)
+	c.timeout = timeout
+	c.logger().Infof("Adding %d handler(s) for topic %s.", concurrency, topic)
+	return nil
+}
+
+// AddBroadcastHandler isn't supported: the peer has no control topics
+func (c *PeerConsumer) AddBroadcastHandler(topic string, handler common.Handler, concurrency int, timeout time.Duration) error {
+	return fmt.Errorf("[peer-consumer] Broadcast topics (%s) aren't supported", topic)
+}
+
+// ConsumeUntilKilled polls the peer until Stop is called
+func (c *PeerConsumer) ConsumeUntilKilled() {
+	interval := c.Interval
+	if interval <= 0 {
+		interval = DefaultPeerPollInterval
+	}
+	stop := c.stopChan()
+	clk := clock.OrReal(c.Clock)
+	for {
+		if err := c.Poll(); err != nil {
+			c.logger().Warnf("Error polling the peer: %s", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-clk.After(interval):
+		}
+	}
+}
+
+// Stop stops ConsumeUntilKilled. Uplets being handled aren't interrupted.
+func (c *PeerConsumer) Stop() {
+	stop := c.stopChan()
+	c.stopOnce.Do(func() { close(stop) })
+}
+
+func (c *PeerConsumer) stopChan() chan struct{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.stop == nil {
+		c.stop = make(chan struct{})
+	}
+	return c.stop
+}
+
+// Poll queries the peer once and hands the new uplets assigned to the worker to the handler (as
+// long as handler slots are free)
+func (c *PeerConsumer) Poll() error {
+	c.lock.Lock()
+	handler, slots, timeout := c.handler, c.slots, c.timeout
+	c.lock.Unlock()
+	if handler == nil {
+		return fmt.Errorf("[peer-consumer] No handler for topic %s", common.TrainTopic)
+	}
+
+	data, err := c.Peer.QueryStatusLearnuplet(common.TaskStatusTodo)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	var uplets []common.LearnupletChaincode
+	if err := json.Unmarshal(data, &uplets); err != nil {
+		return errors.Newf(errors.Permanent, "[peer-consumer] Error un-marshaling learnuplets: %s", err)
+	}
+
+	c.forgetDone(uplets)
+	for _, u := range uplets {
+		if u.Worker != c.WorkerID || !c.claim(u.Key) {
+			continue
+		}
+		learnuplet, err := u.LearnupletFormat()
+		if err != nil {
+			c.logger().Warnf("Skipping learnuplet %s: %s", u.Key, err)
+			c.handled(u.Key)
+			continue
+		}
+		message, err := json.Marshal(learnuplet)
+		if err != nil {
+			c.release(u.Key)
+			return fmt.Errorf("[peer-consumer] Error marshaling learnuplet %s: %s", u.Key, err)
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			// Every handler is busy: the uplet will be picked at the next poll
+			c.release(u.Key)
+			return nil
+		}
+		go c.handle(handler, slots, timeout, u.Key, message)
+	}
+	return nil
+}
+
+func (c *PeerConsumer) handle(handler common.ContextHandler, slots chan struct{}, timeout time.Duration, key string, message []byte) {
+	defer func() { <-slots }()
+	logger := c.logger().With(logging.Fields{logging.FieldUplet: key})
+
+	ctx, span := tracing.StartUplet(context.Background(), common.TypeLearnuplet, key)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	done := make(chan error, 1)
+	go func() { done <- handler(ctx, message) }()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = clock.OrReal(c.Clock).After(timeout)
+	}
+
+	select {
+	case err = <-done:
+		var fatal common.HandlerFatalError
+		switch {
+		case err == nil:
+			c.handled(key)
+		case stderrors.As(err, &fatal):
+			logger.Errorf("Fatal error handling learnuplet: %s", err)
+			c.handled(key)
+		default:
+			logger.Warnf("Error handling learnuplet (it will be retried): %s", err)
+			c.release(key)
+		}
+	case <-expired:
+		err = fmt.Errorf("[peer-consumer] Timeout handling learnuplet %s (after %s)", key, timeout)
+		logger.Warnf("Timeout handling learnuplet (after %s), it will be retried", timeout)
+		c.release(key)
+	}
+}
+
+// claim marks an uplet as being handled, returning false if it already was handed to the handler
+func (c *PeerConsumer) claim(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.seen == nil {
+		c.seen = map[string]bool{}
+	}
+	if _, ok := c.seen[key]; ok {
+		return false
+	}
+	c.seen[key] = true
+	return true
+}
+
+// handled marks an uplet as handled, so that it isn't handed again while the peer lists it as to do
+func (c *PeerConsumer) handled(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.seen[key] = false
+}
+
+// release forgets an uplet, for it to be handed again
+func (c *PeerConsumer) release(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.seen, key)
+}
+
+// forgetDone forgets the handled uplets the peer no longer lists as to do
+func (c *PeerConsumer) forgetDone(todo []common.LearnupletChaincode) {
+	keys := map[string]struct{}{}
+	for _, u := range todo {
+		keys[u.Key] = struct{}{}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key, handling := range c.seen {
+		if _, ok := keys[key]; !ok && !handling {
+			delete(c.seen, key)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+)
+
+// FailoverPeer spreads calls over several peers (e.g. PeerAPIs configured against different peer
+// replicas): calls go to the last peer that answered and, when it fails, are tried against the next
+// ones, so that a peer outage doesn't stall the whole worker fleet.
+//
+// Queries fail over on the errors that may not happen on another peer (unclassified and transient
+// ones, the peer SDK not telling connection errors apart from chaincode errors), but not on invalid
+// or permanently failing calls. Invocations only fail over when their transaction wasn't submitted
+// (see NotSubmitted): a peer failing after committing it would otherwise have it executed twice.
+type FailoverPeer struct {
+	Peers  []Peer
+	Logger logging.Logger
+
+	lock    sync.Mutex
+	current int
+}
+
+// NewFailoverPeer creates a peer failing over between peers
+func NewFailoverPeer(peers ...Peer) *FailoverPeer {
+	return &FailoverPeer{Peers: peers}
+}
+
+// failsOver tells whether a query error may not happen on another peer
+func failsOver(err error) bool {
+	switch errors.KindOf(err) {
+	case errors.Unknown, errors.Transient:
+		return true
+	}
+	return false
+}
+
+// invokeFailsOver tells whether an invocation may be sent again to another peer
+func invokeFailsOver(err error) bool {
+	return failsOver(err) && NotSubmitted(err)
+}
+
+// try calls call against every peer in turn, starting with the last healthy one, until one succeeds
+// or fails with an error failOver rejects
+func (p *FailoverPeer) try(fcn string, failOver func(error) bool, call func(Peer) error) error {
+	p.lock.Lock()
+	start := p.current
+	p.lock.Unlock()
+	if len(p.Peers) == 0 {
+		return fmt.Errorf("[peer-failover] No peer to call %s on", fcn)
+	}
+
+	var err error
+	for i := 0; i < len(p.Peers); i++ {
+		index := (start + i) % len(p.Peers)
+		if err = call(p.Peers[index]); err == nil {
+			p.lock.Lock()
+			p.current = index
+			p.lock.Unlock()
+			return nil
+		}
+		if !failOver(err) {
+			return err
+		}
+		logging.OrDefault(p.Logger).With(logging.Fields{logging.FieldComponent: "peer-failover", logging.FieldRoute: fcn}).Warnf(
+			"Error calling peer %d/%d, failing over: %s", index+1, len(p.Peers), err)
+	}
+	return err
+}
+
+func (p *FailoverPeer) invoke(fcn string, call func(Peer) (string, []byte, error)) (txID string, nonce []byte, err error) {
+	err = p.try(fcn, invokeFailsOver, func(peer Peer) (err error) {
+		txID, nonce, err = call(peer)
+		return err
+	})
+	return txID, nonce, err
+}
+
+// Query performs a query on the current peer, failing over on errors (see FailoverPeer)
+func (p *FailoverPeer) Query(queryFcn string, queryArgs []string) (res []byte, err error) {
+	err = p.try(queryFcn, failsOver, func(peer Peer) (err error) {
+		res, err = peer.Query(queryFcn, queryArgs)
+		return err
+	})
+	return res, err
+}
+
+// Invoke performs an invoke on the current peer, failing over if it couldn't be submitted
+func (p *FailoverPeer) Invoke(txFcn string, txArgs []string) (string, []byte, error) {
+	return p.invoke(txFcn, func(peer Peer) (string, []byte, error) { return peer.Invoke(txFcn, txArgs) })
+}
+
+// RegisterItem registers an item
+func (p *FailoverPeer) RegisterItem(itemType, storageAddress string, problemKeys []string, itemName string) (string, []byte, error) {
+	return p.invoke("registerItem", func(peer Peer) (string, []byte, error) {
+		return peer.RegisterItem(itemType, storageAddress, problemKeys, itemName)
+	})
+}
+
+// RegisterProblem registers a problem
+func (p *FailoverPeer) RegisterProblem(storageAddress string, sizeTrainDataset int, testData []string) (string, []byte, error) {
+	return p.invoke("registerProblem", func(peer Peer) (string, []byte, error) {
+		return peer.RegisterProblem(storageAddress, sizeTrainDataset, testData)
+	})
+}
+
+// SetUpletWorker sets the worker of an uplet
+func (p *FailoverPeer) SetUpletWorker(upletKey, worker string) (string, []byte, error) {
+	return p.invoke("setUpletWorker", func(peer Peer) (string, []byte, error) {
+		return peer.SetUpletWorker(upletKey, worker)
+	})
+}
+
+// PatchUplet partially updates an uplet
+func (p *FailoverPeer) PatchUplet(upletType, upletKey string, patch UpletPatch) (string, []byte, error) {
+	return p.invoke("patchUplet", func(peer Peer) (string, []byte, error) {
+		return peer.PatchUplet(upletType, upletKey, patch)
+	})
+}
+
+// QueryStatusLearnuplet queries the learnuplets by status
+func (p *FailoverPeer) QueryStatusLearnuplet(status string) (res []byte, err error) {
+	err = p.try("queryStatusLearnuplet", failsOver, func(peer Peer) (err error) {
+		res, err = peer.QueryStatusLearnuplet(status)
+		return err
+	})
+	return res, err
+}
+
+// QueryProblem queries a problem
+func (p *FailoverPeer) QueryProblem(problemKey string) (res []byte, err error) {
+	err = p.try("queryProblem", failsOver, func(peer Peer) (err error) {
+		res, err = peer.QueryProblem(problemKey)
+		return err
+	})
+	return res, err
+}
+
+// QueryModelLearnuplet queries the learnuplet that produced a model
+func (p *FailoverPeer) QueryModelLearnuplet(modelKey string) (res []byte, err error) {
+	err = p.try("queryModelLearnuplet", failsOver, func(peer Peer) (err error) {
+		res, err = peer.QueryModelLearnuplet(modelKey)
+		return err
+	})
+	return res, err
+}
+
+// ReportLearn reports the result of a learning task
+func (p *FailoverPeer) ReportLearn(upletKey, status string, perf float64, trainPerf, testPerf map[string]float64) (string, []byte, error) {
+	return p.invoke("reportLearn", func(peer Peer) (string, []byte, error) {
+		return peer.ReportLearn(upletKey, status, perf, trainPerf, testPerf)
+	})
+}
+
+// ReportEvaluation reports the result of an evaluation only learnuplet
+func (p *FailoverPeer) ReportEvaluation(upletKey, status string, perf float64, testPerf map[string]float64) (string, []byte, error) {
+	return p.invoke("reportEvaluation", func(peer Peer) (string, []byte, error) {
+		return peer.ReportEvaluation(upletKey, status, perf, testPerf)
+	})
+}
+
+// RegisterWorker registers a worker
+func (p *FailoverPeer) RegisterWorker(worker common.Worker) (string, []byte, error) {
+	return p.invoke("registerWorker", func(peer Peer) (string, []byte, error) {
+		return peer.RegisterWorker(worker)
+	})
+}
+
+// WorkerHeartbeat records a worker heartbeat
+func (p *FailoverPeer) WorkerHeartbeat(workerID string) (string, []byte, error) {
+	return p.invoke("workerHeartbeat", func(peer Peer) (string, []byte, error) {
+		return peer.WorkerHeartbeat(workerID)
+	})
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client
+
+import (
+	"fmt"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/satori/go.uuid"
+)
+
+// LearnupletDataSize returns the total size of the train and test data blobs referenced by a
+// learnuplet, as declared by storage (no data is downloaded)
+func LearnupletDataSize(s Storage, learnuplet common.Learnuplet) (size int64, err error) {
+	ids := append(append([]uuid.UUID{}, learnuplet.TrainData...), learnuplet.TestData...)
+	return dataBlobsSize(s, ids)
+}
+
+// PredupletDataSize returns the size of the data blob referenced by a preduplet, as declared by
+// storage (no data is downloaded)
+func PredupletDataSize(s Storage, preduplet common.Preduplet) (size int64, err error) {
+	return dataBlobsSize(s, []uuid.UUID{preduplet.Data})
+}
+
+func dataBlobsSize(s Storage, ids []uuid.UUID) (size int64, err error) {
+	for _, id := range ids {
+		blobSize, err := s.GetDataBlobSize(id)
+		if err != nil {
+			return 0, fmt.Errorf("Error retrieving size of data %s: %s", id, err)
+		}
+		size += blobSize
+	}
+	return size, nil
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+)
+
+// ResultHook post-processes learn results before they are reported to the peer (e.g. to meet
+// privacy requirements). It modifies the result in place; an error aborts the report.
+type ResultHook interface {
+	ProcessResult(result *LearnResult) error
+}
+
+// ModelHook post-processes model blobs before they are uploaded to storage. It returns the blob to
+// upload instead, and its size.
+type ModelHook interface {
+	ProcessModel(model *common.Model, blob io.Reader, size int64) (io.Reader, int64, error)
+}
+
+// HookedPeer is a Peer passing learn results through its hooks, in order, before reporting them
+type HookedPeer struct {
+	Peer
+	Hooks []ResultHook
+}
+
+// process passes a result through the hooks (its metrics are copied first, hooks modifying them
+// in place)
+func (p *HookedPeer) process(result *LearnResult) error {
+	result.TrainPerf, result.TestPerf = copyPerfs(result.TrainPerf), copyPerfs(result.TestPerf)
+	for _, hook := range p.Hooks {
+		if err := hook.ProcessResult(result); err != nil {
+			return fmt.Errorf("[peer-hooks] Error post-processing the result of uplet %s: %w", result.UpletKey, err)
+		}
+	}
+	return nil
+}
+
+func copyPerfs(perfs map[string]float64) map[string]float64 {
+	if perfs == nil {
+		return nil
+	}
+	copied := make(map[string]float64, len(perfs))
+	for key, perf := range perfs {
+		copied[key] = perf
+	}
+	return copied
+}
+
+// ReportLearn post-processes the result of a learnuplet and reports it
+func (p *HookedPeer) ReportLearn(upletKey, status string, perf float64, trainPerf, testPerf map[string]float64) (string, []byte, error) {
+	result := &LearnResult{UpletKey: upletKey, Status: status, Perf: perf, TrainPerf: trainPerf, TestPerf: testPerf}
+	if err := p.process(result); err != nil {
+		return "", nil, err
+	}
+	return p.Peer.ReportLearn(result.UpletKey, result.Status, result.Perf, result.TrainPerf, result.TestPerf)
+}
+
+// ReportEvaluation post-processes the result of an evaluation only learnuplet and reports it
+func (p *HookedPeer) ReportEvaluation(upletKey, status string, perf float64, testPerf map[string]float64) (string, []byte, error) {
+	result := &LearnResult{UpletKey: upletKey, Status: status, Perf: perf, TestPerf: testPerf, EvaluationOnly: true}
+	if err := p.process(result); err != nil {
+		return "", nil, err
+	}
+	return p.Peer.ReportEvaluation(result.UpletKey, result.Status, result.Perf, result.TestPerf)
+}
+
+// HookedStorage is a Storage passing model blobs through its hooks, in order, before uploading them
+type HookedStorage struct {
+	Storage
+	Hooks []ModelHook
+}
+
+// WithContext returns a view of the storage whose requests are interrupted once ctx is done, still
+// post-processing its models
+func (s *HookedStorage) WithContext(ctx context.Context) Storage {
+	return &HookedStorage{Storage: StorageWithContext(ctx, s.Storage), Hooks: s.Hooks}
+}
+
+// PostModel post-processes a model blob and uploads it
+func (s *HookedStorage) PostModel(model *common.Model, modelReader io.Reader, size int64) (err error) {
+	for _, hook := range s.Hooks {
+		modelReader, size, err = hook.ProcessModel(model, modelReader, size)
+		if err != nil {
+			return fmt.Errorf("[storage-hooks] Error post-processing model %s: %w", model.ID, err)
+		}
+	}
+	return s.Storage.PostModel(model, modelReader, size)
+}
+
+// MetricNoise is a ResultHook making reported metrics differentially private with the Laplace
+// mechanism: every metric is clipped to [Min, Max], then noised with Laplace noise of scale
+// (Max - Min) / ε. The privacy budget Epsilon of a result is split evenly between its metrics (the
+// performance and every train and test performance), and noised metrics are clipped again to
+// [Min, Max].
+type MetricNoise struct {
+	Min, Max float64
+	Epsilon  float64
+	// Rand is the source of randomness (crypto/rand if nil)
+	Rand io.Reader
+}
+
+// NewMetricNoise creates a hook noising metrics bounded by [min, max] with a privacy budget epsilon
+func NewMetricNoise(min, max, epsilon float64) *MetricNoise {
+	return &MetricNoise{Min: min, Max: max, Epsilon: epsilon}
+}
+
+// ProcessResult clips and noises the metrics of a result
+func (n *MetricNoise) ProcessResult(result *LearnResult) (err error) {
+	if n.Epsilon <= 0 || n.Max <= n.Min {
+		return fmt.Errorf("invalid metric noise settings (epsilon %g, bounds [%g, %g])", n.Epsilon, n.Min, n.Max)
+	}
+	scale := (n.Max - n.Min) * float64(1+len(result.TrainPerf)+len(result.TestPerf)) / n.Epsilon
+
+	if result.Perf, err = n.noise(result.Perf, scale); err != nil {
+		return err
+	}
+	for _, perfs := range []map[string]float64{result.TrainPerf, result.TestPerf} {
+		for key, perf := range perfs {
+			if perfs[key], err = n.noise(perf, scale); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (n *MetricNoise) noise(value, scale float64) (float64, error) {
+	r := n.Rand
+	if r == nil {
+		r = rand.Reader
+	}
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, fmt.Errorf("error reading randomness: %s", err)
+	}
+	// u is uniform in (-0.5, 0.5), the Laplace sample is -scale * sign(u) * ln(1 - 2|u|)
+	u := (float64(binary.BigEndian.Uint64(buf)>>11)+0.5)/(1<<53) - 0.5
+	noise := -scale * math.Log(1-2*math.Abs(u))
+	if u < 0 {
+		noise = -noise
+	}
+	return n.clip(n.clip(value) + noise), nil
+}
+
+func (n *MetricNoise) clip(value float64) float64 {
+	return math.Max(n.Min, math.Min(n.Max, value))
+}
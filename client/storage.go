@@ -39,6 +39,8 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -48,9 +50,20 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/audit"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/auth"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/codec"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/features"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/secrets"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/signing"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/tracing"
 	"github.com/satori/go.uuid"
 )
 
@@ -61,6 +74,7 @@ const (
 	StorageModelRoute           = "model"
 	StorageDataRoute            = "data"
 	BlobSuffix                  = "blob"
+	ManifestSuffix              = "manifest"
 )
 
 // Storage describes the storage service API
@@ -70,6 +84,8 @@ type Storage interface {
 	GetModel(id uuid.UUID) (model *common.Model, err error)
 	GetProblemWorkflow(id uuid.UUID) (problem *common.Problem, err error)
 	GetDataBlob(id uuid.UUID) (dataReader io.ReadCloser, err error)
+	GetDataBlobSize(id uuid.UUID) (size int64, err error)
+	GetDatasetManifest(id uuid.UUID) (manifest *common.DatasetManifest, err error)
 	GetAlgoBlob(id uuid.UUID) (algoReader io.ReadCloser, err error)
 	GetModelBlob(id uuid.UUID) (modelReader io.ReadCloser, err error)
 	GetProblemWorkflowBlob(id uuid.UUID) (problemReader io.ReadCloser, err error)
@@ -85,92 +101,163 @@ type StorageAPI struct {
 	Port     int
 	User     string
 	Password string
-}
-
-func (s *StorageAPI) getObjectBlob(prefix string, id uuid.UUID) (dataReader io.ReadCloser, err error) {
-	url := fmt.Sprintf("http://%s:%d/%s/%s/%s", s.Hostname, s.Port, prefix, id, BlobSuffix)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("[storage-api] Error building GET request against %s: %s", url, err)
-	}
-	req.SetBasicAuth(s.User, s.Password)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("[storage-api] Error performing GET request against %s: %s", url, err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("[storage-api] Bad status code (%s) performing GET request against %s", resp.Status, url)
+	Logger   logging.Logger
+	Features *features.Set
+	// Compression selects the encoding (gzip or zstd) and level of compressed request bodies, when
+	// the features.Compression flag is enabled
+	Compression *httpclient.Compression
+	// TLS, if set, switches to HTTPS with this configuration (see the mtls package)
+	TLS *tls.Config
+	// Transport, if set, tunes the connections to storage (the shared transport is used otherwise, see
+	// httpclient.SharedTransport)
+	Transport *httpclient.TransportConfig
+	// Signer, if set, signs every request with HMAC-SHA256 (blob uploads larger than
+	// Signer.MaxBufferedBody are sent with an unsigned body)
+	Signer *signing.Signer
+	// DedupWindow, if positive, suppresses the requests identical to a request that succeeded less
+	// than DedupWindow ago (see httpclient.DedupWindow)
+	DedupWindow time.Duration
+	// Balancer, if set, spreads requests across several backends (e.g. the addresses Hostname
+	// resolves to, see httpclient.DNSBalancer) instead of the first one Hostname resolves to
+	Balancer httpclient.Balancer
+	// Scope attributes every request to a tenant (X-Morpheo-Org and X-Morpheo-Project headers)
+	Scope httpclient.Scope
+	// Cache, if set, caches objects and blob sizes, which are then revalidated with their ETag
+	// instead of being fetched again (see httpclient.MemoryCache)
+	Cache httpclient.Cache
+	// Secrets, if set, resolves User and Password on every request, so that they may refer to a
+	// secret provider (e.g. "vault:morpheo/storage#password") and be rotated (cached secrets are
+	// flushed when storage answers with a 401)
+	Secrets *secrets.Resolver
+	// MaxResultSize, if positive, bounds the size of the results (models and predictions) posted
+	// to storage: larger ones are rejected with a *common.PayloadTooLargeError
+	MaxResultSize int64
+	// Codec, if set, is the preferred encoding (MessagePack or CBOR, see the codec package) of the
+	// objects sent by storage. Servers not supporting it answer with JSON.
+	Codec codec.Codec
+	// Audit, if set, records the uploads to storage in an audit trail
+	Audit *audit.Recorder
+	// Tokens, if set, authenticates every request with a bearer token granting the scope it
+	// requires (blob:read, blob:write or result:post, see auth.StaticTokens)
+	Tokens httpclient.TokenSource
+
+	// HTTPClient performs the requests against storage. It is built from the fields above on first
+	// use (with every request traced, see tracing.Transport), unless set beforehand.
+	HTTPClient *httpclient.Client
+	lock       sync.Mutex
+}
+
+func (s *StorageAPI) client() *httpclient.Client {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.HTTPClient == nil {
+		scheme := "http"
+		if s.TLS != nil {
+			scheme = "https"
+		}
+		s.HTTPClient = httpclient.New("storage-api", fmt.Sprintf("%s://%s:%d", scheme, s.Hostname, s.Port))
+		httpClient := httpclient.NewHTTPClient(s.Transport, s.TLS)
+		httpClient.Transport = tracing.Transport(httpClient.Transport)
+		s.HTTPClient.HTTPClient = httpClient
+		if s.Secrets != nil {
+			httpClient.Transport = s.Secrets.Transport(httpClient.Transport)
+			s.HTTPClient.RequestEditors = append(s.HTTPClient.RequestEditors, s.Secrets.BasicAuth(s.User, s.Password))
+		} else {
+			s.HTTPClient.User = s.User
+			s.HTTPClient.Password = s.Password
+		}
+		s.HTTPClient.Logger = s.Logger
+		s.HTTPClient.Features = s.Features
+		s.HTTPClient.Compression = s.Compression
+		s.HTTPClient.Balancer = s.Balancer
+		s.HTTPClient.Scope = s.Scope
+		s.HTTPClient.Codec = s.Codec
+		s.HTTPClient.Tokens = s.Tokens
+		s.HTTPClient.Cache = s.Cache
+		if s.DedupWindow > 0 {
+			s.HTTPClient.Dedup = httpclient.NewDedupWindow(s.DedupWindow)
+		}
+		if s.Signer != nil {
+			s.HTTPClient.RequestEditors = append(s.HTTPClient.RequestEditors, s.Signer.RequestEditor())
+		}
 	}
-
-	return resp.Body, nil
+	return s.HTTPClient
 }
 
-func (s *StorageAPI) getAndParseJSONObject(objectRoute string, objectID uuid.UUID, dest interface{}) error {
-	url := fmt.Sprintf("http://%s:%d/%s/%s", s.Hostname, s.Port, objectRoute, objectID)
-
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return fmt.Errorf("[storage-api] Error building GET request against %s: %s", url, err)
-	}
-	req.SetBasicAuth(s.User, s.Password)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("[storage-api] Error performing GET request against %s: %s", url, err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("[storage-api] Bad status code (%s) performing GET request against %s", url, err)
-	}
-	defer resp.Body.Close()
-
-	err = json.NewDecoder(resp.Body).Decode(dest)
-	if err != nil {
-		return fmt.Errorf("[storage-api] Error unmarshaling object retrieved from %s: %s", url, err)
-	}
+// WithScope returns a client performing its requests on behalf of another tenant (the non-empty
+// fields of scope override Scope), sharing this client's connections, e.g.:
+//
+//	storage.WithScope(httpclient.Scope{Project: "mnist"})
+func (s *StorageAPI) WithScope(scope httpclient.Scope) *StorageAPI {
+	return &StorageAPI{Logger: s.Logger, MaxResultSize: s.MaxResultSize, Audit: s.Audit, HTTPClient: s.client().WithScope(scope)}
+}
 
-	return nil
+// WithHeaders returns a client sending additional headers with its requests (correlation IDs,
+// debug flags...), sharing this client's connections, e.g.:
+//
+//	storage.WithHeaders(http.Header{"X-Correlation-Id": []string{id}}).GetModel(id)
+func (s *StorageAPI) WithHeaders(headers http.Header) *StorageAPI {
+	return &StorageAPI{Logger: s.Logger, MaxResultSize: s.MaxResultSize, Audit: s.Audit, HTTPClient: s.client().WithHeaders(headers)}
 }
 
-func (s *StorageAPI) postResourceBlob(prefix string, dataReader io.Reader, size int64) error {
-	url := fmt.Sprintf("http://%s:%d/%s", s.Hostname, s.Port, prefix)
+// WithContext returns a client whose requests (and the transfer of the blobs they return) are
+// interrupted once ctx is done, sharing this client's connections, e.g.:
+//
+//	storage.WithContext(ctx).GetModelBlob(id)
+func (s *StorageAPI) WithContext(ctx context.Context) *StorageAPI {
+	return &StorageAPI{Logger: s.Logger, MaxResultSize: s.MaxResultSize, Audit: s.Audit, HTTPClient: s.client().WithContext(ctx)}
+}
 
-	req, err := http.NewRequest(http.MethodPost, url, dataReader)
+func (s *StorageAPI) getObjectBlob(prefix string, id uuid.UUID) (dataReader io.ReadCloser, err error) {
+	resp, err := s.client().Do(&httpclient.Request{
+		Method: http.MethodGet,
+		Route:  fmt.Sprintf("%s/%s/%s", prefix, id, BlobSuffix),
+		Scopes: []string{auth.ScopeBlobRead},
+	})
 	if err != nil {
-		return fmt.Errorf("[storage-api] Error building streaming POST request against %s: %s", url, err)
+		return nil, err
 	}
+	return resp.Body, nil
+}
 
-	// Add required headers
-	req.SetBasicAuth(s.User, s.Password)
-	req.ContentLength = size
-
-	resp, err := http.DefaultClient.Do(req)
+// getObjectBlobSize performs a HEAD request on a blob to retrieve its size without downloading it
+func (s *StorageAPI) getObjectBlobSize(prefix string, id uuid.UUID) (size int64, err error) {
+	resp, err := s.client().Do(&httpclient.Request{
+		Method:    http.MethodHead,
+		Route:     fmt.Sprintf("%s/%s/%s", prefix, id, BlobSuffix),
+		Cacheable: true,
+		Scopes:    []string{auth.ScopeBlobRead},
+	})
 	if err != nil {
-		return fmt.Errorf("[storage-api] Error performing streaming POST request against %s: %s", url, err)
+		return 0, err
 	}
+	httpclient.DrainAndClose(resp.Body)
 
-	if resp.StatusCode != http.StatusCreated {
-		var apiError common.APIError
-		var errorMessage string
-		err = json.NewDecoder(resp.Body).Decode(&apiError)
-		if err != nil {
-			errorMessage = "Unable to decode error message"
-		}
-		errorMessage = apiError.Message
-		return fmt.Errorf("[storage-api] Bad status code (%s) performing streaming POST request against %s -- API Error: %s", resp.Status, url, errorMessage)
+	if resp.ContentLength < 0 {
+		return 0, errors.Newf(errors.Permanent, "[storage-api] No Content-Length in HEAD response from %s", resp.Request.URL)
 	}
+	return resp.ContentLength, nil
+}
 
-	return nil
+func (s *StorageAPI) getAndParseJSONObject(objectRoute string, objectID uuid.UUID, dest interface{}) error {
+	return s.client().DoJSON(&httpclient.Request{
+		Method:    http.MethodGet,
+		Route:     fmt.Sprintf("%s/%s", objectRoute, objectID),
+		Cacheable: true,
+		Scopes:    []string{auth.ScopeBlobRead},
+	}, dest)
 }
 
 // postResourceMultipartBlob perform a POST request to storage using a multipart form.
-// The filefield is the last field sent in the body, in order to allow streaming request.
-func (s *StorageAPI) postResourceMultipartBlob(prefix string, params map[string]string, fileFieldName string, fileName string, fileReader io.Reader) error {
+// The filefield is the last field sent in the body, in order to allow streaming request: only the
+// other fields and the multipart boundaries are held in memory. The size of the file, if known
+// (positive), sets the length of the request. scope is the scope the upload requires.
+func (s *StorageAPI) postResourceMultipartBlob(prefix, scope string, params map[string]string, fileFieldName string, fileName string, fileReader io.Reader, size int64) error {
 	// TODO: check that params are valid for the corresponding prefix
 
 	// Build the multipart form field
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	envelope := &bytes.Buffer{}
+	writer := multipart.NewWriter(envelope)
 	for key, val := range params {
 		err := writer.WriteField(key, val)
 		if err != nil {
@@ -178,49 +265,32 @@ func (s *StorageAPI) postResourceMultipartBlob(prefix string, params map[string]
 		}
 	}
 
-	part, err := writer.CreateFormFile(fileFieldName, fileName)
+	_, err := writer.CreateFormFile(fileFieldName, fileName)
 	if err != nil {
 		return fmt.Errorf("Error writing param blob in %s multipart writer: %s", prefix, err)
 	}
-	_, err = io.Copy(part, fileReader)
-	if err != nil {
-		return fmt.Errorf("Error copying file in %s multipart write: %s", prefix, err)
-	}
+	headLength := envelope.Len()
 	err = writer.Close()
 	if err != nil {
 		return fmt.Errorf("Error closing %s multipart writer: %s", prefix, err)
 	}
+	head, tail := envelope.Bytes()[:headLength], envelope.Bytes()[headLength:]
 
-	// Build POST request
-	url := fmt.Sprintf("http://%s:%d/%s", s.Hostname, s.Port, prefix)
-	req, err := http.NewRequest(http.MethodPost, url, body)
-	if err != nil {
-		return fmt.Errorf("[storage-api] Error building streaming POST request against %s: %s", url, err)
+	var contentLength int64
+	if size > 0 {
+		contentLength = int64(len(head)) + size + int64(len(tail))
 	}
 
-	// Add required headers
-	req.SetBasicAuth(s.User, s.Password)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
 	// Perform POST Request
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("[storage-api] Error performing streaming POST request against %s: %s", url, err)
-	}
-
-	// Handle response errors
-	if resp.StatusCode != http.StatusCreated {
-		var apiError common.APIError
-		var errorMessage string
-		err = json.NewDecoder(resp.Body).Decode(&apiError)
-		if err != nil {
-			errorMessage = "Unable to decode error message"
-		}
-		errorMessage = apiError.Message
-		return fmt.Errorf("[storage-api] Bad status code (%s) performing streaming POST request against %s -- API Error: %s", resp.Status, url, errorMessage)
-	}
-
-	return nil
+	return s.client().DoJSON(&httpclient.Request{
+		Method:         http.MethodPost,
+		Route:          prefix,
+		Body:           io.MultiReader(bytes.NewReader(head), fileReader, bytes.NewReader(tail)),
+		ContentLength:  contentLength,
+		Header:         http.Header{"Content-Type": []string{writer.FormDataContentType()}},
+		ExpectedStatus: []int{http.StatusCreated},
+		Scopes:         []string{scope},
+	}, nil)
 }
 
 // GetProblemWorkflow returns a ProblemWorkflow's metadata
@@ -251,6 +321,19 @@ func (s *StorageAPI) GetData(id uuid.UUID) (data *common.Data, err error) {
 	return data, err
 }
 
+// GetLearnupletProblem returns the problem (evaluation workflow, metrics and test data) a
+// learnuplet is evaluated against. Problems without test data get the learnuplet's.
+func GetLearnupletProblem(s Storage, learnuplet *common.Learnuplet) (*common.Problem, error) {
+	problem, err := s.GetProblemWorkflow(learnuplet.Problem)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching problem %s of learnuplet %s: %w", learnuplet.Problem, learnuplet.Key, err)
+	}
+	if len(problem.TestData) == 0 {
+		problem.TestData = learnuplet.TestData
+	}
+	return problem, nil
+}
+
 // GetProblemWorkflowBlob returns an io.ReadCloser to a problem workflow image
 //
 // Note that it is up to the caller to call Close() on the returned io.ReadCloser
@@ -280,24 +363,74 @@ func (s *StorageAPI) GetDataBlob(id uuid.UUID) (dataReader io.ReadCloser, err er
 	return s.getObjectBlob(StorageDataRoute, id)
 }
 
-// PostModel returns an io.ReadCloser to a model
+// GetDataBlobSize returns the size in bytes of a dataset blob, without downloading it
+func (s *StorageAPI) GetDataBlobSize(id uuid.UUID) (size int64, err error) {
+	return s.getObjectBlobSize(StorageDataRoute, id)
+}
+
+// GetDatasetManifest returns the manifest of a dataset (the ordered list of its fragments)
+func (s *StorageAPI) GetDatasetManifest(id uuid.UUID) (manifest *common.DatasetManifest, err error) {
+	manifest = &common.DatasetManifest{}
+	err = s.client().DoJSON(&httpclient.Request{
+		Method:    http.MethodGet,
+		Route:     fmt.Sprintf("%s/%s/%s", StorageDataRoute, id, ManifestSuffix),
+		Cacheable: true,
+		Scopes:    []string{auth.ScopeBlobRead},
+	}, manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := manifest.Check(); err != nil {
+		return nil, errors.Newf(errors.Permanent, "[storage-api] Invalid manifest for dataset %s: %s", id, err)
+	}
+	return manifest, nil
+}
+
+// PostModel uploads a model blob, in a multipart form carrying its UUID, its algo and its lineage
+// (if known, see NewModelLineage)
 // TODO: change *common.Model to common.Model, and *args order
-func (s *StorageAPI) PostModel(model *common.Model, modelReader io.Reader, size int64) error {
+func (s *StorageAPI) PostModel(model *common.Model, modelReader io.Reader, size int64) (err error) {
+	defer func() {
+		details := uploadDetails(size)
+		if model.Lineage != nil {
+			details["learnuplet"] = model.Lineage.Learnuplet
+		}
+		s.Audit.Record(audit.TargetStorage, audit.ActionUpload, StorageModelRoute, model.ID.String(), details, err)
+	}()
+
+	if err := common.CheckPayloadSize(size, s.MaxResultSize); err != nil {
+		return fmt.Errorf("Error posting model %s: %w", model.ID, err)
+	}
+
 	// Check for associated Algo existence
 	if _, err := s.GetAlgo(model.Algo); err != nil {
-		return fmt.Errorf("Algorithm %s associated to posted model wasn't found", model.Algo)
+		return fmt.Errorf("Algorithm %s associated to posted model wasn't found: %w", model.Algo, err)
 	}
 
-	return s.postResourceBlob(fmt.Sprintf("%s?uuid=%s&algo=%s", StorageModelRoute, model.ID, model.Algo), modelReader, size)
+	params := map[string]string{
+		"uuid": model.ID.String(),
+		"algo": model.Algo.String(),
+	}
+	if model.Lineage != nil {
+		lineage, err := json.Marshal(model.Lineage)
+		if err != nil {
+			return fmt.Errorf("Error marshaling the lineage of model %s: %s", model.ID, err)
+		}
+		params["lineage"] = string(lineage)
+	}
+	return s.postResourceMultipartBlob(StorageModelRoute, auth.ScopeResultPost, params, "blob", params["uuid"], common.LimitPayload(modelReader, s.MaxResultSize), size)
 }
 
 // PostProblem posts a new problem to storage
-func (s *StorageAPI) PostProblem(problem common.Problem, size int, problemReader io.Reader) error {
+func (s *StorageAPI) PostProblem(problem common.Problem, size int, problemReader io.Reader) (err error) {
+	defer func() {
+		s.Audit.Record(audit.TargetStorage, audit.ActionUpload, StorageProblemWorkflowRoute, problem.ID.String(), uploadDetails(int64(size)), err)
+	}()
 
 	// Check that problem is valid
 	problem.TimestampUpload = int32(time.Now().Unix())
 	if err := problem.Check(); err != nil {
-		return fmt.Errorf("error checking problem resource: %s", err)
+		return errors.Newf(errors.Validation, "error checking problem resource: %s", err)
 	}
 
 	// Build params
@@ -306,16 +439,37 @@ func (s *StorageAPI) PostProblem(problem common.Problem, size int, problemReader
 	params["name"] = problem.Name
 	params["description"] = problem.Description
 	params["size"] = strconv.Itoa(size)
+	if problem.WorkflowImage != "" {
+		params["workflow_image"] = problem.WorkflowImage
+	}
+	// Metrics and test datasets are sent as JSON, like the lineage of models
+	if len(problem.Metrics) > 0 {
+		metrics, err := json.Marshal(problem.Metrics)
+		if err != nil {
+			return fmt.Errorf("Error marshaling the metrics of problem %s: %s", problem.ID, err)
+		}
+		params["metrics"] = string(metrics)
+	}
+	if len(problem.TestData) > 0 {
+		testData, err := json.Marshal(problem.TestData)
+		if err != nil {
+			return fmt.Errorf("Error marshaling the test datasets of problem %s: %s", problem.ID, err)
+		}
+		params["test_data"] = string(testData)
+	}
 
-	return s.postResourceMultipartBlob("problem", params, "blob", params["uuid"], problemReader)
+	return s.postResourceMultipartBlob("problem", auth.ScopeBlobWrite, params, "blob", params["uuid"], problemReader, int64(size))
 }
 
 // PostData posts a new data to storage
-func (s *StorageAPI) PostData(data common.Data, size int, dataReader io.Reader) error {
+func (s *StorageAPI) PostData(data common.Data, size int, dataReader io.Reader) (err error) {
+	defer func() {
+		s.Audit.Record(audit.TargetStorage, audit.ActionUpload, StorageDataRoute, data.ID.String(), uploadDetails(int64(size)), err)
+	}()
 	// Check that problem is valid
 	data.TimestampUpload = int32(time.Now().Unix())
 	if err := data.Check(); err != nil {
-		return fmt.Errorf("error checking data resource: %s", err)
+		return errors.Newf(errors.Validation, "error checking data resource: %s", err)
 	}
 
 	// Build params
@@ -323,16 +477,23 @@ func (s *StorageAPI) PostData(data common.Data, size int, dataReader io.Reader)
 	params["uuid"] = data.ID.String()
 	params["size"] = strconv.Itoa(size)
 
-	return s.postResourceMultipartBlob("data", params, "blob", params["uuid"], dataReader)
+	return s.postResourceMultipartBlob("data", auth.ScopeBlobWrite, params, "blob", params["uuid"], dataReader, int64(size))
 }
 
 // PostPrediction posts a new prediction to storage
 // TOFIX: order in PostPrediction...
-func (s *StorageAPI) PostPrediction(prediction *common.Prediction, predReader io.Reader, size int64) error {
+func (s *StorageAPI) PostPrediction(prediction *common.Prediction, predReader io.Reader, size int64) (err error) {
+	defer func() {
+		s.Audit.Record(audit.TargetStorage, audit.ActionUpload, "prediction", prediction.ID.String(), uploadDetails(size), err)
+	}()
+	if err := common.CheckPayloadSize(size, s.MaxResultSize); err != nil {
+		return fmt.Errorf("Error posting prediction %s: %w", prediction.ID, err)
+	}
+
 	// Check that prediction is valid
 	prediction.TimestampUpload = int32(time.Now().Unix())
 	if err := prediction.Check(); err != nil {
-		return fmt.Errorf("error checking prediction resource: %s", err)
+		return errors.Newf(errors.Validation, "error checking prediction resource: %s", err)
 	}
 
 	// Build params
@@ -340,15 +501,18 @@ func (s *StorageAPI) PostPrediction(prediction *common.Prediction, predReader io
 	params["uuid"] = prediction.ID.String()
 	params["size"] = strconv.FormatInt(size, 10)
 
-	return s.postResourceMultipartBlob("prediction", params, "blob", params["uuid"], predReader)
+	return s.postResourceMultipartBlob("prediction", auth.ScopeResultPost, params, "blob", params["uuid"], common.LimitPayload(predReader, s.MaxResultSize), size)
 }
 
 // PostAlgo posts a new algo to storage
-func (s *StorageAPI) PostAlgo(algo common.Algo, size int64, algoReader io.Reader) error {
+func (s *StorageAPI) PostAlgo(algo common.Algo, size int64, algoReader io.Reader) (err error) {
+	defer func() {
+		s.Audit.Record(audit.TargetStorage, audit.ActionUpload, StorageAlgoRoute, algo.ID.String(), uploadDetails(size), err)
+	}()
 	// Check that algo is valid
 	algo.TimestampUpload = int32(time.Now().Unix())
 	if err := algo.Check(); err != nil {
-		return fmt.Errorf("error checking algo resource: %s", err)
+		return errors.Newf(errors.Validation, "error checking algo resource: %s", err)
 	}
 
 	// Build params
@@ -357,12 +521,28 @@ func (s *StorageAPI) PostAlgo(algo common.Algo, size int64, algoReader io.Reader
 	params["name"] = algo.Name
 	params["size"] = strconv.FormatInt(size, 10)
 
-	return s.postResourceMultipartBlob("algo", params, "blob", params["uuid"], algoReader)
+	return s.postResourceMultipartBlob("algo", auth.ScopeBlobWrite, params, "blob", params["uuid"], algoReader, size)
 }
 
-// StorageAPIMock is a mock of the storage API (for tests & local dev. purposes)
+// uploadDetails returns the audit details of an upload
+func uploadDetails(size int64) map[string]string {
+	return map[string]string{"size": strconv.FormatInt(size, 10)}
+}
+
+// MockBlobSize is the size the storage mock pretends all its data blobs have
+const MockBlobSize = 1 << 20
+
+// StorageAPIMock is a mock of the storage API (for tests & local dev. purposes). It records its
+// invocations (see Calls), the payload of posts being the posted blob.
 type StorageAPIMock struct {
+	common.CallRecorder
+
 	EvilUUID string
+	// Faults, if set, injects failures and latency into the calls
+	Faults *Faults
+	// MaxResultSize bounds the size of the posted results, which the mock reads in memory
+	// (common.DefaultMaxPayloadSize if zero)
+	MaxResultSize int64
 }
 
 // NewStorageAPIMock instantiates our mock of the storage API
@@ -372,28 +552,37 @@ func NewStorageAPIMock() (*StorageAPIMock, error) {
 	}, nil
 }
 
+// call injects faults into a call, fails it if it is about the EvilUUID (for resources named) and
+// records it
+func (s *StorageAPIMock) call(method string, id uuid.UUID, payload []byte, resource string) error {
+	err := s.Faults.Inject(method)
+	if err == nil && resource != "" && id.String() == s.EvilUUID {
+		err = errors.Newf(errors.NotFound, "%s %s not found on storage", resource, id)
+	}
+	s.Record(method, id.String(), payload, err)
+	return err
+}
+
 // GetData returns fake data (the same, no matter the UUID)
 func (s *StorageAPIMock) GetData(id uuid.UUID) (*common.Data, error) {
-	if id.String() == s.EvilUUID {
-		return nil, fmt.Errorf("Data %s not found on storage", id)
+	if err := s.call("GetData", id, nil, "Data"); err != nil {
+		return nil, err
 	}
-
 	return common.NewData(), nil
 }
 
 // GetAlgo returns a fake algo, no matter the UUID
 func (s *StorageAPIMock) GetAlgo(id uuid.UUID) (*common.Algo, error) {
-	if id.String() == s.EvilUUID {
-		return nil, fmt.Errorf("Algo %s not found on storage", id)
+	if err := s.call("GetAlgo", id, nil, "Algo"); err != nil {
+		return nil, err
 	}
-
 	return common.NewAlgo(), nil
 }
 
 // GetModel returns a fake model, no matter the UUID
 func (s *StorageAPIMock) GetModel(id uuid.UUID) (*common.Model, error) {
-	if id.String() == s.EvilUUID {
-		return nil, fmt.Errorf("Model %s not found on storage", id)
+	if err := s.call("GetModel", id, nil, "Model"); err != nil {
+		return nil, err
 	}
 	algo := common.NewAlgo()
 	return common.NewModel(id, algo), nil
@@ -401,56 +590,91 @@ func (s *StorageAPIMock) GetModel(id uuid.UUID) (*common.Model, error) {
 
 // GetProblemWorkflow returns a fake algo, no matter the UUID
 func (s *StorageAPIMock) GetProblemWorkflow(id uuid.UUID) (*common.Problem, error) {
-	// Evil uuid returns Error
-	if id.String() == s.EvilUUID {
-		return nil, fmt.Errorf("Problem workflow %s not found on storage", id)
+	if err := s.call("GetProblemWorkflow", id, nil, "Problem workflow"); err != nil {
+		return nil, err
 	}
 	return common.NewProblem(), nil
 }
 
 // GetDataBlob returns a fake Data, no matter the UUID
 func (s *StorageAPIMock) GetDataBlob(id uuid.UUID) (io.ReadCloser, error) {
-	if id.String() == s.EvilUUID {
-		return nil, fmt.Errorf("Data blob %s not found on storage", id)
+	if err := s.call("GetDataBlob", id, nil, "Data blob"); err != nil {
+		return nil, err
 	}
-
 	return TargzedMock()
 }
 
+// GetDatasetManifest returns a fake manifest, made of a single fragment (the dataset itself)
+func (s *StorageAPIMock) GetDatasetManifest(id uuid.UUID) (*common.DatasetManifest, error) {
+	if err := s.call("GetDatasetManifest", id, nil, "Dataset manifest"); err != nil {
+		return nil, err
+	}
+	return &common.DatasetManifest{Dataset: id, Fragments: []common.DatasetFragment{{ID: id}}}, nil
+}
+
+// GetDataBlobSize returns the size of the fake Data blob, no matter the UUID
+func (s *StorageAPIMock) GetDataBlobSize(id uuid.UUID) (int64, error) {
+	if err := s.call("GetDataBlobSize", id, nil, "Data blob"); err != nil {
+		return 0, err
+	}
+	return MockBlobSize, nil
+}
+
 // GetAlgoBlob returns a fake Algo, no matter the UUID
 func (s *StorageAPIMock) GetAlgoBlob(id uuid.UUID) (io.ReadCloser, error) {
-	if id.String() == s.EvilUUID {
-		return nil, fmt.Errorf("Algo blob %s not found on storage", id)
+	if err := s.call("GetAlgoBlob", id, nil, "Algo blob"); err != nil {
+		return nil, err
 	}
 	return TargzedMock()
 }
 
 // GetModelBlob returns a fake Model, no matter the UUID
 func (s *StorageAPIMock) GetModelBlob(id uuid.UUID) (io.ReadCloser, error) {
-	if id.String() == s.EvilUUID {
-		return nil, fmt.Errorf("Model blob %s not found on storage", id)
+	if err := s.call("GetModelBlob", id, nil, "Model blob"); err != nil {
+		return nil, err
 	}
 	return TargzedMock()
 }
 
 // GetProblemWorkflowBlob returns a fake ProblemWorkflow, no matter the UUID
 func (s *StorageAPIMock) GetProblemWorkflowBlob(id uuid.UUID) (io.ReadCloser, error) {
-	if id.String() == s.EvilUUID {
-		return nil, fmt.Errorf("ProblemWorkflow blob %s not found on storage", id)
+	if err := s.call("GetProblemWorkflowBlob", id, nil, "ProblemWorkflow blob"); err != nil {
+		return nil, err
 	}
 	return TargzedMock()
 }
 
-// PostModel sends a model... to Oblivion
+// PostModel sends a model... to Oblivion (and the call recorder). As with the storage API, the
+// model's algo has to exist.
 func (s *StorageAPIMock) PostModel(model *common.Model, modelReader io.Reader, size int64) error {
-	_, err := io.Copy(ioutil.Discard, modelReader)
-	return err
+	blob, err := common.ReadPayload(s.Faults.Reader("PostModel", modelReader, size), s.MaxResultSize)
+	if err != nil {
+		return err
+	}
+	if err := s.call("PostModel", model.ID, blob, ""); err != nil {
+		return err
+	}
+	if model.Algo.String() == s.EvilUUID {
+		return fmt.Errorf("Algorithm %s associated to posted model wasn't found: %w", model.Algo, errors.Newf(errors.NotFound, "Algo %s not found on storage", model.Algo))
+	}
+	return nil
 }
 
-// PostPrediction sends a prediction... to Oblivion
+// PostPrediction sends a prediction... to Oblivion (and the call recorder). As with the storage
+// API, the prediction has to be valid.
 func (s *StorageAPIMock) PostPrediction(prediction *common.Prediction, predReader io.Reader, size int64) error {
-	_, err := io.Copy(ioutil.Discard, predReader)
-	return err
+	blob, err := common.ReadPayload(s.Faults.Reader("PostPrediction", predReader, size), s.MaxResultSize)
+	if err != nil {
+		return err
+	}
+	if err := s.call("PostPrediction", prediction.ID, blob, ""); err != nil {
+		return err
+	}
+	prediction.TimestampUpload = int32(time.Now().Unix())
+	if err := prediction.Check(); err != nil {
+		return errors.Newf(errors.Validation, "error checking prediction resource: %s", err)
+	}
+	return nil
 }
 
 // TargzedMock create a Readcloser which can be ungzip-ed
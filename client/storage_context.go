@@ -0,0 +1,163 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client
+
+import (
+	"context"
+	"io"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+)
+
+// ContextStorage is a Storage whose requests can be bounded by a context
+type ContextStorage interface {
+	Storage
+	// WithContext returns a view of the storage whose requests (and blob transfers) are interrupted
+	// once ctx is done
+	WithContext(ctx context.Context) Storage
+}
+
+// StorageWithContext returns a view of storage bounded by ctx, so that the downloads and uploads of
+// an uplet share its deadline (see common.ContextHandler), e.g.:
+//
+//	storage := client.StorageWithContext(ctx, storage)
+//	paths, err := client.DownloadDataset(storage, manifest, dir, 0, nil)
+//
+// Storages that aren't ContextStorages are only checked between calls and blob reads: a read
+// already blocked isn't interrupted (see common.ContextReader).
+func StorageWithContext(ctx context.Context, storage Storage) Storage {
+	switch s := storage.(type) {
+	case *StorageAPI:
+		return s.WithContext(ctx)
+	case ContextStorage:
+		return s.WithContext(ctx)
+	}
+	return &contextStorage{Storage: storage, ctx: ctx}
+}
+
+// contextStorage bounds a Storage that can't bound its own requests
+type contextStorage struct {
+	Storage
+	ctx context.Context
+}
+
+func (s *contextStorage) blob(get func(uuid.UUID) (io.ReadCloser, error), id uuid.UUID) (io.ReadCloser, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	blob, err := get(id)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{common.ContextReader(s.ctx, blob), blob}, nil
+}
+
+func (s *contextStorage) GetData(id uuid.UUID) (*common.Data, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetData(id)
+}
+
+func (s *contextStorage) GetAlgo(id uuid.UUID) (*common.Algo, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetAlgo(id)
+}
+
+func (s *contextStorage) GetModel(id uuid.UUID) (*common.Model, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetModel(id)
+}
+
+func (s *contextStorage) GetProblemWorkflow(id uuid.UUID) (*common.Problem, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetProblemWorkflow(id)
+}
+
+func (s *contextStorage) GetDataBlobSize(id uuid.UUID) (int64, error) {
+	if err := s.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.Storage.GetDataBlobSize(id)
+}
+
+func (s *contextStorage) GetDatasetManifest(id uuid.UUID) (*common.DatasetManifest, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetDatasetManifest(id)
+}
+
+func (s *contextStorage) GetDataBlob(id uuid.UUID) (io.ReadCloser, error) {
+	return s.blob(s.Storage.GetDataBlob, id)
+}
+
+func (s *contextStorage) GetAlgoBlob(id uuid.UUID) (io.ReadCloser, error) {
+	return s.blob(s.Storage.GetAlgoBlob, id)
+}
+
+func (s *contextStorage) GetModelBlob(id uuid.UUID) (io.ReadCloser, error) {
+	return s.blob(s.Storage.GetModelBlob, id)
+}
+
+func (s *contextStorage) GetProblemWorkflowBlob(id uuid.UUID) (io.ReadCloser, error) {
+	return s.blob(s.Storage.GetProblemWorkflowBlob, id)
+}
+
+func (s *contextStorage) PostModel(model *common.Model, modelReader io.Reader, size int64) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	return s.Storage.PostModel(model, common.ContextReader(s.ctx, modelReader), size)
+}
+
+func (s *contextStorage) PostPrediction(prediction *common.Prediction, predReader io.Reader, size int64) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	return s.Storage.PostPrediction(prediction, common.ContextReader(s.ctx, predReader), size)
+}
@@ -0,0 +1,294 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/tracing"
+)
+
+// IPFSStorage is a Storage reading objects and blobs from IPFS, for decentralized dataset
+// distribution. Objects live under a root directory (an IPFS or IPNS path, e.g.
+// "/ipns/storage.morpheo.co"), laid out as the storage API routes are:
+//
+//	<root>/<problem|algo|model|data>/<uuid>/metadata.json
+//	<root>/<problem|algo|model|data>/<uuid>/blob
+//	<root>/data/<uuid>/manifest.json
+//
+// Paths are resolved (down to CIDs) by the IPFS node's HTTP API when set, and by the gateways
+// otherwise or if the node fails. Gateways are untrusted: the blocks they serve are checked against
+// their CIDs (see gatewayBlock). IPFS being content-addressed, uploads (PostModel and
+// PostPrediction) go to Uploads, a regular storage, if set.
+type IPFSStorage struct {
+	Root string
+	// API is the base URL of the HTTP API of an IPFS node (e.g. "http://127.0.0.1:5001")
+	API string
+	// Gateways are the base URLs of IPFS HTTP gateways supporting trustless requests (raw blocks),
+	// tried in order. There is no default: public gateways have to be listed explicitly.
+	Gateways []string
+	Uploads  Storage
+
+	// HTTPClient performs the requests against the node and the gateways (an HTTP client using the
+	// shared transport, with every request traced, if nil)
+	HTTPClient *http.Client
+	Logger     logging.Logger
+
+	ctx context.Context
+}
+
+// NewIPFSStorage creates a storage reading from root through a node's API (if not empty) and gateways
+func NewIPFSStorage(root, api string, gateways ...string) *IPFSStorage {
+	return &IPFSStorage{Root: root, API: api, Gateways: gateways}
+}
+
+func (s *IPFSStorage) logger() logging.Logger {
+	return logging.OrDefault(s.Logger).With(logging.Fields{logging.FieldComponent: "ipfs-storage"})
+}
+
+func (s *IPFSStorage) client(name, baseURL string) *httpclient.Client {
+	c := httpclient.New(name, baseURL)
+	c.HTTPClient = s.HTTPClient
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Transport: tracing.Transport(nil)}
+	}
+	c.Logger = s.Logger
+	c.APIVersion = ""
+	if s.ctx != nil {
+		return c.WithContext(s.ctx)
+	}
+	return c
+}
+
+// WithContext returns a view of the storage whose requests (and uploads) are interrupted once ctx is
+// done
+func (s *IPFSStorage) WithContext(ctx context.Context) Storage {
+	bound := *s
+	bound.ctx = ctx
+	if s.Uploads != nil {
+		bound.Uploads = StorageWithContext(ctx, s.Uploads)
+	}
+	return &bound
+}
+
+// Validate checks that the storage has an IPFS node or a gateway to read from
+func (s *IPFSStorage) Validate() error {
+	if s.API == "" && len(s.Gateways) == 0 {
+		return errors.Newf(errors.Permanent, "[ipfs-storage] Neither an IPFS node API nor a gateway is set")
+	}
+	return nil
+}
+
+// ipfsError classifies the errors of IPFS nodes and gateways: missing paths are reported with 404s,
+// or with 500s by older versions (e.g. "no link named \"blob\" under ..."), which are NotFound
+// rather than Transient errors
+func ipfsError(err error) error {
+	var statusErr *httpclient.StatusError
+	if !stderrors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+		return err
+	}
+	message := strings.ToLower(statusErr.Message)
+	for _, notFound := range []string{"no link named", "not found", "could not find", "could not resolve", "no such file"} {
+		if strings.Contains(message, notFound) {
+			return errors.Wrap(errors.NotFound, err)
+		}
+	}
+	return err
+}
+
+func (s *IPFSStorage) path(route string, id uuid.UUID, file string) string {
+	return fmt.Sprintf("/%s/%s/%s/%s", strings.Trim(s.Root, "/"), route, id, file)
+}
+
+// GetBlobByCID returns the content of an IPFS path: a CID, or a path relative to one (e.g.
+// "bafy.../data/blob"). It is up to the caller to close it.
+func (s *IPFSStorage) GetBlobByCID(cid string) (io.ReadCloser, error) {
+	return s.cat("/ipfs/" + strings.TrimPrefix(cid, "/ipfs/"))
+}
+
+// cat returns the content of an IPFS path from the node, or from the first gateway serving it
+func (s *IPFSStorage) cat(path string) (io.ReadCloser, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	var err error
+	if s.API != "" {
+		var resp *http.Response
+		resp, err = s.client("ipfs-api", s.API).Do(&httpclient.Request{
+			Method: http.MethodPost,
+			Route:  "api/v0/cat?arg=" + url.QueryEscape(path),
+		})
+		if err == nil {
+			return resp.Body, nil
+		}
+		err = ipfsError(err)
+		if len(s.Gateways) == 0 {
+			return nil, err
+		}
+		s.logger().Warnf("Error reading %s from the IPFS node, falling back to gateways: %s", path, err)
+	}
+	return s.gatewayCat(path)
+}
+
+// size returns the size of the content of an IPFS path
+func (s *IPFSStorage) size(path string) (int64, error) {
+	if err := s.Validate(); err != nil {
+		return 0, err
+	}
+	var err error
+	if s.API != "" {
+		var stat struct{ Size int64 }
+		err = s.client("ipfs-api", s.API).DoJSON(&httpclient.Request{
+			Method: http.MethodPost,
+			Route:  "api/v0/files/stat?arg=" + url.QueryEscape(path),
+		}, &stat)
+		if err == nil {
+			return stat.Size, nil
+		}
+		err = ipfsError(err)
+		if len(s.Gateways) == 0 {
+			return 0, err
+		}
+		s.logger().Warnf("Error stating %s on the IPFS node, falling back to gateways: %s", path, err)
+	}
+	return s.gatewaySize(path)
+}
+
+func (s *IPFSStorage) getJSON(path string, dest interface{}) error {
+	body, err := s.cat(path)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(dest); err != nil {
+		return errors.Newf(errors.Permanent, "[ipfs-storage] Error decoding %s: %s", path, err)
+	}
+	return nil
+}
+
+// GetData returns the metadata of a dataset
+func (s *IPFSStorage) GetData(id uuid.UUID) (data *common.Data, err error) {
+	data = &common.Data{}
+	err = s.getJSON(s.path(StorageDataRoute, id, "metadata.json"), data)
+	return data, err
+}
+
+// GetAlgo returns the metadata of an algo
+func (s *IPFSStorage) GetAlgo(id uuid.UUID) (algo *common.Algo, err error) {
+	algo = &common.Algo{}
+	err = s.getJSON(s.path(StorageAlgoRoute, id, "metadata.json"), algo)
+	return algo, err
+}
+
+// GetModel returns the metadata of a model
+func (s *IPFSStorage) GetModel(id uuid.UUID) (model *common.Model, err error) {
+	model = &common.Model{}
+	err = s.getJSON(s.path(StorageModelRoute, id, "metadata.json"), model)
+	return model, err
+}
+
+// GetProblemWorkflow returns the metadata of a problem workflow
+func (s *IPFSStorage) GetProblemWorkflow(id uuid.UUID) (problem *common.Problem, err error) {
+	problem = &common.Problem{}
+	err = s.getJSON(s.path(StorageProblemWorkflowRoute, id, "metadata.json"), problem)
+	return problem, err
+}
+
+// GetDatasetManifest returns the manifest of a dataset
+func (s *IPFSStorage) GetDatasetManifest(id uuid.UUID) (manifest *common.DatasetManifest, err error) {
+	manifest = &common.DatasetManifest{}
+	if err := s.getJSON(s.path(StorageDataRoute, id, ManifestSuffix+".json"), manifest); err != nil {
+		return nil, err
+	}
+	if err := manifest.Check(); err != nil {
+		return nil, errors.Newf(errors.Permanent, "[ipfs-storage] Invalid manifest for dataset %s: %s", id, err)
+	}
+	return manifest, nil
+}
+
+// GetDataBlob returns a dataset blob (it is up to the caller to close it)
+func (s *IPFSStorage) GetDataBlob(id uuid.UUID) (io.ReadCloser, error) {
+	return s.cat(s.path(StorageDataRoute, id, BlobSuffix))
+}
+
+// GetDataBlobSize returns the size in bytes of a dataset blob, without downloading it
+func (s *IPFSStorage) GetDataBlobSize(id uuid.UUID) (int64, error) {
+	return s.size(s.path(StorageDataRoute, id, BlobSuffix))
+}
+
+// GetAlgoBlob returns an algo blob (it is up to the caller to close it)
+func (s *IPFSStorage) GetAlgoBlob(id uuid.UUID) (io.ReadCloser, error) {
+	return s.cat(s.path(StorageAlgoRoute, id, BlobSuffix))
+}
+
+// GetModelBlob returns a model blob (it is up to the caller to close it)
+func (s *IPFSStorage) GetModelBlob(id uuid.UUID) (io.ReadCloser, error) {
+	return s.cat(s.path(StorageModelRoute, id, BlobSuffix))
+}
+
+// GetProblemWorkflowBlob returns a problem workflow blob (it is up to the caller to close it)
+func (s *IPFSStorage) GetProblemWorkflowBlob(id uuid.UUID) (io.ReadCloser, error) {
+	return s.cat(s.path(StorageProblemWorkflowRoute, id, BlobSuffix))
+}
+
+// PostModel uploads a model to Uploads
+func (s *IPFSStorage) PostModel(model *common.Model, modelReader io.Reader, size int64) error {
+	if s.Uploads == nil {
+		return errors.Newf(errors.Permanent, "[ipfs-storage] Can't post model %s: no storage to upload to", model.ID)
+	}
+	return s.Uploads.PostModel(model, modelReader, size)
+}
+
+// PostPrediction uploads a prediction to Uploads
+func (s *IPFSStorage) PostPrediction(prediction *common.Prediction, predReader io.Reader, size int64) error {
+	if s.Uploads == nil {
+		return errors.Newf(errors.Permanent, "[ipfs-storage] Can't post prediction %s: no storage to upload to", prediction.ID)
+	}
+	return s.Uploads.PostPrediction(prediction, predReader, size)
+}
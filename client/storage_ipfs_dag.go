@@ -0,0 +1,431 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+)
+
+// Gateways aren't trusted: IPFSStorage fetches raw blocks from them (trustless gateway requests),
+// checks each block against the CID it was asked for, and walks the UnixFS DAG itself to resolve
+// paths and read files. Only CIDv0 and base32 CIDv1 with SHA-256 multihashes, raw and dag-pb blocks,
+// and non-sharded directories are supported.
+
+const (
+	cidCodecRaw   = 0x55
+	cidCodecDagPB = 0x70
+	multihashSHA2 = 0x12
+
+	// maxIPFSBlockSize bounds the blocks read from gateways (blocks are 1MiB at most on the network)
+	maxIPFSBlockSize = 2 << 20
+
+	unixfsRaw       = 0
+	unixfsDirectory = 1
+	unixfsFile      = 2
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var base32CID = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ipfsCID is a parsed content identifier
+type ipfsCID struct {
+	codec  uint64
+	digest []byte
+	text   string
+}
+
+func (c *ipfsCID) String() string {
+	return c.text
+}
+
+// parseCID parses the text form of a CID
+func parseCID(text string) (*ipfsCID, error) {
+	if len(text) == 46 && strings.HasPrefix(text, "Qm") {
+		mh, err := decodeBase58(text)
+		if err != nil {
+			return nil, errors.Newf(errors.Permanent, "[ipfs-storage] Invalid CID %s: %s", text, err)
+		}
+		return binaryCID(mh, text)
+	}
+	if strings.HasPrefix(text, "b") {
+		raw, err := base32CID.DecodeString(strings.ToUpper(text[1:]))
+		if err != nil {
+			return nil, errors.Newf(errors.Permanent, "[ipfs-storage] Invalid CID %s: %s", text, err)
+		}
+		return binaryCID(raw, text)
+	}
+	return nil, errors.Newf(errors.Permanent, "[ipfs-storage] Unsupported CID %s (CIDv0 or base32 CIDv1 expected)", text)
+}
+
+// binaryCID parses the binary form of a CID (as found in dag-pb links)
+func binaryCID(raw []byte, text string) (*ipfsCID, error) {
+	c := &ipfsCID{codec: cidCodecDagPB, text: text}
+	mh := raw
+	if len(raw) == 0 || raw[0] != multihashSHA2 {
+		version, n := binary.Uvarint(raw)
+		if n <= 0 || version != 1 {
+			return nil, errors.Newf(errors.Permanent, "[ipfs-storage] Unsupported CID version in %x", raw)
+		}
+		codec, m := binary.Uvarint(raw[n:])
+		if m <= 0 {
+			return nil, errors.Newf(errors.Permanent, "[ipfs-storage] Invalid CID %x", raw)
+		}
+		c.codec, mh = codec, raw[n+m:]
+	}
+	code, n := binary.Uvarint(mh)
+	if n <= 0 || code != multihashSHA2 {
+		return nil, errors.Newf(errors.Permanent, "[ipfs-storage] Unsupported multihash in CID %x (SHA-256 expected)", raw)
+	}
+	size, m := binary.Uvarint(mh[n:])
+	if m <= 0 || size != sha256.Size || len(mh[n+m:]) != sha256.Size {
+		return nil, errors.Newf(errors.Permanent, "[ipfs-storage] Invalid multihash in CID %x", raw)
+	}
+	c.digest = mh[n+m:]
+	if c.text == "" {
+		if c.codec == cidCodecDagPB && len(raw) == len(mh) {
+			c.text = encodeBase58(raw)
+		} else {
+			c.text = "b" + strings.ToLower(base32CID.EncodeToString(raw))
+		}
+	}
+	return c, nil
+}
+
+func decodeBase58(text string) ([]byte, error) {
+	n := new(big.Int)
+	for _, r := range text {
+		i := strings.IndexRune(base58Alphabet, r)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", r)
+		}
+		n.Mul(n, big.NewInt(58)).Add(n, big.NewInt(int64(i)))
+	}
+	zeros := len(text) - len(strings.TrimLeft(text, "1"))
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+func encodeBase58(raw []byte) string {
+	n := new(big.Int).SetBytes(raw)
+	var text []byte
+	for mod := new(big.Int); n.Sign() > 0; {
+		n.DivMod(n, big.NewInt(58), mod)
+		text = append(text, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range raw {
+		if b != 0 {
+			break
+		}
+		text = append(text, '1')
+	}
+	for i, j := 0, len(text)-1; i < j; i, j = i+1, j-1 {
+		text[i], text[j] = text[j], text[i]
+	}
+	return string(text)
+}
+
+// dagNode is a decoded block: its UnixFS type and data, and its links
+type dagNode struct {
+	kind     uint64
+	data     []byte
+	fileSize uint64
+	links    []dagLink
+}
+
+type dagLink struct {
+	cid  *ipfsCID
+	name string
+}
+
+// decodeBlock decodes a block (checked against its CID) as a UnixFS node
+func decodeBlock(c *ipfsCID, block []byte) (*dagNode, error) {
+	if c.codec == cidCodecRaw {
+		return &dagNode{kind: unixfsRaw, data: block, fileSize: uint64(len(block))}, nil
+	}
+	if c.codec != cidCodecDagPB {
+		return nil, errors.Newf(errors.Permanent, "[ipfs-storage] Unsupported codec %#x of block %s", c.codec, c)
+	}
+	node := &dagNode{}
+	var unixfs []byte
+	err := decodeProtobuf(block, func(field uint64, value []byte, _ uint64) error {
+		switch field {
+		case 1:
+			unixfs = value
+		case 2:
+			link := dagLink{}
+			var hash []byte
+			if err := decodeProtobuf(value, func(field uint64, value []byte, _ uint64) error {
+				switch field {
+				case 1:
+					hash = value
+				case 2:
+					link.name = string(value)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			cid, err := binaryCID(hash, "")
+			if err != nil {
+				return err
+			}
+			link.cid = cid
+			node.links = append(node.links, link)
+		}
+		return nil
+	})
+	if err == nil {
+		err = decodeProtobuf(unixfs, func(field uint64, value []byte, varint uint64) error {
+			switch field {
+			case 1:
+				node.kind = varint
+			case 2:
+				node.data = value
+			case 3:
+				node.fileSize = varint
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, errors.Newf(errors.Permanent, "[ipfs-storage] Error decoding block %s: %s", c, err)
+	}
+	if node.fileSize == 0 {
+		node.fileSize = uint64(len(node.data))
+	}
+	return node, nil
+}
+
+// decodeProtobuf calls field for each field of a protobuf message, with its value if it is
+// length-delimited, or its varint value
+func decodeProtobuf(message []byte, field func(number uint64, value []byte, varint uint64) error) error {
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return fmt.Errorf("invalid field key")
+		}
+		message = message[n:]
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(message)
+			if n <= 0 {
+				return fmt.Errorf("invalid varint")
+			}
+			message = message[n:]
+			if err := field(key>>3, nil, v); err != nil {
+				return err
+			}
+		case 2:
+			size, n := binary.Uvarint(message)
+			if n <= 0 || size > uint64(len(message)-n) {
+				return fmt.Errorf("invalid length")
+			}
+			value := message[n : n+int(size)]
+			message = message[n+int(size):]
+			if err := field(key>>3, value, 0); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported wire type %d", key&7)
+		}
+	}
+	return nil
+}
+
+// gatewayBlock fetches a block from the first gateway serving it, and checks it against its CID
+func (s *IPFSStorage) gatewayBlock(c *ipfsCID) (*dagNode, error) {
+	var err error
+	for _, gateway := range s.Gateways {
+		var block []byte
+		block, err = s.fetchBlock(gateway, c)
+		if err == nil {
+			return decodeBlock(c, block)
+		}
+		s.logger().Warnf("Error reading block %s from gateway %s: %s", c, gateway, err)
+	}
+	return nil, err
+}
+
+func (s *IPFSStorage) fetchBlock(gateway string, c *ipfsCID) ([]byte, error) {
+	resp, err := s.client("ipfs-gateway", gateway).Do(&httpclient.Request{
+		Method: http.MethodGet,
+		Route:  "/ipfs/" + c.String() + "?format=raw",
+		Header: http.Header{"Accept": []string{"application/vnd.ipld.raw"}},
+	})
+	if err != nil {
+		return nil, ipfsError(err)
+	}
+	defer httpclient.DrainAndClose(resp.Body)
+	block, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxIPFSBlockSize+1))
+	if err != nil {
+		return nil, errors.Newf(errors.Transient, "[ipfs-storage] Error reading block %s from %s: %s", c, gateway, err)
+	}
+	if len(block) > maxIPFSBlockSize {
+		return nil, errors.Newf(errors.Permanent, "[ipfs-storage] Block %s from %s is larger than %d bytes", c, gateway, maxIPFSBlockSize)
+	}
+	if digest := sha256.Sum256(block); !bytes.Equal(digest[:], c.digest) {
+		return nil, errors.Newf(errors.Permanent, "[ipfs-storage] Block %s from %s doesn't match its CID", c, gateway)
+	}
+	return block, nil
+}
+
+// gatewayResolve resolves an IPFS path through verified blocks, down to the CID of its target.
+// The names of IPNS paths are resolved by the gateways, which are trusted for this only: use an
+// /ipfs/ root to verify everything.
+func (s *IPFSStorage) gatewayResolve(path string) (*ipfsCID, error) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) < 2 || (segments[0] != "ipfs" && segments[0] != "ipns") {
+		return nil, errors.Newf(errors.Permanent, "[ipfs-storage] Invalid IPFS path %s", path)
+	}
+	root := segments[1]
+	if segments[0] == "ipns" {
+		var err error
+		if root, err = s.resolveName(root); err != nil {
+			return nil, err
+		}
+	}
+	c, err := parseCID(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range segments[2:] {
+		node, err := s.gatewayBlock(c)
+		if err != nil {
+			return nil, err
+		}
+		if node.kind != unixfsDirectory {
+			return nil, errors.Newf(errors.NotFound, "[ipfs-storage] Can't resolve %s: %s isn't a (non-sharded) directory", path, c)
+		}
+		var next *ipfsCID
+		for _, link := range node.links {
+			if link.name == name {
+				next = link.cid
+				break
+			}
+		}
+		if next == nil {
+			return nil, errors.Newf(errors.NotFound, "[ipfs-storage] Can't resolve %s: no link named %q under %s", path, name, c)
+		}
+		c = next
+	}
+	return c, nil
+}
+
+// resolveName resolves an IPNS name to a CID with the first gateway able to (X-Ipfs-Roots header)
+func (s *IPFSStorage) resolveName(name string) (string, error) {
+	var err error
+	for _, gateway := range s.Gateways {
+		var resp *http.Response
+		resp, err = s.client("ipfs-gateway", gateway).Do(&httpclient.Request{Method: http.MethodHead, Route: "/ipns/" + name})
+		if err != nil {
+			err = ipfsError(err)
+			s.logger().Warnf("Error resolving /ipns/%s with gateway %s: %s", name, gateway, err)
+			continue
+		}
+		httpclient.DrainAndClose(resp.Body)
+		if roots := strings.Split(resp.Header.Get("X-Ipfs-Roots"), ","); roots[0] != "" {
+			return strings.TrimSpace(roots[0]), nil
+		}
+		err = errors.Newf(errors.Permanent, "[ipfs-storage] Gateway %s didn't tell what /ipns/%s resolves to", gateway, name)
+	}
+	return "", err
+}
+
+// gatewayCat returns the verified content of a file
+func (s *IPFSStorage) gatewayCat(path string) (io.ReadCloser, error) {
+	c, err := s.gatewayResolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(&dagReader{storage: s, pending: []*ipfsCID{c}}), nil
+}
+
+// gatewaySize returns the size of a file, as told by its verified root block
+func (s *IPFSStorage) gatewaySize(path string) (int64, error) {
+	c, err := s.gatewayResolve(path)
+	if err != nil {
+		return 0, err
+	}
+	node, err := s.gatewayBlock(c)
+	if err != nil {
+		return 0, err
+	}
+	return int64(node.fileSize), nil
+}
+
+// dagReader reads a file by walking its DAG depth-first, one verified block at a time
+type dagReader struct {
+	storage *IPFSStorage
+	// pending lists the blocks left to read, the next one last
+	pending []*ipfsCID
+	data    []byte
+}
+
+func (r *dagReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if len(r.pending) == 0 {
+			return 0, io.EOF
+		}
+		c := r.pending[len(r.pending)-1]
+		r.pending = r.pending[:len(r.pending)-1]
+		node, err := r.storage.gatewayBlock(c)
+		if err != nil {
+			return 0, err
+		}
+		if node.kind != unixfsFile && node.kind != unixfsRaw {
+			return 0, errors.Newf(errors.Permanent, "[ipfs-storage] Block %s isn't part of a file", c)
+		}
+		r.data = node.data
+		for i := len(node.links) - 1; i >= 0; i-- {
+			r.pending = append(r.pending, node.links[i].cid)
+		}
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
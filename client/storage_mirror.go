@@ -0,0 +1,421 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/bufpool"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+)
+
+// DefaultMirrorSyncInterval is how often StorageMirror.Run syncs the mirror when no interval is
+// given
+const DefaultMirrorSyncInterval = 5 * time.Minute
+
+// mirrorWantedFile lists the resources the mirror lacks, in its directory
+const mirrorWantedFile = "wanted.json"
+
+// MirrorEntry identifies a resource of a storage mirror: the metadata (empty Suffix), blob
+// (BlobSuffix) or manifest (ManifestSuffix) of a problem, algo, model or data
+type MirrorEntry struct {
+	Route  string    `json:"route"`
+	Suffix string    `json:"suffix,omitempty"`
+	ID     uuid.UUID `json:"uuid"`
+}
+
+func (e MirrorEntry) String() string {
+	if e.Suffix == "" {
+		return fmt.Sprintf("%s %s", e.Route, e.ID)
+	}
+	return fmt.Sprintf("%s %s %s", e.Route, e.Suffix, e.ID)
+}
+
+// StorageMirror is a Storage reading from a local mirror of central storage first, for sites that
+// are disconnected from it (or only intermittently connected): the resources found in Dir are
+// served from it, the others are fetched from central storage and mirrored. Resources are
+// immutable, so mirrored ones are never fetched again.
+//
+// Resources that can't be fetched are remembered, and fetched again by Sync (see Run), so that the
+// mirror fills up whenever central storage is reachable. Want queues resources ahead of their use.
+// Posts go to central storage.
+type StorageMirror struct {
+	Storage
+
+	Dir    string
+	Logger logging.Logger
+	Clock  clock.Clock
+
+	lock   sync.Mutex
+	wanted map[MirrorEntry]bool
+}
+
+// NewStorageMirror creates a mirror of storage in dir, resuming the fetches a previous mirror
+// left pending
+func NewStorageMirror(storage Storage, dir string) (*StorageMirror, error) {
+	for _, route := range []string{StorageProblemWorkflowRoute, StorageAlgoRoute, StorageModelRoute, StorageDataRoute} {
+		if err := os.MkdirAll(filepath.Join(dir, route), 0755); err != nil {
+			return nil, fmt.Errorf("[storage-mirror] Error creating mirror directory %s: %s", dir, err)
+		}
+	}
+	m := &StorageMirror{Storage: storage, Dir: dir, wanted: map[MirrorEntry]bool{}}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, mirrorWantedFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("[storage-mirror] Error reading %s: %s", mirrorWantedFile, err)
+	}
+	if err == nil {
+		var entries []MirrorEntry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			m.logger().Warnf("Dropping invalid %s: %s", mirrorWantedFile, err)
+		}
+		for _, entry := range entries {
+			m.wanted[entry] = true
+		}
+	}
+	return m, nil
+}
+
+func (m *StorageMirror) logger() logging.Logger {
+	return logging.OrDefault(m.Logger).With(logging.Fields{logging.FieldComponent: "storage-mirror"})
+}
+
+func (m *StorageMirror) path(e MirrorEntry) string {
+	switch e.Suffix {
+	case "":
+		return filepath.Join(m.Dir, e.Route, e.ID.String()+".json")
+	case ManifestSuffix:
+		return filepath.Join(m.Dir, e.Route, e.ID.String()+"."+ManifestSuffix+".json")
+	default:
+		return filepath.Join(m.Dir, e.Route, e.ID.String()+"."+e.Suffix)
+	}
+}
+
+// GetProblemWorkflow returns a problem's metadata
+func (m *StorageMirror) GetProblemWorkflow(id uuid.UUID) (*common.Problem, error) {
+	problem := &common.Problem{}
+	if err := m.getJSON(MirrorEntry{Route: StorageProblemWorkflowRoute, ID: id}, problem); err != nil {
+		return nil, err
+	}
+	return problem, nil
+}
+
+// GetAlgo returns an algo's metadata
+func (m *StorageMirror) GetAlgo(id uuid.UUID) (*common.Algo, error) {
+	algo := &common.Algo{}
+	if err := m.getJSON(MirrorEntry{Route: StorageAlgoRoute, ID: id}, algo); err != nil {
+		return nil, err
+	}
+	return algo, nil
+}
+
+// GetModel returns a model's metadata
+func (m *StorageMirror) GetModel(id uuid.UUID) (*common.Model, error) {
+	model := &common.Model{}
+	if err := m.getJSON(MirrorEntry{Route: StorageModelRoute, ID: id}, model); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// GetData returns a dataset's metadata
+func (m *StorageMirror) GetData(id uuid.UUID) (*common.Data, error) {
+	data := &common.Data{}
+	if err := m.getJSON(MirrorEntry{Route: StorageDataRoute, ID: id}, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// GetDatasetManifest returns the manifest of a dataset
+func (m *StorageMirror) GetDatasetManifest(id uuid.UUID) (*common.DatasetManifest, error) {
+	manifest := &common.DatasetManifest{}
+	if err := m.getJSON(MirrorEntry{Route: StorageDataRoute, Suffix: ManifestSuffix, ID: id}, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// GetProblemWorkflowBlob returns a problem workflow image
+func (m *StorageMirror) GetProblemWorkflowBlob(id uuid.UUID) (io.ReadCloser, error) {
+	return m.getBlob(MirrorEntry{Route: StorageProblemWorkflowRoute, Suffix: BlobSuffix, ID: id})
+}
+
+// GetAlgoBlob returns an algo image
+func (m *StorageMirror) GetAlgoBlob(id uuid.UUID) (io.ReadCloser, error) {
+	return m.getBlob(MirrorEntry{Route: StorageAlgoRoute, Suffix: BlobSuffix, ID: id})
+}
+
+// GetModelBlob returns a model
+func (m *StorageMirror) GetModelBlob(id uuid.UUID) (io.ReadCloser, error) {
+	return m.getBlob(MirrorEntry{Route: StorageModelRoute, Suffix: BlobSuffix, ID: id})
+}
+
+// GetDataBlob returns a dataset
+func (m *StorageMirror) GetDataBlob(id uuid.UUID) (io.ReadCloser, error) {
+	return m.getBlob(MirrorEntry{Route: StorageDataRoute, Suffix: BlobSuffix, ID: id})
+}
+
+// GetDataBlobSize returns the size of a dataset blob, from the mirror if it holds it
+func (m *StorageMirror) GetDataBlobSize(id uuid.UUID) (int64, error) {
+	if info, err := os.Stat(m.path(MirrorEntry{Route: StorageDataRoute, Suffix: BlobSuffix, ID: id})); err == nil {
+		return info.Size(), nil
+	}
+	return m.Storage.GetDataBlobSize(id)
+}
+
+// getJSON reads a resource's metadata (or manifest) from the mirror, fetching it first if needed
+func (m *StorageMirror) getJSON(e MirrorEntry, dest interface{}) error {
+	data, err := ioutil.ReadFile(m.path(e))
+	if os.IsNotExist(err) {
+		if err := m.fetchMissing(e); err != nil {
+			return err
+		}
+		data, err = ioutil.ReadFile(m.path(e))
+	}
+	if err != nil {
+		return fmt.Errorf("[storage-mirror] Error reading mirrored %s: %s", e, err)
+	}
+	if err := json.Unmarshal(data, dest); err != nil {
+		return fmt.Errorf("[storage-mirror] Error unmarshaling mirrored %s: %s", e, err)
+	}
+	return nil
+}
+
+// getBlob opens a blob from the mirror, fetching it first if needed
+func (m *StorageMirror) getBlob(e MirrorEntry) (io.ReadCloser, error) {
+	blob, err := os.Open(m.path(e))
+	if os.IsNotExist(err) {
+		if err := m.fetchMissing(e); err != nil {
+			return nil, err
+		}
+		blob, err = os.Open(m.path(e))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[storage-mirror] Error opening mirrored %s: %s", e, err)
+	}
+	return blob, nil
+}
+
+// fetchMissing fetches a resource the mirror lacks, remembering it for the next Sync if central
+// storage can't provide it (unless it doesn't exist)
+func (m *StorageMirror) fetchMissing(e MirrorEntry) error {
+	err := m.fetch(e)
+	if err == nil {
+		return nil
+	}
+	if errors.MatchKind(errors.NotFound, err) {
+		return err
+	}
+	m.want(e)
+	return errors.Wrap(errors.KindOf(err), fmt.Errorf("[storage-mirror] %s isn't mirrored and fetching it from central storage failed (it will be fetched again at the next sync): %w", e, err))
+}
+
+// fetch copies a resource from central storage into the mirror
+func (m *StorageMirror) fetch(e MirrorEntry) error {
+	if e.Suffix == BlobSuffix {
+		var blob io.ReadCloser
+		var err error
+		switch e.Route {
+		case StorageProblemWorkflowRoute:
+			blob, err = m.Storage.GetProblemWorkflowBlob(e.ID)
+		case StorageAlgoRoute:
+			blob, err = m.Storage.GetAlgoBlob(e.ID)
+		case StorageModelRoute:
+			blob, err = m.Storage.GetModelBlob(e.ID)
+		case StorageDataRoute:
+			blob, err = m.Storage.GetDataBlob(e.ID)
+		default:
+			return fmt.Errorf("[storage-mirror] Can't mirror %s", e)
+		}
+		if err != nil {
+			return err
+		}
+		defer blob.Close()
+		return m.store(e, blob)
+	}
+
+	var resource interface{}
+	var err error
+	switch {
+	case e.Suffix == ManifestSuffix && e.Route == StorageDataRoute:
+		resource, err = m.Storage.GetDatasetManifest(e.ID)
+	case e.Suffix != "":
+		return fmt.Errorf("[storage-mirror] Can't mirror %s", e)
+	case e.Route == StorageProblemWorkflowRoute:
+		resource, err = m.Storage.GetProblemWorkflow(e.ID)
+	case e.Route == StorageAlgoRoute:
+		resource, err = m.Storage.GetAlgo(e.ID)
+	case e.Route == StorageModelRoute:
+		resource, err = m.Storage.GetModel(e.ID)
+	case e.Route == StorageDataRoute:
+		resource, err = m.Storage.GetData(e.ID)
+	default:
+		return fmt.Errorf("[storage-mirror] Can't mirror %s", e)
+	}
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return fmt.Errorf("[storage-mirror] Error marshaling %s: %s", e, err)
+	}
+	return m.store(e, bytes.NewReader(data))
+}
+
+// store writes a resource to the mirror, through a temporary file so that a failed fetch leaves no
+// partial resource behind
+func (m *StorageMirror) store(e MirrorEntry, r io.Reader) error {
+	file, err := ioutil.TempFile(filepath.Join(m.Dir, e.Route), e.ID.String()+".part")
+	if err != nil {
+		return fmt.Errorf("[storage-mirror] Error creating temporary file: %s", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = bufpool.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), m.path(e))
+	}
+	if err != nil {
+		return fmt.Errorf("[storage-mirror] Error mirroring %s: %s", e, err)
+	}
+	return nil
+}
+
+// Want queues resources for the next Sync, unless they are already mirrored (e.g. the algo, model
+// and data of the uplets a disconnected site will run)
+func (m *StorageMirror) Want(entries ...MirrorEntry) {
+	for _, e := range entries {
+		if _, err := os.Stat(m.path(e)); os.IsNotExist(err) {
+			m.want(e)
+		}
+	}
+}
+
+func (m *StorageMirror) want(e MirrorEntry) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.wanted[e] {
+		m.wanted[e] = true
+		m.saveWanted()
+	}
+}
+
+// Pending returns the resources queued for the next Sync
+func (m *StorageMirror) Pending() []MirrorEntry {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	entries := make([]MirrorEntry, 0, len(m.wanted))
+	for e := range m.wanted {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].String() < entries[j].String() })
+	return entries
+}
+
+// saveWanted persists the queued resources, so that a restarted mirror resumes their fetches (the
+// lock has to be held)
+func (m *StorageMirror) saveWanted() {
+	entries := make([]MirrorEntry, 0, len(m.wanted))
+	for e := range m.wanted {
+		entries = append(entries, e)
+	}
+	data, err := json.Marshal(entries)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(m.Dir, mirrorWantedFile), data, 0644)
+	}
+	if err != nil {
+		m.logger().Errorf("Error saving %s: %s", mirrorWantedFile, err)
+	}
+}
+
+// Sync fetches the queued resources from central storage. Those that don't exist are dropped,
+// the others stay queued until they are fetched. It returns the number of resources that are still
+// missing.
+func (m *StorageMirror) Sync() int {
+	missing := 0
+	for _, e := range m.Pending() {
+		err := m.fetch(e)
+		if err != nil && !errors.MatchKind(errors.NotFound, err) {
+			m.logger().Debugf("Error fetching %s: %s", e, err)
+			missing++
+			continue
+		}
+		if err != nil {
+			m.logger().Warnf("Dropping %s from the mirror queue: %s", e, err)
+		}
+		m.lock.Lock()
+		delete(m.wanted, e)
+		m.saveWanted()
+		m.lock.Unlock()
+	}
+	return missing
+}
+
+// Run syncs the mirror every interval (DefaultMirrorSyncInterval if not positive) until stop is
+// closed. It blocks, and is meant to be run in its own goroutine.
+func (m *StorageMirror) Run(interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		interval = DefaultMirrorSyncInterval
+	}
+	clk := clock.OrReal(m.Clock)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-clk.After(interval):
+			if missing := m.Sync(); missing > 0 {
+				m.logger().Warnf("%d resources are still missing from the mirror, central storage may be unreachable", missing)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package client_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/client"
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+)
+
+// TestPostProblemRoundTrip posts a problem and fills it back from the multipart form, as the
+// storage does, checking that its metrics and test datasets make it through
+func TestPostProblemRoundTrip(t *testing.T) {
+	problem := common.Problem{
+		ID:            uuid.NewV4(),
+		Name:          "problem",
+		Description:   "a problem",
+		WorkflowImage: "registry.example.com/workflow:1",
+		Metrics: []common.ProblemMetric{
+			{Name: "accuracy", Description: "share of correct predictions", HigherIsBetter: true},
+			{Name: "loss"},
+		},
+		TestData: []uuid.UUID{uuid.NewV4(), uuid.NewV4()},
+	}
+
+	var received common.Problem
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("Error parsing the problem form: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fields := map[string]interface{}{}
+		for k, v := range r.MultipartForm.Value {
+			switch k {
+			case "size":
+			case "uuid":
+				fields[k] = uuid.FromStringOrNil(v[0])
+			default:
+				fields[k] = v[0]
+			}
+		}
+		if err := received.FillResource(fields); err != nil {
+			t.Errorf("Error filling the problem: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	storage := &client.StorageAPI{HTTPClient: httpclient.New("storage-api", srv.URL)}
+	blob := "workflow"
+	if err := storage.PostProblem(problem, len(blob), strings.NewReader(blob)); err != nil {
+		t.Fatalf("Error posting the problem: %s", err)
+	}
+
+	received.TimestampUpload = problem.TimestampUpload
+	if !reflect.DeepEqual(received, problem) {
+		t.Errorf("Problem %+v filled back as %+v", problem, received)
+	}
+}
@@ -0,0 +1,452 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/client"
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/algopack"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/codec"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/config"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/tracing"
+)
+
+// eventsTimeout is how long printing a status event may take before NSQ sends it again
+const eventsTimeout = 10 * time.Second
+
+// readJSON decodes a JSON file (stdin for -)
+func readJSON(env *environment, path string, dest interface{}) error {
+	var r io.Reader = env.stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	if err := json.NewDecoder(r).Decode(dest); err != nil {
+		return fmt.Errorf("Error decoding %s: %s", path, err)
+	}
+	return nil
+}
+
+// openBlob opens a file to upload and returns its size
+func openBlob(path string) (*os.File, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// postWithPayload opens a payload file and hands it to post
+func postWithPayload(path string, post func(file *os.File, size int64) error) error {
+	file, size, err := openBlob(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return post(file, size)
+}
+
+func runLearn(env *environment, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return usageError("expected a learnuplet file and optionally a payload file")
+	}
+	var learnuplet common.Learnuplet
+	if err := readJSON(env, args[0], &learnuplet); err != nil {
+		return err
+	}
+	if err := learnuplet.Validate(); err != nil {
+		return fmt.Errorf("Invalid learnuplet: %s", err)
+	}
+	compute, err := env.compute()
+	if err != nil {
+		return err
+	}
+	if len(args) == 2 {
+		err = postWithPayload(args[1], func(file *os.File, size int64) error {
+			return compute.PostLearnupletWithPayload(learnuplet, filepath.Base(args[1]), file, size)
+		})
+	} else {
+		err = compute.PostLearnuplet(learnuplet)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(env.stdout, learnuplet.Key)
+	return nil
+}
+
+func runPred(env *environment, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return usageError("expected a preduplet file and optionally a payload file")
+	}
+	var preduplet common.Preduplet
+	if err := readJSON(env, args[0], &preduplet); err != nil {
+		return err
+	}
+	if err := preduplet.Validate(); err != nil {
+		return fmt.Errorf("Invalid preduplet: %s", err)
+	}
+	compute, err := env.compute()
+	if err != nil {
+		return err
+	}
+	if len(args) == 2 {
+		err = postWithPayload(args[1], func(file *os.File, size int64) error {
+			return compute.PostPredupletWithPayload(preduplet, filepath.Base(args[1]), file, size)
+		})
+	} else {
+		err = compute.PostPreduplet(preduplet)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(env.stdout, preduplet.ID)
+	return nil
+}
+
+func runUpload(env *environment, args []string) (err error) {
+	if len(args) < 2 {
+		return usageError("expected a resource type and its files")
+	}
+	ctx, span := tracing.StartPhase(env.ctx, tracing.PhaseUpload)
+	defer func() { tracing.End(span, err) }()
+
+	storageAPI, err := env.storage()
+	if err != nil {
+		return err
+	}
+	storage := storageAPI.WithContext(ctx)
+
+	switch args[0] {
+	case "data":
+		if len(args) != 2 {
+			return usageError("expected a data file")
+		}
+		file, size, err := openBlob(args[1])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		data := common.NewData()
+		if err := storage.PostData(*data, int(size), file); err != nil {
+			return err
+		}
+		fmt.Fprintln(env.stdout, data.ID)
+
+	case "algo":
+		if len(args) != 3 {
+			return usageError("expected an algo name and a directory or archive")
+		}
+		info, err := os.Stat(args[2])
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			pack, err := algopack.FromDir(args[1], args[2])
+			if err != nil {
+				return err
+			}
+			archive, err := pack.Build()
+			if err != nil {
+				return err
+			}
+			if err := storage.PostAlgo(archive.Algo, archive.Size, archive.Reader()); err != nil {
+				return err
+			}
+			fmt.Fprintln(env.stdout, archive.Algo.ID)
+			return nil
+		}
+		file, size, err := openBlob(args[2])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		algo := common.NewAlgo()
+		algo.Name = args[1]
+		if err := storage.PostAlgo(*algo, size, file); err != nil {
+			return err
+		}
+		fmt.Fprintln(env.stdout, algo.ID)
+
+	case "problem":
+		if len(args) != 3 {
+			return usageError("expected a problem file and a workflow file")
+		}
+		var problem common.Problem
+		if err := readJSON(env, args[1], &problem); err != nil {
+			return err
+		}
+		if uuid.Equal(uuid.Nil, problem.ID) {
+			problem.ID = uuid.NewV4()
+		}
+		file, size, err := openBlob(args[2])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if err := storage.PostProblem(problem, int(size), file); err != nil {
+			return err
+		}
+		fmt.Fprintln(env.stdout, problem.ID)
+
+	default:
+		return usageError(fmt.Sprintf("can't upload %s resources", args[0]))
+	}
+	return nil
+}
+
+func runDownload(env *environment, args []string) (err error) {
+	if len(args) != 2 && len(args) != 3 {
+		return usageError("expected a resource type, a UUID and optionally a file")
+	}
+	id, err := uuid.FromString(args[1])
+	if err != nil {
+		return usageError(fmt.Sprintf("invalid UUID %s: %s", args[1], err))
+	}
+	ctx, span := tracing.StartPhase(env.ctx, tracing.PhaseDownload)
+	defer func() { tracing.End(span, err) }()
+
+	storageReader, err := env.storageReader()
+	if err != nil {
+		return err
+	}
+	storage := client.StorageWithContext(ctx, storageReader)
+
+	var blob io.ReadCloser
+	switch args[0] {
+	case "data":
+		blob, err = storage.GetDataBlob(id)
+	case "algo":
+		blob, err = storage.GetAlgoBlob(id)
+	case "model":
+		blob, err = storage.GetModelBlob(id)
+	case "problem":
+		blob, err = storage.GetProblemWorkflowBlob(id)
+	default:
+		return usageError(fmt.Sprintf("can't download %s resources", args[0]))
+	}
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	if len(args) == 2 || args[2] == "-" {
+		_, err = io.Copy(env.stdout, blob)
+		return err
+	}
+	// The blob is written to a temporary file first, so that a failed download leaves no partial file
+	tmpPath := args[2] + ".part"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, blob)
+	file.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("Error writing %s: %s", args[2], err)
+	}
+	return os.Rename(tmpPath, args[2])
+}
+
+func runStatus(env *environment, args []string) error {
+	statuses := []string{common.TaskStatusTodo, common.TaskStatusPending, common.TaskStatusDone, common.TaskStatusFailed, common.TaskStatusCancelled}
+	switch {
+	case len(args) == 1:
+		if _, ok := common.ValidStatuses[args[0]]; !ok {
+			return usageError(fmt.Sprintf("invalid status %s", args[0]))
+		}
+		statuses = args
+	case len(args) > 1:
+		return usageError("expected at most one status")
+	}
+	peer, err := env.peer()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSTATUS\tRANK\tWORKER\tPERF")
+	for _, status := range statuses {
+		data, err := peer.QueryStatusLearnuplet(status)
+		if err != nil {
+			return fmt.Errorf("Error querying %s learnuplets: %s", status, err)
+		}
+		if len(data) == 0 {
+			continue
+		}
+		var uplets []common.LearnupletChaincode
+		if err := json.Unmarshal(data, &uplets); err != nil {
+			return fmt.Errorf("Error un-marshaling %s learnuplets: %s", status, err)
+		}
+		for _, uplet := range uplets {
+			perf := ""
+			if uplet.Status == common.TaskStatusDone {
+				perf = common.FormatPerf(uplet.Perf, 4)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", uplet.Key, uplet.Status, uplet.Rank, uplet.Worker, perf)
+		}
+	}
+	return tw.Flush()
+}
+
+func runEvents(env *environment, args []string) error {
+	if len(args) > 1 {
+		return usageError("expected at most one uplet key")
+	}
+	c := env.cfg.Broker
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if c.Type != config.BrokerNSQ {
+		return fmt.Errorf("status events can only be read from NSQ")
+	}
+
+	consumer := common.NewNSQConsumer(c.LookupURLs, fmt.Sprintf("%s:%d", c.NsqdHost, c.NsqdHTTPPort), c.Channel, time.Duration(c.PollingInterval), log.New(os.Stderr, "", log.LstdFlags))
+	consumer.Log = env.logger.With(logging.Fields{logging.FieldComponent: "nsq-consumer"})
+	resolver, err := env.cfg.Secrets.Resolver()
+	if err != nil {
+		return err
+	}
+	if consumer.Verifier, err = c.Verifier(resolver, consumer.Log); err != nil {
+		return err
+	}
+	// Events are printed within spans continuing the traces of the workers that pushed them
+	err = tracing.Consumer{Consumer: consumer}.AddBroadcastHandler(common.StatusTopic, func(message []byte) error {
+		var event common.StatusEvent
+		if err := codec.DecodeMessage(message, &event); err != nil {
+			env.logger.Warnf("Invalid status event: %s", err)
+			return nil
+		}
+		if len(args) == 1 && event.UpletKey != args[0] {
+			return nil
+		}
+		fmt.Fprintf(env.stdout, "%s\t%s\t%s\t%s\t%s\n", event.Time.Format(time.RFC3339), event.UpletType, event.UpletKey, event.Status, event.Message)
+		return nil
+	}, 1, eventsTimeout)
+	if err != nil {
+		return err
+	}
+	// Stopping the consumer disconnects it, which deletes its ephemeral channel right away
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		consumer.Stop()
+	}()
+	consumer.ConsumeUntilKilled()
+	return nil
+}
+
+// mirrorRoutes maps the resource types of the command line to storage routes
+var mirrorRoutes = map[string]string{
+	"data":    client.StorageDataRoute,
+	"algo":    client.StorageAlgoRoute,
+	"model":   client.StorageModelRoute,
+	"problem": client.StorageProblemWorkflowRoute,
+}
+
+func runMirror(env *environment, args []string) error {
+	if len(args) > 2 || (len(args) == 1 && args[0] != "watch") {
+		return usageError("expected watch, or a resource type and a UUID")
+	}
+	mirror, err := env.storageMirror()
+	if err != nil {
+		return err
+	}
+	if mirror == nil {
+		return fmt.Errorf("no storage mirror is configured (see storage.mirror_dir)")
+	}
+
+	if len(args) == 2 {
+		route, ok := mirrorRoutes[args[0]]
+		if !ok {
+			return usageError(fmt.Sprintf("can't mirror %s resources", args[0]))
+		}
+		id, err := uuid.FromString(args[1])
+		if err != nil {
+			return usageError(fmt.Sprintf("invalid UUID %s: %s", args[1], err))
+		}
+		mirror.Want(client.MirrorEntry{Route: route, ID: id}, client.MirrorEntry{Route: route, Suffix: client.BlobSuffix, ID: id})
+	}
+	missing := mirror.Sync()
+	if len(args) == 1 {
+		stop := make(chan struct{})
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			close(stop)
+		}()
+		mirror.Run(time.Duration(env.cfg.Storage.MirrorSyncInterval), stop)
+		return nil
+	}
+	if missing > 0 {
+		return fmt.Errorf("%d resources are still missing from the mirror", missing)
+	}
+	return nil
+}
+
+func runConfig(env *environment, args []string) error {
+	if len(args) != 0 {
+		return usageError("expected no argument")
+	}
+	return config.DumpEffectiveConfig(env.stdout, env.cfg)
+}
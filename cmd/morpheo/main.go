@@ -0,0 +1,339 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Command morpheo interacts with a Morpheo platform from the command line, through the storage,
+// compute and orchestrator clients: submitting uplets, uploading and downloading blobs, listing
+// uplets by status and tailing uplet status events.
+//
+// It is configured like the other Morpheo components (see the common/config package): a
+// configuration file, environment variables and flags, given before the command:
+//
+//	morpheo -config morpheo.yaml download model 2a8d... model.tar.gz
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/client"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/audit"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/config"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/secrets"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/tracing"
+)
+
+// tracingFlushTimeout bounds the export of the pending spans when the CLI exits
+const tracingFlushTimeout = 5 * time.Second
+
+// command is a subcommand of the CLI
+type command struct {
+	usage       string
+	description string
+	run         func(env *environment, args []string) error
+}
+
+var commands = map[string]command{
+	"learn": {
+		usage:       "learn <learnuplet.json> [payload]",
+		description: "Submit a learnuplet to compute (- reads it from stdin), optionally along a small input file",
+		run:         runLearn,
+	},
+	"pred": {
+		usage:       "pred <preduplet.json> [payload]",
+		description: "Submit a preduplet to compute (- reads it from stdin), optionally along a small input file",
+		run:         runPred,
+	},
+	"upload": {
+		usage:       "upload data <file> | algo <name> <dir|archive.tar.gz> | problem <problem.json> <file>",
+		description: "Upload a blob to storage and print its UUID (algo directories are packed first)",
+		run:         runUpload,
+	},
+	"download": {
+		usage:       "download data|algo|model|problem <uuid> [file]",
+		description: "Download a blob from storage, or its mirror (to stdout if no file or - is given)",
+		run:         runDownload,
+	},
+	"mirror": {
+		usage:       "mirror [watch | data|algo|model|problem <uuid>]",
+		description: "Fetch the resources missing from the storage mirror (every sync interval with watch), queuing a resource first if given",
+		run:         runMirror,
+	},
+	"status": {
+		usage:       "status [todo|pending|done|failed|cancelled]",
+		description: "List the learnuplets with a status (all of them if none is given)",
+		run:         runStatus,
+	},
+	"events": {
+		usage:       "events [uplet key]",
+		description: "Print uplet status events as workers push them (only those of an uplet if given)",
+		run:         runEvents,
+	},
+	"config": {
+		usage:       "config",
+		description: "Print the effective configuration",
+		run:         runConfig,
+	},
+}
+
+// environment holds what commands need: the configuration (their clients are built from it), the
+// audit trail recorder (nil if disabled), the context holding the span of the command and the
+// standard streams
+type environment struct {
+	ctx    context.Context
+	cfg    *config.Config
+	logger logging.Logger
+	audit  *audit.Recorder
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+func (env *environment) tls() (*tls.Config, error) {
+	return env.cfg.TLS.ClientConfig()
+}
+
+func (env *environment) storage() (*client.StorageAPI, error) {
+	if err := env.cfg.Storage.Validate(); err != nil {
+		return nil, err
+	}
+	if env.cfg.Storage.Mock {
+		return nil, fmt.Errorf("the storage mock can't be used from the command line")
+	}
+	tlsConfig, err := env.tls()
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := env.tokens(env.cfg.Storage.TokenSource)
+	if err != nil {
+		return nil, err
+	}
+	return &client.StorageAPI{
+		Hostname: env.cfg.Storage.Host,
+		Port:     env.cfg.Storage.Port,
+		User:     env.cfg.Storage.User,
+		Password: env.cfg.Storage.Password,
+		Logger:   env.logger,
+		TLS:      tlsConfig,
+		Audit:    env.audit,
+		Tokens:   tokens,
+	}, nil
+}
+
+func (env *environment) compute() (*client.ComputeAPI, error) {
+	if err := env.cfg.Compute.Validate(); err != nil {
+		return nil, err
+	}
+	tlsConfig, err := env.tls()
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := env.tokens(env.cfg.Compute.TokenSource)
+	if err != nil {
+		return nil, err
+	}
+	return &client.ComputeAPI{
+		Hostname: env.cfg.Compute.Host,
+		Port:     env.cfg.Compute.Port,
+		APIKey:   env.cfg.Compute.APIKey,
+		Logger:   env.logger,
+		TLS:      tlsConfig,
+		Audit:    env.audit,
+		Tokens:   tokens,
+	}, nil
+}
+
+// storageMirror returns the storage mirror, or nil if none is configured
+func (env *environment) storageMirror() (*client.StorageMirror, error) {
+	if env.cfg.Storage.MirrorDir == "" {
+		return nil, nil
+	}
+	storage, err := env.storage()
+	if err != nil {
+		return nil, err
+	}
+	mirror, err := client.NewStorageMirror(storage, env.cfg.Storage.MirrorDir)
+	if err != nil {
+		return nil, err
+	}
+	mirror.Logger = env.logger
+	return mirror, nil
+}
+
+// storageReader returns the storage mirror if there is one, storage otherwise
+func (env *environment) storageReader() (client.Storage, error) {
+	mirror, err := env.storageMirror()
+	if mirror != nil || err != nil {
+		return mirror, err
+	}
+	return env.storage()
+}
+
+// tokens resolves the bearer tokens of an API, given the TokenSource method of its configuration
+func (env *environment) tokens(source func(*secrets.Resolver) (httpclient.TokenSource, error)) (httpclient.TokenSource, error) {
+	resolver, err := env.cfg.Secrets.Resolver()
+	if err != nil {
+		return nil, err
+	}
+	return source(resolver)
+}
+
+func (env *environment) peer() (client.Peer, error) {
+	c := env.cfg.Orchestrator
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	mock := &client.PeerMock{}
+	if chaotic := env.cfg.Chaos.Chaos(); chaotic != nil {
+		mock.Faults = client.NewFaults().WithChaos(chaotic)
+	}
+	var peer client.Peer = mock
+	if !c.Mock {
+		peerAPI, err := client.NewPeerAPI(c.ConfigFile, c.OrgID, c.ChannelID, c.ChaincodeID)
+		if err != nil {
+			return nil, err
+		}
+		peerAPI.Logger = env.logger
+		peerAPI.PerfPrecision = c.PerfPrecision
+		peer = peerAPI
+	}
+	if env.audit != nil {
+		peer = client.NewAuditedPeer(peer, env.audit)
+	}
+	return peer, nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: morpheo [flags] <command> [arguments]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\n    \t%s\n", commands[name].usage, commands[name].description)
+	}
+	fmt.Fprintf(w, "\nRun morpheo -help for the list of flags.\n")
+}
+
+func main() {
+	cfg, args, err := config.LoadArgs("morpheo", os.Args[1:])
+	if err == flag.ErrHelp {
+		usage(os.Stderr)
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "morpheo: %s\n", err)
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "morpheo: unknown command %s\n\n", args[0])
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	logger, err := cfg.Logging.Logger(os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "morpheo: %s\n", err)
+		os.Exit(2)
+	}
+
+	if err := cfg.Audit.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "morpheo: %s\n", err)
+		os.Exit(2)
+	}
+	err = cfg.AirGap.Validate()
+	if err == nil {
+		err = cfg.CheckAirGap()
+	}
+	if err == nil {
+		err = cfg.Chaos.Validate()
+	}
+	if err == nil {
+		err = cfg.Tracing.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "morpheo: %s\n", err)
+		os.Exit(2)
+	}
+	recorder, err := cfg.Audit.Recorder(logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "morpheo: %s\n", err)
+		os.Exit(2)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Tracing())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "morpheo: %s\n", err)
+		os.Exit(2)
+	}
+	ctx, span := tracing.Tracer().Start(context.Background(), "morpheo "+args[0])
+
+	env := &environment{ctx: ctx, cfg: cfg, logger: logger, audit: recorder, stdin: os.Stdin, stdout: os.Stdout}
+	err = cmd.run(env, args[1:])
+	tracing.End(span, err)
+	flushCtx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+	if err := shutdownTracing(flushCtx); err != nil {
+		logger.Warnf("Error flushing traces: %s", err)
+	}
+	cancel()
+	if err != nil {
+		if _, invalid := err.(usageError); invalid {
+			fmt.Fprintf(os.Stderr, "morpheo: %s\nUsage: morpheo [flags] %s\n", err, cmd.usage)
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "morpheo %s: %s\n", args[0], strings.TrimSpace(err.Error()))
+		os.Exit(1)
+	}
+}
+
+// usageError denotes invalid command arguments
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
@@ -0,0 +1,361 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package algopack assembles algo submissions: the .tar.gz Docker build context posted to storage
+// (see client.StorageAPI.PostAlgo), built either from a Dockerfile and its files, or from a
+// reference to an existing image. Submissions are validated (entrypoint, labels) before being
+// packed, and packed deterministically so that their digest identifies their content.
+//
+//	pack, err := algopack.FromDir("my-algo", "./my-algo")
+//	archive, err := pack.Build()
+//	err = storage.PostAlgo(archive.Algo, archive.Size, archive.Reader())
+package algopack
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+)
+
+// Dockerfile is the name of the Dockerfile in a build context
+const Dockerfile = "Dockerfile"
+
+// Labels set on every algo image
+const (
+	LabelName   = "org.morpheo.algo.name"
+	LabelDigest = "org.morpheo.algo.files-digest"
+)
+
+var (
+	// imageRefRegexp loosely matches Docker image references ([registry/]repository[:tag][@digest])
+	imageRefRegexp = regexp.MustCompile(`^[a-z0-9]+([._/:-][a-z0-9]+)*(:[\w][\w.-]{0,127})?(@sha256:[a-f0-9]{64})?$`)
+	// labelRegexp matches the key=value pairs of a LABEL instruction
+	labelRegexp = regexp.MustCompile(`("[^"]*"|[^\s=]+)=("[^"]*"|\S*)`)
+)
+
+// Pack is an algo submission being assembled
+type Pack struct {
+	Name string
+	// Files of the build context, by slash-separated path. It holds the Dockerfile, unless the
+	// algo is an ImageRef.
+	Files map[string][]byte
+	// ImageRef, if set, refers to an existing image the algo is built from (a Dockerfile is
+	// generated)
+	ImageRef string
+	// Labels are added to the image
+	Labels map[string]string
+	// RequiredLabels have to be set, by Labels or by LABEL instructions of the Dockerfile
+	RequiredLabels []string
+}
+
+// New creates an empty submission
+func New(name string) *Pack {
+	return &Pack{Name: name, Files: map[string][]byte{}, Labels: map[string]string{}}
+}
+
+// FromImage creates a submission built from an existing image
+func FromImage(name, imageRef string) *Pack {
+	p := New(name)
+	p.ImageRef = imageRef
+	return p
+}
+
+// FromDir creates a submission from the files of a directory (holding a Dockerfile)
+func FromDir(name, dir string) (*Pack, error) {
+	p := New(name)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s isn't a regular file", path)
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		p.Files[filepath.ToSlash(rel)] = data
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[algopack] Error reading algo directory %s: %s", dir, err)
+	}
+	return p, nil
+}
+
+// AddFile adds a file to the build context
+func (p *Pack) AddFile(path string, data []byte) *Pack {
+	p.Files[path] = data
+	return p
+}
+
+// Validate returns nil if the submission can be packed: it has a name, either a Dockerfile with an
+// entrypoint or an image reference, the required labels and safe file paths
+func (p *Pack) Validate() error {
+	_, err := p.validate()
+	return err
+}
+
+// normalizedFiles returns the files of the build context keyed by their cleaned, slash-separated
+// paths, rejecting the paths escaping the build context and the ones naming the same file
+func (p *Pack) normalizedFiles() (map[string][]byte, error) {
+	files := make(map[string][]byte, len(p.Files))
+	for path, data := range p.Files {
+		clean := filepath.ToSlash(filepath.Clean(path))
+		if path == "" || strings.HasPrefix(path, "/") || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+			return nil, fmt.Errorf("file path %s escapes the build context", path)
+		}
+		if _, ok := files[clean]; ok {
+			return nil, fmt.Errorf("file path %s is given more than once", clean)
+		}
+		files[clean] = data
+	}
+	return files, nil
+}
+
+// validate validates the submission and returns its normalized files (see normalizedFiles)
+func (p *Pack) validate() (map[string][]byte, error) {
+	if p.Name == "" {
+		return nil, fmt.Errorf("'Name' unset")
+	}
+	files, err := p.normalizedFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	labels := map[string]string{}
+	dockerfile, hasDockerfile := files[Dockerfile]
+	switch {
+	case p.ImageRef != "" && hasDockerfile:
+		return nil, fmt.Errorf("the algo has both an image reference and a Dockerfile")
+	case p.ImageRef != "":
+		if !imageRefRegexp.MatchString(p.ImageRef) {
+			return nil, fmt.Errorf("invalid image reference %s", p.ImageRef)
+		}
+	case hasDockerfile:
+		instructions, err := parseDockerfile(dockerfile)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := instructions["FROM"]; !ok {
+			return nil, fmt.Errorf("the Dockerfile has no FROM instruction")
+		}
+		_, entrypoint := instructions["ENTRYPOINT"]
+		_, cmd := instructions["CMD"]
+		if !entrypoint && !cmd {
+			return nil, fmt.Errorf("the Dockerfile has no ENTRYPOINT nor CMD instruction")
+		}
+		for _, label := range instructions["LABEL"] {
+			for _, key := range labelKeys(label) {
+				labels[key] = ""
+			}
+		}
+	default:
+		return nil, fmt.Errorf("the algo has neither a Dockerfile nor an image reference")
+	}
+
+	for key, value := range p.Labels {
+		labels[key] = value
+	}
+	for _, key := range p.RequiredLabels {
+		if _, ok := labels[key]; !ok {
+			return nil, fmt.Errorf("required label %s unset", key)
+		}
+	}
+	return files, nil
+}
+
+// Archive is a packed algo submission
+type Archive struct {
+	// Algo is the metadata to post along with the archive
+	Algo common.Algo
+	// Digest is the hex SHA-256 digest of the archive
+	Digest string
+	// FileDigests are the hex SHA-256 digests of the files of the build context, by path
+	FileDigests map[string]string
+	Size        int64
+	data        []byte
+}
+
+// Reader returns the content of the archive (a .tar.gz build context)
+func (a *Archive) Reader() io.Reader {
+	return bytes.NewReader(a.data)
+}
+
+// Build validates the submission and packs it. Packing is deterministic (sorted entries, fixed
+// timestamps and permissions): the same files give the same digest.
+func (p *Pack) Build() (*Archive, error) {
+	files, err := p.validate()
+	if err != nil {
+		return nil, fmt.Errorf("[algopack] Invalid algo %s: %s", p.Name, err)
+	}
+
+	digests := map[string]string{}
+	for path, data := range files {
+		sum := sha256.Sum256(data)
+		digests[path] = hex.EncodeToString(sum[:])
+	}
+	if p.ImageRef != "" {
+		files[Dockerfile] = []byte(fmt.Sprintf("FROM %s\n", p.ImageRef))
+	}
+	dockerfile := append([]byte{}, files[Dockerfile]...)
+	files[Dockerfile] = append(dockerfile, p.labelInstruction(digests)...)
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, path := range paths {
+		header := &tar.Header{
+			Name:     path,
+			Mode:     0644,
+			Size:     int64(len(files[path])),
+			ModTime:  time.Unix(0, 0),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, fmt.Errorf("[algopack] Error packing %s: %s", path, err)
+		}
+		if _, err := tw.Write(files[path]); err != nil {
+			return nil, fmt.Errorf("[algopack] Error packing %s: %s", path, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("[algopack] Error packing algo %s: %s", p.Name, err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("[algopack] Error compressing algo %s: %s", p.Name, err)
+	}
+
+	algo := common.NewAlgo()
+	algo.Name = p.Name
+	sum := sha256.Sum256(buf.Bytes())
+	return &Archive{
+		Algo:        *algo,
+		Digest:      hex.EncodeToString(sum[:]),
+		FileDigests: digests,
+		Size:        int64(buf.Len()),
+		data:        buf.Bytes(),
+	}, nil
+}
+
+// labelInstruction returns the LABEL instruction appended to the Dockerfile: the algo labels, the
+// name of the algo and the digest of its files
+func (p *Pack) labelInstruction(digests map[string]string) string {
+	paths := make([]string, 0, len(digests))
+	for path := range digests {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	h := sha256.New()
+	for _, path := range paths {
+		fmt.Fprintf(h, "%s %s\n", digests[path], path)
+	}
+
+	labels := map[string]string{LabelName: p.Name, LabelDigest: hex.EncodeToString(h.Sum(nil))}
+	for key, value := range p.Labels {
+		labels[key] = value
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%q=%q", key, labels[key])
+	}
+	return "\nLABEL " + strings.Join(pairs, " ") + "\n"
+}
+
+// parseDockerfile returns the arguments of the instructions of a Dockerfile, by instruction
+func parseDockerfile(dockerfile []byte) (map[string][]string, error) {
+	instructions := map[string][]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(dockerfile))
+	line := ""
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(text, "#") || (text == "" && line == "") {
+			continue
+		}
+		if strings.HasSuffix(text, "\\") {
+			line += strings.TrimSuffix(text, "\\") + " "
+			continue
+		}
+		line += text
+		fields := strings.SplitN(line, " ", 2)
+		instruction, args := strings.ToUpper(fields[0]), ""
+		if len(fields) == 2 {
+			args = strings.TrimSpace(fields[1])
+		}
+		instructions[instruction] = append(instructions[instruction], args)
+		line = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading the Dockerfile: %s", err)
+	}
+	return instructions, nil
+}
+
+// labelKeys returns the keys set by the arguments of a LABEL instruction (key=value pairs)
+func labelKeys(args string) (keys []string) {
+	for _, pair := range labelRegexp.FindAllStringSubmatch(args, -1) {
+		keys = append(keys, strings.Trim(pair[1], `"`))
+	}
+	return keys
+}
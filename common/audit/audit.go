@@ -0,0 +1,173 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package audit records the mutations a Morpheo component makes on the orchestrator and storage
+// (status updates, result reports, uploads, deletions...) in an audit trail, for compliance
+// purposes: every event tells when, by whom, on which uplet or resource, and with what outcome.
+//
+// Note that this package must not import the common package, so that implementations living in
+// common can use it.
+package audit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+)
+
+// Audited actions
+const (
+	ActionRegister  = "register"
+	ActionSetWorker = "set_worker"
+	ActionPatch     = "patch"
+	ActionReport    = "report"
+	ActionInvoke    = "invoke"
+	ActionHeartbeat = "heartbeat"
+	ActionUpload    = "upload"
+	ActionSubmit    = "submit"
+	ActionDelete    = "delete"
+	ActionRename    = "rename"
+)
+
+// Targets of the audited actions
+const (
+	TargetOrchestrator = "orchestrator"
+	TargetStorage      = "storage"
+	TargetCompute      = "compute"
+	TargetBlobStore    = "blobstore"
+)
+
+// Outcomes of the audited actions
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Event is an entry of the audit trail
+type Event struct {
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Target   string    `json:"target"`
+	Action   string    `json:"action"`
+	Resource string    `json:"resource,omitempty"` // uplet type, or resource type (model, data...)
+	ID       string    `json:"id,omitempty"`       // uplet key, or resource ID
+	// Details holds action specific fields (new status, transaction ID, blob size...)
+	Details map[string]string `json:"details,omitempty"`
+	Outcome string            `json:"outcome"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// Sink stores audit events
+type Sink interface {
+	Record(event Event) error
+}
+
+// Recorder fills in and records audit events. A nil recorder records nothing, so that components
+// may call it unconditionally.
+//
+// Events are recorded once the action is done: a sink failing can't undo it, so its errors are
+// logged instead of being returned.
+type Recorder struct {
+	Sink Sink
+	// Actor identifies the component in the events it records (e.g. a worker ID or the common name
+	// of its certificate)
+	Actor  string
+	Clock  clock.Clock
+	Logger logging.Logger
+}
+
+// NewRecorder creates a recorder of the actions of actor
+func NewRecorder(sink Sink, actor string) *Recorder {
+	return &Recorder{Sink: sink, Actor: actor}
+}
+
+// Record records the outcome of an action: a success if err is nil, a failure otherwise
+func (r *Recorder) Record(target, action, resource, id string, details map[string]string, err error) {
+	if r == nil || r.Sink == nil {
+		return
+	}
+	event := Event{
+		Time:     clock.OrReal(r.Clock).Now().UTC(),
+		Actor:    r.Actor,
+		Target:   target,
+		Action:   action,
+		Resource: resource,
+		ID:       id,
+		Details:  details,
+		Outcome:  OutcomeSuccess,
+	}
+	if err != nil {
+		event.Outcome, event.Error = OutcomeFailure, err.Error()
+	}
+	if sinkErr := r.Sink.Record(event); sinkErr != nil {
+		logging.OrDefault(r.Logger).Errorf("[audit] Error recording %s %s of %s %s: %s", target, action, resource, id, sinkErr)
+	}
+}
+
+// MultiSink records events in every one of its sinks
+type MultiSink []Sink
+
+// Record records an event in every sink, returning the first error met
+func (s MultiSink) Record(event Event) (err error) {
+	for _, sink := range s {
+		if sinkErr := sink.Record(event); sinkErr != nil && err == nil {
+			err = sinkErr
+		}
+	}
+	return err
+}
+
+// MemorySink keeps audit events in memory, for tests
+type MemorySink struct {
+	lock   sync.Mutex
+	events []Event
+}
+
+// Record appends an event
+func (s *MemorySink) Record(event Event) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+// Events returns the recorded events
+func (s *MemorySink) Events() []Event {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Event(nil), s.events...)
+}
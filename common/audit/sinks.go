@@ -0,0 +1,100 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+)
+
+// FileSink appends audit events to a file, one JSON object per line. Every event is synced to
+// disk before Record returns.
+type FileSink struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens (or creates) the audit trail at path
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("[audit] Error opening audit trail %s: %s", path, err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Record appends an event to the file
+func (s *FileSink) Record(event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("[audit] Error marshaling event: %s", err)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("[audit] Error writing to %s: %s", s.file.Name(), err)
+	}
+	return s.file.Sync()
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
+
+// HTTPSink posts audit events (as JSON) to a collector, e.g. a SIEM ingestion endpoint. Its client
+// retries transient failures like any Morpheo HTTP client.
+type HTTPSink struct {
+	Client *httpclient.Client
+	Route  string
+}
+
+// NewHTTPSink creates a sink posting events to url
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{Client: httpclient.New("audit", url)}
+}
+
+// Record posts an event to the collector
+func (s *HTTPSink) Record(event Event) error {
+	return s.Client.PostJSON(s.Route, event, http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent)
+}
@@ -0,0 +1,183 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package auth authenticates the clients of the Morpheo HTTP APIs (the compute uplet submission
+// routes in particular), with static API keys or JWTs signed by a key published in a JWKS, and
+// enforces per-client rate limits and the scopes required by each route (e.g. uplet:create), so
+// that every component can be given least-privilege credentials.
+//
+//	authenticator := auth.Chain{auth.NewAPIKeys(keys), jwtValidator}
+//	mux.Handle(client.ComputeLearnupletRoute, auth.Middleware(authenticator, limiter, handler))
+//	// or, enforcing the scopes of the compute routes:
+//	auth.Middleware(authenticator, limiter, httpapi.ComputeSpec().RouteScopes().Middleware(mux))
+//
+// Note that this package must not import the common package.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+)
+
+// APIKeyHeader is the header carrying static API keys
+const APIKeyHeader = "X-Morpheo-API-Key"
+
+// ErrNoCredentials is returned by authenticators when the request carries no credentials they
+// handle, so that the next authenticator of a Chain gets a chance
+var ErrNoCredentials = errors.Newf(errors.Unauthorized, "No credentials provided")
+
+// Principal is an authenticated client
+type Principal struct {
+	// ID identifies the client (API key name or JWT subject). Rate limits are enforced per ID.
+	ID     string
+	Scopes []string
+	// RateLimit is the number of requests per second the client may perform (the limiter's default
+	// if zero), and Burst the number of requests it may perform at once
+	RateLimit float64
+	Burst     int
+}
+
+// Authenticator identifies the client that performed a request
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Chain tries authenticators in order, until one of them finds credentials it handles
+type Chain []Authenticator
+
+// Authenticate returns the principal identified by the first authenticator that handles the
+// request's credentials
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if err != ErrNoCredentials {
+			return p, err
+		}
+	}
+	return nil, ErrNoCredentials
+}
+
+// APIKeys authenticates requests carrying a static API key in the X-Morpheo-API-Key header
+type APIKeys struct {
+	keys map[[sha256.Size]byte]Principal
+}
+
+// NewAPIKeys creates an authenticator accepting a set of API keys, each one of them identifying a
+// principal
+func NewAPIKeys(keys map[string]Principal) *APIKeys {
+	a := &APIKeys{keys: map[[sha256.Size]byte]Principal{}}
+	for key, principal := range keys {
+		a.keys[sha256.Sum256([]byte(key))] = principal
+	}
+	return a
+}
+
+// Authenticate returns the principal identified by the request's API key
+func (a *APIKeys) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	// Keys are compared through their hash, in constant time
+	hash := sha256.Sum256([]byte(key))
+	for h, principal := range a.keys {
+		if subtle.ConstantTimeCompare(h[:], hash[:]) == 1 {
+			p := principal
+			return &p, nil
+		}
+	}
+	return nil, errors.Newf(errors.Unauthorized, "Invalid API key")
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying an authenticated principal
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal authenticated by Middleware, if any
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	return p, ok
+}
+
+// Middleware rejects unauthenticated requests with a 401, and requests exceeding their
+// principal's rate limit with a 429 (if limiter isn't nil). The principal is passed to next through
+// the request context (see FromContext).
+func Middleware(authenticator Authenticator, limiter *RateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := authenticator.Authenticate(r)
+		if err != nil {
+			WriteError(w, http.StatusUnauthorized, err)
+			return
+		}
+		if limiter != nil {
+			if ok, retryAfter := limiter.Allow(p); !ok {
+				w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(retryAfter.Seconds()))))
+				WriteError(w, http.StatusTooManyRequests, fmt.Errorf("Rate limit exceeded for %s", p.ID))
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), p)))
+	})
+}
+
+// BearerToken returns the token of an "Authorization: Bearer <token>" header, if any
+func BearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
+
+// WriteError writes an error as a Morpheo API JSON error body ({"error": "..."})
+func WriteError(w http.ResponseWriter, status int, err error) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="morpheo"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,327 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+)
+
+// DefaultJWKSRefreshInterval is the minimum interval between two fetches of the JWKS
+const DefaultJWKSRefreshInterval = time.Minute
+
+// JWTValidator authenticates requests carrying a JWT in their Authorization header. Tokens must be
+// signed with RS256 or ES256 by a key of the JWKS published at JWKSURL, which is fetched again
+// when a token refers to an unknown key ID (key rotation).
+type JWTValidator struct {
+	JWKSURL string
+	// Issuer and Audience, if set, must match the iss and aud claims
+	Issuer   string
+	Audience string
+	// Leeway is the clock skew tolerated when checking exp and nbf
+	Leeway time.Duration
+	// RefreshInterval is the minimum interval between two JWKS fetches
+	// (DefaultJWKSRefreshInterval if zero)
+	RefreshInterval time.Duration
+	HTTPClient      *http.Client
+	Clock           clock.Clock
+
+	lock       sync.Mutex
+	keys       map[string]crypto.PublicKey
+	fetchedAt  time.Time
+	refreshing *jwksRefresh
+}
+
+// jwksRefresh is a JWKS fetch in progress, shared by the concurrent callers of refresh
+type jwksRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+// NewJWTValidator creates a validator of the JWTs signed by the keys published at jwksURL
+func NewJWTValidator(jwksURL, issuer, audience string) *JWTValidator {
+	return &JWTValidator{
+		JWKSURL:  jwksURL,
+		Issuer:   issuer,
+		Audience: audience,
+		Leeway:   30 * time.Second,
+	}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Claims are the claims of a JWT checked by JWTValidator
+type Claims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt int64           `json:"exp"`
+	NotBefore int64           `json:"nbf"`
+	Scope     string          `json:"scope"`
+}
+
+// Authenticate validates the request's bearer token and returns the principal it identifies (its
+// subject), with the scopes of its scope claim
+func (v *JWTValidator) Authenticate(r *http.Request) (*Principal, error) {
+	token := BearerToken(r)
+	if token == "" || strings.Count(token, ".") != 2 {
+		return nil, ErrNoCredentials
+	}
+	claims, err := v.Validate(token)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{ID: claims.Subject, Scopes: strings.Fields(claims.Scope)}, nil
+}
+
+// Validate checks the signature and the claims of a token
+func (v *JWTValidator) Validate(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.Newf(errors.Unauthorized, "Malformed JWT")
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.Newf(errors.Unauthorized, "Malformed JWT header: %s", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Newf(errors.Unauthorized, "Malformed JWT signature: %s", err)
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(header.Alg, key, digest[:], signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.Newf(errors.Unauthorized, "Malformed JWT claims: %s", err)
+	}
+	now := clock.OrReal(v.Clock).Now()
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(v.Leeway)) {
+		return nil, errors.Newf(errors.Unauthorized, "JWT expired")
+	}
+	if claims.NotBefore != 0 && now.Add(v.Leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, errors.Newf(errors.Unauthorized, "JWT isn't valid yet")
+	}
+	if v.Issuer != "" && claims.Issuer != v.Issuer {
+		return nil, errors.Newf(errors.Unauthorized, "Unexpected JWT issuer %s", claims.Issuer)
+	}
+	if v.Audience != "" && !hasAudience(claims.Audience, v.Audience) {
+		return nil, errors.Newf(errors.Unauthorized, "JWT isn't intended for audience %s", v.Audience)
+	}
+	if claims.Subject == "" {
+		return nil, errors.Newf(errors.Unauthorized, "JWT has no subject")
+	}
+	return &claims, nil
+}
+
+// key returns the public key identified by kid, fetching the JWKS again if it's unknown
+func (v *JWTValidator) key(kid string) (crypto.PublicKey, error) {
+	if key, ok := v.cachedKey(kid); ok {
+		return key, nil
+	}
+	if err := v.refresh(); err != nil {
+		return nil, err
+	}
+	if key, ok := v.cachedKey(kid); ok {
+		return key, nil
+	}
+	return nil, errors.Newf(errors.Unauthorized, "Unknown JWT key ID %q", kid)
+}
+
+func (v *JWTValidator) cachedKey(kid string) (crypto.PublicKey, bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	key, ok := v.keys[kid]
+	return key, ok
+}
+
+// refresh fetches the JWKS again, unless it was fetched less than RefreshInterval ago. The fetch
+// happens without holding the lock, and concurrent callers wait for the one in progress instead of
+// sending their own.
+func (v *JWTValidator) refresh() error {
+	v.lock.Lock()
+	if r := v.refreshing; r != nil {
+		v.lock.Unlock()
+		<-r.done
+		return r.err
+	}
+	interval := v.RefreshInterval
+	if interval == 0 {
+		interval = DefaultJWKSRefreshInterval
+	}
+	now := clock.OrReal(v.Clock).Now()
+	if v.keys != nil && now.Sub(v.fetchedAt) < interval {
+		v.lock.Unlock()
+		return nil
+	}
+	r := &jwksRefresh{done: make(chan struct{})}
+	v.refreshing = r
+	v.lock.Unlock()
+
+	keys, err := v.fetchJWKS()
+
+	v.lock.Lock()
+	if err == nil {
+		v.keys, v.fetchedAt = keys, now
+	}
+	r.err = err
+	v.refreshing = nil
+	v.lock.Unlock()
+	close(r.done)
+	return err
+}
+
+func (v *JWTValidator) fetchJWKS() (map[string]crypto.PublicKey, error) {
+	client := httpclient.New("jwks", v.JWKSURL)
+	client.APIVersion = ""
+	client.HTTPClient = v.HTTPClient
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := client.DoJSON(&httpclient.Request{Method: http.MethodGet}, &jwks); err != nil {
+		return nil, fmt.Errorf("Error fetching JWKS: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range jwks.Keys {
+		switch k.Kty {
+		case "RSA":
+			n, errN := decodeBigInt(k.N)
+			e, errE := decodeBigInt(k.E)
+			if errN != nil || errE != nil || !e.IsInt64() {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, errX := decodeBigInt(k.X)
+			y, errY := decodeBigInt(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		}
+	}
+	return keys, nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, digest, signature []byte) error {
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if ok && rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest, signature) == nil {
+			return nil
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if ok && len(signature) == 64 {
+			r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+			if ecdsa.Verify(ecKey, digest, r, s) {
+				return nil
+			}
+		}
+	default:
+		return errors.Newf(errors.Unauthorized, "Unsupported JWT algorithm %q", alg)
+	}
+	return errors.Newf(errors.Unauthorized, "Invalid JWT signature")
+}
+
+func decodeSegment(segment string, dest interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dest)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// hasAudience checks the aud claim, which is either a string or a list of strings
+func hasAudience(raw json.RawMessage, audience string) bool {
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		return single == audience
+	}
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		for _, aud := range list {
+			if aud == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+)
+
+// RateLimiter enforces a token bucket rate limit per principal
+type RateLimiter struct {
+	// Rate is the default number of requests per second a principal may perform, and Burst the
+	// default number of requests it may perform at once
+	Rate  float64
+	Burst int
+	// Clock refills the buckets (the wall clock if nil)
+	Clock clock.Clock
+
+	lock    sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a rate limiter with default limits
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{Rate: rate, Burst: burst}
+}
+
+// Allow consumes a token of a principal's bucket. If the bucket is empty, it returns false and the
+// delay after which a token will be available.
+func (l *RateLimiter) Allow(p *Principal) (bool, time.Duration) {
+	rate, burst := l.Rate, l.Burst
+	if p.RateLimit > 0 {
+		rate = p.RateLimit
+	}
+	if p.Burst > 0 {
+		burst = p.Burst
+	}
+	if rate <= 0 {
+		return true, 0
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := clock.OrReal(l.Clock).Now()
+	if l.buckets == nil {
+		l.buckets = map[string]*bucket{}
+	}
+	b, ok := l.buckets[p.ID]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[p.ID] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
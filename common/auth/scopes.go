@@ -0,0 +1,188 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+)
+
+// Scopes granted to the clients of the Morpheo APIs, as <resource>:<action>. A principal granted
+// <resource>:* may perform any action on the resource, and one granted * anything.
+const (
+	ScopeUpletCreate = "uplet:create"
+	ScopeUpletRead   = "uplet:read"
+	ScopeUpletUpdate = "uplet:update"
+	ScopeResultPost  = "result:post"
+	ScopeBlobRead    = "blob:read"
+	ScopeBlobWrite   = "blob:write"
+	ScopeQueueRead   = "queue:read"
+	ScopeAll         = "*"
+)
+
+// HasScope returns true if the principal is granted scope
+func (p *Principal) HasScope(scope string) bool {
+	resource := scope
+	if i := strings.Index(scope, ":"); i >= 0 {
+		resource = scope[:i]
+	}
+	for _, granted := range p.Scopes {
+		if granted == scope || granted == ScopeAll || granted == resource+":*" {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingScopes returns the scopes the principal isn't granted among scopes
+func (p *Principal) MissingScopes(scopes ...string) []string {
+	var missing []string
+	for _, scope := range scopes {
+		if !p.HasScope(scope) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// RequireScopes rejects the requests whose principal (see Middleware) isn't granted every one of
+// scopes with a 403 (a 401 if the request wasn't authenticated)
+func RequireScopes(next http.Handler, scopes ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, scopes) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func authorize(w http.ResponseWriter, r *http.Request, scopes []string) bool {
+	if len(scopes) == 0 {
+		return true
+	}
+	p, ok := FromContext(r.Context())
+	if !ok {
+		WriteError(w, http.StatusUnauthorized, ErrNoCredentials)
+		return false
+	}
+	if missing := p.MissingScopes(scopes...); len(missing) > 0 {
+		WriteError(w, http.StatusForbidden, fmt.Errorf("%s isn't granted the %s scope(s)", p.ID, strings.Join(missing, ", ")))
+		return false
+	}
+	return true
+}
+
+// RouteScopes maps routes ("<METHOD> <path>", whose path segments may be {placeholders}) to the
+// scopes they require, e.g.:
+//
+//	auth.RouteScopes{"POST /learn": {auth.ScopeUpletCreate}, "GET /uplets/{key}": {auth.ScopeUpletRead}}
+//
+// Routes that aren't listed are denied: the routes open to any client (health checks,
+// documentation...) have to be listed with no scope, e.g. "GET /health": nil.
+type RouteScopes map[string][]string
+
+// For returns the scopes a request to path with method requires, and false if the route isn't
+// listed
+func (rs RouteScopes) For(method, path string) ([]string, bool) {
+	if scopes, ok := rs[method+" "+path]; ok {
+		return scopes, true
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for route, scopes := range rs {
+		parts := strings.SplitN(route, " ", 2)
+		if len(parts) == 2 && parts[0] == method && matchPath(strings.Split(strings.Trim(parts[1], "/"), "/"), segments) {
+			return scopes, true
+		}
+	}
+	return nil, false
+}
+
+func matchPath(pattern, segments []string) bool {
+	if len(pattern) != len(segments) {
+		return false
+	}
+	for i, p := range pattern {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if p != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware rejects the requests whose principal (see Middleware) isn't granted the scopes of
+// their route, and the requests to routes that aren't listed, with a 403. It goes after Middleware:
+//
+//	auth.Middleware(authenticator, limiter, scopes.Middleware(mux))
+func (rs RouteScopes) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scopes, ok := rs.For(r.Method, r.URL.Path)
+		if !ok {
+			WriteError(w, http.StatusForbidden, fmt.Errorf("%s %s isn't open to any scope", r.Method, r.URL.Path))
+			return
+		}
+		if !authorize(w, r, scopes) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// StaticTokens maps scopes to the tokens clients authenticate with (see httpclient.TokenSource),
+// so that every request only carries the token it needs. The token of the empty scope is used for
+// requests requiring no scope, or scopes without a token of their own.
+type StaticTokens map[string]string
+
+// Token returns the token of the first of scopes that has one
+func (t StaticTokens) Token(scopes []string) (string, error) {
+	for _, scope := range scopes {
+		if token, ok := t[scope]; ok {
+			return token, nil
+		}
+	}
+	if token, ok := t[""]; ok {
+		return token, nil
+	}
+	return "", errors.Newf(errors.Permanent, "No token granting the %s scope(s)", strings.Join(scopes, ", "))
+}
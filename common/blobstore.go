@@ -35,7 +35,12 @@
 
 package common
 
-import "io"
+import (
+	"io"
+	"strconv"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/audit"
+)
 
 // BlobStore describes an form of storage targeted at storing files, regardless of the data they
 // embed. A file is stored under a given key that can be used for further retrieval. It aims at
@@ -46,3 +51,31 @@ type BlobStore interface {
 	Delete(key string) error
 	Rename(key string, newKey string) error
 }
+
+// AuditedBlobStore is a BlobStore recording its mutations (puts, deletions and renames) in an audit
+// trail
+type AuditedBlobStore struct {
+	BlobStore
+	Audit *audit.Recorder
+}
+
+// Put stores a blob, and records it
+func (s *AuditedBlobStore) Put(key string, data io.Reader, size int64) error {
+	err := s.BlobStore.Put(key, data, size)
+	s.Audit.Record(audit.TargetBlobStore, audit.ActionUpload, "blob", key, map[string]string{"size": strconv.FormatInt(size, 10)}, err)
+	return err
+}
+
+// Delete deletes a blob, and records it
+func (s *AuditedBlobStore) Delete(key string) error {
+	err := s.BlobStore.Delete(key)
+	s.Audit.Record(audit.TargetBlobStore, audit.ActionDelete, "blob", key, nil, err)
+	return err
+}
+
+// Rename renames a blob, and records it
+func (s *AuditedBlobStore) Rename(key string, newKey string) error {
+	err := s.BlobStore.Rename(key, newKey)
+	s.Audit.Record(audit.TargetBlobStore, audit.ActionRename, "blob", key, map[string]string{"new_key": newKey}, err)
+	return err
+}
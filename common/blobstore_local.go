@@ -39,6 +39,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/bufpool"
 )
 
 // LocalBlobStore is a BlobStore implementations that stores data on the local hard drive
@@ -72,7 +74,7 @@ func (s *LocalBlobStore) Put(key string, data io.Reader, size int64) error {
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(file, data)
+	_, err = bufpool.Copy(file, data)
 	return err
 }
 
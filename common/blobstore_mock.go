@@ -41,6 +41,8 @@ import (
 	"io"
 	"io/ioutil"
 	"strings"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/chaos"
 )
 
 // This little perverts make Put and Get calls fail
@@ -49,8 +51,13 @@ const (
 	ViciousDevilUUID = "2cd41d08-ef54-4a15-95a1-2e84ca72a22c"
 )
 
-// MOCKBlobStore is a BlobStore implementations for tests
+// MOCKBlobStore is a BlobStore implementations for tests. It records its invocations (see Calls),
+// the payload of Put being the data written.
 type MOCKBlobStore struct {
+	CallRecorder
+
+	// Chaos, if set, injects seeded random latency, failures and partial writes into the calls
+	Chaos *chaos.Chaos
 }
 
 // NewMOCKBlobStore creates a new Blobstore for tests
@@ -64,30 +71,47 @@ func NewMOCKBlobStore(dataDir string) (BlobStore, error) {
 // Put writes a file in the data directory (and creates necessarry sub-directories if there are
 // forward slashes in the key name)
 func (s *MOCKBlobStore) Put(key string, data io.Reader, size int64) error {
-	if size == NaughtySize {
-		return fmt.Errorf("[fake-blobstore] What a naughty size")
+	if err := s.Chaos.Call("Put"); err != nil {
+		s.Record("Put", key, nil, err)
+		return err
 	}
-	return nil
+	payload, err := ReadPayload(s.Chaos.Reader("Put", data, size), DefaultMaxPayloadSize)
+	if err == nil && size == NaughtySize {
+		err = fmt.Errorf("[fake-blobstore] What a naughty size")
+	}
+	s.Record("Put", key, payload, err)
+	return err
 }
 
 // Get returns an io.ReadCloser on the data living under the provided key. The retriever must
 // explicitely call the Close() method on it when he's done reading.
 func (s *MOCKBlobStore) Get(key string) (data io.ReadCloser, err error) {
+	if err := s.Chaos.Call("Get"); err != nil {
+		s.Record("Get", key, nil, err)
+		return nil, err
+	}
 	// Check if uuid (end of key) is the ViciousDevilUUID
 	if strings.SplitAfter(key, "/")[1] == ViciousDevilUUID {
-		return nil, fmt.Errorf("[fake-blobstore] Runnin' With the Devil")
+		err = fmt.Errorf("[fake-blobstore] Runnin' With the Devil")
+		s.Record("Get", key, nil, err)
+		return nil, err
 	}
+	s.Record("Get", key, nil, nil)
 	return fakeFile(), nil
 }
 
 // Delete remove the file
 func (s *MOCKBlobStore) Delete(key string) (err error) {
-	return nil
+	err = s.Chaos.Call("Delete")
+	s.Record("Delete", key, nil, err)
+	return err
 }
 
 // Rename renames the file
 func (s *MOCKBlobStore) Rename(key string, newKey string) (err error) {
-	return nil
+	err = s.Chaos.Call("Rename")
+	s.Record("Rename", key, []byte(newKey), err)
+	return err
 }
 
 func fakeFile() io.ReadCloser {
@@ -46,6 +46,8 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
 )
 
 // S3BlobStore is a BlobStore implementations that stores data on AWS-S3
@@ -85,7 +87,7 @@ func (s *S3BlobStore) Put(key string, r io.Reader, size int64) error {
 		return fmt.Errorf("[s3-storage] Error constructing presigned request: %s", err)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpclient.SharedClient().Do(req)
 	if err != nil {
 		return fmt.Errorf("[s3-storage] Error uploading file: %s", err)
 	}
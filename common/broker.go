@@ -38,12 +38,22 @@ package common
 import (
 	"fmt"
 	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
 )
 
 // Topics (task queue names) for our broker
 const (
 	TrainTopic   = "train"
 	PredictTopic = "prediction"
+
+	// CancelTopic is a control topic: every worker receives every message pushed on it (see
+	// AddBroadcastHandler) and cancels the matching uplet if it happens to be running it.
+	CancelTopic = "cancel"
+
+	// StatusTopic is a notification topic: workers push a StatusEvent on it on every status
+	// transition of the uplets they process, for live progress notifiers to relay.
+	StatusTopic = "status"
 )
 
 // Producer is an abstract interface to a producer (pushes messages to a topic)
@@ -62,7 +72,25 @@ type Consumer interface {
 	// Add a handler function to the consumer for a given topic name. Up to concurrency tasks will be
 	// executed in parrallel. After the given timeout is reached, the task will be considered failed
 	// and will be re-enqueued.
-	AddHandler(topic string, handler Handler, concurrency int, timeout time.Duration)
+	AddHandler(topic string, handler Handler, concurrency int, timeout time.Duration) error
+
+	// Add a handler function for a given topic name that receives a copy of every message pushed on
+	// that topic, no matter how many other consumers are listening on it. It is meant for control
+	// topics such as CancelTopic.
+	AddBroadcastHandler(topic string, handler Handler, concurrency int, timeout time.Duration) error
+}
+
+// QueueDepth describes the messages waiting on a (topic, channel) pair
+type QueueDepth struct {
+	Topic    string `json:"topic"`
+	Channel  string `json:"channel,omitempty"`
+	Depth    int64  `json:"depth"`
+	InFlight int64  `json:"in_flight"`
+}
+
+// QueueInspector reports the depth of the broker queues
+type QueueInspector interface {
+	QueueDepths() ([]QueueDepth, error)
 }
 
 // Handler is an abstract Interface to a message handler Abstracts the way messages are handled so
@@ -80,6 +108,16 @@ func (err HandlerFatalError) Error() string {
 	return fmt.Sprintf("Fatal error in handler: ")
 }
 
+// Kind returns errors.Permanent: the message won't be requeued
+func (err HandlerFatalError) Kind() errors.Kind {
+	return errors.Permanent
+}
+
+// Is makes errors.Is(err, errors.ErrPermanent) work on fatal handler errors
+func (err HandlerFatalError) Is(target error) bool {
+	return errors.MatchKind(err.Kind(), target)
+}
+
 // NewHandlerFatalError builds an HandlerFatalError given an error message
 func NewHandlerFatalError(err error) HandlerFatalError {
 	return HandlerFatalError{
@@ -0,0 +1,359 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package common
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/chaos"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+)
+
+const (
+	// BrokerMemory identifies the in-memory broker type among other brokers (used when the user
+	// specifies the broker to be used as a CLI flag)
+	BrokerMemory = "memory"
+
+	// DefaultMemoryMaxAttempts is the number of times the in-memory broker delivers a message whose
+	// handler keeps failing, when no maximum is set
+	DefaultMemoryMaxAttempts = 5
+)
+
+// MemoryBroker is an in-memory broker, for integration tests and local development. It implements
+// Producer, and its consumers (see Consumer) implement Consumer, with NSQ's delivery semantics:
+//   - every channel of a topic gets a copy of every message pushed on it, each message of a channel
+//     being handled by a single handler of that channel
+//   - the messages pushed on a topic without channels wait for its first channel
+//   - messages are delivered at least once: those whose handler fails with a non fatal error are
+//     delivered again, up to MaxAttempts times
+//
+// Chaos, if set, delays pushes and deliveries, fails pushes, loses messages in flight (they are
+// delivered again, as if their handler had timed out) and delivers messages twice. Handler
+// timeouts aren't enforced otherwise.
+//
+// The zero value is an empty broker ready to use.
+type MemoryBroker struct {
+	Producer
+
+	MaxAttempts int
+	Chaos       *chaos.Chaos
+	Log         logging.Logger
+
+	lock    sync.Mutex
+	topics  map[string]*memoryTopic
+	stopped chan struct{}
+}
+
+type memoryTopic struct {
+	backlog  [][]byte
+	channels map[string]*memoryChannel
+}
+
+type memoryMessage struct {
+	body     []byte
+	attempts int
+}
+
+type memoryChannel struct {
+	topic, name string
+	queue       []*memoryMessage
+	inFlight    int
+	ready       *sync.Cond
+}
+
+// NewMemoryBroker creates an in-memory broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		Log: logging.Default().With(logging.Fields{logging.FieldComponent: "memory-broker"}),
+	}
+}
+
+// Push pushes a copy of a message to every channel of a topic
+func (b *MemoryBroker) Push(topic string, body []byte) (err error) {
+	if err := b.Chaos.Call("Push " + topic); err != nil {
+		return fmt.Errorf("Error publishing to the memory broker: %w", err)
+	}
+	body = append([]byte(nil), body...)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.isStopped() {
+		return fmt.Errorf("Error publishing to the memory broker: broker stopped")
+	}
+	t := b.topic(topic)
+	if len(t.channels) == 0 {
+		t.backlog = append(t.backlog, body)
+		return nil
+	}
+	for _, channel := range t.channels {
+		channel.enqueue(&memoryMessage{body: body})
+	}
+	return nil
+}
+
+// Stop stops the broker: pushes fail from then on, and its consumers stop consuming
+func (b *MemoryBroker) Stop() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.isStopped() {
+		return
+	}
+	if b.stopped == nil {
+		b.stopped = make(chan struct{})
+	}
+	close(b.stopped)
+	for _, t := range b.topics {
+		for _, channel := range t.channels {
+			channel.ready.Broadcast()
+		}
+	}
+}
+
+// isStopped tells whether Stop was called (the lock has to be held)
+func (b *MemoryBroker) isStopped() bool {
+	select {
+	case <-b.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// topic returns a topic, creating it if needed (the lock has to be held)
+func (b *MemoryBroker) topic(name string) *memoryTopic {
+	if b.topics == nil {
+		b.topics = map[string]*memoryTopic{}
+	}
+	t, ok := b.topics[name]
+	if !ok {
+		t = &memoryTopic{channels: map[string]*memoryChannel{}}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// channel returns a channel of a topic, creating it if needed: the first channel of a topic gets
+// the messages pushed before it existed
+func (b *MemoryBroker) channel(topic, name string) *memoryChannel {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	t := b.topic(topic)
+	channel, ok := t.channels[name]
+	if !ok {
+		channel = &memoryChannel{topic: topic, name: name, ready: sync.NewCond(&b.lock)}
+		for _, body := range t.backlog {
+			channel.enqueue(&memoryMessage{body: body})
+		}
+		t.backlog = nil
+		t.channels[name] = channel
+	}
+	return channel
+}
+
+// removeChannel removes a channel from its topic, dropping its messages
+func (b *MemoryBroker) removeChannel(channel *memoryChannel) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if t, ok := b.topics[channel.topic]; ok && t.channels[channel.name] == channel {
+		delete(t.channels, channel.name)
+	}
+}
+
+// enqueue appends a message to the queue of a channel (the broker lock has to be held)
+func (c *memoryChannel) enqueue(message *memoryMessage) {
+	c.queue = append(c.queue, message)
+	c.ready.Signal()
+}
+
+// QueueDepths reports the messages waiting on, and being handled from, every channel (topics
+// without channels are reported with an empty channel name)
+func (b *MemoryBroker) QueueDepths() ([]QueueDepth, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	depths := []QueueDepth{}
+	for name, t := range b.topics {
+		if len(t.channels) == 0 {
+			depths = append(depths, QueueDepth{Topic: name, Depth: int64(len(t.backlog))})
+		}
+		for _, channel := range t.channels {
+			depths = append(depths, QueueDepth{Topic: name, Channel: channel.name, Depth: int64(len(channel.queue)), InFlight: int64(channel.inFlight)})
+		}
+	}
+	sort.Slice(depths, func(i, j int) bool {
+		if depths[i].Topic != depths[j].Topic {
+			return depths[i].Topic < depths[j].Topic
+		}
+		return depths[i].Channel < depths[j].Channel
+	})
+	return depths, nil
+}
+
+// Consumer creates a consumer of the broker, listening on a channel
+func (b *MemoryBroker) Consumer(channel string) *MemoryConsumer {
+	return &MemoryConsumer{Channel: channel, broker: b}
+}
+
+// MemoryConsumer implements the Consumer interface on top of a MemoryBroker
+type MemoryConsumer struct {
+	Consumer
+
+	Channel string
+	// Verifier, if set, drops the messages whose signature is missing or invalid before they reach
+	// their handler
+	Verifier *MessageVerifier
+
+	broker   *MemoryBroker
+	handlers []memoryHandler
+	stopped  bool // guarded by the broker lock
+}
+
+type memoryHandler struct {
+	channel     *memoryChannel
+	handler     Handler
+	concurrency int
+	ephemeral   bool
+}
+
+// AddHandler adds a handler function (with a tunable level of concurrency) to the consumer
+func (c *MemoryConsumer) AddHandler(topic string, handler Handler, concurrency int, timeout time.Duration) (err error) {
+	return c.addHandlerOnChannel(topic, c.Channel, handler, concurrency, false)
+}
+
+// AddBroadcastHandler adds a handler function to the consumer that listens on its own ephemeral
+// channel, so that it gets to see every message of the topic. The channel is removed when the
+// consumer stops.
+func (c *MemoryConsumer) AddBroadcastHandler(topic string, handler Handler, concurrency int, timeout time.Duration) (err error) {
+	channel := fmt.Sprintf("%s-%s#ephemeral", c.Channel, uuid.NewV4().String()[:8])
+	return c.addHandlerOnChannel(topic, channel, handler, concurrency, true)
+}
+
+func (c *MemoryConsumer) addHandlerOnChannel(topic, channel string, handler Handler, concurrency int, ephemeral bool) error {
+	if concurrency <= 0 {
+		return fmt.Errorf("Error adding handler for topic %s: concurrency must be positive", topic)
+	}
+	c.handlers = append(c.handlers, memoryHandler{
+		channel:     c.broker.channel(topic, channel),
+		handler:     c.Verifier.Handler(topic, handler),
+		concurrency: concurrency,
+		ephemeral:   ephemeral,
+	})
+	return nil
+}
+
+// ConsumeUntilKilled handles messages until the consumer or the broker is stopped, then removes the
+// ephemeral channels of the consumer
+func (c *MemoryConsumer) ConsumeUntilKilled() {
+	var wg sync.WaitGroup
+	for _, h := range c.handlers {
+		for i := 0; i < h.concurrency; i++ {
+			wg.Add(1)
+			go func(h memoryHandler) {
+				defer wg.Done()
+				c.broker.deliver(c, h.channel, h.handler)
+			}(h)
+		}
+	}
+	wg.Wait()
+	for _, h := range c.handlers {
+		if h.ephemeral {
+			c.broker.removeChannel(h.channel)
+		}
+	}
+}
+
+// Stop stops the consumer: ConsumeUntilKilled returns once the messages being handled are
+func (c *MemoryConsumer) Stop() {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+	c.stopped = true
+	for _, h := range c.handlers {
+		h.channel.ready.Broadcast()
+	}
+}
+
+// deliver hands the messages of a channel to a handler until the consumer or the broker is stopped
+func (b *MemoryBroker) deliver(c *MemoryConsumer, channel *memoryChannel, handler Handler) {
+	maxAttempts := b.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMemoryMaxAttempts
+	}
+	logger := logging.OrDefault(b.Log).With(logging.Fields{"topic": channel.topic, "channel": channel.name})
+	for {
+		b.lock.Lock()
+		for len(channel.queue) == 0 && !b.isStopped() && !c.stopped {
+			channel.ready.Wait()
+		}
+		if b.isStopped() || c.stopped {
+			b.lock.Unlock()
+			return
+		}
+		message := channel.queue[0]
+		channel.queue = channel.queue[1:]
+		channel.inFlight++
+		message.attempts++
+		b.lock.Unlock()
+
+		var err error
+		dropped, duplicated := b.Chaos.Delivery(channel.topic + "/" + channel.name)
+		if dropped {
+			err = chaos.ErrDropped
+		} else {
+			err = handler(message.body)
+		}
+
+		b.lock.Lock()
+		channel.inFlight--
+		if duplicated {
+			channel.enqueue(&memoryMessage{body: message.body})
+		}
+		switch {
+		case err == nil:
+		case errors.KindOf(err) == errors.Permanent:
+			logger.Warnf("Dropping message after a fatal handler error: %s", err)
+		case message.attempts >= maxAttempts:
+			logger.Errorf("Dropping message after %d attempts: %s", message.attempts, err)
+		default:
+			channel.enqueue(message)
+		}
+		b.lock.Unlock()
+	}
+}
@@ -37,6 +37,8 @@ package common
 
 import (
 	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/chaos"
 )
 
 const (
@@ -45,30 +47,48 @@ const (
 	BrokerMOCK = "mock"
 )
 
-// ProducerMOCK is an implementation of our Producer interface for MOCK
+// ProducerMOCK is an implementation of our Producer interface for MOCK. It records its invocations
+// (see Calls), the ID of a push being its topic and its payload the message pushed.
 type ProducerMOCK struct {
+	CallRecorder
+
+	// Chaos, if set, injects seeded random latency and failures into the pushes
+	Chaos *chaos.Chaos
 }
 
-// Push returns nil
+// Push returns nil, unless Chaos drops the push
 func (p *ProducerMOCK) Push(topic string, body []byte) (err error) {
-	return nil
+	err = p.Chaos.Call("Push")
+	p.Record("Push", topic, body, err)
+	return err
 }
 
 // Stop returns nothing
 func (p *ProducerMOCK) Stop() {
+	p.Record("Stop", "", nil, nil)
 	return
 }
 
-// ConsumerMOCK implements an MOCK version of our Consumer interface
+// ConsumerMOCK implements an MOCK version of our Consumer interface. It records its invocations (see
+// Calls).
 type ConsumerMOCK struct {
+	CallRecorder
 }
 
 // ConsumeUntilKilled listens for messages on a given MOCK (topic, channel) pair until it's killed
 func (c *ConsumerMOCK) ConsumeUntilKilled() {
+	c.Record("ConsumeUntilKilled", "", nil, nil)
 	return
 }
 
 // AddHandler adds a handler function (with a tunable level of concurrency) to our MOCK consumer
 func (c *ConsumerMOCK) AddHandler(topic string, handler Handler, concurrency int, timeout time.Duration) (err error) {
+	c.Record("AddHandler", topic, nil, nil)
+	return nil
+}
+
+// AddBroadcastHandler adds a broadcast handler function to our MOCK consumer
+func (c *ConsumerMOCK) AddBroadcastHandler(topic string, handler Handler, concurrency int, timeout time.Duration) (err error) {
+	c.Record("AddBroadcastHandler", topic, nil, nil)
 	return nil
 }
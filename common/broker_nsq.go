@@ -36,6 +36,7 @@
 package common
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -43,6 +44,11 @@ import (
 	"time"
 
 	"github.com/nsqio/go-nsq"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
 )
 
 const (
@@ -90,12 +96,19 @@ func (p *ProducerNSQ) Stop() {
 type ConsumerNSQ struct {
 	Consumer
 
+	// NsqConsumer holds a consumer per (topic, channel) pair, keyed by "topic/channel" (see
+	// nsqConsumerKey)
 	NsqConsumer          map[string]*nsq.Consumer
 	LookupUrls           []string
 	NsqdURL              string
 	QueuePollingInterval time.Duration
 	Channel              string
-	Logger               *log.Logger
+	Logger               *log.Logger // Logger passed to the NSQ library
+	Log                  logging.Logger
+	Clock                Clock
+	// Verifier, if set, drops the messages whose signature is missing or invalid before they reach
+	// their handler
+	Verifier *MessageVerifier
 }
 
 // NewNSQConsumer instantiates ConsumerNSQ for the provided channel, using provided nsqlookupd URLs
@@ -107,9 +120,18 @@ func NewNSQConsumer(lookupUrls []string, nsqdURL string, channel string, queuePo
 		QueuePollingInterval: queuePollingInterval,
 		NsqConsumer:          map[string]*nsq.Consumer{},
 		Logger:               logger,
+		Log:                  logging.Default().With(logging.Fields{logging.FieldComponent: "nsq-consumer"}),
+		Clock:                clock.Real,
 	}
 }
 
+func (c *ConsumerNSQ) logger() logging.Logger {
+	if c.Log == nil {
+		return logging.Default().With(logging.Fields{logging.FieldComponent: "nsq-consumer"})
+	}
+	return c.Log
+}
+
 // ConsumeUntilKilled listens for messages on a given NSQ (topic, channel) pair until it's killed
 func (c *ConsumerNSQ) ConsumeUntilKilled() {
 	for _, consumer := range c.NsqConsumer {
@@ -120,10 +142,10 @@ func (c *ConsumerNSQ) ConsumeUntilKilled() {
 					break
 				}
 
-				log.Printf("[nsqlookupd-warning]: %s", err)
-				time.Sleep(c.QueuePollingInterval)
+				c.logger().Warnf("nsqlookupd: %s", err)
+				clock.OrReal(c.Clock).Sleep(c.QueuePollingInterval)
 			}
-			log.Println("[nsqlookupd] Topic found, let's start consuming messages...")
+			c.logger().Infof("nsqlookupd: topic found, let's start consuming messages...")
 		}(consumer)
 	}
 
@@ -134,9 +156,40 @@ func (c *ConsumerNSQ) ConsumeUntilKilled() {
 	}
 }
 
+// Stop stops every NSQ consumer, which makes ConsumeUntilKilled return once their in-flight
+// messages are handled
+func (c *ConsumerNSQ) Stop() {
+	for _, consumer := range c.NsqConsumer {
+		consumer.Stop()
+	}
+}
+
+// nsqConsumerKey identifies the consumer of a (topic, channel) pair. NSQ names can't contain
+// slashes, so the key is unambiguous.
+func nsqConsumerKey(topic, channel string) string {
+	return topic + "/" + channel
+}
+
 // AddHandler adds a handler function (with a tunable level of concurrency) to our NSQ consumer
 func (c *ConsumerNSQ) AddHandler(topic string, handler Handler, concurrency int, timeout time.Duration) (err error) {
-	log.Printf("Adding %d handler(s) for topic %s.", concurrency, topic)
+	c.logger().Infof("Adding %d handler(s) for topic %s.", concurrency, topic)
+	return c.addHandlerOnChannel(topic, c.Channel, handler, concurrency, timeout)
+}
+
+// AddBroadcastHandler adds a handler function to our NSQ consumer that listens on its own ephemeral
+// channel: NSQ copies every message of a topic to each of its channels, so every consumer gets to
+// see all of them. The channel vanishes as soon as the consumer disconnects.
+func (c *ConsumerNSQ) AddBroadcastHandler(topic string, handler Handler, concurrency int, timeout time.Duration) (err error) {
+	channel := fmt.Sprintf("%s-%s#ephemeral", c.Channel, uuid.NewV4().String()[:8])
+	c.logger().Infof("Adding %d broadcast handler(s) for topic %s (channel: %s).", concurrency, topic, channel)
+	return c.addHandlerOnChannel(topic, channel, handler, concurrency, timeout)
+}
+
+func (c *ConsumerNSQ) addHandlerOnChannel(topic, channel string, handler Handler, concurrency int, timeout time.Duration) (err error) {
+	key := nsqConsumerKey(topic, channel)
+	if _, ok := c.NsqConsumer[key]; ok {
+		return fmt.Errorf("Error adding handler: topic %s already has a handler on channel %s", topic, channel)
+	}
 
 	// Let's add our handler to that (topic, channel) tuple
 	config := nsq.NewConfig()
@@ -145,13 +198,16 @@ func (c *ConsumerNSQ) AddHandler(topic string, handler Handler, concurrency int,
 	config.HeartbeatInterval = c.QueuePollingInterval
 	config.MsgTimeout = timeout
 
-	consumer, err := nsq.NewConsumer(topic, c.Channel, config)
+	consumer, err := nsq.NewConsumer(topic, channel, config)
 	if err != nil {
 		return fmt.Errorf("Error creating NSQ Consumer for topic %s: %s", topic, err)
 	}
 	consumer.SetLogger(c.Logger, nsq.LogLevelWarning)
-	consumer.AddConcurrentHandlers(newHandlerWrapper(handler), concurrency)
-	c.NsqConsumer[topic] = consumer
+	consumer.AddConcurrentHandlers(newHandlerWrapper(c.Verifier.Handler(topic, handler), c.logger().With(logging.Fields{"topic": topic, "channel": channel})), concurrency)
+	if c.NsqConsumer == nil {
+		c.NsqConsumer = map[string]*nsq.Consumer{}
+	}
+	c.NsqConsumer[key] = consumer
 
 	// Pre-create Topics in order to avoid "404 not found Error" in logs
 	if err := c.CreateTopic(topic); err != nil {
@@ -165,16 +221,18 @@ type handlerWrapper struct {
 	nsq.Handler
 
 	handler Handler
+	logger  logging.Logger
 }
 
-func newHandlerWrapper(handler Handler) *handlerWrapper {
+func newHandlerWrapper(handler Handler, logger logging.Logger) *handlerWrapper {
 	return &handlerWrapper{
 		handler: handler,
+		logger:  logger,
 	}
 }
 
 func (hw *handlerWrapper) HandleMessage(message *nsq.Message) (err error) {
-	log.Printf("[DEBUG][nsq] nsq-consumer received task")
+	hw.logger.Debugf("nsq-consumer received task")
 	err = hw.handler(message.Body)
 	// TODO: smart backoff strategy
 	// if _, fatal := err.(HandlerFatalError); fatal {
@@ -190,7 +248,7 @@ func (c *ConsumerNSQ) CreateTopic(topic string) error {
 	if err != nil {
 		return fmt.Errorf("[nsqd] Error creating topics POST request against %s: %s", url, err)
 	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpclient.SharedClient().Do(req)
 	if err != nil {
 		return fmt.Errorf("[nsqd] Error performing result POST request against %s: %s", url, err)
 	}
@@ -201,3 +259,60 @@ func (c *ConsumerNSQ) CreateTopic(topic string) error {
 	}
 	return nil
 }
+
+// NSQInspector reports the depth of the topics and channels of an nsqd instance
+type NSQInspector struct {
+	QueueInspector
+
+	NsqdURL string // host:port of nsqd's HTTP interface
+}
+
+// QueueDepths fetches the depth of every topic and channel from nsqd's /stats endpoint. Topics
+// without channels are reported with an empty channel name.
+func (i *NSQInspector) QueueDepths() ([]QueueDepth, error) {
+	url := fmt.Sprintf("http://%s/stats?format=json", i.NsqdURL)
+	resp, err := httpclient.SharedClient().Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("[nsqd] Error performing stats GET request against %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("[nsqd] Unexpected status code (%s): stats GET request against %s, \nBody: %s", resp.Status, url, string(body))
+	}
+
+	type nsqTopics struct {
+		Topics []struct {
+			Name     string `json:"topic_name"`
+			Depth    int64  `json:"depth"`
+			Channels []struct {
+				Name     string `json:"channel_name"`
+				Depth    int64  `json:"depth"`
+				InFlight int64  `json:"in_flight_count"`
+			} `json:"channels"`
+		} `json:"topics"`
+	}
+	// nsqd < 1.0 wraps its stats in a "data" field
+	var stats struct {
+		nsqTopics
+		Data *nsqTopics `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("[nsqd] Error decoding stats retrieved from %s: %s", url, err)
+	}
+	topics := stats.nsqTopics
+	if stats.Data != nil {
+		topics = *stats.Data
+	}
+
+	depths := []QueueDepth{}
+	for _, topic := range topics.Topics {
+		if len(topic.Channels) == 0 {
+			depths = append(depths, QueueDepth{Topic: topic.Name, Depth: topic.Depth})
+		}
+		for _, channel := range topic.Channels {
+			depths = append(depths, QueueDepth{Topic: topic.Name, Channel: channel.Name, Depth: channel.Depth, InFlight: channel.InFlight})
+		}
+	}
+	return depths, nil
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package common
+
+import (
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/signing"
+)
+
+// SigningProducer is a Producer signing the messages it pushes (see signing.SignMessage), so that
+// workers can tell them from messages injected by anyone else with access to the broker
+type SigningProducer struct {
+	Producer
+	Signer *signing.Signer
+}
+
+// Push signs a message and pushes it
+func (p *SigningProducer) Push(topic string, body []byte) error {
+	signed, err := p.Signer.SignMessage(topic, body)
+	if err != nil {
+		return err
+	}
+	return p.Producer.Push(topic, signed)
+}
+
+// MessageVerifier checks the signature of incoming messages before they are handled (see
+// ConsumerNSQ.Verifier)
+type MessageVerifier struct {
+	Keys signing.Keys
+	// MaxAge rejects the messages signed more than MaxAge ago (signing.DefaultMessageMaxAge if not
+	// positive, see signing.VerifyMessage)
+	MaxAge time.Duration
+	// Topics lists the topics whose messages are verified (all of them if empty)
+	Topics []string
+	Clock  Clock
+	Logger logging.Logger
+}
+
+func (v *MessageVerifier) verifies(topic string) bool {
+	if len(v.Topics) == 0 {
+		return true
+	}
+	for _, t := range v.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler wraps the handler of a topic so that it only gets messages whose signature is valid, and
+// their original body. Other messages are dropped (they would fail again if requeued).
+func (v *MessageVerifier) Handler(topic string, handler Handler) Handler {
+	if v == nil || !v.verifies(topic) {
+		return handler
+	}
+	return func(message []byte) error {
+		body, err := signing.VerifyMessage(topic, message, v.Keys, v.MaxAge, v.Clock)
+		if err != nil {
+			logging.OrDefault(v.Logger).Warnf("[broker] Dropping message on topic %s: %s", topic, err)
+			return NewHandlerFatalError(err)
+		}
+		return handler(body)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package bufpool recycles the buffers of hot request paths (JSON marshaling, blob copies) through
+// sync.Pools, so that busy workers don't allocate, and collect, a buffer per request.
+//
+// Note that this package must not import the common package, so that implementations living in
+// common can use it.
+package bufpool
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// CopyBufferSize is the size of the buffers used by Copy (the one io.Copy allocates)
+const CopyBufferSize = 32 << 10
+
+// maxPooledSize is the capacity above which buffers aren't pooled: a single large payload
+// shouldn't pin its memory for the lifetime of the process
+const maxPooledSize = 1 << 20
+
+var (
+	copyBuffers = sync.Pool{New: func() interface{} {
+		buf := make([]byte, CopyBufferSize)
+		return &buf
+	}}
+	buffers = sync.Pool{New: func() interface{} { return &bytes.Buffer{} }}
+)
+
+// writerOnly hides the io.ReaderFrom implementation of a writer (e.g. *os.File, whose ReadFrom
+// falls back on io.Copy, and its own buffer, for sources that aren't files)
+type writerOnly struct {
+	io.Writer
+}
+
+// Copy copies src to dst like io.Copy, with a pooled buffer. It is meant for streams coming from
+// the network (blob downloads, request bodies...): copies from a file to a file are better off
+// with io.Copy, which uses the system's zero-copy calls.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	if wt, ok := src.(io.WriterTo); ok {
+		return wt.WriteTo(dst)
+	}
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	return io.CopyBuffer(writerOnly{dst}, src, *buf)
+}
+
+// Get returns an empty buffer from the pool. Hand it back with Put once its content isn't used
+// anymore.
+func Get() *bytes.Buffer {
+	return buffers.Get().(*bytes.Buffer)
+}
+
+// Put hands a buffer back to the pool
+func Put(buf *bytes.Buffer) {
+	if buf == nil || buf.Cap() > maxPooledSize {
+		return
+	}
+	buf.Reset()
+	buffers.Put(buf)
+}
+
+// MarshalJSON encodes v to JSON in a pooled buffer, to be handed back with Put. Unlike
+// json.Marshal's, the encoding ends with a newline.
+func MarshalJSON(v interface{}) (*bytes.Buffer, error) {
+	buf := Get()
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		Put(buf)
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/codec"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+)
+
+// CancelRequest is the message pushed on the CancelTopic to stop an uplet that is being processed by
+// a worker.
+type CancelRequest struct {
+	UpletType string `json:"uplet_type"`
+	UpletKey  string `json:"uplet_key"` // Key for a learnuplet, uuid for a preduplet
+	Reason    string `json:"reason"`
+}
+
+// Check returns nil if the cancel request is valid, an explicit error otherwise
+func (c *CancelRequest) Check() error {
+	if _, ok := ValidUplets[c.UpletType]; !ok {
+		return fmt.Errorf("uplet_type field ain't valid (provided: %s, possible choices: %s)", c.UpletType, ValidUplets)
+	}
+	if c.UpletKey == "" {
+		return fmt.Errorf("uplet_key field is required")
+	}
+	return nil
+}
+
+// PushCancelRequest validates a cancel request and pushes it on the CancelTopic, as JSON
+func PushCancelRequest(producer Producer, req CancelRequest) error {
+	return PushCancelRequestWith(producer, codec.JSON, req)
+}
+
+// PushCancelRequestWith validates a cancel request and pushes it on the CancelTopic, encoded with c
+func PushCancelRequestWith(producer Producer, c codec.Codec, req CancelRequest) error {
+	if err := req.Check(); err != nil {
+		return errors.Newf(errors.Validation, "Invalid cancel request: %s", err)
+	}
+	body, err := codec.EncodeMessage(c, req)
+	if err != nil {
+		return fmt.Errorf("Error marshaling cancel request to %s: %s", codec.OrJSON(c).ContentType(), err)
+	}
+	return producer.Push(CancelTopic, body)
+}
+
+type upletKeyContextKey struct{}
+
+// WithUpletKey returns a context carrying the key of the uplet it is processing: the containers run
+// with it are labelled with the key, so that KillUpletContainers only kills the containers of that
+// uplet
+func WithUpletKey(parent context.Context, upletKey string) context.Context {
+	return context.WithValue(parent, upletKeyContextKey{}, upletKey)
+}
+
+// UpletKeyFrom returns the uplet key a context carries (see WithUpletKey), if any
+func UpletKeyFrom(ctx context.Context) string {
+	upletKey, _ := ctx.Value(upletKeyContextKey{}).(string)
+	return upletKey
+}
+
+// CancelRegistry keeps track of the uplets a worker is currently processing, and of the function to
+// call to abort each of them (typically, killing its container and cleaning its data up).
+type CancelRegistry struct {
+	Logger logging.Logger
+
+	lock    sync.Mutex
+	running map[string]*cancellable
+}
+
+type cancellable struct {
+	cancel    func()
+	cancelled bool
+	// release, if set, is called when the uplet is unregistered
+	release func()
+}
+
+// NewCancelRegistry creates an empty CancelRegistry
+func NewCancelRegistry() *CancelRegistry {
+	return &CancelRegistry{
+		Logger:  logging.Default().With(logging.Fields{logging.FieldComponent: "cancel"}),
+		running: map[string]*cancellable{},
+	}
+}
+
+// Register declares that an uplet is being processed and how to abort it. The cancel function is
+// called at most once.
+func (r *CancelRegistry) Register(upletKey string, cancel func()) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.running[upletKey] = &cancellable{cancel: cancel}
+}
+
+// Context returns a context derived from parent, cancelled when a cancellation request is received
+// for an uplet: pass it to the processing of the uplet (e.g. RunImageInUntrustedContainerContext).
+// It carries the uplet key (see WithUpletKey), and is released when the uplet is unregistered.
+func (r *CancelRegistry) Context(parent context.Context, upletKey string) context.Context {
+	ctx, cancel := context.WithCancel(WithUpletKey(parent, upletKey))
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.running[upletKey] = &cancellable{cancel: cancel, release: cancel}
+	return ctx
+}
+
+// Unregister declares that an uplet isn't processed anymore. It returns true if the uplet was
+// cancelled in the meantime, in which case the worker should report it as TaskStatusCancelled.
+func (r *CancelRegistry) Unregister(upletKey string) (cancelled bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if c, ok := r.running[upletKey]; ok {
+		cancelled = c.cancelled
+		delete(r.running, upletKey)
+		if c.release != nil {
+			c.release()
+		}
+	}
+	return cancelled
+}
+
+// Cancelled returns true if a cancellation request was received for a running uplet
+func (r *CancelRegistry) Cancelled(upletKey string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	c, ok := r.running[upletKey]
+	return ok && c.cancelled
+}
+
+// Cancel aborts an uplet if it is running. It returns false if the uplet isn't processed here.
+func (r *CancelRegistry) Cancel(upletKey string) bool {
+	r.lock.Lock()
+	c, ok := r.running[upletKey]
+	if !ok || c.cancelled {
+		r.lock.Unlock()
+		return ok
+	}
+	c.cancelled = true
+	r.lock.Unlock()
+
+	c.cancel()
+	return true
+}
+
+// Handler returns a broker handler consuming CancelRequests, to be registered on the CancelTopic with
+// AddBroadcastHandler. Requests targeting uplets this worker isn't running are ignored. Requests may
+// be encoded with any codec (see codec.EncodeMessage).
+func (r *CancelRegistry) Handler() Handler {
+	return func(message []byte) error {
+		var req CancelRequest
+		if err := codec.DecodeMessage(message, &req); err != nil {
+			return NewHandlerFatalError(fmt.Errorf("Error un-marshaling cancel request: %s", err))
+		}
+		if err := req.Check(); err != nil {
+			return NewHandlerFatalError(fmt.Errorf("Invalid cancel request: %s", err))
+		}
+
+		if r.Cancel(req.UpletKey) {
+			r.Logger.With(logging.Fields{logging.FieldUplet: req.UpletKey}).Infof("Cancelled %s (reason: %s)", req.UpletType, req.Reason)
+		}
+		return nil
+	}
+}
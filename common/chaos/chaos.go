@@ -0,0 +1,244 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package chaos injects seeded, reproducible misbehaviour (latency, dropped calls, duplicate
+// deliveries and partial writes) into the mocks and fakes of the Morpheo components, so that
+// resilience paths (retries, deduplication, requeues) can be exercised in integration tests:
+//
+//	c := chaos.New(chaos.Config{Seed: 42, MaxLatency: 50 * time.Millisecond, DropRate: 0.1})
+//	storage.Faults = client.NewFaults().WithChaos(c)
+//	broker.Chaos = c
+//
+// Every decision only depends on the seed, the operation it is about and the number of times this
+// operation was subjected to chaos before: a run can be replayed by reusing its seed, even if
+// operations interleave differently across goroutines.
+//
+// Note that this package must not import the common package, so that the mocks living in common
+// can use it.
+package chaos
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+)
+
+// Injected failures. Both are transient: retrying the operation may succeed.
+var (
+	ErrDropped      = errors.Newf(errors.Transient, "chaos: dropped")
+	ErrPartialWrite = errors.Newf(errors.Transient, "chaos: partial write")
+)
+
+// Config describes the misbehaviour to inject. Rates are probabilities, between 0 and 1.
+type Config struct {
+	Seed int64
+	// Operations are delayed by a duration picked between MinLatency and MaxLatency
+	MinLatency time.Duration
+	MaxLatency time.Duration
+	// DropRate is the probability that an operation fails (or that a message is lost in flight)
+	DropRate float64
+	// DuplicateRate is the probability that a message is delivered twice
+	DuplicateRate float64
+	// PartialWriteRate is the probability that a write stops before its end
+	PartialWriteRate float64
+}
+
+// Validate checks the rates and latencies of a configuration
+func (c Config) Validate() error {
+	rates := map[string]float64{"drop": c.DropRate, "duplicate": c.DuplicateRate, "partial write": c.PartialWriteRate}
+	for name, rate := range rates {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("chaos: %s rate must be between 0 and 1 (provided: %g)", name, rate)
+		}
+	}
+	if c.MinLatency < 0 || c.MaxLatency < c.MinLatency {
+		return fmt.Errorf("chaos: latencies must satisfy 0 <= min <= max (provided: %s, %s)", c.MinLatency, c.MaxLatency)
+	}
+	return nil
+}
+
+// Stats counts the misbehaviours injected so far
+type Stats struct {
+	Operations int `json:"operations"`
+	Delayed    int `json:"delayed"`
+	Dropped    int `json:"dropped"`
+	Duplicated int `json:"duplicated"`
+	Partial    int `json:"partial"`
+}
+
+// Chaos injects misbehaviour into operations. A nil *Chaos injects nothing, so that mocks may call
+// it unconditionally.
+type Chaos struct {
+	Config
+	// Clock waits out the injected latency (the wall clock if nil): use a fake clock to keep tests
+	// fast
+	Clock clock.Clock
+
+	lock  sync.Mutex
+	count map[string]uint64
+	stats Stats
+}
+
+// New creates a chaos injector
+func New(config Config) *Chaos {
+	return &Chaos{Config: config}
+}
+
+// draws returns the pseudo-random numbers in [0, 1) of the next occurrence of an operation, one
+// per decision to make about it
+func (c *Chaos) draws(op string, n int) []float64 {
+	c.lock.Lock()
+	if c.count == nil {
+		c.count = map[string]uint64{}
+	}
+	occurrence := c.count[op]
+	c.count[op]++
+	c.stats.Operations++
+	c.lock.Unlock()
+
+	h := fnv.New64a()
+	io.WriteString(h, op)
+	state := uint64(c.Seed) ^ h.Sum64() ^ (occurrence * 0x9e3779b97f4a7c15)
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = float64(splitmix64(&state)>>11) / (1 << 53)
+	}
+	return values
+}
+
+// splitmix64 is a small, well distributed pseudo-random generator
+func splitmix64(state *uint64) uint64 {
+	*state += 0x9e3779b97f4a7c15
+	z := *state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (c *Chaos) inc(stat *int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	*stat++
+}
+
+// delay waits out the latency picked by draw
+func (c *Chaos) delay(draw float64) {
+	latency := c.MinLatency + time.Duration(draw*float64(c.MaxLatency-c.MinLatency))
+	if latency > 0 {
+		c.inc(&c.stats.Delayed)
+		clock.OrReal(c.Clock).Sleep(latency)
+	}
+}
+
+// Call delays an operation (a mock method, a message push...) and returns ErrDropped if it should
+// fail
+func (c *Chaos) Call(op string) error {
+	if c == nil {
+		return nil
+	}
+	draws := c.draws(op, 2)
+	c.delay(draws[0])
+	if draws[1] < c.DropRate {
+		c.inc(&c.stats.Dropped)
+		return ErrDropped
+	}
+	return nil
+}
+
+// Delivery delays the delivery of a message and tells whether it is lost in flight and whether it
+// is delivered twice
+func (c *Chaos) Delivery(op string) (dropped, duplicated bool) {
+	if c == nil {
+		return false, false
+	}
+	draws := c.draws(op, 3)
+	c.delay(draws[0])
+	if dropped = draws[1] < c.DropRate; dropped {
+		c.inc(&c.stats.Dropped)
+	}
+	if duplicated = !dropped && draws[2] < c.DuplicateRate; duplicated {
+		c.inc(&c.stats.Duplicated)
+	}
+	return dropped, duplicated
+}
+
+// Reader returns a reader on the data an operation writes, which may fail with ErrPartialWrite
+// partway, after a number of bytes picked below size (or below 64kB if size is unknown)
+func (c *Chaos) Reader(op string, r io.Reader, size int64) io.Reader {
+	if c == nil || c.PartialWriteRate <= 0 {
+		return r
+	}
+	draws := c.draws(op, 2)
+	if draws[0] >= c.PartialWriteRate {
+		return r
+	}
+	if size <= 0 {
+		size = 64 << 10
+	}
+	c.inc(&c.stats.Partial)
+	return &partialReader{r: r, left: int64(draws[1] * float64(size))}
+}
+
+type partialReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (p *partialReader) Read(b []byte) (int, error) {
+	if p.left <= 0 {
+		return 0, ErrPartialWrite
+	}
+	if int64(len(b)) > p.left {
+		b = b[:p.left]
+	}
+	n, err := p.r.Read(b)
+	p.left -= int64(n)
+	return n, err
+}
+
+// Stats returns the misbehaviours injected so far
+func (c *Chaos) Stats() Stats {
+	if c == nil {
+		return Stats{}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.stats
+}
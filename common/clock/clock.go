@@ -0,0 +1,157 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package clock abstracts time away, so that retries, heartbeats, polling and deadlines can be
+// tested deterministically with a Fake clock instead of real sleeps.
+//
+// Note that this package must not import the common package (which re-exports Clock).
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits
+type Clock interface {
+	Now() time.Time
+	// After sends the current time on the returned channel once d has elapsed
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+// Real is the wall clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+// OrReal returns c, or the Real clock if c is nil
+func OrReal(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+// Fake is a clock whose time only moves forward when Advance (or Set) is called. Its zero value
+// isn't usable: use NewFake.
+type Fake struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+type waiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+// NewFake creates a fake clock set at a given time
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the fake current time
+func (f *Fake) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+// After returns a channel receiving the fake time once the clock has been advanced by d
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	w := &waiter{deadline: f.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- f.now
+		return w.c
+	}
+	f.waiters = append(f.waiters, w)
+	return w.c
+}
+
+// Sleep blocks until the clock has been advanced by d
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+// Advance moves the clock forward, waking up the waiters whose deadline is reached
+func (f *Fake) Advance(d time.Duration) {
+	f.lock.Lock()
+	f.setLocked(f.now.Add(d))
+	f.lock.Unlock()
+}
+
+// Set moves the clock to a given time (which must not be in the fake past)
+func (f *Fake) Set(now time.Time) {
+	f.lock.Lock()
+	f.setLocked(now)
+	f.lock.Unlock()
+}
+
+// Waiters returns the number of pending After and Sleep calls, so that tests can wait for the code
+// under test to block before advancing the clock
+func (f *Fake) Waiters() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.waiters)
+}
+
+// BlockUntil waits (for real) until at least n After or Sleep calls are pending
+func (f *Fake) BlockUntil(n int) {
+	for f.Waiters() < n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (f *Fake) setLocked(now time.Time) {
+	f.now = now
+	sort.Slice(f.waiters, func(i, j int) bool { return f.waiters[i].deadline.Before(f.waiters[j].deadline) })
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.deadline.After(now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.c <- now
+	}
+	f.waiters = pending
+}
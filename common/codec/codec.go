@@ -0,0 +1,247 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package codec abstracts the serialization of the payloads exchanged by the Morpheo components
+// (broker messages and HTTP bodies): JSON, or the more compact MessagePack and CBOR encodings,
+// identified by their content type. Binary codecs reuse the `json` struct tags, so that the same
+// data structures can be sent either way.
+//
+// Note that this package must not import the common package, so that implementations living in
+// common can use it.
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	ugorji "github.com/ugorji/go/codec"
+)
+
+// Content types of the supported encodings
+const (
+	ContentTypeJSON        = "application/json"
+	ContentTypeMessagePack = "application/msgpack"
+	ContentTypeCBOR        = "application/cbor"
+)
+
+// Codec encodes and decodes payloads of a given content type
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Supported codecs
+var (
+	JSON        Codec = jsonCodec{}
+	MessagePack Codec = newUgorjiCodec(ContentTypeMessagePack, msgpackHandle())
+	CBOR        Codec = newUgorjiCodec(ContentTypeCBOR, cborHandle())
+)
+
+// aliases maps the content types found in the wild to the supported codecs
+var aliases = map[string]Codec{
+	ContentTypeJSON:           JSON,
+	ContentTypeMessagePack:    MessagePack,
+	"application/x-msgpack":   MessagePack,
+	"application/vnd.msgpack": MessagePack,
+	ContentTypeCBOR:           CBOR,
+}
+
+// ForContentType returns the codec of a content type (e.g. a Content-Type header, parameters are
+// ignored)
+func ForContentType(contentType string) (Codec, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	c, ok := aliases[strings.ToLower(mediaType)]
+	return c, ok
+}
+
+// Negotiate picks the codec of a response from the Accept header of a request: the offered codec
+// the client prefers (JSON if the client accepts none of them)
+func Negotiate(accept string, offered ...Codec) Codec {
+	type candidate struct {
+		codec   Codec
+		quality float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil || quality <= 0 {
+				continue
+			}
+		}
+		c, ok := aliases[strings.ToLower(mediaType)]
+		if !ok {
+			continue
+		}
+		for _, o := range offered {
+			if o == c {
+				candidates = append(candidates, candidate{codec: c, quality: quality})
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return JSON
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+	return candidates[0].codec
+}
+
+// Accept returns the Accept header of a client preferring c, falling back to JSON
+func Accept(c Codec) string {
+	if c == nil || c == JSON {
+		return ContentTypeJSON
+	}
+	return c.ContentType() + ", " + ContentTypeJSON + ";q=0.5"
+}
+
+// OrJSON returns c, or JSON if c is nil
+func OrJSON(c Codec) Codec {
+	if c == nil {
+		return JSON
+	}
+	return c
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type ugorjiCodec struct {
+	contentType string
+	handle      ugorji.Handle
+}
+
+func newUgorjiCodec(contentType string, handle ugorji.Handle) *ugorjiCodec {
+	return &ugorjiCodec{contentType: contentType, handle: handle}
+}
+
+func (c *ugorjiCodec) ContentType() string {
+	return c.contentType
+}
+
+func (c *ugorjiCodec) Marshal(v interface{}) (data []byte, err error) {
+	err = ugorji.NewEncoderBytes(&data, c.handle).Encode(v)
+	return data, err
+}
+
+func (c *ugorjiCodec) Unmarshal(data []byte, v interface{}) error {
+	return ugorji.NewDecoderBytes(data, c.handle).Decode(v)
+}
+
+// typeInfos makes binary codecs honor `json` struct tags
+var typeInfos = ugorji.NewTypeInfos([]string{"codec", "json"})
+
+func msgpackHandle() *ugorji.MsgpackHandle {
+	h := &ugorji.MsgpackHandle{WriteExt: true}
+	h.TypeInfos = typeInfos
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}
+
+func cborHandle() *ugorji.CborHandle {
+	h := &ugorji.CborHandle{}
+	h.TypeInfos = typeInfos
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}
+
+// Broker messages have no headers to carry their content type: messages encoded with a binary
+// codec are prefixed with messageMagic, the length of their content type and their content type.
+// JSON messages aren't prefixed, so that they stay readable by consumers unaware of codecs.
+const messageMagic = 0x00
+
+// EncodeMessage encodes a broker message body with c
+func EncodeMessage(c Codec, v interface{}) ([]byte, error) {
+	c = OrJSON(c)
+	data, err := c.Marshal(v)
+	if err != nil || c == JSON {
+		return data, err
+	}
+	contentType := c.ContentType()
+	message := make([]byte, 0, 2+len(contentType)+len(data))
+	message = append(message, messageMagic, byte(len(contentType)))
+	message = append(message, contentType...)
+	return append(message, data...), nil
+}
+
+// MessageCodec returns the codec a broker message was encoded with, and its payload
+func MessageCodec(message []byte) (Codec, []byte, error) {
+	if len(message) == 0 || message[0] != messageMagic {
+		return JSON, message, nil
+	}
+	if len(message) < 2 || len(message) < 2+int(message[1]) {
+		return nil, nil, fmt.Errorf("[codec] Truncated message header")
+	}
+	contentType := string(message[2 : 2+int(message[1])])
+	c, ok := ForContentType(contentType)
+	if !ok {
+		return nil, nil, fmt.Errorf("[codec] Unsupported message content type %s", contentType)
+	}
+	return c, message[2+int(message[1]):], nil
+}
+
+// DecodeMessage decodes a broker message body encoded by EncodeMessage (with any codec)
+func DecodeMessage(message []byte, v interface{}) error {
+	c, payload, err := MessageCodec(message)
+	if err != nil {
+		return err
+	}
+	return c.Unmarshal(payload, v)
+}
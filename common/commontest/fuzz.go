@@ -0,0 +1,109 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package commontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+)
+
+// Fuzz functions of the decoders of untrusted broker and HTTP input. The native fuzz targets of
+// common/fuzz_test.go run them over seed corpora (go test -fuzz FuzzPreduplet ./common/); they
+// also build for go-fuzz:
+//
+//	go-fuzz-build -func FuzzLearnuplet github.com/MorpheoOrg/morpheo-go-packages/common/commontest
+//	go-fuzz -bin commontest-fuzz.zip -workdir fuzz/learnuplet
+//
+// The golden fixtures (testdata/) make a good initial corpus. Besides not panicking, decoding a
+// valid value, encoding it and decoding it again has to give the same value.
+
+// FuzzLearnuplet fuzzes the decoding and validation of learnuplets
+func FuzzLearnuplet(data []byte) int {
+	return fuzzJSON(data, func() validatable { return &common.Learnuplet{} })
+}
+
+// FuzzPreduplet fuzzes the decoding and validation of preduplets
+func FuzzPreduplet(data []byte) int {
+	return fuzzJSON(data, func() validatable { return &common.Preduplet{} })
+}
+
+// FuzzStatusEvent fuzzes the decoding and validation of status events
+func FuzzStatusEvent(data []byte) int {
+	return fuzzJSON(data, func() validatable { return &common.StatusEvent{} })
+}
+
+// FuzzLearnupletChaincode fuzzes the decoding of chaincode learnuplets and their conversion
+func FuzzLearnupletChaincode(data []byte) int {
+	var l common.LearnupletChaincode
+	if err := json.Unmarshal(data, &l); err != nil {
+		return 0
+	}
+	learnuplet, err := l.LearnupletFormat()
+	if err != nil {
+		return 0
+	}
+	learnuplet.Check()
+	return 1
+}
+
+type validatable interface {
+	Check() error
+}
+
+func fuzzJSON(data []byte, newValue func() validatable) int {
+	v := newValue()
+	if err := json.Unmarshal(data, v); err != nil {
+		return 0
+	}
+	if err := v.Check(); err != nil {
+		return 0
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("Error encoding valid %T: %s", v, err))
+	}
+	decoded := newValue()
+	if err := json.Unmarshal(encoded, decoded); err != nil {
+		panic(fmt.Sprintf("Error decoding encoded %T: %s", v, err))
+	}
+	if !reflect.DeepEqual(v, decoded) {
+		panic(fmt.Sprintf("%T changed through a round trip: %+v != %+v", v, v, decoded))
+	}
+	return 1
+}
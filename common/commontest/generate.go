@@ -0,0 +1,191 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package commontest
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/satori/go.uuid"
+)
+
+var statuses = []string{
+	common.TaskStatusTodo,
+	common.TaskStatusPending,
+	common.TaskStatusDone,
+	common.TaskStatusFailed,
+	common.TaskStatusCancelled,
+}
+
+// RandomUUID returns a random (version 4) UUID drawn from rng, so that generated values are
+// reproducible from the rng seed
+func RandomUUID(rng *rand.Rand) uuid.UUID {
+	b := make([]byte, 16)
+	rng.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	id, _ := uuid.FromBytes(b)
+	return id
+}
+
+func randomUUIDs(rng *rand.Rand, min, max int) []uuid.UUID {
+	ids := make([]uuid.UUID, min+rng.Intn(max-min+1))
+	for i := range ids {
+		ids[i] = RandomUUID(rng)
+	}
+	return ids
+}
+
+func randomTimestamp(rng *rand.Rand) int {
+	return 1500000000 + rng.Intn(100000000)
+}
+
+// RandomLearnuplet returns a random valid learnuplet
+func RandomLearnuplet(rng *rand.Rand) common.Learnuplet {
+	l := common.Learnuplet{
+		Key:         fmt.Sprintf("learnuplet_%s", RandomUUID(rng)),
+		Problem:     RandomUUID(rng),
+		TrainData:   randomUUIDs(rng, 1, 5),
+		TestData:    randomUUIDs(rng, 1, 3),
+		Algo:        RandomUUID(rng),
+		ModelEnd:    RandomUUID(rng),
+		Rank:        rng.Intn(4),
+		Worker:      RandomUUID(rng),
+		Status:      statuses[rng.Intn(len(statuses))],
+		RequestDate: randomTimestamp(rng),
+	}
+	if l.Rank > 0 {
+		l.ModelStart = RandomUUID(rng)
+	}
+	if l.Status == common.TaskStatusDone {
+		l.CompletionDate = l.RequestDate + rng.Intn(86400)
+	}
+	return l
+}
+
+// NearValidLearnuplet returns a random learnuplet with exactly one invalid field, and the name of
+// this field (as reported in validation errors)
+func NearValidLearnuplet(rng *rand.Rand) (common.Learnuplet, string) {
+	l := RandomLearnuplet(rng)
+	switch rng.Intn(8) {
+	case 0:
+		l.Key = ""
+		return l, "key"
+	case 1:
+		l.Problem = uuid.Nil
+		return l, "problem"
+	case 2:
+		l.Algo = uuid.Nil
+		return l, "algo"
+	case 3:
+		l.TrainData = nil
+		return l, "train_data"
+	case 4:
+		n := rng.Intn(len(l.TrainData))
+		l.TrainData[n] = uuid.Nil
+		return l, fmt.Sprintf("train_data[%d]", n)
+	case 5:
+		l.TestData = []uuid.UUID{}
+		return l, "test_data"
+	case 6:
+		l.Status = "unknown"
+		return l, "status"
+	default:
+		l.Rank = 1 + rng.Intn(3)
+		l.ModelStart = uuid.Nil
+		return l, "model_start"
+	}
+}
+
+// RandomPreduplet returns a random valid preduplet
+func RandomPreduplet(rng *rand.Rand) common.Preduplet {
+	p := common.Preduplet{
+		ID:          RandomUUID(rng),
+		Problem:     RandomUUID(rng),
+		Model:       RandomUUID(rng),
+		Data:        RandomUUID(rng),
+		Worker:      RandomUUID(rng),
+		Status:      statuses[rng.Intn(len(statuses))],
+		RequestDate: randomTimestamp(rng),
+	}
+	if p.Status == common.TaskStatusDone {
+		p.CompletionDate = p.RequestDate + rng.Intn(86400)
+		p.PredictionStorageID = RandomUUID(rng)
+	}
+	return p
+}
+
+// NearValidPreduplet returns a random preduplet with exactly one invalid field, and the name of
+// this field (as reported in validation errors)
+func NearValidPreduplet(rng *rand.Rand) (common.Preduplet, string) {
+	p := RandomPreduplet(rng)
+	switch rng.Intn(5) {
+	case 0:
+		p.ID = uuid.Nil
+		return p, "uuid"
+	case 1:
+		p.Problem = uuid.Nil
+		return p, "problem"
+	case 2:
+		p.Model = uuid.Nil
+		return p, "model"
+	case 3:
+		p.Data = uuid.Nil
+		return p, "data"
+	default:
+		p.Status = ""
+		return p, "status"
+	}
+}
+
+// RandomStatusEvent returns a random valid status event
+func RandomStatusEvent(rng *rand.Rand) common.StatusEvent {
+	e := common.StatusEvent{
+		UpletType: common.TypeLearnuplet,
+		Status:    statuses[rng.Intn(len(statuses))],
+		Time:      time.Unix(int64(randomTimestamp(rng)), 0).UTC(),
+	}
+	if rng.Intn(2) == 0 {
+		e.UpletType = common.TypePredUplet
+	}
+	e.UpletKey = fmt.Sprintf("%s_%s", e.UpletType, RandomUUID(rng))
+	if e.Status == common.TaskStatusFailed {
+		e.Message = "container exited with status 1"
+	}
+	return e
+}
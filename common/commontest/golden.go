@@ -0,0 +1,150 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package commontest provides test helpers for the data structures of the common package: golden
+// JSON fixtures of the wire formats, and round-trip checks against them.
+package commontest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+)
+
+// UpdateGoldenEnv, if set to 1, makes GoldenRoundTrip rewrite the fixtures from the Go values
+// instead of checking them (to be used, and reviewed, after an intended wire format change)
+const UpdateGoldenEnv = "MORPHEO_UPDATE_GOLDEN"
+
+// Fixtures maps the golden fixtures (testdata/<name>.json) to the type they hold. Fixtures are
+// versioned after the wire format of their type: a breaking change to a type calls for a new
+// fixture (e.g. learnuplet.v2) rather than an update of the existing one.
+var Fixtures = map[string]func() interface{}{
+	"learnuplet.v1":           func() interface{} { return &common.Learnuplet{} },
+	"preduplet.v1":            func() interface{} { return &common.Preduplet{} },
+	"learnuplet_chaincode.v1": func() interface{} { return &common.LearnupletChaincode{} },
+	"worker.v1":               func() interface{} { return &common.Worker{} },
+	"status_event.v1":         func() interface{} { return &common.StatusEvent{} },
+	"api_error.v1":            func() interface{} { return &common.APIError{} },
+}
+
+// FixturePath returns the path of a golden fixture
+func FixturePath(name string) string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "testdata", name+".json")
+}
+
+// LoadFixture decodes a golden fixture into dest, failing on fields dest doesn't know about
+func LoadFixture(name string, dest interface{}) error {
+	data, err := ioutil.ReadFile(FixturePath(name))
+	if err != nil {
+		return fmt.Errorf("Error reading fixture %s: %s", name, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(dest); err != nil {
+		return fmt.Errorf("Error decoding fixture %s into %T: %s", name, dest, err)
+	}
+	return nil
+}
+
+// GoldenRoundTrip decodes a golden fixture into a new value of its type, encodes it back and
+// checks that the result is the fixture (field order and whitespace aside): a field renamed,
+// dropped or encoded differently fails the test.
+func GoldenRoundTrip(t testing.TB, name string) {
+	t.Helper()
+	newValue, ok := Fixtures[name]
+	if !ok {
+		t.Fatalf("Unknown fixture %s", name)
+	}
+	value := newValue()
+	if err := LoadFixture(name, value); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("Error encoding %T: %s", value, err)
+	}
+
+	if os.Getenv(UpdateGoldenEnv) == "1" {
+		var indented bytes.Buffer
+		json.Indent(&indented, encoded, "", "  ")
+		indented.WriteByte('\n')
+		if err := ioutil.WriteFile(FixturePath(name), indented.Bytes(), 0644); err != nil {
+			t.Fatalf("Error updating fixture %s: %s", name, err)
+		}
+		return
+	}
+
+	golden, err := ioutil.ReadFile(FixturePath(name))
+	if err != nil {
+		t.Fatalf("Error reading fixture %s: %s", name, err)
+	}
+	var want, got interface{}
+	if err := json.Unmarshal(golden, &want); err != nil {
+		t.Fatalf("Error decoding fixture %s: %s", name, err)
+	}
+	if err := json.Unmarshal(encoded, &got); err != nil {
+		t.Fatalf("Error decoding encoded %T: %s", value, err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		wantJSON, _ := json.MarshalIndent(want, "", "  ")
+		gotJSON, _ := json.MarshalIndent(got, "", "  ")
+		t.Errorf("Wire format of %T changed (fixture %s):\nwant: %s\ngot:  %s", value, name, wantJSON, gotJSON)
+	}
+}
+
+// RoundTripFixtures runs GoldenRoundTrip on every fixture, as subtests
+func RoundTripFixtures(t *testing.T) {
+	names := make([]string, 0, len(Fixtures))
+	for name := range Fixtures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			GoldenRoundTrip(t, name)
+		})
+	}
+}
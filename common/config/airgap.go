@@ -0,0 +1,166 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Endpoint is a remote endpoint a component may reach, given its configuration
+type Endpoint struct {
+	// Field is the configuration field the endpoint comes from (e.g. storage.host)
+	Field string
+	Host  string
+}
+
+// Validate checks the air gap configuration
+func (c *AirGapConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.AllowedHosts) == 0 {
+		return fmt.Errorf("air_gap: allowed_hosts is required")
+	}
+	for _, allowed := range c.AllowedHosts {
+		if strings.Contains(allowed, "/") {
+			if _, _, err := net.ParseCIDR(allowed); err != nil {
+				return fmt.Errorf("air_gap: invalid allowed host %s: %s", allowed, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Allows tells whether a host is in the allowed hosts
+func (c *AirGapConfig) Allows(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	for _, allowed := range c.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		switch {
+		case strings.Contains(allowed, "/"):
+			if _, network, err := net.ParseCIDR(allowed); err == nil && ip != nil && network.Contains(ip) {
+				return true
+			}
+		case strings.HasPrefix(allowed, "*."):
+			if strings.HasSuffix(host, allowed[1:]) {
+				return true
+			}
+		case ip != nil:
+			if allowedIP := net.ParseIP(allowed); allowedIP != nil && allowedIP.Equal(ip) {
+				return true
+			}
+		case host == allowed:
+			return true
+		}
+	}
+	return false
+}
+
+// Endpoints lists the remote endpoints of the configuration. The mocks and the disabled features
+// (tracing, Vault, HTTP audit sink...) have none.
+//
+// The endpoints of the Fabric network, which are described in the orchestrator's configuration
+// file, aren't listed.
+func (c *Config) Endpoints() []Endpoint {
+	endpoints := []Endpoint{}
+	add := func(field, address string) {
+		if address != "" {
+			endpoints = append(endpoints, Endpoint{Field: field, Host: hostOf(address)})
+		}
+	}
+	if c.Broker.Type == BrokerNSQ {
+		add("broker.nsqd_host", c.Broker.NsqdHost)
+		for _, lookupURL := range c.Broker.LookupURLs {
+			add("broker.nsqlookupd_urls", lookupURL)
+		}
+	}
+	if !c.Storage.Mock {
+		add("storage.host", c.Storage.Host)
+	}
+	add("compute.host", c.Compute.Host)
+	if c.Runtime.Type == RuntimeDocker {
+		add("runtime.registry_mirror", c.Runtime.RegistryMirror)
+	}
+	if c.Tracing.Enabled {
+		add("tracing.endpoint", c.Tracing.Endpoint)
+	}
+	add("secrets.vault_address", c.Secrets.VaultAddress)
+	if c.Audit.Sink == AuditHTTP {
+		add("audit.url", c.Audit.URL)
+	}
+	// Loaded by the browsers of the API documentation readers, which are on the site too
+	add("docs.swagger_ui_assets", c.Docs.SwaggerUIAssets)
+	return endpoints
+}
+
+// CheckAirGap checks, if the site is air gapped, that the configuration doesn't require internet
+// access: every endpoint has to be an allowed host, and the images built by the Docker runtime have
+// to be pulled from a registry mirror. All the offending fields are reported at once.
+func (c *Config) CheckAirGap() error {
+	if !c.AirGap.Enabled {
+		return nil
+	}
+	problems := []string{}
+	if c.Runtime.Type == RuntimeDocker && c.Runtime.RegistryMirror == "" {
+		problems = append(problems, "runtime.registry_mirror is required: base images would be pulled from Docker Hub")
+	}
+	for _, endpoint := range c.Endpoints() {
+		if !c.AirGap.Allows(endpoint.Host) {
+			problems = append(problems, fmt.Sprintf("%s %s isn't an allowed host: reaching it would require internet access", endpoint.Field, endpoint.Host))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("air_gap: the configuration requires internet access:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// hostOf returns the host of an address: a URL, a host:port pair or a host
+func hostOf(address string) string {
+	if strings.Contains(address, "://") {
+		if u, err := url.Parse(address); err == nil {
+			return u.Hostname()
+		}
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return strings.Trim(address, "[]")
+}
@@ -0,0 +1,754 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package config defines the configuration of the Morpheo components (broker, storage, compute,
+// orchestrator and container runtime clients) and loads it from a YAML or TOML file, environment
+// variables and command line flags, in that order of precedence (flags win).
+//
+// Each leaf field of the configuration structs declares its sources through struct tags:
+//
+//	Host string `yaml:"host" toml:"host" env:"STORAGE_HOST" flag:"storage-host" usage:"..."`
+//
+// Fields tagged with `secret:"true"` are redacted by DumpEffectiveConfig.
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/audit"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/auth"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/chaos"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/features"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpapi"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/mtls"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/secrets"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/signing"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/tracing"
+)
+
+// Broker types
+const (
+	BrokerNSQ    = "nsq"
+	BrokerMock   = "mock"
+	BrokerMemory = "memory"
+)
+
+// Container runtime types
+const (
+	RuntimeDocker = "docker"
+	RuntimeMock   = "mock"
+)
+
+// Audit sink types
+const (
+	AuditFile   = "file"
+	AuditSyslog = "syslog"
+	AuditHTTP   = "http"
+)
+
+// Config holds the configuration of all the Morpheo components. A component only reads (and
+// validates) the sections it uses.
+type Config struct {
+	Broker       BrokerConfig       `yaml:"broker" toml:"broker"`
+	Storage      StorageConfig      `yaml:"storage" toml:"storage"`
+	Compute      ComputeConfig      `yaml:"compute" toml:"compute"`
+	Orchestrator OrchestratorConfig `yaml:"orchestrator" toml:"orchestrator"`
+	Runtime      RuntimeConfig      `yaml:"runtime" toml:"runtime"`
+	Logging      LoggingConfig      `yaml:"logging" toml:"logging"`
+	Tracing      TracingConfig      `yaml:"tracing" toml:"tracing"`
+	Features     FeaturesConfig     `yaml:"features" toml:"features"`
+	Secrets      SecretsConfig      `yaml:"secrets" toml:"secrets"`
+	TLS          TLSConfig          `yaml:"tls" toml:"tls"`
+	CORS         CORSConfig         `yaml:"cors" toml:"cors"`
+	Docs         DocsConfig         `yaml:"docs" toml:"docs"`
+	Audit        AuditConfig        `yaml:"audit" toml:"audit"`
+	AirGap       AirGapConfig       `yaml:"air_gap" toml:"air_gap"`
+	Chaos        ChaosConfig        `yaml:"chaos" toml:"chaos"`
+}
+
+// BrokerConfig describes how to reach the broker
+type BrokerConfig struct {
+	Type            string   `yaml:"type" toml:"type" env:"BROKER" flag:"broker" usage:"Broker type (nsq, memory or mock)"`
+	NsqdHost        string   `yaml:"nsqd_host" toml:"nsqd_host" env:"NSQD_HOST" flag:"nsqd-host" usage:"Hostname of the nsqd instance to push messages to"`
+	NsqdPort        int      `yaml:"nsqd_port" toml:"nsqd_port" env:"NSQD_PORT" flag:"nsqd-port" usage:"TCP port of the nsqd instance to push messages to"`
+	NsqdHTTPPort    int      `yaml:"nsqd_http_port" toml:"nsqd_http_port" env:"NSQD_HTTP_PORT" flag:"nsqd-http-port" usage:"HTTP port of the nsqd instance (topic creation)"`
+	LookupURLs      []string `yaml:"nsqlookupd_urls" toml:"nsqlookupd_urls" env:"NSQLOOKUPD_URLS" flag:"nsqlookupd-url" usage:"URL(s) of the nsqlookupd instances (comma separated or repeated)"`
+	Channel         string   `yaml:"channel" toml:"channel" env:"NSQ_CHANNEL" flag:"nsq-channel" usage:"NSQ channel the consumer listens on"`
+	PollingInterval Duration `yaml:"polling_interval" toml:"polling_interval" env:"NSQ_POLLING_INTERVAL" flag:"nsq-polling-interval" usage:"Interval between two nsqlookupd polls"`
+	SigningKeyID    string   `yaml:"signing_key_id" toml:"signing_key_id" env:"BROKER_SIGNING_KEY_ID" flag:"broker-signing-key-id" usage:"ID of the key pushed messages are signed with (empty for a shared secret)"`
+	SigningKey      string   `yaml:"signing_key" toml:"signing_key" env:"BROKER_SIGNING_KEY" flag:"broker-signing-key" usage:"Key pushed messages are signed with (may be a secret reference, messages aren't signed if empty)" secret:"true"`
+	VerifyKeys      []string `yaml:"verify_keys" toml:"verify_keys" env:"BROKER_VERIFY_KEYS" flag:"broker-verify-key" usage:"Keys consumed messages are verified with, as <key ID>=<key> (=<key> for a shared secret, keys may be secret references; messages aren't verified if empty)" secret:"true"`
+	VerifyTopics    []string `yaml:"verify_topics" toml:"verify_topics" env:"BROKER_VERIFY_TOPICS" flag:"broker-verify-topic" usage:"Topics whose messages are verified (all of them if empty)"`
+	MessageMaxAge   Duration `yaml:"message_max_age" toml:"message_max_age" env:"BROKER_MESSAGE_MAX_AGE" flag:"broker-message-max-age" usage:"Age past which signed messages are rejected, as replays"`
+}
+
+// StorageConfig describes how to reach the storage API
+type StorageConfig struct {
+	Host     string   `yaml:"host" toml:"host" env:"STORAGE_HOST" flag:"storage-host" usage:"Hostname of the storage API"`
+	Port     int      `yaml:"port" toml:"port" env:"STORAGE_PORT" flag:"storage-port" usage:"TCP port of the storage API"`
+	User     string   `yaml:"user" toml:"user" env:"STORAGE_USER" flag:"storage-user" usage:"Basic auth user of the storage API"`
+	Password string   `yaml:"password" toml:"password" env:"STORAGE_PASSWORD" flag:"storage-password" usage:"Basic auth password of the storage API" secret:"true"`
+	Mock     bool     `yaml:"mock" toml:"mock" env:"STORAGE_MOCK" flag:"storage-mock" usage:"Use a mock of the storage API"`
+	Tokens   []string `yaml:"tokens" toml:"tokens" env:"STORAGE_TOKENS" flag:"storage-token" usage:"Bearer tokens of the storage API requests, as <scope>=<token> (<token> alone for requests without a token of their own, tokens may be secret references)" secret:"true"`
+	// MirrorDir, if set, holds a local mirror of storage that reads are served from first (see
+	// client.StorageMirror)
+	MirrorDir          string   `yaml:"mirror_dir" toml:"mirror_dir" env:"STORAGE_MIRROR_DIR" flag:"storage-mirror-dir" usage:"Directory of a local mirror of storage, reads are served from first (no mirror if empty)"`
+	MirrorSyncInterval Duration `yaml:"mirror_sync_interval" toml:"mirror_sync_interval" env:"STORAGE_MIRROR_SYNC_INTERVAL" flag:"storage-mirror-sync-interval" usage:"Interval between two fetches of the resources missing from the storage mirror"`
+}
+
+// ComputeConfig describes how to reach the compute API
+type ComputeConfig struct {
+	Host   string   `yaml:"host" toml:"host" env:"COMPUTE_HOST" flag:"compute-host" usage:"Hostname of the compute API"`
+	Port   int      `yaml:"port" toml:"port" env:"COMPUTE_PORT" flag:"compute-port" usage:"TCP port of the compute API"`
+	APIKey string   `yaml:"api_key" toml:"api_key" env:"COMPUTE_API_KEY" flag:"compute-api-key" usage:"API key authenticating requests against the compute API" secret:"true"`
+	Tokens []string `yaml:"tokens" toml:"tokens" env:"COMPUTE_TOKENS" flag:"compute-token" usage:"Bearer tokens of the compute API requests, as <scope>=<token> (<token> alone for requests without a token of their own, tokens may be secret references)" secret:"true"`
+}
+
+// OrchestratorConfig describes how to reach the orchestrator (a Fabric Hyperledger peer)
+type OrchestratorConfig struct {
+	ConfigFile  string `yaml:"config_file" toml:"config_file" env:"PEER_CONFIG_FILE" flag:"peer-config-file" usage:"Fabric SDK configuration file"`
+	OrgID       string `yaml:"org_id" toml:"org_id" env:"PEER_ORG_ID" flag:"peer-org-id" usage:"Fabric organisation ID"`
+	ChannelID   string `yaml:"channel_id" toml:"channel_id" env:"PEER_CHANNEL_ID" flag:"peer-channel-id" usage:"Fabric channel ID"`
+	ChaincodeID string `yaml:"chaincode_id" toml:"chaincode_id" env:"PEER_CHAINCODE_ID" flag:"peer-chaincode-id" usage:"Orchestrator chaincode ID"`
+	Mock        bool   `yaml:"mock" toml:"mock" env:"PEER_MOCK" flag:"peer-mock" usage:"Use a mock of the orchestrator"`
+	// PerfPrecision is the number of significant digits of reported performances (exact if zero)
+	PerfPrecision int `yaml:"perf_precision" toml:"perf_precision" env:"PEER_PERF_PRECISION" flag:"peer-perf-precision" usage:"Significant digits of the performances reported to the orchestrator (0 for exact values)"`
+}
+
+// RuntimeConfig describes the container runtime uplets are run with
+type RuntimeConfig struct {
+	Type    string   `yaml:"type" toml:"type" env:"CONTAINER_RUNTIME" flag:"container-runtime" usage:"Container runtime (docker or mock)"`
+	Timeout Duration `yaml:"timeout" toml:"timeout" env:"CONTAINER_RUNTIME_TIMEOUT" flag:"container-runtime-timeout" usage:"Timeout of container runtime operations"`
+	DataDir string   `yaml:"data_dir" toml:"data_dir" env:"DATA_DIR" flag:"data-dir" usage:"Directory uplet data and models are written to"`
+	// RegistryMirror, if set, is the registry base images are pulled from (see
+	// common.MirrorImageRef)
+	RegistryMirror string `yaml:"registry_mirror" toml:"registry_mirror" env:"REGISTRY_MIRROR" flag:"registry-mirror" usage:"host[:port] of the registry mirror base images are pulled from (Docker Hub and other public registries if empty)"`
+}
+
+// LoggingConfig describes the logs written by a component
+type LoggingConfig struct {
+	Level  string `yaml:"level" toml:"level" env:"LOG_LEVEL" flag:"log-level" usage:"Minimum level of logged lines (debug, info, warn or error)"`
+	Format string `yaml:"format" toml:"format" env:"LOG_FORMAT" flag:"log-format" usage:"Log format (text or json)"`
+}
+
+// TracingConfig describes where OpenTelemetry traces are exported
+type TracingConfig struct {
+	Enabled     bool    `yaml:"enabled" toml:"enabled" env:"TRACING_ENABLED" flag:"tracing" usage:"Export traces to an OTLP collector"`
+	ServiceName string  `yaml:"service_name" toml:"service_name" env:"TRACING_SERVICE_NAME" flag:"tracing-service-name" usage:"Service name reported in traces"`
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" flag:"tracing-endpoint" usage:"host:port of the OTLP/HTTP collector"`
+	Insecure    bool    `yaml:"insecure" toml:"insecure" env:"TRACING_INSECURE" flag:"tracing-insecure" usage:"Export traces over plain HTTP"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" flag:"tracing-sample-ratio" usage:"Ratio of the traces that are sampled (0 to 1)"`
+}
+
+// FeaturesConfig lists the feature flags toggled in a deployment
+type FeaturesConfig struct {
+	Flags []string `yaml:"flags" toml:"flags" env:"MORPHEO_FEATURES" flag:"feature" usage:"Feature flags to enable, or to disable if prefixed with - (comma separated or repeated)"`
+}
+
+// SecretsConfig describes where the secrets referred to by the configuration (see the secrets
+// package) are fetched from
+type SecretsConfig struct {
+	Dir          string   `yaml:"dir" toml:"dir" env:"SECRETS_DIR" flag:"secrets-dir" usage:"Directory relative file: secrets are read from"`
+	VaultAddress string   `yaml:"vault_address" toml:"vault_address" env:"VAULT_ADDR" flag:"vault-address" usage:"URL of the Vault server vault: secrets are read from"`
+	VaultToken   string   `yaml:"vault_token" toml:"vault_token" env:"VAULT_TOKEN" flag:"vault-token" usage:"Vault token (may itself be an env: or file: secret)" secret:"true"`
+	VaultMount   string   `yaml:"vault_mount" toml:"vault_mount" env:"VAULT_MOUNT" flag:"vault-mount" usage:"Mount point of Vault's KV v2 secrets engine"`
+	VaultTTL     Duration `yaml:"vault_ttl" toml:"vault_ttl" env:"VAULT_TTL" flag:"vault-ttl" usage:"How long Vault secrets are cached at most (shorter leases are honoured)"`
+}
+
+// TLSConfig describes the certificates used to mutually authenticate the Morpheo components
+type TLSConfig struct {
+	Enabled  bool   `yaml:"enabled" toml:"enabled" env:"TLS_ENABLED" flag:"tls" usage:"Use mutual TLS between Morpheo components"`
+	CAFile   string `yaml:"ca_file" toml:"ca_file" env:"TLS_CA_FILE" flag:"tls-ca-file" usage:"CA certificate peers' certificates are verified against"`
+	CertFile string `yaml:"cert_file" toml:"cert_file" env:"TLS_CERT_FILE" flag:"tls-cert-file" usage:"Certificate presented to peers (reloaded on change)"`
+	KeyFile  string `yaml:"key_file" toml:"key_file" env:"TLS_KEY_FILE" flag:"tls-key-file" usage:"Private key of the certificate presented to peers"`
+}
+
+// CORSConfig describes the browser origins allowed to call the compute API
+type CORSConfig struct {
+	AllowedOrigins   []string `yaml:"allowed_origins" toml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS" flag:"cors-allowed-origin" usage:"Origin(s) allowed to call the API, * for any (comma separated or repeated, CORS is disabled if empty)"`
+	AllowedMethods   []string `yaml:"allowed_methods" toml:"allowed_methods" env:"CORS_ALLOWED_METHODS" flag:"cors-allowed-method" usage:"HTTP method(s) allowed in cross-origin requests"`
+	AllowedHeaders   []string `yaml:"allowed_headers" toml:"allowed_headers" env:"CORS_ALLOWED_HEADERS" flag:"cors-allowed-header" usage:"Header(s) allowed in cross-origin requests"`
+	AllowCredentials bool     `yaml:"allow_credentials" toml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS" flag:"cors-allow-credentials" usage:"Allow cross-origin requests to carry credentials"`
+	MaxAge           Duration `yaml:"max_age" toml:"max_age" env:"CORS_MAX_AGE" flag:"cors-max-age" usage:"How long browsers may cache preflight responses"`
+}
+
+// DocsConfig describes the API documentation pages
+type DocsConfig struct {
+	SwaggerUIAssets string `yaml:"swagger_ui_assets" toml:"swagger_ui_assets" env:"SWAGGER_UI_ASSETS" flag:"swagger-ui-assets" usage:"Base URL the Swagger UI page loads its assets from (a local copy on air-gapped sites)"`
+}
+
+// AuditConfig describes where the audit trail of the mutations made on the orchestrator and storage
+// is recorded
+type AuditConfig struct {
+	Sink      string `yaml:"sink" toml:"sink" env:"AUDIT_SINK" flag:"audit-sink" usage:"Audit trail sink (file, syslog or http, no audit trail if empty)"`
+	File      string `yaml:"file" toml:"file" env:"AUDIT_FILE" flag:"audit-file" usage:"File the audit trail is appended to (file sink)"`
+	SyslogTag string `yaml:"syslog_tag" toml:"syslog_tag" env:"AUDIT_SYSLOG_TAG" flag:"audit-syslog-tag" usage:"Tag of the audit events sent to syslog (syslog sink)"`
+	URL       string `yaml:"url" toml:"url" env:"AUDIT_URL" flag:"audit-url" usage:"URL audit events are posted to (http sink)"`
+	Actor     string `yaml:"actor" toml:"actor" env:"AUDIT_ACTOR" flag:"audit-actor" usage:"Identity of the component in the audit trail (e.g. its worker ID)"`
+}
+
+// AirGapConfig describes the constraints of a site disconnected from the internet: every endpoint
+// of the configuration has to be in AllowedHosts (see Config.CheckAirGap)
+type AirGapConfig struct {
+	Enabled      bool     `yaml:"enabled" toml:"enabled" env:"AIR_GAPPED" flag:"air-gapped" usage:"Refuse to start if the configuration requires internet access"`
+	AllowedHosts []string `yaml:"allowed_hosts" toml:"allowed_hosts" env:"AIR_GAP_ALLOWED_HOSTS" flag:"air-gap-allowed-host" usage:"Hosts reachable from the site: host names, *.domain wildcards, IP addresses or CIDR ranges (comma separated or repeated)"`
+}
+
+// ChaosConfig describes the misbehaviour injected into the mocks and the in-memory broker (see the
+// chaos package), to exercise resilience paths in integration tests
+type ChaosConfig struct {
+	Enabled          bool     `yaml:"enabled" toml:"enabled" env:"CHAOS" flag:"chaos" usage:"Inject latency, failures, duplicate deliveries and partial writes into the mocks and the memory broker"`
+	Seed             int64    `yaml:"seed" toml:"seed" env:"CHAOS_SEED" flag:"chaos-seed" usage:"Seed of the injected misbehaviour (a run is replayed by reusing its seed)"`
+	MinLatency       Duration `yaml:"min_latency" toml:"min_latency" env:"CHAOS_MIN_LATENCY" flag:"chaos-min-latency" usage:"Minimum latency added to every operation"`
+	MaxLatency       Duration `yaml:"max_latency" toml:"max_latency" env:"CHAOS_MAX_LATENCY" flag:"chaos-max-latency" usage:"Maximum latency added to every operation"`
+	DropRate         float64  `yaml:"drop_rate" toml:"drop_rate" env:"CHAOS_DROP_RATE" flag:"chaos-drop-rate" usage:"Probability that an operation fails or a message is lost in flight (0 to 1)"`
+	DuplicateRate    float64  `yaml:"duplicate_rate" toml:"duplicate_rate" env:"CHAOS_DUPLICATE_RATE" flag:"chaos-duplicate-rate" usage:"Probability that a message is delivered twice (0 to 1)"`
+	PartialWriteRate float64  `yaml:"partial_write_rate" toml:"partial_write_rate" env:"CHAOS_PARTIAL_WRITE_RATE" flag:"chaos-partial-write-rate" usage:"Probability that a write stops before its end (0 to 1)"`
+}
+
+// Default returns the default configuration
+func Default() *Config {
+	return &Config{
+		Broker: BrokerConfig{
+			Type:            BrokerNSQ,
+			NsqdHost:        "nsqd",
+			NsqdPort:        4150,
+			NsqdHTTPPort:    4151,
+			LookupURLs:      []string{"nsqlookupd:4161"},
+			Channel:         "compute",
+			PollingInterval: Duration(5 * time.Second),
+			MessageMaxAge:   Duration(signing.DefaultMessageMaxAge),
+		},
+		Storage: StorageConfig{
+			Host:               "storage",
+			Port:               8081,
+			MirrorSyncInterval: Duration(5 * time.Minute),
+		},
+		Compute: ComputeConfig{
+			Host: "compute",
+			Port: 8082,
+		},
+		Orchestrator: OrchestratorConfig{
+			ConfigFile: "/secrets/config.yaml",
+		},
+		Runtime: RuntimeConfig{
+			Type:    RuntimeDocker,
+			Timeout: Duration(20 * time.Minute),
+			DataDir: "/data",
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: logging.FormatText,
+		},
+		Tracing: TracingConfig{
+			ServiceName: "morpheo",
+			Endpoint:    "otel-collector:4318",
+			SampleRatio: 1,
+		},
+		Secrets: SecretsConfig{
+			Dir:        "/run/secrets",
+			VaultMount: "secret",
+			VaultTTL:   Duration(secrets.DefaultVaultTTL),
+		},
+		CORS: CORSConfig{
+			AllowedMethods: httpapi.DefaultCORSMethods,
+			AllowedHeaders: httpapi.DefaultCORSHeaders,
+			MaxAge:         Duration(10 * time.Minute),
+		},
+		Docs: DocsConfig{
+			SwaggerUIAssets: httpapi.DefaultSwaggerUIAssets,
+		},
+		Audit: AuditConfig{
+			SyslogTag: "morpheo",
+		},
+	}
+}
+
+// Validate checks the broker configuration
+func (c *BrokerConfig) Validate() error {
+	switch c.Type {
+	case BrokerMock, BrokerMemory:
+		return nil
+	case BrokerNSQ:
+	default:
+		return fmt.Errorf("broker: unknown type %s (possible choices: %s, %s, %s)", c.Type, BrokerNSQ, BrokerMemory, BrokerMock)
+	}
+	if c.NsqdHost == "" {
+		return fmt.Errorf("broker: nsqd_host is required")
+	}
+	if err := validatePort("broker: nsqd_port", c.NsqdPort); err != nil {
+		return err
+	}
+	if err := validatePort("broker: nsqd_http_port", c.NsqdHTTPPort); err != nil {
+		return err
+	}
+	if len(c.LookupURLs) == 0 {
+		return fmt.Errorf("broker: at least one nsqlookupd URL is required")
+	}
+	if c.Channel == "" {
+		return fmt.Errorf("broker: channel is required")
+	}
+	if c.PollingInterval <= 0 {
+		return fmt.Errorf("broker: polling_interval must be positive")
+	}
+	if _, err := c.verifyKeys(); err != nil {
+		return err
+	}
+	if c.MessageMaxAge <= 0 {
+		return fmt.Errorf("broker: message_max_age must be positive")
+	}
+	return nil
+}
+
+// verifyKeys splits the verification keys into key IDs and (unresolved) keys
+func (c *BrokerConfig) verifyKeys() (map[string]string, error) {
+	keys := map[string]string{}
+	for _, entry := range c.VerifyKeys {
+		keyID, key := "", entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			keyID, key = entry[:i], entry[i+1:]
+		}
+		if key == "" {
+			return nil, fmt.Errorf("broker: verify key %q is empty", keyID)
+		}
+		keys[keyID] = key
+	}
+	return keys, nil
+}
+
+// Signer returns the signer of pushed messages, or nil if they aren't signed
+func (c *BrokerConfig) Signer(resolver *secrets.Resolver) (*signing.Signer, error) {
+	if c.SigningKey == "" {
+		return nil, nil
+	}
+	key, err := resolver.Resolve(c.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("broker: signing_key: %s", err)
+	}
+	return &signing.Signer{KeyID: c.SigningKeyID, Key: []byte(key)}, nil
+}
+
+// Verifier returns the verifier of consumed messages, or nil if they aren't verified
+func (c *BrokerConfig) Verifier(resolver *secrets.Resolver, logger logging.Logger) (*common.MessageVerifier, error) {
+	refs, err := c.verifyKeys()
+	if err != nil || len(refs) == 0 {
+		return nil, err
+	}
+	keys := signing.StaticKeys{}
+	for keyID, ref := range refs {
+		key, err := resolver.Resolve(ref)
+		if err != nil {
+			return nil, fmt.Errorf("broker: verify key %q: %s", keyID, err)
+		}
+		keys[keyID] = []byte(key)
+	}
+	return &common.MessageVerifier{
+		Keys:   keys,
+		MaxAge: time.Duration(c.MessageMaxAge),
+		Topics: c.VerifyTopics,
+		Logger: logger,
+	}, nil
+}
+
+// Validate checks the storage configuration
+func (c *StorageConfig) Validate() error {
+	if c.Mock {
+		return nil
+	}
+	if c.Host == "" {
+		return fmt.Errorf("storage: host is required")
+	}
+	if c.MirrorDir != "" && c.MirrorSyncInterval <= 0 {
+		return fmt.Errorf("storage: mirror_sync_interval must be positive")
+	}
+	return validatePort("storage: port", c.Port)
+}
+
+// Validate checks the compute configuration
+func (c *ComputeConfig) Validate() error {
+	if c.Host == "" {
+		return fmt.Errorf("compute: host is required")
+	}
+	return validatePort("compute: port", c.Port)
+}
+
+// TokenSource returns the bearer tokens of the storage API requests, or nil if there are none
+func (c *StorageConfig) TokenSource(resolver *secrets.Resolver) (httpclient.TokenSource, error) {
+	return tokenSource("storage", c.Tokens, resolver)
+}
+
+// TokenSource returns the bearer tokens of the compute API requests, or nil if there are none
+func (c *ComputeConfig) TokenSource(resolver *secrets.Resolver) (httpclient.TokenSource, error) {
+	return tokenSource("compute", c.Tokens, resolver)
+}
+
+// tokenSource resolves <scope>=<token> entries into scoped tokens
+func tokenSource(section string, entries []string, resolver *secrets.Resolver) (httpclient.TokenSource, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	tokens := auth.StaticTokens{}
+	for _, entry := range entries {
+		scope, ref := "", entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			scope, ref = entry[:i], entry[i+1:]
+		}
+		token, err := resolver.Resolve(ref)
+		if err != nil {
+			return nil, fmt.Errorf("%s: token of scope %q: %s", section, scope, err)
+		}
+		if token == "" {
+			return nil, fmt.Errorf("%s: token of scope %q is empty", section, scope)
+		}
+		tokens[scope] = token
+	}
+	return tokens, nil
+}
+
+// Validate checks the orchestrator configuration
+func (c *OrchestratorConfig) Validate() error {
+	if c.Mock {
+		return nil
+	}
+	if c.ConfigFile == "" {
+		return fmt.Errorf("orchestrator: config_file is required")
+	}
+	if c.ChannelID == "" {
+		return fmt.Errorf("orchestrator: channel_id is required")
+	}
+	if c.ChaincodeID == "" {
+		return fmt.Errorf("orchestrator: chaincode_id is required")
+	}
+	if c.PerfPrecision < 0 || c.PerfPrecision > 17 {
+		return fmt.Errorf("orchestrator: perf_precision must be between 0 and 17 (provided: %d)", c.PerfPrecision)
+	}
+	return nil
+}
+
+// Validate checks the container runtime configuration
+func (c *RuntimeConfig) Validate() error {
+	if c.Type != RuntimeDocker && c.Type != RuntimeMock {
+		return fmt.Errorf("runtime: unknown type %s (possible choices: %s, %s)", c.Type, RuntimeDocker, RuntimeMock)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("runtime: timeout must be positive")
+	}
+	if c.DataDir == "" {
+		return fmt.Errorf("runtime: data_dir is required")
+	}
+	return nil
+}
+
+// Validate checks the logging configuration
+func (c *LoggingConfig) Validate() error {
+	if _, err := logging.ParseLevel(c.Level); err != nil {
+		return fmt.Errorf("logging: %s", err)
+	}
+	if c.Format != logging.FormatText && c.Format != logging.FormatJSON {
+		return fmt.Errorf("logging: unknown format %s (possible choices: %s, %s)", c.Format, logging.FormatText, logging.FormatJSON)
+	}
+	return nil
+}
+
+// Logger builds the logger described by the configuration
+func (c *LoggingConfig) Logger(w io.Writer) (logging.Logger, error) {
+	level, err := logging.ParseLevel(c.Level)
+	if err != nil {
+		return nil, err
+	}
+	logger, err := logging.New(w, level, c.Format)
+	if err != nil {
+		return nil, err
+	}
+	return logger, nil
+}
+
+// Validate checks the tracing configuration
+func (c *TracingConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Endpoint == "" {
+		return fmt.Errorf("tracing: endpoint is required")
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("tracing: sample_ratio must be between 0 and 1 (provided: %g)", c.SampleRatio)
+	}
+	return nil
+}
+
+// Tracing returns the tracing.Setup configuration
+func (c *TracingConfig) Tracing() tracing.Config {
+	return tracing.Config{
+		Enabled:     c.Enabled,
+		ServiceName: c.ServiceName,
+		Endpoint:    c.Endpoint,
+		Insecure:    c.Insecure,
+		SampleRatio: c.SampleRatio,
+	}
+}
+
+// Validate checks the feature flags
+func (c *FeaturesConfig) Validate() error {
+	if _, err := c.Features(); err != nil {
+		return fmt.Errorf("features: %s", err)
+	}
+	return nil
+}
+
+// Features returns the set of toggled feature flags
+func (c *FeaturesConfig) Features() (*features.Set, error) {
+	return features.Parse(c.Flags...)
+}
+
+// Resolver returns the resolver of the secrets referred to by the configuration. The vault: scheme
+// is only available if a Vault address is configured.
+func (c *SecretsConfig) Resolver() (*secrets.Resolver, error) {
+	if c.VaultAddress == "" {
+		return secrets.NewResolver(c.Dir, nil), nil
+	}
+	token, err := secrets.NewResolver(c.Dir, nil).Resolve(c.VaultToken)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %s", err)
+	}
+	vault := secrets.NewVaultProvider(c.VaultAddress, token, c.VaultMount)
+	vault.TTL = time.Duration(c.VaultTTL)
+	return secrets.NewResolver(c.Dir, vault), nil
+}
+
+// Validate checks the TLS configuration
+func (c *TLSConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.CAFile == "" || c.CertFile == "" || c.KeyFile == "" {
+		return fmt.Errorf("tls: ca_file, cert_file and key_file are required")
+	}
+	return nil
+}
+
+// Files returns the certificate files of the configuration
+func (c *TLSConfig) Files() mtls.Files {
+	return mtls.Files{CAFile: c.CAFile, CertFile: c.CertFile, KeyFile: c.KeyFile}
+}
+
+// ClientConfig returns the TLS configuration of outgoing calls, or nil if TLS is disabled
+func (c *TLSConfig) ClientConfig() (*tls.Config, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	return mtls.ClientConfig(c.Files())
+}
+
+// ServerConfig returns the TLS configuration of a server verifying its clients' certificates, or
+// nil if TLS is disabled
+func (c *TLSConfig) ServerConfig() (*tls.Config, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	return mtls.ServerConfig(c.Files())
+}
+
+// Validate checks the CORS configuration
+func (c *CORSConfig) Validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" && c.AllowCredentials {
+			return fmt.Errorf("cors: allow_credentials can't be used with the * origin")
+		}
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("cors: max_age must be positive")
+	}
+	return nil
+}
+
+// CORS returns the httpapi.CORS middleware configuration
+func (c *CORSConfig) CORS() httpapi.CORSConfig {
+	return httpapi.CORSConfig{
+		AllowedOrigins:   c.AllowedOrigins,
+		AllowedMethods:   c.AllowedMethods,
+		AllowedHeaders:   c.AllowedHeaders,
+		AllowCredentials: c.AllowCredentials,
+		MaxAge:           time.Duration(c.MaxAge),
+	}
+}
+
+// Validate checks the documentation configuration
+func (c *DocsConfig) Validate() error {
+	if c.SwaggerUIAssets == "" {
+		return nil
+	}
+	if u, err := url.Parse(c.SwaggerUIAssets); err != nil || u.Host == "" {
+		return fmt.Errorf("docs: swagger_ui_assets must be an absolute URL (provided: %s)", c.SwaggerUIAssets)
+	}
+	return nil
+}
+
+// Validate checks the audit configuration
+func (c *AuditConfig) Validate() error {
+	switch c.Sink {
+	case "":
+		return nil
+	case AuditFile:
+		if c.File == "" {
+			return fmt.Errorf("audit: file is required by the %s sink", AuditFile)
+		}
+	case AuditSyslog:
+	case AuditHTTP:
+		if c.URL == "" {
+			return fmt.Errorf("audit: url is required by the %s sink", AuditHTTP)
+		}
+	default:
+		return fmt.Errorf("audit: unknown sink %s (possible choices: %s, %s, %s)", c.Sink, AuditFile, AuditSyslog, AuditHTTP)
+	}
+	if c.Actor == "" {
+		return fmt.Errorf("audit: actor is required")
+	}
+	return nil
+}
+
+// Recorder builds the recorder of the audit trail, or returns nil if there is none
+func (c *AuditConfig) Recorder(logger logging.Logger) (*audit.Recorder, error) {
+	var sink audit.Sink
+	var err error
+	switch c.Sink {
+	case "":
+		return nil, nil
+	case AuditFile:
+		sink, err = audit.NewFileSink(c.File)
+	case AuditSyslog:
+		sink, err = audit.NewSyslogSink(c.SyslogTag)
+	case AuditHTTP:
+		sink = audit.NewHTTPSink(c.URL)
+	default:
+		err = fmt.Errorf("audit: unknown sink %s", c.Sink)
+	}
+	if err != nil {
+		return nil, err
+	}
+	recorder := audit.NewRecorder(sink, c.Actor)
+	recorder.Logger = logger
+	return recorder, nil
+}
+
+// Validate checks the chaos configuration
+func (c *ChaosConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	return c.config().Validate()
+}
+
+func (c *ChaosConfig) config() chaos.Config {
+	return chaos.Config{
+		Seed:             c.Seed,
+		MinLatency:       time.Duration(c.MinLatency),
+		MaxLatency:       time.Duration(c.MaxLatency),
+		DropRate:         c.DropRate,
+		DuplicateRate:    c.DuplicateRate,
+		PartialWriteRate: c.PartialWriteRate,
+	}
+}
+
+// Chaos builds the chaos injector of the mocks and the in-memory broker, or returns nil if chaos
+// is disabled
+func (c *ChaosConfig) Chaos() *chaos.Chaos {
+	if !c.Enabled {
+		return nil
+	}
+	return chaos.New(c.config())
+}
+
+// Validate checks every section of the configuration. Components that only use some of them
+// should call the Validate method of these sections instead.
+func (c *Config) Validate() error {
+	validators := []interface {
+		Validate() error
+	}{&c.Broker, &c.Storage, &c.Compute, &c.Orchestrator, &c.Runtime, &c.Logging, &c.Tracing, &c.Features, &c.TLS, &c.CORS, &c.Docs, &c.Audit, &c.AirGap, &c.Chaos}
+	for _, v := range validators {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+	return c.CheckAirGap()
+}
+
+func validatePort(name string, port int) error {
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("%s must be a valid TCP port (provided: %d)", name, port)
+	}
+	return nil
+}
+
+// Duration is a time.Duration that is read from and written to configuration sources as a
+// string such as "1m30s"
+type Duration time.Duration
+
+// UnmarshalText parses a duration string
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// MarshalText formats a duration as a string
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalYAML parses a YAML duration string
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var text string
+	if err := unmarshal(&text); err != nil {
+		return err
+	}
+	return d.UnmarshalText([]byte(text))
+}
+
+// String formats a duration as a string
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
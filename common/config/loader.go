@@ -0,0 +1,319 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package config
+
+import (
+	"encoding"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigFileEnv is the environment variable pointing to the configuration file when the -config
+// flag isn't set
+const ConfigFileEnv = "MORPHEO_CONFIG"
+
+// Load builds the effective configuration of a component from its command line arguments: the
+// defaults are overridden by the YAML or TOML file given with the -config flag (or the
+// MORPHEO_CONFIG environment variable), then by environment variables, then by flags. Secret
+// fields referring to a secret provider are then resolved (see ResolveSecrets). The result isn't
+// validated: call the Validate method of the sections the component uses.
+func Load(name string, args []string) (*Config, error) {
+	cfg, _, err := LoadArgs(name, args)
+	return cfg, err
+}
+
+// LoadArgs is Load for command line tools taking arguments after their flags: it also returns the
+// arguments left after the flags.
+func LoadArgs(name string, args []string) (*Config, []string, error) {
+	// First pass: we only want to know where the configuration file is
+	path := os.Getenv(ConfigFileEnv)
+	pre := flag.NewFlagSet(name, flag.ContinueOnError)
+	pre.SetOutput(ioutil.Discard)
+	pre.StringVar(&path, "config", path, "")
+	RegisterFlags(pre, Default())
+	if err := pre.Parse(args); err != nil && err != flag.ErrHelp {
+		return nil, nil, fmt.Errorf("Error parsing command line: %s", err)
+	}
+
+	cfg := Default()
+	if path != "" {
+		if err := LoadFile(path, cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := ApplyEnv(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.String("config", path, fmt.Sprintf("YAML or TOML configuration file (env: %s)", ConfigFileEnv))
+	RegisterFlags(fs, cfg)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if err := ResolveSecrets(cfg); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// LoadFile overrides cfg with the content of a configuration file. Its format is deduced from its
+// extension (.yaml, .yml or .toml).
+func LoadFile(path string, cfg *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Error reading configuration file %s: %s", path, err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("Unsupported configuration file format: %s (should be .yaml, .yml or .toml)", path)
+	}
+	if err != nil {
+		return fmt.Errorf("Error parsing configuration file %s: %s", path, err)
+	}
+	return nil
+}
+
+// ApplyEnv overrides cfg with the environment variables set for its fields
+func ApplyEnv(cfg *Config) error {
+	for _, f := range fieldsOf(cfg) {
+		value, ok := os.LookupEnv(f.env)
+		if f.env == "" || !ok {
+			continue
+		}
+		if err := setField(f.value, value); err != nil {
+			return fmt.Errorf("Invalid value for environment variable %s: %s", f.env, err)
+		}
+	}
+	return nil
+}
+
+// ResolveSecrets replaces the value of the secret fields of cfg referring to a secret provider
+// (env:NAME, file:path or vault:path#key) by the secret itself
+func ResolveSecrets(cfg *Config) error {
+	resolver, err := cfg.Secrets.Resolver()
+	if err != nil {
+		return err
+	}
+	for _, f := range fieldsOf(cfg) {
+		if !f.secret || f.value.Kind() != reflect.String || !resolver.IsReference(f.value.String()) {
+			continue
+		}
+		secret, err := resolver.Resolve(f.value.String())
+		if err != nil {
+			return fmt.Errorf("%s: %s", f.path, err)
+		}
+		f.value.SetString(secret)
+	}
+	return nil
+}
+
+// RegisterFlags declares a flag on fs for each field of cfg, bound to the field: parsing fs
+// overrides cfg with the flags that were set.
+func RegisterFlags(fs *flag.FlagSet, cfg *Config) {
+	for _, f := range fieldsOf(cfg) {
+		if f.flag == "" {
+			continue
+		}
+		usage := f.usage
+		if f.env != "" {
+			usage = fmt.Sprintf("%s (env: %s)", usage, f.env)
+		}
+		fs.Var(&flagValue{field: f}, f.flag, usage)
+	}
+}
+
+// field is a leaf of the configuration tree
+type field struct {
+	path   string
+	value  reflect.Value
+	env    string
+	flag   string
+	usage  string
+	secret bool
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// fieldsOf walks the configuration structs and returns their leaves
+func fieldsOf(cfg *Config) []field {
+	return walk(reflect.ValueOf(cfg).Elem(), "")
+}
+
+func walk(v reflect.Value, prefix string) (fields []field) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		path := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if path == "" {
+			path = strings.ToLower(sf.Name)
+		}
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && !fv.Addr().Type().Implements(textUnmarshalerType) {
+			fields = append(fields, walk(fv, path)...)
+			continue
+		}
+		fields = append(fields, field{
+			path:   path,
+			value:  fv,
+			env:    sf.Tag.Get("env"),
+			flag:   sf.Tag.Get("flag"),
+			usage:  sf.Tag.Get("usage"),
+			secret: sf.Tag.Get("secret") == "true",
+		})
+	}
+	return fields
+}
+
+// setField parses a string into a configuration field. Lists are comma separated.
+func setField(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float64:
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(x)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", v.Type())
+		}
+		items := []string{}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// formatField returns the string representation of a configuration field
+func formatField(v reflect.Value) string {
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		if err != nil {
+			return fmt.Sprintf("<%s>", err)
+		}
+		return string(text)
+	}
+	if v.Kind() == reflect.Slice {
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// flagValue binds a flag to a configuration field. Repeating a list flag appends to the list
+// (overriding its previous value the first time).
+type flagValue struct {
+	field field
+	set   bool
+}
+
+func (f *flagValue) String() string {
+	if f == nil || !f.field.value.IsValid() {
+		return ""
+	}
+	return formatField(f.field.value)
+}
+
+func (f *flagValue) Set(s string) error {
+	v := f.field.value
+	if f.set && v.Kind() == reflect.Slice {
+		previous := v.Interface().([]string)
+		if err := setField(v, s); err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(append(previous, v.Interface().([]string)...)))
+		return nil
+	}
+	f.set = true
+	return setField(v, s)
+}
+
+// IsBoolFlag allows boolean fields to be set with a bare -flag
+func (f *flagValue) IsBoolFlag() bool {
+	return f.field.value.Kind() == reflect.Bool
+}
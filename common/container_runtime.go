@@ -35,7 +35,10 @@
 
 package common
 
-import "io"
+import (
+	"context"
+	"io"
+)
 
 // ContainerRuntime abstracts Docker/rkt/... it can load/unload images and run them, in a secured
 // way :)
@@ -53,6 +56,22 @@ type ContainerRuntime interface {
 	// Runs a given command in a network isolated container
 	RunImageInUntrustedContainer(imageName string, args []string, mounts map[string]string, autoRemove bool) (containerID string, err error)
 
+	// RunImageInUntrustedContainerContext runs a given command in a network isolated container,
+	// killing it if ctx is cancelled or its deadline passes (ctx's error is then wrapped in the
+	// returned error)
+	RunImageInUntrustedContainerContext(ctx context.Context, imageName string, args []string, mounts map[string]string, autoRemove bool) (containerID string, err error)
+
+	// RunImageInUntrustedContainerWithStdin runs a given command like
+	// RunImageInUntrustedContainerContext, streaming stdin (e.g. a blob being downloaded from
+	// storage) to its standard input instead of writing it to a volume first. The run fails if stdin
+	// can't be streamed entirely (e.g. the download breaks midway), whatever the command does.
+	RunImageInUntrustedContainerWithStdin(ctx context.Context, imageName string, args []string, mounts map[string]string, stdin io.Reader, autoRemove bool) (containerID string, err error)
+
+	// KillUpletContainers kills the running containers that were started for a given uplet (see
+	// WithUpletKey) by RunImageInUntrustedContainerContext (the latter then returns an error). The
+	// containers of other uplets, even running the same image, are left alone.
+	KillUpletContainers(upletKey string) error
+
 	// SnapshotContainer gets a snapshot of a given container and returns a ReadCloser on it.
 	//
 	// Note that it is up to the caller to call Close on the returned ReadCloser
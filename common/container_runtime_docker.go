@@ -39,21 +39,34 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"time"
 
 	dockerTypes "github.com/docker/docker/api/types"
 	dockerContainer "github.com/docker/docker/api/types/container"
+	dockerFilters "github.com/docker/docker/api/types/filters"
 	dockerNetwork "github.com/docker/docker/api/types/network"
 	dockerCli "github.com/docker/docker/client"
 	uuid "github.com/satori/go.uuid"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+)
+
+// Labels set on untrusted containers, to retrieve them by image name and by uplet
+const (
+	ImageLabel = "org.morpheo.image"
+	UpletLabel = "org.morpheo.uplet"
 )
 
 // DockerRuntime implements ExecutionBackend for Docker
 type DockerRuntime struct {
 	ContainerRuntime
 
+	Logger logging.Logger
+	// RegistryMirror, if set, is the registry (host[:port]) the base images of built images are pulled
+	// from, instead of the internet (see MirrorImageRef)
+	RegistryMirror string
+
 	timeout time.Duration
 	docker  *dockerCli.Client
 }
@@ -66,6 +79,7 @@ func NewDockerRuntime(timeout time.Duration) (b *DockerRuntime, err error) {
 	}
 
 	return &DockerRuntime{
+		Logger:  logging.Default().With(logging.Fields{logging.FieldComponent: "docker-runtime"}),
 		timeout: timeout,
 
 		docker: apiClient,
@@ -75,8 +89,11 @@ func NewDockerRuntime(timeout time.Duration) (b *DockerRuntime, err error) {
 // ImageBuild builds a Docker image from a given build context. The context actually simply is a tar
 // archive of a folder containing a Dockerfile and all the files required to build that Dockerfile.
 //
+// The images its Dockerfile refers to are pulled from RegistryMirror, if set.
+//
 // Note that it is up to the caller to call Close() on the returned io.ReadCloser()
 func (r *DockerRuntime) ImageBuild(name string, buildContext io.Reader) (image io.ReadCloser, err error) {
+	buildContext = MirrorBuildContext(buildContext, r.RegistryMirror)
 	dockerImage, err := r.docker.ImageBuild(context.Background(), buildContext, dockerTypes.ImageBuildOptions{
 		Tags:           []string{name},
 		SuppressOutput: false,
@@ -127,12 +144,34 @@ func (r *DockerRuntime) ImageUnload(imageID string) error {
 // RunImageInUntrustedContainer launch a container on the bound docker host with as many
 // restrictions as possibe for our use case.
 func (r *DockerRuntime) RunImageInUntrustedContainer(imageName string, args []string, mounts map[string]string, autoRemove bool) (containerID string, err error) {
+	return r.RunImageInUntrustedContainerContext(context.Background(), imageName, args, mounts, autoRemove)
+}
+
+// RunImageInUntrustedContainerContext launches a container like RunImageInUntrustedContainer, and
+// kills it as soon as ctx is cancelled or its deadline passes (or the runtime's timeout expires).
+func (r *DockerRuntime) RunImageInUntrustedContainerContext(parent context.Context, imageName string, args []string, mounts map[string]string, autoRemove bool) (containerID string, err error) {
+	return r.run(parent, imageName, args, mounts, nil, autoRemove)
+}
+
+// RunImageInUntrustedContainerWithStdin launches a container like
+// RunImageInUntrustedContainerContext, streaming stdin to the standard input of its command
+func (r *DockerRuntime) RunImageInUntrustedContainerWithStdin(ctx context.Context, imageName string, args []string, mounts map[string]string, stdin io.Reader, autoRemove bool) (containerID string, err error) {
+	return r.run(ctx, imageName, args, mounts, stdin, autoRemove)
+}
+
+func (r *DockerRuntime) run(parent context.Context, imageName string, args []string, mounts map[string]string, stdin io.Reader, autoRemove bool) (containerID string, err error) {
 	containerName := uuid.NewV4().String()
-	log.Printf("[INFO][docker-backend] Running `%s` in untrusted container %s (image: %s)", args, containerName, imageName)
+	logger := r.Logger.With(logging.Fields{"container": containerName, "image": imageName})
+	logger.Infof("Running `%s` in untrusted container", args)
 
-	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	ctx, cancel := context.WithTimeout(parent, r.timeout)
 	defer cancel()
 
+	labels := map[string]string{ImageLabel: imageName}
+	if upletKey := UpletKeyFrom(parent); upletKey != "" {
+		labels[UpletLabel] = upletKey
+	}
+
 	binds := []string{}
 	for hostPath, containerPath := range mounts {
 		binds = append(binds, fmt.Sprintf("%s:%s", hostPath, containerPath))
@@ -145,18 +184,19 @@ func (r *DockerRuntime) RunImageInUntrustedContainer(imageName string, args []st
 			// Hostname: containerName,
 			// Domainname:   "",
 			User:         "root:root", // <-- FIXME nope, no damn way this will run as root :)
-			AttachStdin:  false,
+			AttachStdin:  stdin != nil,
 			AttachStdout: true,
 			AttachStderr: true,
 			Tty:          false,
-			OpenStdin:    false,
+			OpenStdin:    stdin != nil,
+			StdinOnce:    stdin != nil,
 			// Env:          []string{},
 			Cmd: args,
 			// TODO: make sure not setting the entrypoint makes Docker use the one defined in the image
 			Image:           imageName,
 			WorkingDir:      "/data",
 			NetworkDisabled: true,
-			Labels:          map[string]string{},
+			Labels:          labels,
 			// StopSignal:
 			// StopTimeout:
 			// Shell
@@ -172,14 +212,34 @@ func (r *DockerRuntime) RunImageInUntrustedContainer(imageName string, args []st
 		},
 		containerName,
 	)
-	log.Print("[DEBUG][docker-backend] Docker container created")
+	logger.Debugf("Docker container created")
 	if err != nil {
 		return "", fmt.Errorf("Error creating Docker container %s: %s", containerName, err)
 	}
 
 	// Let's log any warning that was trigger
 	for n, warning := range containerCreateBody.Warnings {
-		log.Printf("[WARNING %d][docker-backend] Warning creating container: %s", n, warning)
+		logger.Warnf("Warning %d creating container: %s", n, warning)
+	}
+
+	// The standard input is attached before the container starts, so that none of it is lost. The
+	// run fails if it can't be streamed entirely (the command would see a truncated input).
+	var copied chan error
+	if stdin != nil {
+		hijacked, err := r.docker.ContainerAttach(ctx, containerCreateBody.ID, dockerTypes.ContainerAttachOptions{
+			Stream: true,
+			Stdin:  true,
+		})
+		if err != nil {
+			return "", fmt.Errorf("Error attaching to the standard input of Docker container %s: %s", containerCreateBody.ID, err)
+		}
+		defer hijacked.Close()
+		copied = make(chan error, 1)
+		go func() {
+			_, err := io.Copy(hijacked.Conn, stdin)
+			hijacked.CloseWrite()
+			copied <- err
+		}()
 	}
 
 	err = r.docker.ContainerStart(
@@ -191,25 +251,43 @@ func (r *DockerRuntime) RunImageInUntrustedContainer(imageName string, args []st
 		return "", fmt.Errorf("Error starting Docker container %s: %s", containerCreateBody.ID, err)
 	}
 
-	// Defer the container removal if that was asked before
+	// Defer the container removal if that was asked before (ctx may be done by then)
 	defer (func() {
 		if autoRemove {
-			err = r.docker.ContainerRemove(ctx, containerCreateBody.ID, dockerTypes.ContainerRemoveOptions{
+			cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), r.timeout)
+			defer cleanupCancel()
+			err := r.docker.ContainerRemove(cleanupCtx, containerCreateBody.ID, dockerTypes.ContainerRemoveOptions{
 				Force:         true,
 				RemoveVolumes: true,
 			})
 			if err != nil {
-				log.Printf("[ERROR][docker-backend] Error removing container %s: %s", containerCreateBody.ID, err)
+				logger.Errorf("Error removing container %s: %s", containerCreateBody.ID, err)
 			}
 		}
 	})()
 
 	// Let's wait for the command to be over
 	status, err := r.docker.ContainerWait(ctx, containerCreateBody.ID)
+	if ctx.Err() != nil {
+		logger.Warnf("Killing container: %s", ctx.Err())
+		r.killContainer(containerCreateBody.ID)
+		return "", fmt.Errorf("Untrusted container %s aborted: %w", containerCreateBody.ID, ctx.Err())
+	}
 	if err != nil {
-		log.Printf("[ERROR] ContainerWaitOKBody has status %v", status)
+		logger.Errorf("ContainerWaitOKBody has status %v", status)
 		return "", fmt.Errorf("Error waiting for untrusted container to exit: %s", err)
 	}
+	if copied != nil {
+		select {
+		case err := <-copied:
+			if err != nil {
+				logger.Errorf("Error streaming the standard input of the container: %s", err)
+				return "", fmt.Errorf("Error streaming the standard input of untrusted container %s: %s", containerCreateBody.ID, err)
+			}
+		case <-ctx.Done():
+			return "", fmt.Errorf("Untrusted container %s aborted while streaming its standard input: %w", containerCreateBody.ID, ctx.Err())
+		}
+	}
 
 	logs, err := r.docker.ContainerLogs(
 		ctx,
@@ -237,11 +315,45 @@ func (r *DockerRuntime) RunImageInUntrustedContainer(imageName string, args []st
 		return "", fmt.Errorf("Container exited with error code %d", containerInfo.State.ExitCode)
 	}
 
-	log.Printf("[INFO][docker-backend] Untrusted container ran command, status code: %v", status)
+	logger.Infof("Untrusted container ran command, status code: %v", status)
 
 	return containerCreateBody.ID, nil
 }
 
+// KillUpletContainers kills the untrusted containers started for a given uplet (equivalent to a
+// "docker kill" on each container labelled with the uplet key)
+func (r *DockerRuntime) KillUpletContainers(upletKey string) error {
+	if upletKey == "" {
+		return fmt.Errorf("[docker-runtime] Error killing uplet containers: no uplet key")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	filters := dockerFilters.NewArgs()
+	filters.Add("label", fmt.Sprintf("%s=%s", UpletLabel, upletKey))
+	containers, err := r.docker.ContainerList(ctx, dockerTypes.ContainerListOptions{Filters: filters})
+	if err != nil {
+		return fmt.Errorf("[docker-runtime] Error listing containers of uplet %s: %s", upletKey, err)
+	}
+
+	for _, container := range containers {
+		r.Logger.With(logging.Fields{"container": container.ID, logging.FieldUplet: upletKey}).Infof("Killing container")
+		if err := r.docker.ContainerKill(ctx, container.ID, "SIGKILL"); err != nil {
+			return fmt.Errorf("[docker-runtime] Error killing container %s (uplet: %s): %s", container.ID, upletKey, err)
+		}
+	}
+	return nil
+}
+
+// killContainer kills a container, with a context of its own (the one it was run with being done)
+func (r *DockerRuntime) killContainer(containerID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	if err := r.docker.ContainerKill(ctx, containerID, "SIGKILL"); err != nil {
+		r.Logger.With(logging.Fields{"container": containerID}).Errorf("Error killing container: %s", err)
+	}
+}
+
 // SnapshotContainer exports the trained container and pipes it in an image builder that forwards
 // back a reader on the image's bytes.
 func (r *DockerRuntime) SnapshotContainer(containerID, imageName string) (image io.ReadCloser, err error) {
@@ -37,16 +37,27 @@ package common
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	uuid "github.com/satori/go.uuid"
 	"io"
 	"io/ioutil"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/chaos"
 )
 
 // ContainerRuntime abstracts Docker/rkt/... it can load/unload images and run them, in a secured
 // way :)
 
-// MockRuntime implements a mock for containerRuntime
+// MockRuntime implements a mock for containerRuntime. It records its invocations (see Calls), with
+// the image name as ID.
 type MockRuntime struct {
+	CallRecorder
+
+	// Chaos, if set, injects seeded random latency, failures and partial image writes into the calls
+	Chaos *chaos.Chaos
+
 	image       io.ReadCloser
 	containerID string
 }
@@ -62,29 +73,85 @@ func NewMockRuntime() *MockRuntime {
 // ImageBuild builds an Image from a reader on a tar.gz archive containing all requirements
 // to build the image. It returns an io.ReadCloser on the image and an error if error there is.
 func (s *MockRuntime) ImageBuild(name string, buildContext io.Reader) (image io.ReadCloser, err error) {
-	_, err = io.Copy(ioutil.Discard, buildContext)
+	payload, err := ioutil.ReadAll(s.Chaos.Reader("ImageBuild", buildContext, -1))
+	if err == nil {
+		err = s.Chaos.Call("ImageBuild")
+	}
+	s.Record("ImageBuild", name, payload, err)
 	return s.image, err
 }
 
 // ImageLoad loads a saved image from an io.Reader into the container runtime
 func (s *MockRuntime) ImageLoad(name string, imageReader io.Reader) error {
-	_, err := io.Copy(ioutil.Discard, imageReader)
+	payload, err := ioutil.ReadAll(s.Chaos.Reader("ImageLoad", imageReader, -1))
+	if err == nil {
+		err = s.Chaos.Call("ImageLoad")
+	}
+	s.Record("ImageLoad", name, payload, err)
 	return err
 }
 
 // ImageUnload removes an Image from the ContainerRuntime's image store (aka from disk)
 func (s *MockRuntime) ImageUnload(name string) error {
-	return nil
+	err := s.Chaos.Call("ImageUnload")
+	s.Record("ImageUnload", name, nil, err)
+	return err
 }
 
 // RunImageInUntrustedContainer runs a given command in a network isolated container
 func (s *MockRuntime) RunImageInUntrustedContainer(imageName string, args []string, mounts map[string]string, autoRemove bool) (containerID string, err error) {
+	return s.RunImageInUntrustedContainerContext(context.Background(), imageName, args, mounts, autoRemove)
+}
+
+// RunImageInUntrustedContainerContext runs a given command in a network isolated container, failing
+// if ctx is already done (calls are recorded as RunImageInUntrustedContainer)
+func (s *MockRuntime) RunImageInUntrustedContainerContext(ctx context.Context, imageName string, args []string, mounts map[string]string, autoRemove bool) (containerID string, err error) {
+	payload, _ := json.Marshal(map[string]interface{}{"args": args, "mounts": mounts, "auto_remove": autoRemove})
+	if ctx.Err() != nil {
+		err = fmt.Errorf("Container aborted: %w", ctx.Err())
+	} else {
+		err = s.Chaos.Call("RunImageInUntrustedContainer")
+	}
+	s.Record("RunImageInUntrustedContainer", imageName, payload, err)
+	if err != nil {
+		return "", err
+	}
 	return s.containerID, nil
 }
 
+// RunImageInUntrustedContainerWithStdin reads stdin and runs a given command in a network isolated
+// container (calls are recorded with their standard input, in a "stdin" payload field)
+func (s *MockRuntime) RunImageInUntrustedContainerWithStdin(ctx context.Context, imageName string, args []string, mounts map[string]string, stdin io.Reader, autoRemove bool) (containerID string, err error) {
+	input, err := ioutil.ReadAll(stdin)
+	payload, _ := json.Marshal(map[string]interface{}{"args": args, "mounts": mounts, "auto_remove": autoRemove, "stdin": input})
+	if err == nil && ctx.Err() != nil {
+		err = fmt.Errorf("Container aborted: %w", ctx.Err())
+	}
+	if err == nil {
+		err = s.Chaos.Call("RunImageInUntrustedContainerWithStdin")
+	}
+	s.Record("RunImageInUntrustedContainerWithStdin", imageName, payload, err)
+	if err != nil {
+		return "", err
+	}
+	return s.containerID, nil
+}
+
+// KillUpletContainers kills the containers started for a given uplet
+func (s *MockRuntime) KillUpletContainers(upletKey string) error {
+	err := s.Chaos.Call("KillUpletContainers")
+	s.Record("KillUpletContainers", upletKey, nil, err)
+	return err
+}
+
 // SnapshotContainer gets a snapshot of a given container and returns a ReadCloser on it.
 //
 // Note that it is up to the caller to call Close on the returned ReadCloser
 func (s *MockRuntime) SnapshotContainer(containerID, imageName string) (image io.ReadCloser, err error) {
+	if err := s.Chaos.Call("SnapshotContainer"); err != nil {
+		s.Record("SnapshotContainer", imageName, []byte(containerID), err)
+		return nil, err
+	}
+	s.Record("SnapshotContainer", imageName, []byte(containerID), nil)
 	return s.image, nil
 }
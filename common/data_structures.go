@@ -36,11 +36,14 @@
 package common
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/satori/go.uuid"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
 )
 
 // Uplet types
@@ -59,19 +62,21 @@ var (
 
 // Task statuses
 const (
-	TaskStatusTodo    = "todo"
-	TaskStatusPending = "pending"
-	TaskStatusDone    = "done"
-	TaskStatusFailed  = "failed"
+	TaskStatusTodo      = "todo"
+	TaskStatusPending   = "pending"
+	TaskStatusDone      = "done"
+	TaskStatusFailed    = "failed"
+	TaskStatusCancelled = "cancelled"
 )
 
 var (
 	// ValidStatuses is a set of all possible values for the "status" field
 	ValidStatuses = map[string]struct{}{
-		TaskStatusTodo:    struct{}{},
-		TaskStatusPending: struct{}{},
-		TaskStatusDone:    struct{}{},
-		TaskStatusFailed:  struct{}{},
+		TaskStatusTodo:      struct{}{},
+		TaskStatusPending:   struct{}{},
+		TaskStatusDone:      struct{}{},
+		TaskStatusFailed:    struct{}{},
+		TaskStatusCancelled: struct{}{},
 	}
 )
 
@@ -106,6 +111,7 @@ type LearnupletChaincode struct {
 	Perf                  float64            `json:"perf"`
 	TrainPerf             map[string]float64 `json:"train_perf"`
 	TestPerf              map[string]float64 `json:"test_perf"`
+	EvaluationOnly        bool               `json:"evaluation_only,omitempty"`
 }
 
 // LearnupletFormat convert LearnupletChaincode into Learnuplet
@@ -164,17 +170,18 @@ func (s *LearnupletChaincode) LearnupletFormat() (Learnuplet, error) {
 		}
 	}
 	return Learnuplet{
-		Key:         s.Key,
-		Problem:     problem,
-		TrainData:   trainData,
-		TestData:    testData,
-		Algo:        algo,
-		ModelStart:  modelStart,
-		ModelEnd:    modelEnd,
-		Rank:        s.Rank,
-		Worker:      worker,
-		Status:      s.Status,
-		RequestDate: int(time.Now().Unix()),
+		Key:            s.Key,
+		Problem:        problem,
+		TrainData:      trainData,
+		TestData:       testData,
+		Algo:           algo,
+		ModelStart:     modelStart,
+		ModelEnd:       modelEnd,
+		Rank:           s.Rank,
+		Worker:         worker,
+		Status:         s.Status,
+		RequestDate:    int(time.Now().Unix()),
+		EvaluationOnly: s.EvaluationOnly,
 	}, nil
 }
 
@@ -228,6 +235,9 @@ type Learnuplet struct {
 	Status         string      `json:"status" yaml:"status"`
 	RequestDate    int         `json:"timestamp_request" yaml:"timestamp_request"`
 	CompletionDate int         `json:"timestamp_done" yaml:"timestamp_done"`
+	// EvaluationOnly learnuplets skip training: ModelStart is only evaluated on the test data (no
+	// model is uploaded, only its performance is reported)
+	EvaluationOnly bool `json:"evaluation_only,omitempty" yaml:"evaluation_only,omitempty"`
 }
 
 // Preduplet describes a prediction task.
@@ -248,72 +258,131 @@ type Preduplet struct {
 
 // Check returns nil if the learnuplet is valid, an explicit error otherwise
 func (s *Learnuplet) Check() (err error) {
+	return s.Validate()
+}
+
+// Validate returns nil if the learnuplet is valid, a *ValidationError listing every invalid field
+// otherwise
+func (s *Learnuplet) Validate() error {
+	verr := &ValidationError{}
 
 	if s.Key == "" {
-		return fmt.Errorf("id field is required")
+		verr.Add("key", "id field is required")
 	}
 
 	if uuid.Equal(uuid.Nil, s.Problem) {
-		return fmt.Errorf("problem field is required")
+		verr.Add("problem", "problem field is required")
 	}
 
 	if uuid.Equal(uuid.Nil, s.Algo) {
-		return fmt.Errorf("algo field is required")
+		verr.Add("algo", "algo field is required")
 	}
 
-	if len(s.TrainData) == 0 {
-		return fmt.Errorf("train_data field is empty or unset")
+	if len(s.TrainData) == 0 && !s.EvaluationOnly {
+		verr.Add("train_data", "train_data field is empty or unset")
 	}
 	for n, id := range s.TrainData {
 		if uuid.Equal(uuid.Nil, id) {
-			return fmt.Errorf("Nil UUID in train_data field at pos %d", n)
+			verr.Add(fmt.Sprintf("train_data[%d]", n), "Nil UUID in train_data field at pos %d", n)
 		}
 	}
 
 	if len(s.TestData) == 0 {
-		return fmt.Errorf("test_data field is empty or unset")
+		verr.Add("test_data", "test_data field is empty or unset")
 	}
 	for n, id := range s.TestData {
 		if uuid.Equal(uuid.Nil, id) {
-			return fmt.Errorf("Empty UUID in test_data field at pos %d", n)
+			verr.Add(fmt.Sprintf("test_data[%d]", n), "Empty UUID in test_data field at pos %d", n)
 		}
 	}
 
 	if _, ok := ValidStatuses[s.Status]; !ok {
-		return fmt.Errorf("status field ain't valid (provided: %s, possible choices: %s", s.Status, ValidStatuses)
+		verr.Add("status", "status field ain't valid (provided: %s, possible choices: %s", s.Status, ValidStatuses)
 	}
 
 	if s.Rank > 0 {
 		if uuid.Equal(uuid.Nil, s.ModelStart) {
-			return fmt.Errorf("rank %d and empty ModelStart", s.Rank)
+			verr.Add("model_start", "rank %d and empty ModelStart", s.Rank)
 		}
 	}
 
-	return nil
+	if s.EvaluationOnly {
+		if uuid.Equal(uuid.Nil, s.ModelStart) {
+			verr.Add("model_start", "evaluation only learnuplet without a model to evaluate")
+		}
+		if !uuid.Equal(uuid.Nil, s.ModelEnd) {
+			verr.Add("model_end", "evaluation only learnuplets don't produce models")
+		}
+	}
+
+	return verr.OrNil()
 }
 
 // Check returns nil if the preduplet is valid, an explicit error otherwise
 func (s *Preduplet) Check() (err error) {
+	return s.Validate()
+}
+
+// Validate returns nil if the preduplet is valid, a *ValidationError listing every invalid field
+// otherwise
+func (s *Preduplet) Validate() error {
+	verr := &ValidationError{}
 
 	if uuid.Equal(uuid.Nil, s.ID) {
-		return fmt.Errorf("id field is unset")
+		verr.Add("uuid", "id field is unset")
 	}
 	if uuid.Equal(uuid.Nil, s.Problem) {
-		return fmt.Errorf("problem field is unset")
+		verr.Add("problem", "problem field is unset")
 	}
 	if uuid.Equal(uuid.Nil, s.Model) {
-		return fmt.Errorf("model field is required")
-	}
-	if len(s.Data) == 0 {
-		return fmt.Errorf("data field is empty or unset")
+		verr.Add("model", "model field is required")
 	}
 	if uuid.Equal(uuid.Nil, s.Data) {
-		return fmt.Errorf("Nil UUID in data field")
+		verr.Add("data", "Nil UUID in data field")
 	}
 	if _, ok := ValidStatuses[s.Status]; !ok {
-		return fmt.Errorf("status field ain't valid (provided: %s, possible choices: %s", s.Status, ValidStatuses)
+		verr.Add("status", "status field ain't valid (provided: %s, possible choices: %s", s.Status, ValidStatuses)
 	}
 
+	return verr.OrNil()
+}
+
+// ===========================================================================
+// Worker Data Structures
+// ===========================================================================
+// Used by
+// ====================================
+//   - Compute-worker to register itself to the orchestrator
+//   - Go-package/client Peer to send the worker's capabilities
+//
+// Functions
+// ====================================
+// Check: Check that the worker struct fields are correctly set
+
+// Worker describes a compute worker and what it is able to process
+type Worker struct {
+	ID         uuid.UUID `json:"uuid" yaml:"uuid"`
+	GPUCount   int       `json:"gpu_count" yaml:"gpu_count"`
+	Memory     uint64    `json:"memory" yaml:"memory"`
+	UpletTypes []string  `json:"uplet_types" yaml:"uplet_types"`
+}
+
+// Check returns nil if the worker is valid, an explicit error otherwise
+func (w *Worker) Check() error {
+	if uuid.Equal(uuid.Nil, w.ID) {
+		return fmt.Errorf("uuid field is unset")
+	}
+	if w.GPUCount < 0 {
+		return fmt.Errorf("gpu_count field can't be negative (provided: %d)", w.GPUCount)
+	}
+	if len(w.UpletTypes) == 0 {
+		return fmt.Errorf("uplet_types field is empty or unset")
+	}
+	for _, upletType := range w.UpletTypes {
+		if _, ok := ValidUplets[upletType]; !ok {
+			return fmt.Errorf("uplet_types field ain't valid (provided: %s, possible choices: %s)", upletType, ValidUplets)
+		}
+	}
 	return nil
 }
 
@@ -360,6 +429,8 @@ type Model struct {
 	ID              uuid.UUID `json:"uuid" yaml:"uuid" db:"uuid"`
 	TimestampUpload int32     `json:"timestamp_upload" yaml:"timestamp_upload" db:"timestamp_upload"`
 	Algo            uuid.UUID `json:"algo" yaml:"algo" db:"algo"`
+	// Lineage, if known, records how the model was trained
+	Lineage *ModelLineage `json:"lineage,omitempty" yaml:"lineage,omitempty" db:"-"`
 }
 
 // Prediction describes a prediction blob
@@ -374,6 +445,21 @@ type Problem struct {
 	TimestampUpload int32     `json:"timestamp_upload" yaml:"timestamp_upload" db:"timestamp_upload"`
 	Name            string    `json:"name" yaml:"name" db:"name"`
 	Description     string    `json:"description" yaml:"description" db:"description"`
+	// WorkflowImage is the reference of the evaluation workflow image (when it isn't built from
+	// the problem workflow blob)
+	WorkflowImage string `json:"workflow_image,omitempty" yaml:"workflow_image,omitempty" db:"workflow_image"`
+	// Metrics defines the performance metrics computed by the workflow
+	Metrics []ProblemMetric `json:"metrics,omitempty" yaml:"metrics,omitempty" db:"-"`
+	// TestData lists the test datasets of the problem
+	TestData []uuid.UUID `json:"test_data,omitempty" yaml:"test_data,omitempty" db:"-"`
+}
+
+// ProblemMetric defines a performance metric computed by a problem workflow
+type ProblemMetric struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// HigherIsBetter is true for scores (accuracy...), false for losses
+	HigherIsBetter bool `json:"higher_is_better" yaml:"higher_is_better"`
 }
 
 // Functions to create new data structures
@@ -549,9 +635,34 @@ func (p *Problem) Check() error {
 	if p.TimestampUpload <= 0 {
 		return fmt.Errorf("'Timestamp_upload' unset")
 	}
+	metrics := map[string]bool{}
+	for i, m := range p.Metrics {
+		if m.Name == "" {
+			return fmt.Errorf("metric %d: 'Name' unset", i)
+		}
+		if metrics[m.Name] {
+			return fmt.Errorf("metric %s is defined twice", m.Name)
+		}
+		metrics[m.Name] = true
+	}
+	for i, id := range p.TestData {
+		if uuid.Equal(uuid.Nil, id) {
+			return fmt.Errorf("test data %d: nil UUID", i)
+		}
+	}
 	return nil
 }
 
+// Metric returns the definition of a metric of the problem
+func (p *Problem) Metric(name string) (ProblemMetric, bool) {
+	for _, m := range p.Metrics {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return ProblemMetric{}, false
+}
+
 // GetUUID returns the resource uuid
 func (p *Problem) GetUUID() uuid.UUID {
 	return p.ID
@@ -568,6 +679,16 @@ func (p *Problem) FillResource(fields map[string]interface{}) error {
 			p.Name = v.(string)
 		case "description":
 			p.Description = v.(string)
+		case "workflow_image":
+			p.WorkflowImage = v.(string)
+		case "metrics":
+			if err := unmarshalField(k, v, &p.Metrics); err != nil {
+				return err
+			}
+		case "test_data":
+			if err := unmarshalField(k, v, &p.TestData); err != nil {
+				return err
+			}
 		default:
 			return fmt.Errorf("%s is not a valid field for problem", k)
 		}
@@ -576,6 +697,25 @@ func (p *Problem) FillResource(fields map[string]interface{}) error {
 	return nil
 }
 
+// unmarshalField decodes a field holding a JSON value (as a form value or raw JSON)
+func unmarshalField(name string, value interface{}, dst interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	case json.RawMessage:
+		data = v
+	default:
+		return fmt.Errorf("%s: unexpected %T value", name, value)
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return fmt.Errorf("%s: invalid JSON value: %s", name, err)
+	}
+	return nil
+}
+
 // ===========================================================================
 // Errors management
 // ===========================================================================
@@ -589,6 +729,8 @@ func (p *Problem) FillResource(fields map[string]interface{}) error {
 type APIError struct {
 	Message string `json:"error"`
 	Status  int    `json:"status"`
+	// Fields lists the invalid fields of a rejected payload, if any
+	Fields []FieldError `json:"fields,omitempty"`
 }
 
 // NewAPIError creates an APIError object, given an error message
@@ -603,6 +745,16 @@ func (err *APIError) Error() string {
 	return err.Message
 }
 
+// Kind classifies the error according to its HTTP status
+func (err *APIError) Kind() errors.Kind {
+	return errors.FromHTTPStatus(err.Status)
+}
+
+// Is makes errors.Is(err, errors.ErrNotFound) (and alike) work on API errors
+func (err *APIError) Is(target error) bool {
+	return errors.MatchKind(err.Kind(), target)
+}
+
 // TaskError describes an error happening in the consumer that indicates the errord task can be
 // retried (if the retry limit hasn't been reached)
 type TaskError struct {
@@ -613,6 +765,16 @@ func (e *TaskError) Error() string {
 	return e.Message
 }
 
+// Kind returns errors.Transient: the task can be retried
+func (e *TaskError) Kind() errors.Kind {
+	return errors.Transient
+}
+
+// Is makes errors.Is(err, errors.ErrTransient) work on task errors
+func (e *TaskError) Is(target error) bool {
+	return errors.MatchKind(e.Kind(), target)
+}
+
 // FatalTaskError describes an error happening in the consumer that isn't worth a retry
 type FatalTaskError struct {
 	Message string `json:"error"`
@@ -621,3 +783,13 @@ type FatalTaskError struct {
 func (e *FatalTaskError) Error() string {
 	return e.Message
 }
+
+// Kind returns errors.Permanent: the task isn't worth a retry
+func (e *FatalTaskError) Kind() errors.Kind {
+	return errors.Permanent
+}
+
+// Is makes errors.Is(err, errors.ErrPermanent) work on fatal task errors
+func (e *FatalTaskError) Is(target error) bool {
+	return errors.MatchKind(e.Kind(), target)
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package common
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/satori/go.uuid"
+)
+
+// DatasetManifest lists, in order, the fragments (data blobs) a dataset is made of
+type DatasetManifest struct {
+	Dataset   uuid.UUID         `json:"dataset"`
+	Fragments []DatasetFragment `json:"fragments"`
+}
+
+// DatasetFragment describes a fragment of a dataset. Its size and digest are checked after download
+// when set.
+type DatasetFragment struct {
+	ID     uuid.UUID `json:"uuid"`
+	Size   int64     `json:"size,omitempty"`
+	SHA256 string    `json:"sha256,omitempty"`
+}
+
+// Check returns nil if the manifest is correctly filled
+func (m *DatasetManifest) Check() error {
+	if uuid.Equal(uuid.Nil, m.Dataset) {
+		return fmt.Errorf("'dataset' unset")
+	}
+	if len(m.Fragments) == 0 {
+		return fmt.Errorf("'fragments' is empty")
+	}
+	seen := map[uuid.UUID]bool{}
+	for i, f := range m.Fragments {
+		if uuid.Equal(uuid.Nil, f.ID) {
+			return fmt.Errorf("fragment %d: 'uuid' unset", i)
+		}
+		if seen[f.ID] {
+			return fmt.Errorf("fragment %d: %s is listed twice", i, f.ID)
+		}
+		seen[f.ID] = true
+		if f.Size < 0 {
+			return fmt.Errorf("fragment %d: negative 'size' (%d)", i, f.Size)
+		}
+		if digest, err := hex.DecodeString(f.SHA256); f.SHA256 != "" && (err != nil || len(digest) != 32) {
+			return fmt.Errorf("fragment %d: 'sha256' isn't an hex encoded SHA-256 digest (%s)", i, f.SHA256)
+		}
+	}
+	return nil
+}
+
+// TotalSize returns the sum of the sizes of the fragments (the ones whose size is known)
+func (m *DatasetManifest) TotalSize() (size int64) {
+	for _, f := range m.Fragments {
+		size += f.Size
+	}
+	return size
+}
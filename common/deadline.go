@@ -0,0 +1,81 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package common
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// ContextHandler is a broker handler receiving a context, to be passed to the whole processing of
+// the message (downloads, container execution, uploads) so that it respects a single deadline. Register
+// it with tracing.Consumer.AddContextHandler (or client.PeerConsumer.AddContextHandler), or turn it
+// into a Handler with WithTimeout, and pass its context to storage with client.StorageWithContext.
+type ContextHandler func(ctx context.Context, message []byte) error
+
+// WithTimeout turns a ContextHandler into a Handler, whose context expires after timeout (zero
+// meaning no deadline). Use the timeout the handler is registered with, so that uplets are aborted
+// before the broker considers them failed and hands them again.
+func (h ContextHandler) WithTimeout(timeout time.Duration) Handler {
+	return func(message []byte) error {
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		defer cancel()
+		return h(ctx, message)
+	}
+}
+
+// ContextReader returns a reader failing with the error of ctx once ctx is done, so that a
+// download or an upload stops as soon as the uplet is cancelled or its deadline passes. It can't
+// interrupt a Read already blocked: bind HTTP transfers to ctx too (see httpclient.Client.WithContext).
+func ContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
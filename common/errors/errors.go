@@ -0,0 +1,183 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package errors defines the kinds of errors that can occur throughout the Morpheo pipeline
+// (Transient, Permanent, NotFound, Unauthorized, Validation) so that retry and alerting decisions
+// can be taken uniformly, whichever client or component the error comes from.
+//
+// Errors are classified either by wrapping them (Wrap, Newf) or by implementing the Classified
+// interface (and an Is method relying on MatchKind). Both work with the standard library's
+// errors.Is and errors.As:
+//
+//	if errors.Is(err, errors.ErrNotFound) { ... }
+//
+// Note that this package must not import the common package.
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+)
+
+// Kind is the category of an error
+type Kind int
+
+// Error kinds
+const (
+	// Unknown errors weren't classified
+	Unknown Kind = iota
+	// Transient errors may not happen again if the operation is retried later
+	Transient
+	// Permanent errors will happen again, no matter how many times the operation is retried
+	Permanent
+	// NotFound errors denote a missing resource
+	NotFound
+	// Unauthorized errors denote missing or invalid credentials, or insufficient permissions
+	Unauthorized
+	// Validation errors denote an invalid input
+	Validation
+)
+
+var kindNames = map[Kind]string{
+	Unknown:      "unknown",
+	Transient:    "transient",
+	Permanent:    "permanent",
+	NotFound:     "not found",
+	Unauthorized: "unauthorized",
+	Validation:   "validation",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// Sentinel errors, to be used as errors.Is targets
+var (
+	ErrTransient    = &Error{Kind: Transient}
+	ErrPermanent    = &Error{Kind: Permanent}
+	ErrNotFound     = &Error{Kind: NotFound}
+	ErrUnauthorized = &Error{Kind: Unauthorized}
+	ErrValidation   = &Error{Kind: Validation}
+)
+
+// Classified is implemented by error types that know their own kind
+type Classified interface {
+	error
+	Kind() Kind
+}
+
+// Error is an error of a given kind
+type Error struct {
+	Kind Kind
+	Err  error
+}
+
+// Wrap classifies an error. It returns nil if err is nil.
+func Wrap(kind Kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// Newf creates an error of a given kind
+func Newf(kind Kind, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s error", e.Kind)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the classified error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether e has the same kind as a sentinel error
+func (e *Error) Is(target error) bool {
+	return MatchKind(KindOf(e), target)
+}
+
+// MatchKind reports whether target is the sentinel error of a given kind (an *Error without cause)
+func MatchKind(kind Kind, target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Err == nil && t.Kind == kind && kind != Unknown
+}
+
+// KindOf returns the kind of the first classified error in err's chain, or Unknown
+func KindOf(err error) Kind {
+	for err != nil {
+		switch e := err.(type) {
+		case *Error:
+			if e.Kind != Unknown {
+				return e.Kind
+			}
+		case Classified:
+			if kind := e.Kind(); kind != Unknown {
+				return kind
+			}
+		}
+		err = stderrors.Unwrap(err)
+	}
+	return Unknown
+}
+
+// IsRetryable returns true if the operation that failed with err is worth retrying
+func IsRetryable(err error) bool {
+	return KindOf(err) == Transient
+}
+
+// FromHTTPStatus returns the kind of error denoted by an HTTP status code (Unknown for non error
+// codes)
+func FromHTTPStatus(code int) Kind {
+	switch {
+	case code == http.StatusBadRequest || code == http.StatusUnprocessableEntity:
+		return Validation
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return Unauthorized
+	case code == http.StatusNotFound || code == http.StatusGone:
+		return NotFound
+	case code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500:
+		return Transient
+	case code >= 400:
+		return Permanent
+	}
+	return Unknown
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package features implements feature flags, so that risky behaviors can be rolled out (or rolled
+// back) per deployment without code changes.
+//
+// Flags are declared in the configuration (features.flags) or in the MORPHEO_FEATURES environment
+// variable as a comma separated list. A flag prefixed with "-" is explicitly disabled:
+//
+//	MORPHEO_FEATURES=compression,-preduplet-batching
+//
+// Note that this package must not import the common package.
+package features
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// EnvVar is the environment variable feature flags are read from by FromEnv
+const EnvVar = "MORPHEO_FEATURES"
+
+// Flag identifies a feature
+type Flag string
+
+// Known feature flags
+const (
+	// Compression compresses (gzip or zstd) the bodies of the requests sent by the HTTP clients
+	Compression Flag = "compression"
+	// PredupletBatching lets the worker run several preduplets sharing the same model in a single
+	// container
+	PredupletBatching Flag = "preduplet-batching"
+)
+
+// Known lists the flags that can be toggled, and what they do
+var Known = map[Flag]string{
+	Compression:       "compress the bodies of the requests sent by the HTTP clients",
+	PredupletBatching: "run preduplets sharing the same model in a single container",
+}
+
+// Set is a set of toggled feature flags. It is safe for concurrent use. A nil *Set has every flag
+// disabled.
+type Set struct {
+	lock  sync.RWMutex
+	flags map[Flag]bool
+}
+
+// New creates a set with the given flags enabled
+func New(enabled ...Flag) *Set {
+	s := &Set{flags: map[Flag]bool{}}
+	for _, f := range enabled {
+		s.flags[f] = true
+	}
+	return s
+}
+
+// Parse creates a set from a list of flags, each one of them possibly prefixed with "-" to disable
+// it. Unknown flags are rejected. Items may themselves be comma separated lists.
+func Parse(specs ...string) (*Set, error) {
+	s := New()
+	if err := s.Apply(specs...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// FromEnv creates a set from the MORPHEO_FEATURES environment variable
+func FromEnv() (*Set, error) {
+	return Parse(os.Getenv(EnvVar))
+}
+
+// Apply toggles the flags of a list (see Parse) on top of the current ones
+func (s *Set) Apply(specs ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, spec := range specs {
+		for _, item := range strings.Split(spec, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			enabled := !strings.HasPrefix(item, "-")
+			f := Flag(strings.TrimPrefix(item, "-"))
+			if _, ok := Known[f]; !ok {
+				return fmt.Errorf("Unknown feature flag %s (known flags: %s)", f, knownFlags())
+			}
+			s.flags[f] = enabled
+		}
+	}
+	return nil
+}
+
+// Enabled returns true if a flag is enabled
+func (s *Set) Enabled(f Flag) bool {
+	if s == nil {
+		return false
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.flags[f]
+}
+
+// Toggle enables or disables a flag
+func (s *Set) Toggle(f Flag, enabled bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.flags[f] = enabled
+}
+
+// String lists the enabled flags, comma separated
+func (s *Set) String() string {
+	if s == nil {
+		return ""
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	enabled := []string{}
+	for f, ok := range s.flags {
+		if ok {
+			enabled = append(enabled, string(f))
+		}
+	}
+	sort.Strings(enabled)
+	return strings.Join(enabled, ",")
+}
+
+func knownFlags() string {
+	flags := make([]string, 0, len(Known))
+	for f := range Known {
+		flags = append(flags, string(f))
+	}
+	sort.Strings(flags)
+	return strings.Join(flags, ", ")
+}
@@ -0,0 +1,98 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/bufpool"
+)
+
+// StreamToFifo creates a named pipe at path (in a volume mounted in a container, typically) and
+// streams r to it in the background, so that the container reads a blob as it is downloaded,
+// instead of once it has been written to disk:
+//
+//	blob, err := storage.GetDataBlob(id)
+//	done, err := common.StreamToFifo(ctx, filepath.Join(dataDir, "input"), blob)
+//	_, err = runtime.RunImageInUntrustedContainerContext(ctx, image, args, mounts, true)
+//	err = <-done
+//
+// The returned channel yields the outcome of the copy. The pipe is removed once it is over, which
+// happens when the container is done reading it, or when ctx is done (if the container never opens
+// the pipe, for instance).
+func StreamToFifo(ctx context.Context, path string, r io.Reader) (<-chan error, error) {
+	if err := syscall.Mkfifo(path, 0644); err != nil {
+		return nil, fmt.Errorf("Error creating named pipe %s: %s", path, err)
+	}
+
+	done := make(chan error, 1)
+	opened := make(chan struct{})
+	go func() {
+		select {
+		case <-opened:
+		case <-ctx.Done():
+			// Opening the pipe for reading unblocks the writer, which then fails writing to it
+			if reader, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0); err == nil {
+				reader.Close()
+			}
+		}
+	}()
+	go func() {
+		defer os.Remove(path)
+		writer, err := os.OpenFile(path, os.O_WRONLY, 0)
+		close(opened)
+		if err != nil {
+			done <- fmt.Errorf("Error opening named pipe %s: %s", path, err)
+			return
+		}
+		_, err = bufpool.Copy(writer, ContextReader(ctx, r))
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			err = fmt.Errorf("Error streaming to named pipe %s: %w", path, err)
+		}
+		done <- err
+	}()
+	return done, nil
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package common_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/commontest"
+)
+
+// Native fuzz targets of the decoders of untrusted broker and HTTP input. Without -fuzz, go test
+// only runs them over their seed corpus; fuzz one of them with e.g.
+//
+//	go test -run '^$' -fuzz '^FuzzLearnuplet$' ./common/
+//
+// Seeds are the golden fixtures and valid and near-valid values from the commontest generators.
+
+const fuzzSeeds = 16
+
+func FuzzLearnuplet(f *testing.F) {
+	addFixtureSeed(f, "learnuplet.v1")
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < fuzzSeeds; i++ {
+		addJSONSeed(f, commontest.RandomLearnuplet(rng))
+		l, _ := commontest.NearValidLearnuplet(rng)
+		addJSONSeed(f, l)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		commontest.FuzzLearnuplet(data)
+	})
+}
+
+func FuzzPreduplet(f *testing.F) {
+	addFixtureSeed(f, "preduplet.v1")
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < fuzzSeeds; i++ {
+		addJSONSeed(f, commontest.RandomPreduplet(rng))
+		p, _ := commontest.NearValidPreduplet(rng)
+		addJSONSeed(f, p)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		commontest.FuzzPreduplet(data)
+	})
+}
+
+func FuzzStatusEvent(f *testing.F) {
+	addFixtureSeed(f, "status_event.v1")
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < fuzzSeeds; i++ {
+		addJSONSeed(f, commontest.RandomStatusEvent(rng))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		commontest.FuzzStatusEvent(data)
+	})
+}
+
+func FuzzLearnupletChaincode(f *testing.F) {
+	addFixtureSeed(f, "learnuplet_chaincode.v1")
+	f.Add([]byte(`{}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		commontest.FuzzLearnupletChaincode(data)
+	})
+}
+
+// TestFuzzSeedsAreValid checks that the generators actually produce valid seeds, the fuzz targets
+// only exploring rejected inputs otherwise
+func TestFuzzSeedsAreValid(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < fuzzSeeds; i++ {
+		if data := mustMarshal(t, commontest.RandomLearnuplet(rng)); commontest.FuzzLearnuplet(data) != 1 {
+			t.Errorf("Random learnuplet rejected: %s", data)
+		}
+		if data := mustMarshal(t, commontest.RandomPreduplet(rng)); commontest.FuzzPreduplet(data) != 1 {
+			t.Errorf("Random preduplet rejected: %s", data)
+		}
+		if data := mustMarshal(t, commontest.RandomStatusEvent(rng)); commontest.FuzzStatusEvent(data) != 1 {
+			t.Errorf("Random status event rejected: %s", data)
+		}
+		l, field := commontest.NearValidLearnuplet(rng)
+		if data := mustMarshal(t, l); commontest.FuzzLearnuplet(data) != 0 {
+			t.Errorf("Learnuplet with an invalid %s accepted: %s", field, data)
+		}
+		p, field := commontest.NearValidPreduplet(rng)
+		if data := mustMarshal(t, p); commontest.FuzzPreduplet(data) != 0 {
+			t.Errorf("Preduplet with an invalid %s accepted: %s", field, data)
+		}
+	}
+}
+
+func addFixtureSeed(f *testing.F, name string) {
+	data, err := ioutil.ReadFile(commontest.FixturePath(name))
+	if err != nil {
+		f.Fatalf("Error reading fixture %s: %s", name, err)
+	}
+	f.Add(data)
+}
+
+func addJSONSeed(f *testing.F, v interface{}) {
+	f.Add(mustMarshal(f, v))
+}
+
+func mustMarshal(t testing.TB, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Error encoding %T: %s", v, err)
+	}
+	return data
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package common_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/commontest"
+)
+
+// TestGoldenRoundTrip checks that the wire formats of the common data structures match their
+// golden fixtures (see commontest.Fixtures). Run with MORPHEO_UPDATE_GOLDEN=1 to rewrite the
+// fixtures after an intended change.
+func TestGoldenRoundTrip(t *testing.T) {
+	commontest.RoundTripFixtures(t)
+}
+
+// TestGoldenFixturesRegistered checks that no fixture of testdata/ is left out of the round trips
+func TestGoldenFixturesRegistered(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join(filepath.Dir(commontest.FixturePath("any")), "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("No golden fixture found")
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		if _, ok := commontest.Fixtures[name]; !ok {
+			t.Errorf("Fixture %s isn't registered in commontest.Fixtures", name)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/auth"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+)
+
+// Compute API uplet submission routes (the client package's ComputeLearnupletRoute and
+// ComputePredupletRoute; not imported so that servers don't depend on the clients)
+const (
+	LearnupletRoute = "/learn"
+	PredupletRoute  = "/pred"
+)
+
+// ComputeSpec describes the compute API: uplet submission, introspection and status events
+func ComputeSpec() *Spec {
+	apiError := Response{Description: "Invalid request", Body: common.APIError{}}
+	return &Spec{
+		Title:       "Morpheo compute API",
+		Version:     httpclient.APIVersion,
+		Description: "Submission of learning and prediction tasks to the Morpheo compute workers",
+		Operations: []Operation{
+			{
+				Method:        http.MethodPost,
+				Path:          LearnupletRoute,
+				OperationID:   "postLearnuplet",
+				Summary:       "Queues a learning task",
+				Tags:          []string{"uplets"},
+				Request:       common.Learnuplet{},
+				InlinePayload: "Small input data of the learnuplet, instead of a storage round trip",
+				Scopes:        []string{auth.ScopeUpletCreate},
+				Responses: map[int]Response{
+					http.StatusAccepted:              {Description: "Learnuplet queued"},
+					http.StatusBadRequest:            apiError,
+					http.StatusRequestEntityTooLarge: {Description: "Learnuplet or inline payload too large", Body: common.APIError{}},
+				},
+			},
+			{
+				Method:        http.MethodPost,
+				Path:          PredupletRoute,
+				OperationID:   "postPreduplet",
+				Summary:       "Queues a prediction task",
+				Tags:          []string{"uplets"},
+				Request:       common.Preduplet{},
+				InlinePayload: "Small input data of the prediction, instead of a storage round trip",
+				Scopes:        []string{auth.ScopeUpletCreate},
+				Responses: map[int]Response{
+					http.StatusAccepted:              {Description: "Preduplet queued"},
+					http.StatusBadRequest:            apiError,
+					http.StatusRequestEntityTooLarge: {Description: "Preduplet or inline payload too large", Body: common.APIError{}},
+				},
+			},
+			{
+				Method:      http.MethodGet,
+				Path:        UpletsRoute,
+				OperationID: "listUplets",
+				Summary:     "Lists the recently accepted uplets and their publish status, most recent first",
+				Tags:        []string{"introspection"},
+				Query:       map[string]string{"offset": "Number of uplets to skip", "limit": "Maximum number of uplets returned"},
+				Scopes:      []string{auth.ScopeUpletRead},
+				Responses: map[int]Response{
+					http.StatusOK: {Description: "A page of accepted uplets", Body: struct {
+						Page
+						Items []AcceptedUplet `json:"items"`
+					}{}},
+					http.StatusBadRequest: apiError,
+				},
+			},
+			{
+				Method:      http.MethodGet,
+				Path:        UpletsRoute + "/{key}",
+				OperationID: "getUplet",
+				Summary:     "Returns a recently accepted uplet and its publish status",
+				Tags:        []string{"introspection"},
+				Scopes:      []string{auth.ScopeUpletRead},
+				Responses: map[int]Response{
+					http.StatusOK:       {Description: "The accepted uplet", Body: AcceptedUplet{}},
+					http.StatusNotFound: {Description: "Uplet not accepted recently", Body: common.APIError{}},
+				},
+			},
+			{
+				Method:      http.MethodGet,
+				Path:        QueuesRoute,
+				OperationID: "getQueues",
+				Summary:     "Returns the depth of every broker topic and channel",
+				Tags:        []string{"introspection"},
+				Scopes:      []string{auth.ScopeQueueRead},
+				Responses: map[int]Response{
+					http.StatusOK: {Description: "Queue depths", Body: struct {
+						Queues []common.QueueDepth `json:"queues"`
+					}{}},
+				},
+			},
+			{
+				Method:      http.MethodGet,
+				Path:        EventsRoute,
+				OperationID: "streamEvents",
+				Summary:     "Streams uplet status events (Server-Sent Events, whose data is a StatusEvent)",
+				Tags:        []string{"events"},
+				Query:       map[string]string{"uplet": "Key of an uplet to follow (repeatable, every uplet if unset)"},
+				Scopes:      []string{auth.ScopeUpletRead},
+				Responses: map[int]Response{
+					http.StatusOK: {Description: "Event stream", ContentType: "text/event-stream"},
+				},
+			},
+		},
+	}
+}
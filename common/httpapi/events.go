@@ -0,0 +1,204 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/codec"
+)
+
+// EventsRoute streams uplet status events
+const EventsRoute = "/events"
+
+// DefaultKeepAlive is the interval between two keep-alive comments sent on idle event streams (so
+// that proxies don't close them)
+const DefaultKeepAlive = 15 * time.Second
+
+// subscriberBuffer is the number of events buffered per subscriber. Slow subscribers miss the
+// events that don't fit rather than blocking the hub.
+const subscriberBuffer = 64
+
+// EventHub relays uplet status events to Server-Sent Events subscribers:
+//
+//	GET /events?uplet=<key>&uplet=<key>
+//
+// streams the status transitions of the given uplets (of every uplet if none is given). Events are
+// fed to the hub with Publish, or by registering Handler as a broadcast handler on the StatusTopic.
+type EventHub struct {
+	KeepAlive time.Duration
+	Clock     common.Clock
+
+	lock        sync.Mutex
+	subscribers map[*subscriber]struct{}
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+type subscriber struct {
+	uplets map[string]struct{} // every uplet if empty
+	events chan common.StatusEvent
+}
+
+// NewEventHub creates a hub without subscribers
+func NewEventHub() *EventHub {
+	return &EventHub{KeepAlive: DefaultKeepAlive, subscribers: map[*subscriber]struct{}{}}
+}
+
+// Publish sends an event to the subscribers interested in its uplet
+func (h *EventHub) Publish(event common.StatusEvent) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for s := range h.subscribers {
+		if _, ok := s.uplets[event.UpletKey]; len(s.uplets) > 0 && !ok {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+		}
+	}
+}
+
+// Handler returns a broker handler consuming StatusEvents, to be registered on the StatusTopic with
+// AddBroadcastHandler
+func (h *EventHub) Handler() common.Handler {
+	return func(message []byte) error {
+		var event common.StatusEvent
+		if err := codec.DecodeMessage(message, &event); err != nil {
+			return common.NewHandlerFatalError(fmt.Errorf("Error un-marshaling status event: %s", err))
+		}
+		h.Publish(event)
+		return nil
+	}
+}
+
+// Register adds the events route to a mux
+func (h *EventHub) Register(mux *http.ServeMux) {
+	mux.Handle(EventsRoute, h)
+}
+
+// Close ends every event stream, so that they don't hold a graceful server shutdown back (see
+// http.Server.RegisterOnShutdown)
+func (h *EventHub) Close() {
+	h.closeOnce.Do(func() {
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		if h.closed == nil {
+			h.closed = make(chan struct{})
+		}
+		close(h.closed)
+	})
+}
+
+func (h *EventHub) closedChan() <-chan struct{} {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.closed == nil {
+		h.closed = make(chan struct{})
+	}
+	return h.closed
+}
+
+// ServeHTTP streams status events until the client disconnects
+func (h *EventHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("Streaming isn't supported"))
+		return
+	}
+
+	s := &subscriber{uplets: map[string]struct{}{}, events: make(chan common.StatusEvent, subscriberBuffer)}
+	for _, key := range r.URL.Query()["uplet"] {
+		s.uplets[key] = struct{}{}
+	}
+	h.subscribe(s)
+	defer h.unsubscribe(s)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := h.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = DefaultKeepAlive
+	}
+	clk := clock.OrReal(h.Clock)
+	closed := h.closedChan()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-closed:
+			return
+		case event := <-s.events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+		case <-clk.After(keepAlive):
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+func (h *EventHub) subscribe(s *subscriber) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.subscribers == nil {
+		h.subscribers = map[*subscriber]struct{}{}
+	}
+	h.subscribers[s] = struct{}{}
+}
+
+func (h *EventHub) unsubscribe(s *subscriber) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.subscribers, s)
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package httpapi holds the server side building blocks of the Morpheo HTTP APIs (the compute API in
+// particular): JSON (or MessagePack and CBOR, negotiated) responses, pagination and operational endpoints.
+//
+// Note that this package must not import the client package: servers mustn't depend on the clients
+// (the client package imports this one for the submission formats).
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/bufpool"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/codec"
+)
+
+// Pagination defaults
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// Page is a paginated list of items
+type Page struct {
+	Items  interface{} `json:"items"`
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	Total  int         `json:"total"`
+}
+
+// WriteJSON sends a JSON response
+func WriteJSON(w http.ResponseWriter, status int, body interface{}) {
+	buf, err := bufpool.MarshalJSON(body)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("Error marshaling response to JSON: %s", err))
+		return
+	}
+	defer bufpool.Put(buf)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// WriteResponse sends a response encoded with the codec the client prefers (JSON, MessagePack or
+// CBOR, negotiated from the Accept header of the request)
+func WriteResponse(w http.ResponseWriter, r *http.Request, status int, body interface{}) {
+	c := codec.Negotiate(r.Header.Get("Accept"), codec.JSON, codec.MessagePack, codec.CBOR)
+	if c == codec.JSON {
+		WriteJSON(w, status, body)
+		return
+	}
+	data, err := c.Marshal(body)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("Error marshaling response to %s: %s", c.ContentType(), err))
+		return
+	}
+	w.Header().Set("Content-Type", c.ContentType())
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// ErrUnsupportedMediaType is returned by DecodeRequest for bodies of an unknown content type, to be
+// sent with http.StatusUnsupportedMediaType (clients then fall back to JSON)
+var ErrUnsupportedMediaType = errors.New("unsupported content type")
+
+// DecodeRequest decodes the body of a request into dest, with the codec of its Content-Type (JSON if
+// it has none)
+func DecodeRequest(r *http.Request, dest interface{}) error {
+	return decodeBody(r.Header.Get("Content-Type"), r.Body, dest)
+}
+
+func decodeBody(contentType string, body io.Reader, dest interface{}) error {
+	c := codec.JSON
+	if contentType != "" {
+		var ok bool
+		if c, ok = codec.ForContentType(contentType); !ok {
+			return fmt.Errorf("%w: %s", ErrUnsupportedMediaType, contentType)
+		}
+	}
+	if c == codec.JSON {
+		return json.NewDecoder(body).Decode(dest)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	return c.Unmarshal(data, dest)
+}
+
+// WriteError sends an error as a Morpheo API error ({"error": "...", "status": ...}). Validation
+// errors (*common.ValidationError) list the invalid fields too ({..., "fields": [...]}).
+func WriteError(w http.ResponseWriter, status int, err error) {
+	apiErr, ok := err.(*common.APIError)
+	var validationErr *common.ValidationError
+	switch {
+	case ok:
+	case errors.As(err, &validationErr):
+		apiErr = common.NewValidationAPIError(validationErr)
+	default:
+		apiErr = common.NewAPIError(err.Error())
+	}
+	apiErr.Status = status
+	WriteJSON(w, status, apiErr)
+}
+
+// Pagination reads the offset and limit query parameters of a request
+func Pagination(r *http.Request) (offset, limit int, err error) {
+	limit = DefaultLimit
+	query := r.URL.Query()
+	if s := query.Get("offset"); s != "" {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must be a positive integer (provided: %s)", s)
+		}
+	}
+	if s := query.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 || limit > MaxLimit {
+			return 0, 0, fmt.Errorf("limit must be an integer between 1 and %d (provided: %s)", MaxLimit, s)
+		}
+	}
+	return offset, limit, nil
+}
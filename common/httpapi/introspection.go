@@ -0,0 +1,233 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+)
+
+// Introspection routes
+const (
+	UpletsRoute = "/uplets"
+	QueuesRoute = "/queues"
+)
+
+// DefaultUpletLogSize is the number of accepted uplets an UpletLog remembers by default
+const DefaultUpletLogSize = 1000
+
+// Publish statuses of an accepted uplet
+const (
+	PublishPending = "pending"
+	PublishDone    = "published"
+	PublishFailed  = "failed"
+)
+
+// AcceptedUplet describes an uplet accepted by the API, and whether it made it to the broker
+type AcceptedUplet struct {
+	Type          string     `json:"uplet_type"`
+	Key           string     `json:"uplet_key"`
+	Topic         string     `json:"topic"`
+	AcceptedAt    time.Time  `json:"accepted_at"`
+	PublishStatus string     `json:"publish_status"`
+	PublishError  string     `json:"publish_error,omitempty"`
+	PublishedAt   *time.Time `json:"published_at,omitempty"`
+}
+
+// UpletLog remembers the last uplets accepted by the API (in memory, oldest ones being forgotten
+// first) so that operators can check they were actually queued
+type UpletLog struct {
+	Size  int
+	Clock common.Clock
+
+	lock   sync.Mutex
+	uplets []*AcceptedUplet
+	byKey  map[string]*AcceptedUplet
+}
+
+// NewUpletLog creates a log remembering the last size accepted uplets
+func NewUpletLog(size int) *UpletLog {
+	return &UpletLog{Size: size, byKey: map[string]*AcceptedUplet{}}
+}
+
+// Push records an accepted uplet, pushes it to the broker and records the outcome
+func (l *UpletLog) Push(producer common.Producer, upletType, upletKey, topic string, body []byte) error {
+	l.accept(upletType, upletKey, topic)
+	err := producer.Push(topic, body)
+	l.published(upletKey, err)
+	return err
+}
+
+// Get returns a copy of an accepted uplet
+func (l *UpletLog) Get(upletKey string) (AcceptedUplet, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	u, ok := l.byKey[upletKey]
+	if !ok {
+		return AcceptedUplet{}, false
+	}
+	return *u, true
+}
+
+// List returns a page of accepted uplets, most recent first, and the total number of uplets
+// remembered
+func (l *UpletLog) List(offset, limit int) ([]AcceptedUplet, int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	total := len(l.uplets)
+	page := []AcceptedUplet{}
+	for i := total - 1 - offset; i >= 0 && len(page) < limit; i-- {
+		page = append(page, *l.uplets[i])
+	}
+	return page, total
+}
+
+func (l *UpletLog) accept(upletType, upletKey, topic string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	u := &AcceptedUplet{
+		Type:          upletType,
+		Key:           upletKey,
+		Topic:         topic,
+		AcceptedAt:    clock.OrReal(l.Clock).Now(),
+		PublishStatus: PublishPending,
+	}
+	if l.byKey == nil {
+		l.byKey = map[string]*AcceptedUplet{}
+	}
+	l.uplets = append(l.uplets, u)
+	l.byKey[upletKey] = u
+
+	size := l.Size
+	if size <= 0 {
+		size = DefaultUpletLogSize
+	}
+	for len(l.uplets) > size {
+		if oldest := l.uplets[0]; l.byKey[oldest.Key] == oldest {
+			delete(l.byKey, oldest.Key)
+		}
+		l.uplets[0] = nil
+		l.uplets = l.uplets[1:]
+	}
+}
+
+func (l *UpletLog) published(upletKey string, err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	u, ok := l.byKey[upletKey]
+	if !ok {
+		return
+	}
+	if err != nil {
+		u.PublishStatus, u.PublishError = PublishFailed, err.Error()
+		return
+	}
+	now := clock.OrReal(l.Clock).Now()
+	u.PublishStatus, u.PublishedAt = PublishDone, &now
+}
+
+// Introspection serves the introspection endpoints:
+//   - GET /uplets?offset=&limit=: recently accepted uplets and their publish status
+//   - GET /uplets/<key>: a recently accepted uplet
+//   - GET /queues: the depth of every topic/channel
+type Introspection struct {
+	Log       *UpletLog
+	Inspector common.QueueInspector // nil if the broker can't be inspected
+}
+
+// Register adds the introspection routes to a mux
+func (i *Introspection) Register(mux *http.ServeMux) {
+	mux.HandleFunc(UpletsRoute, i.listUplets)
+	mux.HandleFunc(UpletsRoute+"/", i.getUplet)
+	mux.HandleFunc(QueuesRoute, i.queues)
+}
+
+func (i *Introspection) listUplets(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	offset, limit, err := Pagination(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	uplets, total := i.Log.List(offset, limit)
+	WriteResponse(w, r, http.StatusOK, Page{Items: uplets, Offset: offset, Limit: limit, Total: total})
+}
+
+func (i *Introspection) getUplet(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, UpletsRoute+"/")
+	uplet, ok := i.Log.Get(key)
+	if !ok {
+		WriteError(w, http.StatusNotFound, fmt.Errorf("Uplet %s wasn't accepted recently", key))
+		return
+	}
+	WriteResponse(w, r, http.StatusOK, uplet)
+}
+
+func (i *Introspection) queues(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	if i.Inspector == nil {
+		WriteError(w, http.StatusNotImplemented, fmt.Errorf("The broker in use can't be inspected"))
+		return
+	}
+	depths, err := i.Inspector.QueueDepths()
+	if err != nil {
+		WriteError(w, http.StatusBadGateway, err)
+		return
+	}
+	WriteResponse(w, r, http.StatusOK, map[string]interface{}{"queues": depths})
+}
+
+func allowGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		WriteError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %s not allowed", r.Method))
+		return false
+	}
+	return true
+}
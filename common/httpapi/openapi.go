@@ -0,0 +1,416 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package httpapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/auth"
+)
+
+// OpenAPI routes
+const (
+	OpenAPIRoute   = "/openapi.json"
+	SwaggerUIRoute = "/docs"
+)
+
+// DefaultSwaggerUIAssets is the base URL the Swagger UI page loads its assets from when none is set
+const DefaultSwaggerUIAssets = "https://unpkg.com/swagger-ui-dist@5"
+
+// Operation describes an API route. Request and response bodies are described by Go values whose
+// types are turned into JSON schemas (following their json struct tags).
+type Operation struct {
+	Method      string
+	Path        string
+	OperationID string
+	Summary     string
+	Tags        []string
+	// Query lists the query parameters of the route, with their description
+	Query   map[string]string
+	Request interface{}
+	// InlinePayload, if set, describes the file that may be sent along Request in a multipart
+	// submission (see DecodeSubmission)
+	InlinePayload string
+	// Scopes lists the scopes the route requires (see the auth package)
+	Scopes    []string
+	Responses map[int]Response
+}
+
+// Response describes a response of an operation
+type Response struct {
+	Description string
+	ContentType string // application/json if empty and Body isn't nil
+	Body        interface{}
+}
+
+// Spec generates an OpenAPI 3 document from a list of operations
+type Spec struct {
+	Title       string
+	Version     string
+	Description string
+	Operations  []Operation
+	// SwaggerUIAssets is the base URL of the swagger-ui-dist assets loaded by the Swagger UI page
+	// (DefaultSwaggerUIAssets if empty), e.g. a local copy on air-gapped sites
+	SwaggerUIAssets string
+}
+
+// RouteScopes returns the scopes required by the routes of the spec, for the auth middleware to
+// enforce them. Operations without scopes, and the documentation routes (see Register), are open
+// to any client; other routes are denied.
+func (s *Spec) RouteScopes() auth.RouteScopes {
+	scopes := auth.RouteScopes{
+		http.MethodGet + " " + OpenAPIRoute:   nil,
+		http.MethodGet + " " + SwaggerUIRoute: nil,
+	}
+	for _, op := range s.Operations {
+		scopes[op.Method+" "+op.Path] = op.Scopes
+	}
+	return scopes
+}
+
+// Document builds the OpenAPI document. Struct types are described once, in the schemas
+// components, and referred to.
+func (s *Spec) Document() map[string]interface{} {
+	schemas := map[string]interface{}{}
+	paths := map[string]map[string]interface{}{}
+
+	for _, op := range s.Operations {
+		operation := map[string]interface{}{
+			"operationId": op.OperationID,
+			"summary":     op.Summary,
+		}
+		if len(op.Tags) > 0 {
+			operation["tags"] = op.Tags
+		}
+		if len(op.Query) > 0 {
+			names := make([]string, 0, len(op.Query))
+			for name := range op.Query {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			params := []map[string]interface{}{}
+			for _, name := range names {
+				params = append(params, map[string]interface{}{
+					"name":        name,
+					"in":          "query",
+					"description": op.Query[name],
+					"schema":      map[string]string{"type": "string"},
+				})
+			}
+			operation["parameters"] = params
+		}
+		if op.Request != nil {
+			schema := schemaOf(reflect.TypeOf(op.Request), schemas)
+			content := map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+			if op.InlinePayload != "" {
+				content["multipart/form-data"] = map[string]interface{}{
+					"schema": map[string]interface{}{
+						"type":     "object",
+						"required": []string{SubmissionDescriptorField},
+						"properties": map[string]interface{}{
+							SubmissionDescriptorField: schema,
+							SubmissionPayloadField:    map[string]string{"type": "string", "format": "binary", "description": op.InlinePayload},
+						},
+					},
+					"encoding": map[string]interface{}{
+						SubmissionDescriptorField: map[string]string{"contentType": "application/json"},
+					},
+				}
+			}
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  content,
+			}
+		}
+		if len(op.Scopes) > 0 {
+			operation["x-morpheo-scopes"] = op.Scopes
+		}
+		responses := map[string]interface{}{}
+		for status, resp := range op.Responses {
+			r := map[string]interface{}{"description": resp.Description}
+			contentType := resp.ContentType
+			if contentType == "" && resp.Body != nil {
+				contentType = "application/json"
+			}
+			if contentType != "" {
+				schema := map[string]interface{}{"type": "string"}
+				if resp.Body != nil {
+					schema = schemaOf(reflect.TypeOf(resp.Body), schemas)
+				}
+				r["content"] = map[string]interface{}{contentType: map[string]interface{}{"schema": schema}}
+			}
+			responses[fmt.Sprint(status)] = r
+		}
+		operation["responses"] = responses
+
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]interface{}{}
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":       s.Title,
+			"version":     s.Version,
+			"description": s.Description,
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+// Handler serves the OpenAPI document as JSON
+func (s *Spec) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if allowGet(w, r) {
+			WriteJSON(w, http.StatusOK, s.Document())
+		}
+	})
+}
+
+// Register adds the OpenAPI document and Swagger UI routes to a mux
+func (s *Spec) Register(mux *http.ServeMux) {
+	mux.Handle(OpenAPIRoute, s.Handler())
+	mux.Handle(SwaggerUIRoute, SwaggerUIHandler(s.Title, OpenAPIRoute, s.SwaggerUIAssets))
+}
+
+// SwaggerUIHandler serves a Swagger UI page browsing the OpenAPI document served at specURL, whose
+// assets are loaded from assetsURL (DefaultSwaggerUIAssets if empty)
+func SwaggerUIHandler(title, specURL, assetsURL string) http.Handler {
+	if assetsURL == "" {
+		assetsURL = DefaultSwaggerUIAssets
+	}
+	assetsURL = strings.TrimSuffix(assetsURL, "/")
+	page := fmt.Sprintf(swaggerUIPage, title, assetsURL, assetsURL, specURL)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowGet(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	})
+}
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>%s</title>
+  <link rel="stylesheet" href="%s/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="%s/swagger-ui-bundle.js"></script>
+  <script>SwaggerUIBundle({url: %q, dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+var (
+	timeType           = reflect.TypeOf(time.Time{})
+	textMarshalerType  = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonMarshalerType  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	emptyInterfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+	jsonRawMessageType = reflect.TypeOf(json.RawMessage{})
+	byteSliceType      = reflect.TypeOf([]byte{})
+	durationType       = reflect.TypeOf(time.Duration(0))
+	errorInterfaceType = reflect.TypeOf((*error)(nil)).Elem()
+	perfType           = reflect.TypeOf(common.Perf(0))
+)
+
+// schemaOf returns the JSON schema of a type, registering the schemas of the struct types it uses
+// in schemas
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == durationType:
+		return map[string]interface{}{"type": "integer", "format": "int64", "description": "nanoseconds"}
+	case t == jsonRawMessageType || t == emptyInterfaceType:
+		return map[string]interface{}{}
+	case t == byteSliceType:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	case t == perfType:
+		return map[string]interface{}{"type": "number"}
+	case t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType):
+		schema := map[string]interface{}{"type": "string"}
+		if t.Name() == "UUID" {
+			schema["format"] = "uuid"
+		}
+		return schema
+	case t.Implements(jsonMarshalerType) || t.Implements(errorInterfaceType):
+		// Custom JSON encodings can't be described from the type
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Uint8, reflect.Uint16:
+		return map[string]interface{}{"type": "integer", "format": "int32", "minimum": 0}
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		// uint32 overflows int32: there is no unsigned format
+		return map[string]interface{}{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		name := schemaName(t)
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+		if _, ok := schemas[name]; ok {
+			return ref
+		}
+		// Placeholder, for recursive types
+		schemas[name] = map[string]interface{}{}
+		schemas[name] = structSchema(t, schemas)
+		return ref
+	}
+	return map[string]interface{}{}
+}
+
+// modulePath is trimmed from the package paths of schema names
+const modulePath = "github.com/MorpheoOrg/morpheo-go-packages/"
+
+// schemaName returns the name of the schema of a struct type: its package path and name (e.g.
+// common.Learnuplet, common.httpclient.FieldError), so that homonyms from different packages don't
+// collide
+func schemaName(t reflect.Type) string {
+	name := strings.ReplaceAll(strings.TrimPrefix(t.PkgPath(), modulePath), "/", ".") + "." + t.Name()
+	// Component names may only hold letters, digits, dots, dashes and underscores
+	return strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(".-_", r)) {
+			return r
+		}
+		return '_'
+	}, strings.TrimPrefix(name, "."))
+}
+
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	// Fields declared on the struct itself win over the fields of embedded structs, as in
+	// encoding/json
+	properties := map[string]interface{}{}
+	required := map[string]bool{}
+	embeddedProperties := map[string]interface{}{}
+	embeddedRequired := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue // unexported
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" {
+			// Embedded structs are flattened, embedded interfaces ignored
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded := structSchema(ft, schemas)
+				for k, v := range embedded["properties"].(map[string]interface{}) {
+					embeddedProperties[k] = v
+				}
+				if req, ok := embedded["required"].([]string); ok {
+					for _, k := range req {
+						embeddedRequired[k] = true
+					}
+				}
+			}
+			continue
+		}
+		if f.PkgPath != "" || f.Type.Kind() == reflect.Func || f.Type.Kind() == reflect.Chan {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = schemaOf(f.Type, schemas)
+		if !strings.Contains(tag, "omitempty") && f.Type.Kind() != reflect.Ptr {
+			required[name] = true
+		}
+	}
+	for name, property := range embeddedProperties {
+		if _, ok := properties[name]; ok {
+			continue
+		}
+		properties[name] = property
+		if embeddedRequired[name] {
+			required[name] = true
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		names := make([]string, 0, len(required))
+		for name := range required {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		schema["required"] = names
+	}
+	return schema
+}
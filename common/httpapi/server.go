@@ -0,0 +1,156 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/tracing"
+)
+
+// DefaultShutdownTimeout is how long in-flight requests are given to complete on shutdown
+const DefaultShutdownTimeout = 30 * time.Second
+
+// Server runs an HTTP API until it receives a termination signal, then shuts it down gracefully:
+// it stops accepting connections, lets in-flight requests complete (within ShutdownTimeout), and
+// only then stops the broker producer, flushing the uplets accepted in the meantime. Every request
+// is served within a server span, child of the trace context sent by the client (see
+// tracing.Middleware).
+type Server struct {
+	HTTP *http.Server
+	// Name identifies the server in its spans ("http-server" if empty)
+	Name string
+	// Producer, if set, is stopped once the HTTP server is shut down
+	Producer common.Producer
+	// Events, if set, has its streams closed when the shutdown starts: they would otherwise hold it
+	// back until ShutdownTimeout
+	Events          *EventHub
+	ShutdownTimeout time.Duration
+	// Signals trigger the shutdown (SIGINT and SIGTERM if empty)
+	Signals []os.Signal
+	Logger  logging.Logger
+}
+
+// ListenAndServe serves HTTP (or HTTPS if the server has a TLS configuration) until a termination
+// signal is received or ctx is done, then shuts down gracefully. It returns nil after a graceful
+// shutdown.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	logger := logging.OrDefault(s.Logger).With(logging.Fields{logging.FieldComponent: "http-server"})
+
+	signals := s.Signals
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, signals...)
+	defer signal.Stop(sigChan)
+
+	handler := s.HTTP.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	name := s.Name
+	if name == "" {
+		name = "http-server"
+	}
+	s.HTTP.Handler = tracing.Middleware(handler, name)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Infof("Listening on %s", s.HTTP.Addr)
+		if s.HTTP.TLSConfig != nil {
+			serveErr <- s.HTTP.ListenAndServeTLS("", "")
+		} else {
+			serveErr <- s.HTTP.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		s.stopProducer(logger)
+		return fmt.Errorf("Error serving HTTP: %s", err)
+	case sig := <-sigChan:
+		logger.Infof("Received %s, shutting down", sig)
+	case <-ctx.Done():
+		logger.Infof("Shutting down: %s", ctx.Err())
+	}
+	return s.Shutdown()
+}
+
+// Shutdown stops accepting connections, closes the event streams, waits for in-flight requests to
+// complete (or for the shutdown timeout to expire) and stops the producer
+func (s *Server) Shutdown() error {
+	logger := logging.OrDefault(s.Logger).With(logging.Fields{logging.FieldComponent: "http-server"})
+
+	timeout := s.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if s.Events != nil {
+		s.HTTP.RegisterOnShutdown(s.Events.Close)
+	}
+	err := s.HTTP.Shutdown(ctx)
+	if err != nil {
+		logger.Warnf("In-flight requests didn't complete within %s: %s", timeout, err)
+		s.HTTP.Close()
+	} else {
+		logger.Infof("In-flight requests completed")
+	}
+	s.stopProducer(logger)
+	if err != nil {
+		return fmt.Errorf("Error shutting down HTTP server: %s", err)
+	}
+	return nil
+}
+
+func (s *Server) stopProducer(logger logging.Logger) {
+	if s.Producer == nil {
+		return
+	}
+	logger.Infof("Flushing pending broker publishes")
+	s.Producer.Stop()
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+)
+
+// Fields of the multipart/form-data uplet submissions: the uplet descriptor (JSON, or any codec
+// given by the part's Content-Type) must come first, optionally followed by a small inline payload
+// (e.g. the input data of a prediction), which spares simple clients a round trip to storage.
+const (
+	SubmissionDescriptorField = "uplet"
+	SubmissionPayloadField    = "payload"
+)
+
+// Submission size limits, when none is given
+const (
+	DefaultMaxDescriptorSize    = 1 << 20
+	DefaultMaxInlinePayloadSize = 4 << 20
+)
+
+// SubmissionLimits bounds the size of uplet submissions (the defaults apply to zero fields)
+type SubmissionLimits struct {
+	MaxDescriptorSize int64
+	MaxPayloadSize    int64
+}
+
+func (l SubmissionLimits) withDefaults() SubmissionLimits {
+	if l.MaxDescriptorSize <= 0 {
+		l.MaxDescriptorSize = DefaultMaxDescriptorSize
+	}
+	if l.MaxPayloadSize <= 0 {
+		l.MaxPayloadSize = DefaultMaxInlinePayloadSize
+	}
+	return l
+}
+
+// InlinePayload is the payload sent along an uplet descriptor in a multipart submission
+type InlinePayload struct {
+	FileName    string
+	ContentType string
+	Data        []byte
+}
+
+// DecodeSubmission decodes an uplet submission into dest: either a plain body (see DecodeRequest)
+// or a multipart/form-data one, whose parts are read as they arrive (nothing is spooled to disk).
+// The inline payload is nil if the submission has none. Descriptors implementing
+// common.Validatable are validated, so that invalid ones are rejected with their invalid fields
+// (see WriteError). Errors are to be sent with the status given by SubmissionErrorStatus.
+func DecodeSubmission(r *http.Request, dest interface{}, limits SubmissionLimits) (*InlinePayload, error) {
+	limits = limits.withDefaults()
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		if err := decodeBody(r.Header.Get("Content-Type"), common.LimitPayload(r.Body, limits.MaxDescriptorSize), dest); err != nil {
+			return nil, err
+		}
+		return nil, validate(dest)
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid multipart submission: %s", err)
+	}
+	part, err := reader.NextPart()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid multipart submission: %s", err)
+	}
+	if part.FormName() != SubmissionDescriptorField {
+		return nil, fmt.Errorf("Invalid multipart submission: expected the %s field first, got %s", SubmissionDescriptorField, part.FormName())
+	}
+	if err := decodeBody(part.Header.Get("Content-Type"), common.LimitPayload(part, limits.MaxDescriptorSize), dest); err != nil {
+		return nil, fmt.Errorf("Error decoding the %s field: %w", SubmissionDescriptorField, err)
+	}
+	if err := validate(dest); err != nil {
+		return nil, err
+	}
+
+	var payload *InlinePayload
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return payload, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid multipart submission: %s", err)
+		}
+		if part.FormName() != SubmissionPayloadField || payload != nil {
+			return nil, fmt.Errorf("Invalid multipart submission: unexpected field %s", part.FormName())
+		}
+		data, err := common.ReadPayload(part, limits.MaxPayloadSize)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading the %s field: %w", SubmissionPayloadField, err)
+		}
+		payload = &InlinePayload{FileName: part.FileName(), ContentType: part.Header.Get("Content-Type"), Data: data}
+	}
+}
+
+// validate validates a decoded descriptor, if it can be
+func validate(dest interface{}) error {
+	if v, ok := dest.(common.Validatable); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// SubmissionErrorStatus returns the status of the response to a submission DecodeSubmission failed
+// to decode
+func SubmissionErrorStatus(err error) int {
+	var tooLarge *common.PayloadTooLargeError
+	switch {
+	case errors.As(err, &tooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,238 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package httpclient
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+)
+
+// Balancer spreads the requests of a client across the backends of an API
+type Balancer interface {
+	// Pick returns the base URL the next request should be sent to
+	Pick() (string, error)
+	// Report reports the outcome of a request sent to a base URL (err is nil on success)
+	Report(baseURL string, err error)
+}
+
+// Default DNSBalancer settings
+const (
+	DefaultDNSTTL          = 30 * time.Second
+	DefaultBackendCooldown = 30 * time.Second
+)
+
+// DNSBalancer spreads requests across the addresses a DNS name resolves to (its A/AAAA records, or
+// the targets of an SRV record), in turn. Backends failing with connection errors or 5xx are skipped
+// for Cooldown (unless all of them are failing).
+//
+// Requests carry the DNS name in their Host header. For HTTPS, the ServerName of the TLS
+// configuration has to be set to the name the backends' certificates are issued for.
+type DNSBalancer struct {
+	Scheme string
+	// Name is resolved to its addresses, or is an SRV record name (e.g.
+	// "_storage._tcp.morpheo.svc") if SRV is true
+	Name string
+	// Port of the backends (ignored for SRV records, which hold the port of each target)
+	Port int
+	SRV  bool
+	// TTL is how long resolved addresses are used before being resolved again
+	TTL time.Duration
+	// Cooldown is how long a failing backend is skipped
+	Cooldown time.Duration
+	Clock    clock.Clock
+
+	lock       sync.Mutex
+	backends   []string
+	resolvedAt time.Time
+	next       int
+	failing    map[string]time.Time
+}
+
+// NewDNSBalancer creates a balancer across the addresses name resolves to
+func NewDNSBalancer(scheme, name string, port int) *DNSBalancer {
+	return &DNSBalancer{Scheme: scheme, Name: name, Port: port}
+}
+
+// NewSRVBalancer creates a balancer across the targets of an SRV record
+func NewSRVBalancer(scheme, name string) *DNSBalancer {
+	return &DNSBalancer{Scheme: scheme, Name: name, SRV: true}
+}
+
+// Host returns the host requests should be addressed to (in their Host header)
+func (b *DNSBalancer) Host() string {
+	if b.SRV {
+		return ""
+	}
+	return net.JoinHostPort(b.Name, strconv.Itoa(b.Port))
+}
+
+// Pick returns the next healthy backend
+func (b *DNSBalancer) Pick() (string, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := clock.OrReal(b.Clock).Now()
+
+	ttl := b.TTL
+	if ttl <= 0 {
+		ttl = DefaultDNSTTL
+	}
+	if len(b.backends) == 0 || now.Sub(b.resolvedAt) >= ttl {
+		backends, err := b.resolve()
+		switch {
+		case err == nil:
+			b.backends, b.resolvedAt = backends, now
+		case len(b.backends) == 0:
+			return "", err
+		}
+		// On resolution errors, the previously resolved backends are kept
+	}
+
+	for i := 0; i < len(b.backends); i++ {
+		backend := b.backends[(b.next+i)%len(b.backends)]
+		if until, ok := b.failing[backend]; !ok || now.After(until) {
+			b.next = (b.next + i + 1) % len(b.backends)
+			return backend, nil
+		}
+	}
+	// Every backend is failing: let's keep on rotating anyway
+	backend := b.backends[b.next%len(b.backends)]
+	b.next = (b.next + 1) % len(b.backends)
+	return backend, nil
+}
+
+// Report marks a backend as failing if err is a connection error or a 5xx, and as healthy on
+// success
+func (b *DNSBalancer) Report(baseURL string, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err == nil {
+		delete(b.failing, baseURL)
+		return
+	}
+	if !IsBackendFailure(err) {
+		return
+	}
+	cooldown := b.Cooldown
+	if cooldown <= 0 {
+		cooldown = DefaultBackendCooldown
+	}
+	if b.failing == nil {
+		b.failing = map[string]time.Time{}
+	}
+	b.failing[baseURL] = clock.OrReal(b.Clock).Now().Add(cooldown)
+}
+
+func (b *DNSBalancer) resolve() ([]string, error) {
+	var backends []string
+	if b.SRV {
+		_, records, err := net.LookupSRV("", "", b.Name)
+		if err != nil {
+			return nil, errors.Newf(errors.Transient, "[dns-balancer] Error resolving SRV record %s: %s", b.Name, err)
+		}
+		for _, r := range records {
+			host := strings.TrimSuffix(r.Target, ".")
+			backends = append(backends, fmt.Sprintf("%s://%s", b.Scheme, net.JoinHostPort(host, strconv.Itoa(int(r.Port)))))
+		}
+	} else {
+		addrs, err := net.LookupHost(b.Name)
+		if err != nil {
+			return nil, errors.Newf(errors.Transient, "[dns-balancer] Error resolving %s: %s", b.Name, err)
+		}
+		for _, addr := range addrs {
+			backends = append(backends, fmt.Sprintf("%s://%s", b.Scheme, net.JoinHostPort(addr, strconv.Itoa(b.Port))))
+		}
+	}
+	if len(backends) == 0 {
+		return nil, errors.Newf(errors.Transient, "[dns-balancer] %s resolves to no address", b.Name)
+	}
+	return backends, nil
+}
+
+// IsBackendFailure returns true if err denotes a failing backend (a connection error or a 5xx), as
+// opposed to an error caused by the request itself
+func IsBackendFailure(err error) bool {
+	var statusErr *StatusError
+	if stderrors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return errors.KindOf(err) == errors.Transient
+}
+
+// FailoverBalancer sends requests to the first of several replicas of an API and sticks to it until
+// it fails (with a connection error or a 5xx): requests then go to the next replica, and stick to
+// it in turn.
+type FailoverBalancer struct {
+	BaseURLs []string
+
+	lock    sync.Mutex
+	current int
+}
+
+// NewFailoverBalancer creates a balancer failing over between the replicas of an API
+func NewFailoverBalancer(baseURLs ...string) *FailoverBalancer {
+	return &FailoverBalancer{BaseURLs: baseURLs}
+}
+
+// Pick returns the replica requests currently stick to
+func (b *FailoverBalancer) Pick() (string, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.BaseURLs) == 0 {
+		return "", errors.Newf(errors.Permanent, "[failover-balancer] No base URL to fail over between")
+	}
+	return b.BaseURLs[b.current%len(b.BaseURLs)], nil
+}
+
+// Report switches to the next replica if the current one failed
+func (b *FailoverBalancer) Report(baseURL string, err error) {
+	if err == nil || !IsBackendFailure(err) {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	// Concurrent requests failing against the same replica only switch once
+	if len(b.BaseURLs) > 0 && b.BaseURLs[b.current%len(b.BaseURLs)] == baseURL {
+		b.current = (b.current + 1) % len(b.BaseURLs)
+	}
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package httpclient
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DefaultCacheEntries is the number of responses a MemoryCache created with NewMemoryCache(0) holds
+const DefaultCacheEntries = 1024
+
+// CachedResponse is a response stored in a Cache, along with its ETag
+type CachedResponse struct {
+	ETag string
+	// Vary holds the values of the request headers the response varies with (see its Vary header):
+	// it only answers the requests sending the same ones
+	Vary          http.Header
+	StatusCode    int
+	Header        http.Header
+	ContentLength int64
+	Body          []byte
+}
+
+// Cache stores the responses of cacheable requests, keyed by method, URL, scope and accepted content
+// types (the codec negotiated for the response). Its entries are revalidated with an If-None-Match
+// header: unchanged resources cost a 304 instead of a full transfer.
+type Cache interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, resp *CachedResponse)
+}
+
+// MemoryCache is an in-memory Cache evicting the least recently used responses beyond MaxEntries
+type MemoryCache struct {
+	MaxEntries int
+
+	lock    sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key  string
+	resp *CachedResponse
+}
+
+// NewMemoryCache creates an in-memory cache of up to maxEntries responses (DefaultCacheEntries if
+// not positive)
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheEntries
+	}
+	return &MemoryCache{MaxEntries: maxEntries, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// Get returns the response cached under key
+func (c *MemoryCache) Get(key string) (*CachedResponse, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*memoryCacheEntry).resp, true
+}
+
+// Set caches a response under key
+func (c *MemoryCache) Set(key string, resp *CachedResponse) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*memoryCacheEntry).resp = resp
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&memoryCacheEntry{key: key, resp: resp})
+	for c.order.Len() > c.MaxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+// cached returns the cache key of a request and its cached response, if any (and if it answers
+// this request, see CachedResponse.Vary). The key is empty if the request isn't cacheable.
+func (c *Client) cached(r *Request, req *http.Request) (string, *CachedResponse) {
+	if c.Cache == nil || !r.Cacheable || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return "", nil
+	}
+	key := fmt.Sprintf("%s %s %s/%s %s", r.Method, c.URL(r.Route), c.Scope.Org, c.Scope.Project, req.Header.Get("Accept"))
+	cached, ok := c.Cache.Get(key)
+	if !ok || !cached.matches(req) {
+		return key, nil
+	}
+	return key, cached
+}
+
+// cache stores a successful response carrying an ETag, handing back a response whose body can
+// still be read. Responses varying with any request header (Vary: *) aren't cached.
+func (c *Client) cache(key string, req *http.Request, resp *http.Response) (*http.Response, error) {
+	etag := resp.Header.Get("ETag")
+	if key == "" || etag == "" {
+		return resp, nil
+	}
+	vary := http.Header{}
+	for _, value := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return resp, nil
+			}
+			if name != "" {
+				vary[http.CanonicalHeaderKey(name)] = req.Header.Values(name)
+			}
+		}
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	c.Cache.Set(key, &CachedResponse{
+		ETag:          etag,
+		Vary:          vary,
+		StatusCode:    resp.StatusCode,
+		Header:        resp.Header,
+		ContentLength: resp.ContentLength,
+		Body:          body,
+	})
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// matches tells whether a request sends the header values the response varies with
+func (r *CachedResponse) matches(req *http.Request) bool {
+	for name, values := range r.Vary {
+		if strings.Join(req.Header.Values(name), ",") != strings.Join(values, ",") {
+			return false
+		}
+	}
+	return true
+}
+
+// response rebuilds the response to a request from its cached version
+func (r *CachedResponse) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Header:        r.Header,
+		ContentLength: r.ContentLength,
+		Body:          ioutil.NopCloser(bytes.NewReader(r.Body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package httpclient
+
+import (
+	"compress/gzip"
+	stderrors "errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/bufpool"
+)
+
+// Content encodings of compressed request bodies
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// Compression configures how request bodies are compressed when the features.Compression flag is
+// enabled (gzip at its default level if nil).
+//
+// If the API rejects zstd encoded bodies (415 Unsupported Media Type about the encoding, see
+// encodingRejected), the request is sent again gzipped, and so are the following requests.
+type Compression struct {
+	// Encoding is EncodingGzip or EncodingZstd (EncodingGzip if empty)
+	Encoding string
+	// Level is the compression level: 1 (fastest) to 9 (best) for gzip, 1 (fastest) to 4 (best)
+	// for zstd (see zstd.EncoderLevel). The encoding's default level is used if 0.
+	Level int
+
+	lock     sync.Mutex
+	rejected bool
+}
+
+// encoding returns the encoding the next request bodies are compressed with
+func (c *Compression) encoding() string {
+	if c == nil {
+		return EncodingGzip
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.Encoding == "" || c.rejected {
+		return EncodingGzip
+	}
+	return c.Encoding
+}
+
+func (c *Compression) level() int {
+	if c == nil {
+		return 0
+	}
+	return c.Level
+}
+
+// encodingRejected tells whether err is the API rejecting the Content-Encoding of a request body:
+// a 415 Unsupported Media Type listing the encodings the API accepts (Accept-Encoding header, see
+// RFC 7694) or whose message is about the encoding. APIs also answer 415 to payloads of an
+// unsupported Content-Type (see Client.Codec), which compression has nothing to do with.
+func encodingRejected(err error) bool {
+	var statusErr *StatusError
+	if !stderrors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnsupportedMediaType {
+		return false
+	}
+	return statusErr.AcceptEncoding != "" || strings.Contains(strings.ToLower(statusErr.Message), "encoding")
+}
+
+// fallBack switches to gzip if err is the API rejecting the encoding of a request body, returning
+// true if the request is worth sending again
+func (c *Compression) fallBack(err error) bool {
+	if !encodingRejected(err) || c.encoding() == EncodingGzip {
+		return false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.rejected = true
+	return true
+}
+
+// compressedBody is a request body compressed on the fly by a goroutine (see compressStream)
+type compressedBody struct {
+	*io.PipeReader
+	done chan struct{}
+}
+
+// compressStream compresses a request body on the fly (its compressed size isn't known beforehand,
+// so the request is sent with chunked transfer encoding). The compressing goroutine stops reading
+// body once the returned body is closed.
+func compressStream(body io.Reader, encoding string, level int) *compressedBody {
+	pr, pw := io.Pipe()
+	compressed := &compressedBody{PipeReader: pr, done: make(chan struct{})}
+	go func() {
+		defer close(compressed.done)
+		zw, err := newCompressor(pw, encoding, level)
+		if err == nil {
+			_, err = bufpool.Copy(zw, body)
+		}
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return compressed
+}
+
+// Close stops the compression and waits for the compressing goroutine to be done with the original
+// body, which may then be rewound. It may be called several times, and on a nil body.
+func (b *compressedBody) Close() error {
+	if b == nil {
+		return nil
+	}
+	b.PipeReader.Close()
+	<-b.done
+	return nil
+}
+
+func newCompressor(w io.Writer, encoding string, level int) (io.WriteCloser, error) {
+	if encoding == EncodingZstd {
+		if level == 0 {
+			return zstd.NewWriter(w)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevel(level)))
+	}
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return gzip.NewWriterLevel(w, level)
+	}
+	pool := &gzipWriters[level-gzip.HuffmanOnly]
+	if zw, ok := pool.Get().(*gzip.Writer); ok {
+		zw.Reset(w)
+		return &pooledGzipWriter{Writer: zw, pool: pool}, nil
+	}
+	zw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledGzipWriter{Writer: zw, pool: pool}, nil
+}
+
+// gzipWriters recycles gzip writers (and their sizeable compression state), one pool per level
+var gzipWriters [gzip.BestCompression - gzip.HuffmanOnly + 1]sync.Pool
+
+// pooledGzipWriter hands its gzip writer back to its pool once closed
+type pooledGzipWriter struct {
+	*gzip.Writer
+	pool *sync.Pool
+}
+
+func (zw *pooledGzipWriter) Close() error {
+	err := zw.Writer.Close()
+	zw.pool.Put(zw.Writer)
+	return err
+}
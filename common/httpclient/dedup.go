@@ -0,0 +1,166 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package httpclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+)
+
+// DeduplicatedHeader is set on the responses synthesized for suppressed duplicate requests
+const DeduplicatedHeader = "X-Morpheo-Deduplicated"
+
+// DedupWindow suppresses the requests identical (same method, URL, credentials, scope and body) to
+// a request that succeeded less than Interval ago, so that overlapping retry layers (broker
+// redelivery on top of HTTP retries...) don't send the same update over and over again.
+//
+// Only requests changing state (not GET nor HEAD) whose body can be read without being consumed (nil,
+// *bytes.Reader, *bytes.Buffer or *strings.Reader, as sent by PostJSON) are deduplicated.
+// Suppressed requests get an empty response with the first expected status code: the window is
+// meant for requests whose response bodies are ignored.
+type DedupWindow struct {
+	Interval time.Duration
+	Clock    clock.Clock
+
+	lock sync.Mutex
+	sent map[string]time.Time
+}
+
+// NewDedupWindow creates a deduplication window of a given interval
+func NewDedupWindow(interval time.Duration) *DedupWindow {
+	return &DedupWindow{Interval: interval}
+}
+
+// seen returns true if a request with this key succeeded within the window, forgetting the requests
+// that fell out of it
+func (w *DedupWindow) seen(key string) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	now := clock.OrReal(w.Clock).Now()
+	for k, sentAt := range w.sent {
+		if now.Sub(sentAt) >= w.Interval {
+			delete(w.sent, k)
+		}
+	}
+	_, ok := w.sent[key]
+	return ok
+}
+
+// record records the success of a request
+func (w *DedupWindow) record(key string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.sent == nil {
+		w.sent = map[string]time.Time{}
+	}
+	w.sent[key] = clock.OrReal(w.Clock).Now()
+}
+
+// dedupHeaders are the headers that tell requests apart in deduplication keys: the same update
+// sent with other credentials or on behalf of another tenant isn't a duplicate
+var dedupHeaders = []string{"Authorization", OrgHeader, ProjectHeader}
+
+// dedupKey hashes the method, URL, credentials, scope and body of a request. It returns false if the
+// request can't be deduplicated.
+func (c *Client) dedupKey(r *Request) (string, bool) {
+	if c.Dedup == nil || c.Dedup.Interval <= 0 || r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return "", false
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, c.URL(r.Route))
+	header := http.Header{}
+	c.setHeaders(header, r)
+	for _, key := range dedupHeaders {
+		fmt.Fprintf(h, "%s: %q\n", key, header.Values(key))
+	}
+	// Credentials added when the request is built (see newRequest)
+	fmt.Fprintf(h, "%q %q %q\n", c.User, c.Password, r.Scopes)
+	switch body := r.Body.(type) {
+	case nil:
+	case *bytes.Buffer:
+		h.Write(body.Bytes())
+	case *bytes.Reader:
+		if !hashSeeker(h, body) {
+			return "", false
+		}
+	case *strings.Reader:
+		if !hashSeeker(h, body) {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// hashSeeker hashes what remains of a body and rewinds it
+func hashSeeker(w io.Writer, body io.ReadSeeker) bool {
+	pos, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false
+	}
+	_, err = io.Copy(w, body)
+	if _, seekErr := body.Seek(pos, io.SeekStart); err != nil || seekErr != nil {
+		return false
+	}
+	return true
+}
+
+// deduplicatedResponse synthesizes the response to a suppressed request
+func deduplicatedResponse(r *Request, url string) *http.Response {
+	status := http.StatusOK
+	if len(r.ExpectedStatus) > 0 {
+		status = r.ExpectedStatus[0]
+	}
+	req, _ := http.NewRequest(r.Method, url, nil)
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Header:     http.Header{DeduplicatedHeader: []string{"true"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		Request:    req,
+	}
+}
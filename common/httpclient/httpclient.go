@@ -0,0 +1,555 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package httpclient holds the request building and executing logic shared by the Morpheo HTTP API
+// clients (storage, compute...): URL building, authentication, header injection, status code
+// checks, error decoding and response body draining are implemented once, here.
+//
+// Note that this package must not import the common package, so that implementations living in
+// common can use it.
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/codec"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/features"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/logging"
+)
+
+// DefaultMaxResponseSize bounds the size of the responses DoJSON decodes when the client sets no
+// other bound (the same as common.DefaultMaxPayloadSize)
+const DefaultMaxResponseSize = 64 << 20
+
+// maxDrainedBytes is the maximum number of bytes read from a response body we aren't interested in
+// before closing it (so that the underlying connection can be reused)
+const maxDrainedBytes = 64 << 10
+
+// RequestEditor modifies a request before it is sent (to add headers for instance)
+type RequestEditor func(req *http.Request) error
+
+// TokenSource provides the bearer tokens authenticating requests: a token granting (at least) the
+// scopes a request requires (see Request.Scopes and the auth package)
+type TokenSource interface {
+	Token(scopes []string) (string, error)
+}
+
+// Client performs requests against a Morpheo HTTP API
+type Client struct {
+	// Name identifies the API in logs and error messages (e.g. "storage-api")
+	Name string
+	// BaseURL is the URL routes are relative to (e.g. "http://storage:8081")
+	BaseURL string
+	// User and Password are sent as basic auth credentials if User isn't empty
+	User     string
+	Password string
+	// APIVersion is sent in the VersionHeader of every request. Responses advertising an
+	// incompatible version are turned into a *VersionError. Version checks are disabled if empty.
+	APIVersion string
+
+	// HTTPClient performs the requests. If nil, a client using the shared transport is used (see
+	// SharedTransport).
+	HTTPClient *http.Client
+	Logger     logging.Logger
+	// Features toggles optional behaviors (features.Compression compresses request bodies)
+	Features *features.Set
+	// Compression configures the compression of request bodies (gzip if nil)
+	Compression *Compression
+	// RequestEditors are applied, in order, to every request before it is sent
+	RequestEditors []RequestEditor
+	// Retry is the retry policy of failed requests (no retries if nil)
+	Retry *RetryPolicy
+	// Clock is used to wait between retries (the wall clock if nil)
+	Clock clock.Clock
+	// Dedup, if set, suppresses duplicate requests (see DedupWindow)
+	Dedup *DedupWindow
+	// Balancer, if set, picks the base URL of every request (BaseURL then only identifies the API
+	// in logs and deduplication keys)
+	Balancer Balancer
+	// Cache, if set, caches the responses of cacheable requests (see Request.Cacheable)
+	Cache Cache
+	// Scope attributes every request to a tenant (see WithScope for per-call overrides)
+	Scope Scope
+	// Headers are sent with every request (unless the request sets them), e.g. correlation IDs or
+	// debug flags (see WithHeaders for per-call headers)
+	Headers http.Header
+	// Codec encodes the payloads sent by PostJSON and is preferred for responses (JSON if nil).
+	// Servers answering 415 Unsupported Media Type (to the content type, not to the encoding of the
+	// payload, see Compression) are sent JSON instead.
+	Codec codec.Codec
+	// MaxResponseSize bounds the size of the responses decoded by DoJSON (DefaultMaxResponseSize
+	// if zero or less)
+	MaxResponseSize int64
+	// Tokens, if set, authenticates every request with a bearer token granting its scopes
+	Tokens TokenSource
+
+	// ctx bounds the requests that don't carry their own context (see WithContext)
+	ctx context.Context
+}
+
+// New creates a client for the API living under baseURL
+func New(name, baseURL string) *Client {
+	retry := DefaultRetryPolicy
+	return &Client{
+		Name:       name,
+		BaseURL:    baseURL,
+		APIVersion: APIVersion,
+		Retry:      &retry,
+	}
+}
+
+// Request describes a request to perform against the API
+type Request struct {
+	Method string
+	// Route is the path of the resource, relative to the client's BaseURL. It may include a query
+	// string.
+	Route string
+	Body  io.Reader
+	// ContentLength is the size of Body, if known and if Body isn't a bytes.Buffer, bytes.Reader or
+	// strings.Reader (in which case it is computed automatically)
+	ContentLength int64
+	Header        http.Header
+	// ExpectedStatus lists the status codes denoting a success (http.StatusOK if empty)
+	ExpectedStatus []int
+	// Cacheable GET and HEAD requests are cached by the client's Cache (if any), and revalidated
+	// with their ETag. Their response bodies are read in memory.
+	Cacheable bool
+	// Scopes lists the permissions the request requires (e.g. "blob:write"), for the client's
+	// Tokens to pick a token granting them
+	Scopes []string
+	// Context, if set, bounds the request: once it is done, the request (or the reading of its
+	// response body) is interrupted and isn't retried. The client's context is used if nil (see
+	// WithContext).
+	Context context.Context
+}
+
+// WithContext returns a copy of the client whose requests are bounded by ctx (unless they carry
+// their own context, see Request.Context), e.g. to bound every request made for an uplet by its
+// deadline. The copy shares the client's connections, caches and balancer.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	withContext := *c
+	withContext.ctx = ctx
+	return &withContext
+}
+
+// context returns the context bounding a request
+func (c *Client) context(r *Request) context.Context {
+	switch {
+	case r.Context != nil:
+		return r.Context
+	case c.ctx != nil:
+		return c.ctx
+	default:
+		return context.Background()
+	}
+}
+
+// StatusError is returned when the API answers with an unexpected status code
+type StatusError struct {
+	API        string
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	// Message is the error message sent by the API, if any
+	Message string
+	// Fields lists the invalid fields of a rejected payload, if the API sent them
+	Fields []FieldError
+	// RetryAfter is the delay the API asked to wait for before retrying (Retry-After header), if any
+	RetryAfter time.Duration
+	// AcceptEncoding lists the content encodings the API accepts for request bodies, if it rejected
+	// the one of the request (Accept-Encoding header of 415 responses, see RFC 7694)
+	AcceptEncoding string
+}
+
+// FieldError describes why a field of a payload was rejected by the API
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Kind classifies the error according to its status code
+func (e *StatusError) Kind() errors.Kind {
+	return errors.FromHTTPStatus(e.StatusCode)
+}
+
+// Is makes errors.Is(err, errors.ErrNotFound) (and alike) work on status errors
+func (e *StatusError) Is(target error) bool {
+	return errors.MatchKind(e.Kind(), target)
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("[%s] Bad status code (%s) performing %s request against %s", e.API, e.Status, e.Method, e.URL)
+	if e.Message != "" {
+		msg += " -- API Error: " + e.Message
+	}
+	if len(e.Fields) > 0 && e.Message == "" {
+		fields := make([]string, len(e.Fields))
+		for i, f := range e.Fields {
+			fields[i] = fmt.Sprintf("%s: %s", f.Field, f.Message)
+		}
+		msg += " -- Invalid fields: " + strings.Join(fields, ", ")
+	}
+	return msg
+}
+
+// URL returns the absolute URL of a route (the BaseURL itself if route is empty)
+func (c *Client) URL(route string) string {
+	return joinURL(c.BaseURL, route)
+}
+
+func joinURL(baseURL, route string) string {
+	if route == "" {
+		return baseURL
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/" + strings.TrimPrefix(route, "/")
+}
+
+// NewRequest builds an *http.Request, authenticated and edited by the client's RequestEditors
+func (c *Client) NewRequest(r *Request) (*http.Request, error) {
+	req, _, err := c.newRequest(r, c.BaseURL)
+	return req, err
+}
+
+// newRequest builds an *http.Request. If its body is compressed, the compressed body is returned
+// too: closing it (which is done here if the request can't be built) stops the compression.
+func (c *Client) newRequest(r *Request, baseURL string) (req *http.Request, compressed *compressedBody, err error) {
+	url := joinURL(baseURL, r.Route)
+	body, encoding := r.Body, ""
+	if body != nil && c.Features.Enabled(features.Compression) {
+		encoding = c.Compression.encoding()
+		compressed = compressStream(body, encoding, c.Compression.level())
+		body = compressed
+	}
+	defer func() {
+		if err != nil {
+			compressed.Close()
+			compressed = nil
+		}
+	}()
+	req, err = http.NewRequestWithContext(c.context(r), r.Method, url, body)
+	if err != nil {
+		return nil, nil, errors.Newf(errors.Permanent, "[%s] Error building %s request against %s: %s", c.Name, r.Method, url, err)
+	}
+	if r.ContentLength > 0 && encoding == "" {
+		req.ContentLength = r.ContentLength
+	}
+	c.setHeaders(req.Header, r)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	if h, ok := c.Balancer.(interface{ Host() string }); ok && h.Host() != "" {
+		req.Host = h.Host()
+	}
+	if c.APIVersion != "" {
+		req.Header.Set(VersionHeader, c.APIVersion)
+	}
+	if c.User != "" {
+		req.SetBasicAuth(c.User, c.Password)
+	}
+	if c.Tokens != nil {
+		token, err := c.Tokens.Token(r.Scopes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("[%s] Error building %s request against %s: %w", c.Name, r.Method, url, err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for _, edit := range c.RequestEditors {
+		if err := edit(req); err != nil {
+			return nil, nil, fmt.Errorf("[%s] Error building %s request against %s: %w", c.Name, r.Method, url, err)
+		}
+	}
+	return req, compressed, nil
+}
+
+// setHeaders sets the headers of a request: its own, the client's (unless the request sets them)
+// and the scope headers
+func (c *Client) setHeaders(h http.Header, r *Request) {
+	for key, values := range r.Header {
+		for _, value := range values {
+			h.Add(key, value)
+		}
+	}
+	for key, values := range c.Headers {
+		if _, ok := r.Header[http.CanonicalHeaderKey(key)]; ok {
+			continue
+		}
+		for _, value := range values {
+			h.Add(key, value)
+		}
+	}
+	c.Scope.SetHeaders(h)
+}
+
+// Do performs a request and returns the response if its status code is expected (it is up to the
+// caller to close its body then). Otherwise, the response body is decoded as an API error, drained
+// and closed, and a *StatusError is returned (or a *VersionError if the server advertises an
+// incompatible API version, which is likely the actual cause of the failure).
+//
+// Failed requests are retried according to the client's retry policy, honoring the Retry-After
+// header of 429 and 503 responses.
+func (c *Client) Do(r *Request) (*http.Response, error) {
+	dedupKey, dedup := c.dedupKey(r)
+	if dedup && c.Dedup.seen(dedupKey) {
+		c.logger(r.Route).Debugf("Suppressing duplicate %s request against %s", r.Method, c.URL(r.Route))
+		return deduplicatedResponse(r, c.URL(r.Route)), nil
+	}
+
+	seeker, rewindable := r.Body.(io.Seeker)
+	if r.Body == nil {
+		rewindable = true
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := c.do(r)
+		if err == nil && dedup {
+			c.Dedup.record(dedupKey)
+		}
+		if err == nil || !rewindable {
+			return resp, err
+		}
+		compressed := r.Body != nil && c.Features.Enabled(features.Compression)
+		if compressed && c.Compression.fallBack(err) {
+			c.logger(r.Route).Warnf("%s rejected the encoding of the request body, falling back to gzip: %s", c.Name, err)
+			attempt--
+		} else if c.context(r).Err() == nil && c.Retry.ShouldRetry(r.Method, attempt, err) {
+			delay := c.Retry.Delay(attempt, err)
+			c.logger(r.Route).Warnf("Attempt %d/%d failed, retrying in %s: %s", attempt, c.Retry.MaxAttempts, delay, err)
+			select {
+			case <-clock.OrReal(c.Clock).After(delay):
+			case <-c.context(r).Done():
+				return nil, errors.Newf(errors.Permanent, "[%s] Giving up retrying %s request against %s: %s", c.Name, r.Method, c.URL(r.Route), c.context(r).Err())
+			}
+		} else {
+			return resp, err
+		}
+		if seeker != nil {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return nil, errors.Newf(errors.Permanent, "[%s] Error rewinding request body to retry: %s", c.Name, err)
+			}
+		}
+	}
+}
+
+func (c *Client) do(r *Request) (*http.Response, error) {
+	if c.Balancer == nil {
+		return c.doAt(r, c.BaseURL)
+	}
+	baseURL, err := c.Balancer.Pick()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.doAt(r, baseURL)
+	c.Balancer.Report(baseURL, err)
+	return resp, err
+}
+
+func (c *Client) doAt(r *Request, baseURL string) (resp *http.Response, err error) {
+	req, compressed, err := c.newRequest(r, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	// The transport may still be reading a failed request's body: the compression has to stop
+	// before the body is rewound to be sent again
+	defer func() {
+		if err != nil {
+			compressed.Close()
+		}
+	}()
+	cacheKey, cached := c.cached(r, req)
+	if cached != nil {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+
+	c.logger(r.Route).Debugf("Performing %s request against %s", req.Method, req.URL)
+	resp, err = c.httpClient().Do(req)
+	if err != nil {
+		return nil, errors.Newf(errors.Transient, "[%s] Error performing %s request against %s: %s", c.Name, req.Method, req.URL, err)
+	}
+
+	if err := c.checkVersion(resp); err != nil {
+		DrainAndClose(resp.Body)
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		DrainAndClose(resp.Body)
+		c.logger(r.Route).Debugf("%s is unchanged, using the cached response", req.URL)
+		return cached.response(req), nil
+	}
+
+	expected := r.ExpectedStatus
+	if len(expected) == 0 {
+		expected = []int{http.StatusOK}
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return c.cache(cacheKey, req, resp)
+		}
+	}
+
+	defer DrainAndClose(resp.Body)
+	statusErr := &StatusError{
+		API:        c.Name,
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+	}
+	statusErr.Message, statusErr.Fields = decodeError(resp.Body)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		statusErr.RetryAfter = ParseRetryAfter(resp.Header.Get("Retry-After"), clock.OrReal(c.Clock).Now())
+	}
+	if resp.StatusCode == http.StatusUnsupportedMediaType {
+		statusErr.AcceptEncoding = resp.Header.Get("Accept-Encoding")
+	}
+	return nil, statusErr
+}
+
+// DoJSON performs a request and decodes the response body into dest (unless dest is nil), with
+// the codec of its Content-Type (JSON if it has none or an unknown one)
+func (c *Client) DoJSON(r *Request, dest interface{}) error {
+	if dest != nil && r.Header.Get("Accept") == "" {
+		if r.Header == nil {
+			r.Header = http.Header{}
+		}
+		r.Header.Set("Accept", codec.Accept(c.Codec))
+	}
+	resp, err := c.Do(r)
+	if err != nil {
+		return err
+	}
+	defer DrainAndClose(resp.Body)
+
+	if dest == nil {
+		return nil
+	}
+	decoder, ok := codec.ForContentType(resp.Header.Get("Content-Type"))
+	if !ok {
+		decoder = codec.JSON
+	}
+	limit := c.MaxResponseSize
+	if limit <= 0 {
+		limit = DefaultMaxResponseSize
+	}
+	// One byte more than the limit is read, to tell responses of exactly limit bytes from larger ones
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err == nil && int64(len(data)) > limit {
+		return errors.Newf(errors.Permanent, "[%s] Response from %s is larger than %d bytes", c.Name, resp.Request.URL, limit)
+	}
+	if err == nil {
+		err = decoder.Unmarshal(data, dest)
+	}
+	if err != nil {
+		return errors.Newf(errors.Permanent, "[%s] Error unmarshaling object retrieved from %s: %s", c.Name, resp.Request.URL, err)
+	}
+	return nil
+}
+
+// PostJSON sends a resource to a given route, encoded with the client's codec (JSON by default)
+func (c *Client) PostJSON(route string, resource interface{}, expectedStatus ...int) error {
+	return c.PostJSONScoped(route, resource, nil, expectedStatus...)
+}
+
+// PostJSONScoped is PostJSON for routes requiring scopes (see Request.Scopes)
+func (c *Client) PostJSONScoped(route string, resource interface{}, scopes []string, expectedStatus ...int) error {
+	encoder := codec.OrJSON(c.Codec)
+	err := c.post(encoder, route, resource, scopes, expectedStatus)
+	if statusErr, ok := err.(*StatusError); ok && statusErr.StatusCode == http.StatusUnsupportedMediaType && !encodingRejected(err) && encoder != codec.JSON {
+		c.logger(route).Warnf("%s doesn't support %s payloads, falling back to JSON", c.Name, encoder.ContentType())
+		err = c.post(codec.JSON, route, resource, scopes, expectedStatus)
+	}
+	return err
+}
+
+func (c *Client) post(encoder codec.Codec, route string, resource interface{}, scopes []string, expectedStatus []int) error {
+	data, err := encoder.Marshal(resource)
+	if err != nil {
+		return errors.Newf(errors.Permanent, "[%s] Error building POST request against %s: Error marshaling to %s: %s", c.Name, c.URL(route), encoder.ContentType(), err)
+	}
+	return c.DoJSON(&Request{
+		Method:         http.MethodPost,
+		Route:          route,
+		Body:           bytes.NewReader(data),
+		Header:         http.Header{"Content-Type": []string{encoder.ContentType()}},
+		ExpectedStatus: expectedStatus,
+		Scopes:         scopes,
+	}, nil)
+}
+
+// DrainAndClose reads what remains of a response body (up to a limit) and closes it, so that the
+// underlying connection can be reused
+func DrainAndClose(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, io.LimitReader(body, maxDrainedBytes))
+	body.Close()
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return SharedClient()
+	}
+	return c.HTTPClient
+}
+
+func (c *Client) logger(route string) logging.Logger {
+	return logging.OrDefault(c.Logger).With(logging.Fields{logging.FieldComponent: c.Name, logging.FieldRoute: route})
+}
+
+// decodeError extracts the message and invalid fields of an error sent by a Morpheo API
+// ({"error": "...", "fields": [...]}), falling back on the raw body
+func decodeError(body io.Reader) (string, []FieldError) {
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxDrainedBytes))
+	if err != nil || len(data) == 0 {
+		return "", nil
+	}
+	var apiError struct {
+		Message string       `json:"error"`
+		Fields  []FieldError `json:"fields"`
+	}
+	if err := json.Unmarshal(data, &apiError); err == nil && (apiError.Message != "" || len(apiError.Fields) > 0) {
+		return apiError.Message, apiError.Fields
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package httpclient
+
+import (
+	stderrors "errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+)
+
+// DefaultRetryPolicy is the retry policy of the clients created with New
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Second,
+	MaxDelay:    time.Minute,
+}
+
+// RetryPolicy describes how failed requests are retried.
+//
+// Requests rejected with a 429 (Too Many Requests) or with a 503 (Service Unavailable) carrying a
+// Retry-After header weren't processed by the server, so they are always retried. Other transient
+// errors (network errors, 5xx...) are only retried for idempotent methods. Requests whose body
+// can't be rewound (i.e. isn't an io.Seeker) are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a request is sent (1 disables retries)
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubled at each subsequent retry, unless the
+	// server sent a Retry-After header
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between two attempts, Retry-After included
+	MaxDelay time.Duration
+}
+
+// ShouldRetry returns true if a request that failed with err is worth sending again
+func (p *RetryPolicy) ShouldRetry(method string, attempt int, err error) bool {
+	if p == nil || attempt >= p.MaxAttempts || !errors.IsRetryable(err) {
+		return false
+	}
+	var statusErr *StatusError
+	if stderrors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusTooManyRequests || statusErr.RetryAfter > 0) {
+		return true
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// Delay returns the delay before the next attempt: the Retry-After delay requested by the server if
+// any, an exponential backoff otherwise
+func (p *RetryPolicy) Delay(attempt int, err error) time.Duration {
+	delay := p.BaseDelay << uint(attempt-1)
+	var statusErr *StatusError
+	if stderrors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		delay = statusErr.RetryAfter
+	}
+	if p.MaxDelay > 0 && (delay > p.MaxDelay || delay < 0) {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// ParseRetryAfter parses the value of a Retry-After header, either a number of seconds or an HTTP
+// date. It returns 0 if the header is empty or malformed.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
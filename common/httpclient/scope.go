@@ -0,0 +1,85 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package httpclient
+
+import "net/http"
+
+// Headers through which requests are attributed to a tenant
+const (
+	OrgHeader     = "X-Morpheo-Org"
+	ProjectHeader = "X-Morpheo-Project"
+)
+
+// Scope identifies the tenant (organization and project) a request is made on behalf of
+type Scope struct {
+	Org     string
+	Project string
+}
+
+// Override returns the scope with the non-empty fields of o replacing its own
+func (s Scope) Override(o Scope) Scope {
+	if o.Org != "" {
+		s.Org = o.Org
+	}
+	if o.Project != "" {
+		s.Project = o.Project
+	}
+	return s
+}
+
+// SetHeaders sets the scope headers (the non-empty ones) of a request
+func (s Scope) SetHeaders(h http.Header) {
+	if s.Org != "" {
+		h.Set(OrgHeader, s.Org)
+	}
+	if s.Project != "" {
+		h.Set(ProjectHeader, s.Project)
+	}
+}
+
+// ScopeFromRequest returns the scope of a received request
+func ScopeFromRequest(r *http.Request) Scope {
+	return Scope{Org: r.Header.Get(OrgHeader), Project: r.Header.Get(ProjectHeader)}
+}
+
+// WithScope returns a copy of the client sending its requests on behalf of another tenant (the
+// non-empty fields of scope override the client's). The copy shares the client's connections,
+// caches and balancer.
+func (c *Client) WithScope(scope Scope) *Client {
+	scoped := *c
+	scoped.Scope = c.Scope.Override(scope)
+	return &scoped
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package httpclient
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TransportConfig tunes the connection pooling of HTTP clients. Zero fields keep the values of
+// DefaultTransportConfig.
+type TransportConfig struct {
+	// MaxIdleConns bounds the idle connections kept across all hosts
+	MaxIdleConns int
+	// MaxIdleConnsPerHost bounds the idle connections kept per host (Go defaults to 2, which
+	// makes busy workers open and close connections to storage all the time)
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost bounds the connections (idle or not) per host (unbounded if zero)
+	MaxConnsPerHost int
+	IdleConnTimeout time.Duration
+
+	DialTimeout time.Duration
+	// KeepAlive is the interval between TCP keep-alive probes
+	KeepAlive             time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ExpectContinueTimeout time.Duration
+	// ResponseHeaderTimeout bounds the wait for response headers (unbounded if zero: uploads of
+	// large blobs may take a while to be acknowledged)
+	ResponseHeaderTimeout time.Duration
+
+	// DisableHTTP2 sticks to HTTP/1.1 (HTTP/2 is negotiated with TLS servers supporting it
+	// otherwise)
+	DisableHTTP2 bool
+}
+
+// DefaultTransportConfig is the configuration of the shared transport, tuned for workers
+// exchanging many large blobs with a handful of hosts
+var DefaultTransportConfig = TransportConfig{
+	MaxIdleConns:          256,
+	MaxIdleConnsPerHost:   64,
+	IdleConnTimeout:       90 * time.Second,
+	DialTimeout:           10 * time.Second,
+	KeepAlive:             30 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// withDefaults fills the zero fields of c with the default ones
+func (c TransportConfig) withDefaults() TransportConfig {
+	d := DefaultTransportConfig
+	if c.MaxIdleConns > 0 {
+		d.MaxIdleConns = c.MaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost > 0 {
+		d.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	}
+	if c.MaxConnsPerHost > 0 {
+		d.MaxConnsPerHost = c.MaxConnsPerHost
+	}
+	if c.IdleConnTimeout > 0 {
+		d.IdleConnTimeout = c.IdleConnTimeout
+	}
+	if c.DialTimeout > 0 {
+		d.DialTimeout = c.DialTimeout
+	}
+	if c.KeepAlive > 0 {
+		d.KeepAlive = c.KeepAlive
+	}
+	if c.TLSHandshakeTimeout > 0 {
+		d.TLSHandshakeTimeout = c.TLSHandshakeTimeout
+	}
+	if c.ExpectContinueTimeout > 0 {
+		d.ExpectContinueTimeout = c.ExpectContinueTimeout
+	}
+	if c.ResponseHeaderTimeout > 0 {
+		d.ResponseHeaderTimeout = c.ResponseHeaderTimeout
+	}
+	d.DisableHTTP2 = c.DisableHTTP2
+	return d
+}
+
+// NewTransport builds a transport from the configuration, using a TLS configuration if not nil
+func (c TransportConfig) NewTransport(tlsConfig *tls.Config) *http.Transport {
+	c = c.withDefaults()
+	dialer := &net.Dialer{Timeout: c.DialTimeout, KeepAlive: c.KeepAlive}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          c.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       c.MaxConnsPerHost,
+		IdleConnTimeout:       c.IdleConnTimeout,
+		TLSHandshakeTimeout:   c.TLSHandshakeTimeout,
+		ExpectContinueTimeout: c.ExpectContinueTimeout,
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     !c.DisableHTTP2,
+	}
+	if c.DisableHTTP2 {
+		// A non-nil, empty map disables HTTP/2 (see the net/http documentation)
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+var (
+	sharedTransport     *http.Transport
+	sharedTransportOnce sync.Once
+)
+
+// SharedTransport returns the transport shared by the HTTP clients of the process (built from
+// DefaultTransportConfig on first use), so that they pool their connections
+func SharedTransport() *http.Transport {
+	sharedTransportOnce.Do(func() {
+		sharedTransport = DefaultTransportConfig.NewTransport(nil)
+	})
+	return sharedTransport
+}
+
+// SharedClient returns an HTTP client using the shared transport
+func SharedClient() *http.Client {
+	return &http.Client{Transport: SharedTransport()}
+}
+
+// NewHTTPClient returns an HTTP client with its own transport if it needs one (a configuration or a
+// TLS configuration is given), or using the shared transport otherwise
+func NewHTTPClient(cfg *TransportConfig, tlsConfig *tls.Config) *http.Client {
+	if cfg == nil && tlsConfig == nil {
+		return SharedClient()
+	}
+	if cfg == nil {
+		cfg = &DefaultTransportConfig
+	}
+	return &http.Client{Transport: cfg.NewTransport(tlsConfig)}
+}
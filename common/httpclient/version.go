@@ -0,0 +1,129 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package httpclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+)
+
+// VersionHeader is the header through which clients and servers advertise the version of the
+// Morpheo API they speak
+const VersionHeader = "X-Morpheo-API-Version"
+
+// APIVersion is the version of the Morpheo API spoken by this package's clients. Its major number
+// is bumped on breaking changes: two versions are compatible if they share the same major number.
+const APIVersion = "1.0"
+
+// VersionError is returned when the server advertises an API version incompatible with the
+// client's
+type VersionError struct {
+	API           string
+	URL           string
+	ClientVersion string
+	ServerVersion string
+}
+
+// Kind returns errors.Permanent: retrying won't help until either side is upgraded
+func (e *VersionError) Kind() errors.Kind {
+	return errors.Permanent
+}
+
+// Is makes errors.Is(err, errors.ErrPermanent) work on version errors
+func (e *VersionError) Is(target error) bool {
+	return errors.MatchKind(e.Kind(), target)
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("[%s] API version mismatch with %s: client speaks version %s, server speaks version %s", e.API, e.URL, e.ClientVersion, e.ServerVersion)
+}
+
+// CompatibleVersions returns true if two API versions share the same major number. Empty or
+// malformed versions are considered compatible with anything, so that peers that don't advertise
+// their version keep working.
+func CompatibleVersions(a, b string) bool {
+	majorA, okA := majorVersion(a)
+	majorB, okB := majorVersion(b)
+	return !okA || !okB || majorA == majorB
+}
+
+// checkVersion returns a *VersionError if the response comes from a server speaking an
+// incompatible API version
+func (c *Client) checkVersion(resp *http.Response) error {
+	serverVersion := resp.Header.Get(VersionHeader)
+	if c.APIVersion == "" || CompatibleVersions(c.APIVersion, serverVersion) {
+		return nil
+	}
+	return &VersionError{
+		API:           c.Name,
+		URL:           resp.Request.URL.String(),
+		ClientVersion: c.APIVersion,
+		ServerVersion: serverVersion,
+	}
+}
+
+// VersionMiddleware advertises the API version a server speaks on every response, and rejects
+// requests from clients speaking an incompatible version with a 400 and an explicit error message.
+// Requests without a version header are let through.
+func VersionMiddleware(version string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(VersionHeader, version)
+		clientVersion := r.Header.Get(VersionHeader)
+		if !CompatibleVersions(version, clientVersion) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": fmt.Sprintf("API version mismatch: client speaks version %s, server speaks version %s", clientVersion, version),
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func majorVersion(version string) (int, bool) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if version == "" {
+		return 0, false
+	}
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	return major, err == nil
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package common
+
+import (
+	"fmt"
+
+	"github.com/satori/go.uuid"
+)
+
+// ModelLineage records where a model comes from, for reproducibility audits: the model it was
+// trained from, by which learnuplet, with which algo and data
+type ModelLineage struct {
+	Model uuid.UUID `json:"model" yaml:"model"`
+	// Parent is the model the training started from (uuid.Nil for models trained from scratch)
+	Parent     uuid.UUID `json:"parent" yaml:"parent"`
+	Learnuplet string    `json:"learnuplet" yaml:"learnuplet"`
+	Algo       uuid.UUID `json:"algo" yaml:"algo"`
+	// AlgoDigest is the SHA-256 digest of the algo blob
+	AlgoDigest string `json:"algo_digest,omitempty" yaml:"algo_digest,omitempty"`
+	// DataDigests are the SHA-256 digests of the train data fragments, by data UUID
+	DataDigests map[string]string `json:"data_digests,omitempty" yaml:"data_digests,omitempty"`
+	Rank        int               `json:"rank" yaml:"rank"`
+	// Siblings are the models an aggregated model combines (see the client/aggregation package). An
+	// aggregated model has no parent nor learnuplet, and the data digests of all its siblings.
+	Siblings []uuid.UUID `json:"siblings,omitempty" yaml:"siblings,omitempty"`
+}
+
+// IsRoot returns true if the model was trained from scratch
+func (l *ModelLineage) IsRoot() bool {
+	return uuid.Equal(uuid.Nil, l.Parent) && len(l.Siblings) == 0
+}
+
+// Lineage returns the lineage of the model a learnuplet produces (without digests, which the
+// chaincode doesn't hold)
+func (s *LearnupletChaincode) Lineage() (ModelLineage, error) {
+	learnuplet, err := s.LearnupletFormat()
+	if err != nil {
+		return ModelLineage{}, err
+	}
+	return learnuplet.Lineage()
+}
+
+// Lineage returns the lineage of the model the learnuplet produces, without digests (see
+// client.NewModelLineage)
+func (u *Learnuplet) Lineage() (ModelLineage, error) {
+	if uuid.Equal(uuid.Nil, u.ModelEnd) {
+		return ModelLineage{}, fmt.Errorf("learnuplet %s has no model_end", u.Key)
+	}
+	return ModelLineage{
+		Model:      u.ModelEnd,
+		Parent:     u.ModelStart,
+		Learnuplet: u.Key,
+		Algo:       u.Algo,
+		Rank:       u.Rank,
+	}, nil
+}
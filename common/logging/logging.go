@@ -0,0 +1,248 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package logging provides the leveled, structured logger injected into the Morpheo clients, broker
+// consumers, container runtimes and workers. Log lines carry fields (uplet, component, route...)
+// and can be written as plain text or as JSON objects for log aggregation systems.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log line
+type Level int
+
+// Log levels, by increasing severity
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel returns the level matching a name (debug, info, warn or error)
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.ToLower(name) == levelName {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %s (possible choices: debug, info, warn, error)", name)
+}
+
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Well-known field names
+const (
+	FieldComponent = "component"
+	FieldUplet     = "uplet"
+	FieldRoute     = "route"
+	FieldError     = "error"
+)
+
+// Fields are key/value pairs attached to log lines
+type Fields map[string]interface{}
+
+// Logger is a leveled, structured logger
+type Logger interface {
+	// With returns a logger adding the given fields to all the lines it writes
+	With(fields Fields) Logger
+
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// StreamLogger is a Logger writing lines to an io.Writer
+type StreamLogger struct {
+	out    *lockedWriter
+	level  Level
+	format string
+	fields Fields
+}
+
+type lockedWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// New creates a logger writing lines of at least the given level to w, in the given format
+// (FormatText or FormatJSON)
+func New(w io.Writer, level Level, format string) (*StreamLogger, error) {
+	if format != FormatText && format != FormatJSON {
+		return nil, fmt.Errorf("unknown log format %s (possible choices: %s, %s)", format, FormatText, FormatJSON)
+	}
+	return &StreamLogger{
+		out:    &lockedWriter{w: w},
+		level:  level,
+		format: format,
+		fields: Fields{},
+	}, nil
+}
+
+// Default returns a logger writing info (and more severe) lines to stderr, as plain text
+func Default() Logger {
+	return &StreamLogger{
+		out:    &lockedWriter{w: os.Stderr},
+		level:  LevelInfo,
+		format: FormatText,
+		fields: Fields{},
+	}
+}
+
+// OrDefault returns logger, or the Default logger if it is nil. It lets structs have an optional
+// Logger field.
+func OrDefault(logger Logger) Logger {
+	if logger == nil {
+		return Default()
+	}
+	return logger
+}
+
+// With returns a logger adding the given fields to all the lines it writes
+func (l *StreamLogger) With(fields Fields) Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &StreamLogger{out: l.out, level: l.level, format: l.format, fields: merged}
+}
+
+// Debugf writes a debug line
+func (l *StreamLogger) Debugf(format string, args ...interface{}) {
+	l.log(LevelDebug, format, args)
+}
+
+// Infof writes an info line
+func (l *StreamLogger) Infof(format string, args ...interface{}) {
+	l.log(LevelInfo, format, args)
+}
+
+// Warnf writes a warning line
+func (l *StreamLogger) Warnf(format string, args ...interface{}) {
+	l.log(LevelWarn, format, args)
+}
+
+// Errorf writes an error line
+func (l *StreamLogger) Errorf(format string, args ...interface{}) {
+	l.log(LevelError, format, args)
+}
+
+func (l *StreamLogger) log(level Level, format string, args []interface{}) {
+	if level < l.level {
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	msg := fmt.Sprintf(format, args...)
+
+	var line []byte
+	if l.format == FormatJSON {
+		entry := make(map[string]interface{}, len(l.fields)+3)
+		for k, v := range l.fields {
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			entry[k] = v
+		}
+		entry["time"] = now
+		entry["level"] = level.String()
+		entry["msg"] = msg
+		var err error
+		if line, err = json.Marshal(entry); err != nil {
+			line = []byte(fmt.Sprintf(`{"time":%q,"level":"error","msg":"Error marshaling log line: %s"}`, now, err))
+		}
+		line = append(line, '\n')
+	} else {
+		keys := make([]string, 0, len(l.fields))
+		for k := range l.fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		text := fmt.Sprintf("%s [%s] %s", now, strings.ToUpper(level.String()), msg)
+		for _, k := range keys {
+			text += fmt.Sprintf(" %s=%v", k, l.fields[k])
+		}
+		line = []byte(text + "\n")
+	}
+
+	l.out.lock.Lock()
+	defer l.out.lock.Unlock()
+	l.out.w.Write(line)
+}
+
+// NopLogger is a Logger discarding everything
+type NopLogger struct{}
+
+// With returns the NopLogger itself
+func (l NopLogger) With(fields Fields) Logger { return l }
+
+// Debugf does nothing
+func (NopLogger) Debugf(format string, args ...interface{}) {}
+
+// Infof does nothing
+func (NopLogger) Infof(format string, args ...interface{}) {}
+
+// Warnf does nothing
+func (NopLogger) Warnf(format string, args ...interface{}) {}
+
+// Errorf does nothing
+func (NopLogger) Errorf(format string, args ...interface{}) {}
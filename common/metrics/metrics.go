@@ -0,0 +1,240 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package metrics computes the performance metrics of a problem from the predictions of an algo
+// and the ground truth, with computations registered by the deployment (Go functions, Go plugins or
+// sidecar containers) instead of trusting the performance the algo container reports.
+//
+//	registry := metrics.NewRegistry()
+//	err := registry.Register(metrics.Func("accuracy", accuracy))
+//	values, err := registry.ComputeProblem(problem, "/data/pred", "/data/test/truth")
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+)
+
+// Computation computes performance metrics from the prediction outputs of an algo and the ground
+// truth (paths to files or directories)
+type Computation interface {
+	// Metrics returns the names of the metrics the computation computes
+	Metrics() []string
+	Compute(predictions, truth string) (map[string]float64, error)
+}
+
+// funcComputation is a Computation of a single metric by a Go function
+type funcComputation struct {
+	name string
+	fn   func(predictions, truth string) (float64, error)
+}
+
+// Func returns a computation of a single metric by a Go function
+func Func(name string, fn func(predictions, truth string) (float64, error)) Computation {
+	return &funcComputation{name: name, fn: fn}
+}
+
+func (c *funcComputation) Metrics() []string {
+	return []string{c.name}
+}
+
+func (c *funcComputation) Compute(predictions, truth string) (map[string]float64, error) {
+	value, err := c.fn(predictions, truth)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]float64{c.name: value}, nil
+}
+
+// Registry holds the metric computations of a deployment, by metric name
+type Registry struct {
+	lock         sync.RWMutex
+	computations []Computation
+	// byMetric holds the index of the computation of each metric
+	byMetric map[string]int
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{byMetric: map[string]int{}}
+}
+
+// Register adds a computation to the registry. A metric can only be computed by one computation.
+func (r *Registry) Register(computation Computation) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, name := range computation.Metrics() {
+		if _, ok := r.byMetric[name]; ok {
+			return fmt.Errorf("[metrics] Metric %s is already registered", name)
+		}
+	}
+	for _, name := range computation.Metrics() {
+		r.byMetric[name] = len(r.computations)
+	}
+	r.computations = append(r.computations, computation)
+	return nil
+}
+
+// Metrics returns the names of the registered metrics
+func (r *Registry) Metrics() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	var names []string
+	for _, computation := range r.computations {
+		names = append(names, computation.Metrics()...)
+	}
+	return names
+}
+
+// Compute computes metrics, by name. Each computation providing some of them is run once.
+func (r *Registry) Compute(names []string, predictions, truth string) (map[string]float64, error) {
+	r.lock.RLock()
+	needed := map[int]bool{}
+	for _, name := range names {
+		index, ok := r.byMetric[name]
+		if !ok {
+			r.lock.RUnlock()
+			return nil, fmt.Errorf("[metrics] No computation registered for metric %s", name)
+		}
+		needed[index] = true
+	}
+	var computations []Computation
+	for index, computation := range r.computations {
+		if needed[index] {
+			computations = append(computations, computation)
+		}
+	}
+	r.lock.RUnlock()
+
+	values := map[string]float64{}
+	for _, computation := range computations {
+		computed, err := computation.Compute(predictions, truth)
+		if err != nil {
+			return nil, fmt.Errorf("[metrics] Error computing %v: %w", computation.Metrics(), err)
+		}
+		for name, value := range computed {
+			values[name] = value
+		}
+	}
+	for _, name := range names {
+		if _, ok := values[name]; !ok {
+			return nil, fmt.Errorf("[metrics] Metric %s wasn't computed", name)
+		}
+	}
+	return values, nil
+}
+
+// ComputeProblem computes the metrics of a problem, by name. The performance of the algo is the
+// value of the first one (see Perf).
+func (r *Registry) ComputeProblem(problem *common.Problem, predictions, truth string) (map[string]float64, error) {
+	names := make([]string, len(problem.Metrics))
+	for i, metric := range problem.Metrics {
+		names[i] = metric.Name
+	}
+	return r.Compute(names, predictions, truth)
+}
+
+// Perf returns the performance of an algo on a problem: the value of the first metric of the
+// problem
+func Perf(problem *common.Problem, values map[string]float64) (float64, error) {
+	if len(problem.Metrics) == 0 {
+		return 0, fmt.Errorf("[metrics] Problem %s defines no metric", problem.ID)
+	}
+	value, ok := values[problem.Metrics[0].Name]
+	if !ok {
+		return 0, fmt.Errorf("[metrics] Metric %s wasn't computed", problem.Metrics[0].Name)
+	}
+	return value, nil
+}
+
+// Mount points of metric sidecar containers
+const (
+	SidecarPredictionsDir = "/data/predictions"
+	SidecarTruthDir       = "/data/truth"
+	// SidecarOutputDir is where sidecars write metrics.json, a JSON object of metric values by name
+	SidecarOutputDir = "/data/metrics"
+)
+
+// Sidecar computes metrics by running a container: the predictions and the ground truth are
+// mounted (read only by convention) in SidecarPredictionsDir and SidecarTruthDir, and the container
+// writes the metric values to SidecarOutputDir/metrics.json.
+type Sidecar struct {
+	Runtime common.ContainerRuntime
+	// Image is the metric image (already loaded into the runtime)
+	Image string
+	Args  []string
+	// Names are the metrics the sidecar computes
+	Names []string
+	// WorkDir is where metrics.json is written to (the system's temporary directory if empty)
+	WorkDir string
+}
+
+// Metrics returns the names of the metrics the sidecar computes
+func (s *Sidecar) Metrics() []string {
+	return s.Names
+}
+
+// Compute runs the sidecar
+func (s *Sidecar) Compute(predictions, truth string) (map[string]float64, error) {
+	output, err := ioutil.TempDir(s.WorkDir, "metrics-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(output)
+
+	mounts := map[string]string{
+		predictions: SidecarPredictionsDir,
+		truth:       SidecarTruthDir,
+		output:      SidecarOutputDir,
+	}
+	if _, err := s.Runtime.RunImageInUntrustedContainer(s.Image, s.Args, mounts, true); err != nil {
+		return nil, fmt.Errorf("error running metric sidecar %s: %s", s.Image, err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(output, "metrics.json"))
+	if err != nil {
+		return nil, fmt.Errorf("metric sidecar %s wrote no metrics: %s", s.Image, err)
+	}
+	var values map[string]float64
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("metric sidecar %s wrote invalid metrics: %s", s.Image, err)
+	}
+	return values, nil
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package common
+
+import (
+	"sync"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+)
+
+// MockCall is an invocation of a mock method
+type MockCall struct {
+	Method string
+	// ID is the ID of the uplet or resource the call is about, if any
+	ID string
+	// Payload holds the data sent by the caller (JSON-encoded arguments, uploaded blob...)
+	Payload []byte
+	Err     error
+	Time    time.Time
+}
+
+// CallRecorder records the invocations of a mock, for tests to assert on what was sent. It is
+// meant to be embedded in mocks.
+type CallRecorder struct {
+	// Clock timestamps the calls (the wall clock if nil)
+	Clock Clock
+
+	callsLock sync.Mutex
+	calls     []MockCall
+}
+
+// Record records an invocation
+func (r *CallRecorder) Record(method, id string, payload []byte, err error) {
+	r.callsLock.Lock()
+	defer r.callsLock.Unlock()
+	r.calls = append(r.calls, MockCall{
+		Method:  method,
+		ID:      id,
+		Payload: payload,
+		Err:     err,
+		Time:    clock.OrReal(r.Clock).Now(),
+	})
+}
+
+// Calls returns the invocations recorded so far, oldest first
+func (r *CallRecorder) Calls() []MockCall {
+	r.callsLock.Lock()
+	defer r.callsLock.Unlock()
+	return append([]MockCall(nil), r.calls...)
+}
+
+// CallsTo returns the recorded invocations of a method, oldest first
+func (r *CallRecorder) CallsTo(method string) []MockCall {
+	r.callsLock.Lock()
+	defer r.callsLock.Unlock()
+	calls := []MockCall{}
+	for _, c := range r.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// LastCall returns the last recorded invocation of a method about an ID (any ID if empty)
+func (r *CallRecorder) LastCall(method, id string) (MockCall, bool) {
+	r.callsLock.Lock()
+	defer r.callsLock.Unlock()
+	for i := len(r.calls) - 1; i >= 0; i-- {
+		if c := r.calls[i]; c.Method == method && (id == "" || c.ID == id) {
+			return c, true
+		}
+	}
+	return MockCall{}, false
+}
+
+// ResetCalls forgets the recorded invocations
+func (r *CallRecorder) ResetCalls() {
+	r.callsLock.Lock()
+	defer r.callsLock.Unlock()
+	r.calls = nil
+}
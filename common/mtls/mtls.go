@@ -0,0 +1,227 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package mtls builds the TLS configurations used to mutually authenticate Morpheo components:
+// clients present a certificate to the APIs they call, and APIs only accept clients whose
+// certificate is signed by the platform's CA.
+//
+// Certificates are reloaded from disk when their files change, so that rotated certificates are
+// picked up without restarting.
+//
+// Note that this package must not import the common package.
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+)
+
+// DefaultReloadInterval is the minimum interval between two checks of the certificate files
+const DefaultReloadInterval = 30 * time.Second
+
+// Files locates the certificate (and its key) a component presents, and the CA certificates it
+// trusts
+type Files struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// CertReloader serves a certificate, reloading it when its files are modified
+type CertReloader struct {
+	CertFile string
+	KeyFile  string
+	// Interval is the minimum interval between two checks of the files (DefaultReloadInterval if
+	// zero)
+	Interval time.Duration
+	// Clock tells when to check the files again (the wall clock if nil)
+	Clock clock.Clock
+
+	lock      sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewCertReloader loads a certificate and its key
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{CertFile: certFile, KeyFile: keyFile}
+	if _, err := r.Certificate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Certificate returns the current certificate, reloading it first if its files changed since the
+// last check. If reloading fails, the previous certificate is kept.
+func (r *CertReloader) Certificate() (*tls.Certificate, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	interval := r.Interval
+	if interval == 0 {
+		interval = DefaultReloadInterval
+	}
+	now := clock.OrReal(r.Clock).Now()
+	if r.cert != nil && now.Sub(r.checkedAt) < interval {
+		return r.cert, nil
+	}
+	r.checkedAt = now
+
+	modTime, err := r.latestModTime()
+	if err != nil || (r.cert != nil && !modTime.After(r.modTime)) {
+		if r.cert == nil {
+			return nil, fmt.Errorf("Error reading certificate %s: %s", r.CertFile, err)
+		}
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		if r.cert == nil {
+			return nil, fmt.Errorf("Error loading certificate %s (key: %s): %s", r.CertFile, r.KeyFile, err)
+		}
+		return r.cert, nil
+	}
+	r.cert, r.modTime = &cert, modTime
+	return r.cert, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate()
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.Certificate()
+}
+
+func (r *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.CertFile, r.KeyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// ClientConfig returns the TLS configuration of a client presenting its certificate (if any) and
+// verifying servers against the CA (the system pool is used if no CA file is given)
+func ClientConfig(files Files) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if files.CAFile != "" {
+		pool, err := loadCertPool(files.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if files.CertFile != "" {
+		reloader, err := NewCertReloader(files.CertFile, files.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = reloader.GetClientCertificate
+	}
+	return cfg, nil
+}
+
+// ServerConfig returns the TLS configuration of a server presenting its certificate and requiring
+// clients to present a certificate signed by the CA
+func ServerConfig(files Files) (*tls.Config, error) {
+	if files.CertFile == "" || files.KeyFile == "" {
+		return nil, fmt.Errorf("A certificate and its key are required to serve TLS")
+	}
+	if files.CAFile == "" {
+		return nil, fmt.Errorf("A CA certificate is required to verify client certificates")
+	}
+	pool, err := loadCertPool(files.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	reloader, err := NewCertReloader(files.CertFile, files.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		ClientCAs:      pool,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// HTTPClient returns an HTTP client using a TLS configuration (typically built by ClientConfig), with
+// the tuned transport of httpclient.DefaultTransportConfig
+func HTTPClient(cfg *tls.Config) *http.Client {
+	return httpclient.NewHTTPClient(nil, cfg)
+}
+
+// PeerIdentity returns the common name of the verified certificate a client presented to a server,
+// or an empty string if the request wasn't mutually authenticated
+func PeerIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading CA certificate %s: %s", caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("No PEM certificate found in %s", caFile)
+	}
+	return pool, nil
+}
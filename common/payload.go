@@ -0,0 +1,116 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package common
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+)
+
+// DefaultMaxPayloadSize bounds the payloads read in memory when no other bound is given
+const DefaultMaxPayloadSize = 64 << 20
+
+// PayloadTooLargeError is returned when a payload (e.g. a model produced by an algo) is larger than
+// allowed. It is a permanent error: the payload won't shrink if sent again.
+type PayloadTooLargeError struct {
+	Limit int64
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("payload too large (more than %d bytes)", e.Limit)
+}
+
+// Kind classifies payload too large errors as permanent
+func (e *PayloadTooLargeError) Kind() errors.Kind {
+	return errors.Permanent
+}
+
+// Is makes errors.Is(err, errors.ErrPermanent) work on payload too large errors
+func (e *PayloadTooLargeError) Is(target error) bool {
+	return errors.MatchKind(e.Kind(), target)
+}
+
+// CheckPayloadSize returns a *PayloadTooLargeError if a declared payload size exceeds limit (no
+// limit if zero or less)
+func CheckPayloadSize(size, limit int64) error {
+	if limit > 0 && size > limit {
+		return &PayloadTooLargeError{Limit: limit}
+	}
+	return nil
+}
+
+// LimitPayload returns a reader failing with a *PayloadTooLargeError as soon as more than limit
+// bytes are read from r (r is returned as is if limit is zero or less). Unlike io.LimitReader, it
+// tells truncated payloads from complete ones.
+func LimitPayload(r io.Reader, limit int64) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+	return &limitedPayload{r: r, limit: limit, remaining: limit}
+}
+
+type limitedPayload struct {
+	r                io.Reader
+	limit, remaining int64
+}
+
+func (l *limitedPayload) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, &PayloadTooLargeError{Limit: l.limit}
+	}
+	// One byte past the limit is read to detect oversized payloads
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n - 1, &PayloadTooLargeError{Limit: l.limit}
+	}
+	return n, err
+}
+
+// ReadPayload reads a payload in memory, failing with a *PayloadTooLargeError if it is larger than
+// limit bytes (DefaultMaxPayloadSize if zero or less)
+func ReadPayload(r io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		limit = DefaultMaxPayloadSize
+	}
+	return ioutil.ReadAll(LimitPayload(r, limit))
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// FormatPerf formats a performance value with precision significant digits. A precision of zero
+// (or less) formats it exactly: with the shortest representation parsing back to the same float64.
+func FormatPerf(perf float64, precision int) string {
+	if precision <= 0 {
+		precision = -1
+	}
+	return strconv.FormatFloat(perf, 'g', precision, 64)
+}
+
+// ParsePerf parses a performance value (a decimal or JSON number, e.g. as formatted by FormatPerf)
+func ParsePerf(s string) (float64, error) {
+	perf, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid performance value %s: %s", s, err)
+	}
+	return perf, nil
+}
+
+// PerfNumber returns a performance value as a JSON number with precision significant digits (see
+// FormatPerf), to marshal performance maps without rounding them through another float
+func PerfNumber(perf float64, precision int) (json.Number, error) {
+	if math.IsNaN(perf) || math.IsInf(perf, 0) {
+		return "", fmt.Errorf("performance value %v can't be represented in JSON", perf)
+	}
+	return json.Number(FormatPerf(perf, precision)), nil
+}
+
+// PerfNumbers converts a performance map to JSON numbers with precision significant digits
+func PerfNumbers(perfs map[string]float64, precision int) (map[string]json.Number, error) {
+	if perfs == nil {
+		return nil, nil
+	}
+	numbers := make(map[string]json.Number, len(perfs))
+	for key, perf := range perfs {
+		number, err := PerfNumber(perf, precision)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+		numbers[key] = number
+	}
+	return numbers, nil
+}
+
+// Perf is a performance value as exchanged with the orchestrator. It is marshaled to JSON exactly
+// (see FormatPerf), and un-marshaled from JSON numbers or strings (as some chaincode versions
+// send them) without going through a less precise float.
+type Perf float64
+
+// MarshalJSON encodes the performance value as an exact JSON number
+func (p Perf) MarshalJSON() ([]byte, error) {
+	number, err := PerfNumber(float64(p), 0)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(number), nil
+}
+
+// UnmarshalJSON decodes a performance value from a JSON number or string
+func (p *Perf) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	s := string(data)
+	if len(data) > 1 && data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	perf, err := ParsePerf(s)
+	if err != nil {
+		return err
+	}
+	*p = Perf(perf)
+	return nil
+}
+
+// String formats the performance value exactly
+func (p Perf) String() string {
+	return FormatPerf(float64(p), 0)
+}
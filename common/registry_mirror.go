@@ -0,0 +1,195 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package common
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/bufpool"
+)
+
+// dockerHubLibrary is the namespace of the official Docker Hub images (e.g. python:3 is
+// library/python:3)
+const dockerHubLibrary = "library/"
+
+// MirrorImageRef returns the reference of an image in a registry mirror (a host[:port] such as
+// "registry.site.local:5000"), so that it is pulled through the mirror instead of the internet:
+//   - Docker Hub images keep their path (python:3 becomes <mirror>/library/python:3)
+//   - images of other registries are looked up under the host name of their registry
+//     (ghcr.io/org/image becomes <mirror>/ghcr.io/org/image)
+//   - images already in the mirror, or referring to build arguments ($VAR), are left unchanged
+//
+// ref is returned unchanged if mirror is empty.
+func MirrorImageRef(ref, mirror string) string {
+	mirror = strings.TrimSuffix(mirror, "/")
+	if mirror == "" || ref == "" || strings.Contains(ref, "$") {
+		return ref
+	}
+	if ref == mirror || strings.HasPrefix(ref, mirror+"/") {
+		return ref
+	}
+	i := strings.Index(ref, "/")
+	if i < 0 {
+		return mirror + "/" + dockerHubLibrary + ref
+	}
+	first := ref[:i]
+	if first == "docker.io" || first == "index.docker.io" {
+		ref = strings.TrimPrefix(ref[len(first)+1:], "/")
+		if !strings.Contains(ref, "/") {
+			ref = dockerHubLibrary + ref
+		}
+	}
+	return mirror + "/" + ref
+}
+
+// MirrorDockerfile rewrites the images a Dockerfile refers to (in its FROM instructions and the
+// --from flags of its COPY instructions) with MirrorImageRef. Build stages and scratch are left
+// unchanged.
+func MirrorDockerfile(dockerfile []byte, mirror string) []byte {
+	if mirror == "" {
+		return dockerfile
+	}
+	stages := map[string]bool{"scratch": true}
+	lines := strings.Split(string(dockerfile), "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "FROM":
+			// FROM [--platform=<platform>] <image> [AS <name>]
+			j := 1
+			for j < len(fields) && strings.HasPrefix(fields[j], "--") {
+				j++
+			}
+			if j == len(fields) {
+				continue
+			}
+			if !stages[strings.ToLower(fields[j])] {
+				fields[j] = MirrorImageRef(fields[j], mirror)
+				lines[i] = indentation(line) + strings.Join(fields, " ")
+			}
+			// Later instructions may refer to the stage this one declares
+			if j+2 < len(fields) && strings.EqualFold(fields[j+1], "AS") {
+				stages[strings.ToLower(fields[j+2])] = true
+			}
+		case "COPY":
+			rewritten := false
+			for j := 1; j < len(fields) && strings.HasPrefix(fields[j], "--"); j++ {
+				if !strings.HasPrefix(fields[j], "--from=") {
+					continue
+				}
+				from := strings.TrimPrefix(fields[j], "--from=")
+				if _, err := strconv.Atoi(from); err == nil || stages[strings.ToLower(from)] {
+					continue
+				}
+				fields[j], rewritten = "--from="+MirrorImageRef(from, mirror), true
+			}
+			if rewritten {
+				lines[i] = indentation(line) + strings.Join(fields, " ")
+			}
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+func indentation(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// MirrorBuildContext rewrites the Dockerfile at the root of a build context (a tar archive,
+// optionally gzipped) with MirrorDockerfile. The rewritten context is streamed, as an uncompressed
+// tar archive: only the Dockerfile is held in memory.
+func MirrorBuildContext(buildContext io.Reader, mirror string) io.Reader {
+	if mirror == "" {
+		return buildContext
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(mirrorBuildContext(writer, buildContext, mirror))
+	}()
+	return reader
+}
+
+func mirrorBuildContext(dst io.Writer, src io.Reader, mirror string) error {
+	buffered := bufio.NewReader(src)
+	var archive io.Reader = buffered
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipped, err := gzip.NewReader(buffered)
+		if err != nil {
+			return fmt.Errorf("[registry-mirror] Error reading gzipped build context: %s", err)
+		}
+		defer gzipped.Close()
+		archive = gzipped
+	}
+
+	in, out := tar.NewReader(archive), tar.NewWriter(dst)
+	for {
+		header, err := in.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("[registry-mirror] Error reading build context: %s", err)
+		}
+		var body io.Reader = in
+		if header.Typeflag == tar.TypeReg && path.Clean(header.Name) == "Dockerfile" {
+			dockerfile, err := ioutil.ReadAll(in)
+			if err != nil {
+				return fmt.Errorf("[registry-mirror] Error reading Dockerfile: %s", err)
+			}
+			dockerfile = MirrorDockerfile(dockerfile, mirror)
+			header.Size, body = int64(len(dockerfile)), bytes.NewReader(dockerfile)
+		}
+		if err := out.WriteHeader(header); err != nil {
+			return fmt.Errorf("[registry-mirror] Error writing build context: %s", err)
+		}
+		if _, err := bufpool.Copy(out, body); err != nil {
+			return fmt.Errorf("[registry-mirror] Error writing build context: %s", err)
+		}
+	}
+	return out.Close()
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package common
+
+import "fmt"
+
+// Resources describes the resources available on the host a worker runs on, in bytes
+type Resources struct {
+	DiskFree        uint64
+	DiskTotal       uint64
+	MemoryAvailable uint64
+	MemoryTotal     uint64
+}
+
+// ResourceRequirements describes the resources an uplet needs to be processed, in bytes. They are
+// typically derived from the declared sizes of the data blobs it references.
+type ResourceRequirements struct {
+	Disk   uint64
+	Memory uint64
+}
+
+// Fit checks that a given set of requirements can be satisfied. A *TaskError is returned if they
+// can't be satisfied right now but could be later (the uplet should be requeued), a *FatalTaskError
+// if they exceed the total capacity of the host (the uplet should be rejected).
+func (r *Resources) Fit(req ResourceRequirements) error {
+	if req.Disk > r.DiskTotal {
+		return &FatalTaskError{fmt.Sprintf("Uplet requires %d bytes of disk, more than the %d bytes this worker has", req.Disk, r.DiskTotal)}
+	}
+	if req.Memory > r.MemoryTotal {
+		return &FatalTaskError{fmt.Sprintf("Uplet requires %d bytes of memory, more than the %d bytes this worker has", req.Memory, r.MemoryTotal)}
+	}
+	if req.Disk > r.DiskFree {
+		return &TaskError{fmt.Sprintf("Uplet requires %d bytes of disk, only %d are free", req.Disk, r.DiskFree)}
+	}
+	if req.Memory > r.MemoryAvailable {
+		return &TaskError{fmt.Sprintf("Uplet requires %d bytes of memory, only %d are available", req.Memory, r.MemoryAvailable)}
+	}
+	return nil
+}
+
+// CheckResources fetches the resources available on the host (disk resources being the ones of the
+// filesystem dataDir lives on) and checks the given requirements fit in them (see Resources.Fit).
+func CheckResources(dataDir string, req ResourceRequirements) error {
+	resources, err := GetResources(dataDir)
+	if err != nil {
+		return fmt.Errorf("Error fetching available resources: %s", err)
+	}
+	return resources.Fit(req)
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// GetResources returns the free and total disk space of the filesystem holding dataDir, and the
+// available and total memory as reported by /proc/meminfo
+func GetResources(dataDir string) (*Resources, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dataDir, &stat); err != nil {
+		return nil, fmt.Errorf("Error performing statfs on %s: %s", dataDir, err)
+	}
+	resources := &Resources{
+		DiskFree:  stat.Bavail * uint64(stat.Bsize),
+		DiskTotal: stat.Blocks * uint64(stat.Bsize),
+	}
+
+	meminfo, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return nil, fmt.Errorf("Error opening /proc/meminfo: %s", err)
+	}
+	defer meminfo.Close()
+
+	// Lines look like "MemAvailable:    1234567 kB"
+	scanner := bufio.NewScanner(meminfo)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing /proc/meminfo line \"%s\": %s", scanner.Text(), err)
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			resources.MemoryTotal = value * 1024
+		case "MemAvailable:":
+			resources.MemoryAvailable = value * 1024
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading /proc/meminfo: %s", err)
+	}
+	return resources, nil
+}
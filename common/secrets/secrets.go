@@ -0,0 +1,210 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package secrets fetches credentials (storage passwords, registry passwords, orchestrator
+// tokens...) from the environment, from files (Docker or Kubernetes secrets) or from HashiCorp
+// Vault, so that they don't have to be written in configuration files.
+//
+// Configuration values refer to secrets with a scheme prefix:
+//
+//	env:STORAGE_PASSWORD
+//	file:/run/secrets/storage_password
+//	vault:morpheo/storage#password
+//
+// Values without a known scheme are used as is.
+//
+// Note that this package must not import the common package.
+package secrets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+)
+
+// Schemes of the built-in providers
+const (
+	SchemeEnv   = "env"
+	SchemeFile  = "file"
+	SchemeVault = "vault"
+)
+
+// Provider fetches secrets by name
+type Provider interface {
+	Secret(name string) (string, error)
+}
+
+// EnvProvider reads secrets from environment variables
+type EnvProvider struct{}
+
+// Secret returns the value of an environment variable, which must be set
+func (p EnvProvider) Secret(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", errors.Newf(errors.NotFound, "Secret environment variable %s isn't set", name)
+	}
+	return value, nil
+}
+
+// FileProvider reads secrets from files, one per secret (as mounted by Docker or Kubernetes).
+// Trailing newlines are stripped.
+type FileProvider struct {
+	// Dir is the directory relative secret names are resolved from
+	Dir string
+}
+
+// Secret returns the content of a secret file
+func (p FileProvider) Secret(name string) (string, error) {
+	path := name
+	if !filepath.IsAbs(path) && p.Dir != "" {
+		path = filepath.Join(p.Dir, path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", errors.Newf(errors.NotFound, "Secret file %s doesn't exist", path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error reading secret file %s: %s", path, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Flusher is implemented by the providers caching secrets
+type Flusher interface {
+	Flush()
+}
+
+// Resolver resolves configuration values referring to secrets through the provider registered for
+// their scheme
+type Resolver struct {
+	Providers map[string]Provider
+}
+
+// NewResolver creates a resolver handling the env: and file: schemes (relative file names being
+// resolved from secretsDir), and the vault: scheme if vault isn't nil
+func NewResolver(secretsDir string, vault *VaultProvider) *Resolver {
+	r := &Resolver{Providers: map[string]Provider{
+		SchemeEnv:  EnvProvider{},
+		SchemeFile: FileProvider{Dir: secretsDir},
+	}}
+	if vault != nil {
+		r.Providers[SchemeVault] = vault
+	}
+	return r
+}
+
+// IsReference returns true if a value refers to a secret of a known scheme
+func (r *Resolver) IsReference(value string) bool {
+	_, _, ok := r.split(value)
+	return ok
+}
+
+// Resolve returns the secret a value refers to, or the value itself if it isn't a reference
+func (r *Resolver) Resolve(value string) (string, error) {
+	provider, name, ok := r.split(value)
+	if !ok {
+		return value, nil
+	}
+	secret, err := provider.Secret(name)
+	if err != nil {
+		return "", fmt.Errorf("Error resolving secret %s: %w", value, err)
+	}
+	return secret, nil
+}
+
+// Flush empties the caches of the providers, so that rotated secrets are fetched again
+func (r *Resolver) Flush() {
+	for _, provider := range r.Providers {
+		if f, ok := provider.(Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// BasicAuth returns an HTTP client request editor setting basic auth credentials resolved on every
+// request, so that rotated secrets are picked up without restarting (see Transport to pick them up
+// as soon as the old ones are rejected)
+func (r *Resolver) BasicAuth(user, password string) httpclient.RequestEditor {
+	return func(req *http.Request) error {
+		u, err := r.Resolve(user)
+		if err != nil {
+			return err
+		}
+		p, err := r.Resolve(password)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(u, p)
+		return nil
+	}
+}
+
+// Transport wraps an HTTP transport (http.DefaultTransport if nil) so that the cached secrets are
+// flushed when a request is rejected with a 401: its credentials were likely rotated, and the next
+// request reads them again
+func (r *Resolver) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &flushingTransport{resolver: r, next: next}
+}
+
+type flushingTransport struct {
+	resolver *Resolver
+	next     http.RoundTripper
+}
+
+func (t *flushingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		t.resolver.Flush()
+	}
+	return resp, err
+}
+
+func (r *Resolver) split(value string) (provider Provider, name string, ok bool) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, "", false
+	}
+	provider, ok = r.Providers[parts[0]]
+	return provider, parts[1], ok
+}
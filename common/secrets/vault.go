@@ -0,0 +1,156 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package secrets
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+)
+
+// DefaultVaultTTL is how long Vault secrets are cached when no TTL is set
+const DefaultVaultTTL = 5 * time.Minute
+
+// VaultProvider reads secrets from the KV (version 2) secrets engine of a HashiCorp Vault server.
+// Secret names have the form "path#key", e.g. "morpheo/storage#password". Fetched secrets are
+// cached for their lease duration, if Vault sets one, and TTL at most, or until Flush is called.
+type VaultProvider struct {
+	// Mount is the mount point of the KV secrets engine ("secret" if empty)
+	Mount string
+	// TTL bounds how long fetched secrets are cached (DefaultVaultTTL if not positive), so that
+	// rotated secrets are picked up
+	TTL time.Duration
+	// HTTPClient performs the requests against Vault
+	HTTPClient *httpclient.Client
+	// Clock tells when cached secrets expire (the wall clock if nil)
+	Clock clock.Clock
+
+	lock  sync.Mutex
+	cache map[string]vaultSecret
+}
+
+// vaultSecret is a cached secret
+type vaultSecret struct {
+	data    map[string]string
+	expires time.Time
+}
+
+// NewVaultProvider creates a provider fetching secrets from the Vault server at address,
+// authenticated with a token
+func NewVaultProvider(address, token, mount string) *VaultProvider {
+	client := httpclient.New("vault", address)
+	client.APIVersion = ""
+	client.RequestEditors = append(client.RequestEditors, func(req *http.Request) error {
+		req.Header.Set("X-Vault-Token", token)
+		return nil
+	})
+	return &VaultProvider{
+		Mount:      mount,
+		HTTPClient: client,
+	}
+}
+
+// Secret returns the value of a key of a Vault secret
+func (p *VaultProvider) Secret(name string) (string, error) {
+	parts := strings.SplitN(name, "#", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", errors.Newf(errors.Validation, "Invalid Vault secret name %s (should be path#key)", name)
+	}
+	path, key := parts[0], parts[1]
+
+	data, err := p.read(path)
+	if err != nil {
+		return "", err
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", errors.Newf(errors.NotFound, "Vault secret %s has no key %s", path, key)
+	}
+	return value, nil
+}
+
+// Flush empties the cache, so that rotated secrets are fetched again before their TTL expires
+func (p *VaultProvider) Flush() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.cache = nil
+}
+
+func (p *VaultProvider) read(path string) (map[string]string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := clock.OrReal(p.Clock).Now()
+	if cached, ok := p.cache[path]; ok && now.Before(cached.expires) {
+		return cached.data, nil
+	}
+
+	mount := p.Mount
+	if mount == "" {
+		mount = "secret"
+	}
+	var secret struct {
+		LeaseDuration int `json:"lease_duration"`
+		Data          struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	err := p.HTTPClient.DoJSON(&httpclient.Request{
+		Method: http.MethodGet,
+		Route:  fmt.Sprintf("v1/%s/data/%s", strings.Trim(mount, "/"), strings.TrimPrefix(path, "/")),
+	}, &secret)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading Vault secret %s: %w", path, err)
+	}
+
+	ttl := p.TTL
+	if ttl <= 0 {
+		ttl = DefaultVaultTTL
+	}
+	if lease := time.Duration(secret.LeaseDuration) * time.Second; lease > 0 && lease < ttl {
+		ttl = lease
+	}
+	if p.cache == nil {
+		p.cache = map[string]vaultSecret{}
+	}
+	p.cache[path] = vaultSecret{data: secret.Data.Data, expires: now.Add(ttl)}
+	return secret.Data.Data, nil
+}
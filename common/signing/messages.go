@@ -0,0 +1,115 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package signing
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+)
+
+// Broker messages have no headers: signed messages are wrapped in an envelope carrying their
+// signature (in the format of the X-Morpheo-Signature header), whose HMAC covers the timestamp,
+// the topic (so that a message can't be replayed on another topic) and the body. The body is
+// always base64-encoded in Payload, JSON or not: embedding JSON bodies as is would have them
+// compacted and escaped by the encoder, so that the verifier would get other bytes than the signed
+// ones.
+type messageEnvelope struct {
+	Signature string `json:"morpheo_signature"`
+	Payload   []byte `json:"payload"`
+}
+
+// messageMethod stands for the request method in the HMAC of broker messages
+const messageMethod = "MESSAGE"
+
+// DefaultMessageMaxAge is the maximum age of signed messages when none is set: a captured message
+// can't be replayed past it
+const DefaultMessageMaxAge = 24 * time.Hour
+
+// SignMessage wraps the body of a message pushed on topic in a signed envelope
+func (s *Signer) SignMessage(topic string, body []byte) ([]byte, error) {
+	env := messageEnvelope{Signature: s.Sign(messageMethod, topic, body), Payload: body}
+	signed, err := json.Marshal(env)
+	if err != nil {
+		return nil, fmt.Errorf("[signing] Error wrapping message in a signed envelope: %s", err)
+	}
+	return signed, nil
+}
+
+// VerifyMessage checks the signature of a message received on topic and returns its body. Unsigned
+// messages are rejected, and so are the messages signed more than maxAge ago (DefaultMessageMaxAge
+// if maxAge isn't positive) or dated in the future: unlike requests, messages may legitimately
+// wait in a queue, so maxAge should be set to the longest time a message may be queued for. Nonces
+// aren't checked: brokers deliver a message again when its handling fails.
+func VerifyMessage(topic string, message []byte, keys Keys, maxAge time.Duration, c clock.Clock) ([]byte, error) {
+	var env messageEnvelope
+	if err := json.Unmarshal(message, &env); err != nil || env.Signature == "" {
+		return nil, errors.Newf(errors.Unauthorized, "Unsigned message on topic %s", topic)
+	}
+	params := parseSignature(env.Signature)
+	timestamp, err := parseTimestamp(params["t"])
+	if err != nil {
+		return nil, err
+	}
+	if maxAge <= 0 {
+		maxAge = DefaultMessageMaxAge
+	}
+	age := clock.OrReal(c).Now().Sub(time.Unix(timestamp, 0))
+	if age > maxAge {
+		return nil, errors.Newf(errors.Unauthorized, "Message signed %s ago, more than the accepted %s", age.Truncate(time.Second), maxAge)
+	}
+	if age < -DefaultMaxSkew {
+		return nil, errors.Newf(errors.Unauthorized, "Message signed in the future (%s ahead)", (-age).Truncate(time.Second))
+	}
+	signature, err := hex.DecodeString(params["sig"])
+	if err != nil {
+		return nil, errors.Newf(errors.Unauthorized, "Invalid signature encoding")
+	}
+	key, err := keys.Key(params["keyId"])
+	if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal(signature, mac(key, timestamp, params["nonce"], messageMethod, topic, digest(env.Payload))) {
+		return nil, errors.Newf(errors.Unauthorized, "Invalid signature of message on topic %s", topic)
+	}
+	return env.Payload, nil
+}
@@ -0,0 +1,402 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package signing authenticates request bodies with HMAC-SHA256, so that the receiving side of a
+// status update or result submission can reject forged or replayed requests.
+//
+// The signature is sent in the X-Morpheo-Signature header:
+//
+//	X-Morpheo-Signature: keyId=worker-1,t=1510000000,nonce=<hex>,sig=<hex encoded HMAC>
+//
+// where the HMAC covers the timestamp, the nonce, the request method and URI, and the SHA-256
+// digest of the body. Keys are either a secret shared by every worker (empty key ID) or a per-worker
+// key. Verifiers remembering nonces (see Verifier.Nonces) reject a signed request sent twice.
+//
+// Bodies are hashed as they are streamed when they can be read again (in-memory bodies), and
+// buffered otherwise, up to Signer.MaxBufferedBody: larger ones (blob uploads) are left out of the
+// signature (body=unsigned), and only accepted by verifiers allowing it.
+//
+// Broker messages are signed the same way, the signature being carried by an envelope (see
+// SignMessage).
+//
+// Note that this package must not import the common package.
+package signing
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/clock"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+)
+
+// Header is the header carrying the signature of a request
+const Header = "X-Morpheo-Signature"
+
+// DefaultMaxSkew is the default maximum age (or advance) of a signature timestamp
+const DefaultMaxSkew = 5 * time.Minute
+
+// DefaultMaxBufferedBody is the default size above which streamed request bodies aren't buffered to
+// be signed
+const DefaultMaxBufferedBody = 1 << 20
+
+// DefaultMaxBodySize is the default maximum size of the signed bodies a Verifier reads
+const DefaultMaxBodySize = 16 << 20
+
+// unsignedBody stands for the digest of the bodies left out of a signature
+const unsignedBody = "unsigned"
+
+// Signer signs request bodies with a key
+type Signer struct {
+	// KeyID identifies the key on the receiving side (empty for a shared secret)
+	KeyID string
+	Key   []byte
+	// MaxBufferedBody is the size (DefaultMaxBufferedBody if not positive) above which request
+	// bodies that can't be read twice are sent unsigned rather than buffered
+	MaxBufferedBody int64
+	// Clock tells the signing time (the wall clock if nil)
+	Clock clock.Clock
+}
+
+// Sign returns the signature header value of a request
+func (s *Signer) Sign(method, requestURI string, body []byte) string {
+	return s.sign(method, requestURI, digest(body))
+}
+
+func (s *Signer) sign(method, requestURI, bodyDigest string) string {
+	timestamp := clock.OrReal(s.Clock).Now().Unix()
+	nonce := newNonce()
+	signature := fmt.Sprintf("keyId=%s,t=%d,nonce=%s,sig=%s", s.KeyID, timestamp, nonce, hex.EncodeToString(mac(s.Key, timestamp, nonce, method, requestURI, bodyDigest)))
+	if bodyDigest == unsignedBody {
+		signature += ",body=" + unsignedBody
+	}
+	return signature
+}
+
+// RequestEditor returns an HTTP client request editor signing every request. Bodies that can be
+// read again are hashed as they are streamed, other ones are buffered up to MaxBufferedBody and
+// sent unsigned beyond.
+func (s *Signer) RequestEditor() httpclient.RequestEditor {
+	return func(req *http.Request) error {
+		bodyDigest, err := s.bodyDigest(req)
+		if err != nil {
+			return fmt.Errorf("Error reading request body to sign it: %s", err)
+		}
+		req.Header.Set(Header, s.sign(req.Method, req.URL.RequestURI(), bodyDigest))
+		return nil
+	}
+}
+
+func (s *Signer) bodyDigest(req *http.Request) (string, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return digest(nil), nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, body); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	limit := s.MaxBufferedBody
+	if limit <= 0 {
+		limit = DefaultMaxBufferedBody
+	}
+	buffered, err := ioutil.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		req.Body.Close()
+		return "", err
+	}
+	if int64(len(buffered)) > limit {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buffered), req.Body), req.Body}
+		return unsignedBody, nil
+	}
+	req.Body.Close()
+	replaceBody(req, buffered)
+	return digest(buffered), nil
+}
+
+// Keys returns the key identified by a key ID
+type Keys interface {
+	Key(keyID string) ([]byte, error)
+}
+
+// StaticKeys maps key IDs to keys. A shared secret has an empty key ID.
+type StaticKeys map[string][]byte
+
+// Key returns the key identified by a key ID
+func (k StaticKeys) Key(keyID string) ([]byte, error) {
+	key, ok := k[keyID]
+	if !ok {
+		return nil, errors.Newf(errors.Unauthorized, "Unknown signing key %q", keyID)
+	}
+	return key, nil
+}
+
+// Nonces remembers the nonces of the signatures seen, until they expire
+type Nonces interface {
+	// Use records a nonce, valid until expiry, and returns false if it was already used
+	Use(keyID, nonce string, expiry, now time.Time) bool
+}
+
+// MemoryNonces is an in-memory Nonces, to be shared by the handlers of a single server
+type MemoryNonces struct {
+	nonces map[string]time.Time
+	lock   sync.Mutex
+}
+
+// NewMemoryNonces creates an empty nonce store
+func NewMemoryNonces() *MemoryNonces {
+	return &MemoryNonces{nonces: map[string]time.Time{}}
+}
+
+// Use records a nonce, valid until expiry, and returns false if it was already used. Expired nonces
+// are forgotten.
+func (n *MemoryNonces) Use(keyID, nonce string, expiry, now time.Time) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for key, exp := range n.nonces {
+		if !exp.After(now) {
+			delete(n.nonces, key)
+		}
+	}
+	key := keyID + "\n" + nonce
+	if _, ok := n.nonces[key]; ok {
+		return false
+	}
+	n.nonces[key] = expiry
+	return true
+}
+
+// BodyTooLargeError is returned by Verifier.Verify when a signed body is larger than MaxBodySize
+type BodyTooLargeError struct {
+	Limit int64
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("Request body is larger than the %d bytes accepted", e.Limit)
+}
+
+// Kind classifies the error as a validation error
+func (e *BodyTooLargeError) Kind() errors.Kind {
+	return errors.Validation
+}
+
+// Verifier checks the signature of requests
+type Verifier struct {
+	Keys Keys
+	// MaxSkew is the maximum age (or advance) of signatures (DefaultMaxSkew if not positive)
+	MaxSkew time.Duration
+	// MaxBodySize bounds the size of signed bodies (DefaultMaxBodySize if not positive)
+	MaxBodySize int64
+	// AllowUnsignedBody accepts the requests whose body is left out of the signature (see
+	// Signer.MaxBufferedBody), typically on blob upload routes
+	AllowUnsignedBody bool
+	// Nonces, if set, rejects the signatures seen before (replayed requests), instead of relying on
+	// MaxSkew only. Signatures without a nonce are rejected then.
+	Nonces Nonces
+	// Clock tells the verification time (the wall clock if nil)
+	Clock clock.Clock
+}
+
+// Verify checks the signature of a request and returns its body (the request body is replaced so
+// that it can be read again by the handler). The body of requests allowed to be unsigned isn't
+// read: nil is returned and the request body is left as is.
+func (v *Verifier) Verify(r *http.Request) ([]byte, error) {
+	header := r.Header.Get(Header)
+	if header == "" {
+		return nil, errors.Newf(errors.Unauthorized, "Missing %s header", Header)
+	}
+	params := parseSignature(header)
+	timestamp, err := parseTimestamp(params["t"])
+	if err != nil {
+		return nil, err
+	}
+	maxSkew := v.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	now := clock.OrReal(v.Clock).Now()
+	if skew := now.Sub(time.Unix(timestamp, 0)); skew > maxSkew || skew < -maxSkew {
+		return nil, errors.Newf(errors.Unauthorized, "Signature timestamp is out of the accepted window (%s)", maxSkew)
+	}
+	signature, err := hex.DecodeString(params["sig"])
+	if err != nil {
+		return nil, errors.Newf(errors.Unauthorized, "Invalid signature encoding")
+	}
+	key, err := v.Keys.Key(params["keyId"])
+	if err != nil {
+		return nil, err
+	}
+	nonce := params["nonce"]
+	if v.Nonces != nil && nonce == "" {
+		return nil, errors.Newf(errors.Unauthorized, "Signature without nonce")
+	}
+
+	var body []byte
+	bodyDigest := unsignedBody
+	if params["body"] == unsignedBody {
+		if !v.AllowUnsignedBody {
+			return nil, errors.Newf(errors.Unauthorized, "Request body isn't signed")
+		}
+	} else {
+		limit := v.MaxBodySize
+		if limit <= 0 {
+			limit = DefaultMaxBodySize
+		}
+		if body, err = readBody(r, limit); err != nil {
+			return nil, err
+		}
+		bodyDigest = digest(body)
+	}
+	if !hmac.Equal(signature, mac(key, timestamp, nonce, r.Method, r.URL.RequestURI(), bodyDigest)) {
+		return nil, errors.Newf(errors.Unauthorized, "Invalid request signature")
+	}
+	// Nonces are recorded once the signature is known to be valid, so that forged requests can't
+	// burn them
+	if v.Nonces != nil && !v.Nonces.Use(params["keyId"], nonce, time.Unix(timestamp, 0).Add(maxSkew), now) {
+		return nil, errors.Newf(errors.Unauthorized, "Replayed request signature")
+	}
+	return body, nil
+}
+
+// Middleware rejects requests whose signature is missing or invalid with a 401 (or a 413 if their
+// body is too large to be verified)
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := v.Verify(r); err != nil {
+			status := http.StatusUnauthorized
+			var tooLarge *BodyTooLargeError
+			switch {
+			case stderrors.As(err, &tooLarge):
+				status = http.StatusRequestEntityTooLarge
+			case errors.KindOf(err) != errors.Unauthorized:
+				status = http.StatusBadRequest
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseSignature splits a signature into its parameters (keyId, t, nonce, sig and body)
+func parseSignature(signature string) map[string]string {
+	params := map[string]string{}
+	for _, part := range strings.Split(signature, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = kv[1]
+		}
+	}
+	return params
+}
+
+func parseTimestamp(t string) (int64, error) {
+	timestamp, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return 0, errors.Newf(errors.Unauthorized, "Invalid signature timestamp %q", t)
+	}
+	return timestamp, nil
+}
+
+func newNonce() string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	return hex.EncodeToString(nonce)
+}
+
+// digest returns the hex encoded SHA-256 digest of a body
+func digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func mac(key []byte, timestamp int64, nonce, method, requestURI, bodyDigest string) []byte {
+	h := hmac.New(sha256.New, key)
+	fmt.Fprintf(h, "%d\n%s\n%s\n%s\n%s", timestamp, nonce, method, requestURI, bodyDigest)
+	return h.Sum(nil)
+}
+
+// readBody reads a request body of at most limit bytes and replaces it with an in-memory copy
+func readBody(r *http.Request, limit int64) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading request body: %s", err)
+	}
+	if int64(len(body)) > limit {
+		return nil, &BodyTooLargeError{Limit: limit}
+	}
+	replaceBody(r, body)
+	return body, nil
+}
+
+// replaceBody replaces the body of a request with an in-memory copy
+func replaceBody(r *http.Request, body []byte) {
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.ContentLength = int64(len(body))
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package common
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/codec"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+)
+
+// StatusEvent is the message pushed on the StatusTopic when an uplet changes status
+type StatusEvent struct {
+	UpletType string    `json:"uplet_type"`
+	UpletKey  string    `json:"uplet_key"`
+	Status    string    `json:"status"`
+	Message   string    `json:"message,omitempty"` // e.g. the current phase, or an error
+	Time      time.Time `json:"time"`
+}
+
+// Check returns nil if the status event is valid, an explicit error otherwise
+func (e *StatusEvent) Check() error {
+	if _, ok := ValidUplets[e.UpletType]; !ok {
+		return fmt.Errorf("uplet_type field ain't valid (provided: %s, possible choices: %s)", e.UpletType, ValidUplets)
+	}
+	if e.UpletKey == "" {
+		return fmt.Errorf("uplet_key field is required")
+	}
+	if _, ok := ValidStatuses[e.Status]; !ok {
+		return fmt.Errorf("status field ain't valid (provided: %s, possible choices: %s)", e.Status, ValidStatuses)
+	}
+	return nil
+}
+
+// PushStatusEvent validates a status event and pushes it on the StatusTopic, as JSON
+func PushStatusEvent(producer Producer, event StatusEvent) error {
+	return PushStatusEventWith(producer, codec.JSON, event)
+}
+
+// PushStatusEventWith validates a status event and pushes it on the StatusTopic, encoded with c
+// (consumers decode it with codec.DecodeMessage)
+func PushStatusEventWith(producer Producer, c codec.Codec, event StatusEvent) error {
+	if err := event.Check(); err != nil {
+		return errors.Newf(errors.Validation, "Invalid status event: %s", err)
+	}
+	body, err := codec.EncodeMessage(c, event)
+	if err != nil {
+		return fmt.Errorf("Error marshaling status event to %s: %s", codec.OrJSON(c).ContentType(), err)
+	}
+	return producer.Push(StatusTopic, body)
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common"
+)
+
+// envelope carries the trace context of a broker message alongside its body, our brokers having no
+// notion of message headers. The body is kept as opaque (base64-encoded) bytes, so that consumers
+// get it byte for byte, whatever its encoding (JSON, MessagePack... see the codec package) and
+// whatever signs it (see common.SigningProducer).
+type envelope struct {
+	TraceContext map[string]string `json:"trace_context"`
+	Payload      []byte            `json:"payload"`
+}
+
+// InjectMessage wraps a message body in an envelope carrying the trace context of ctx
+func InjectMessage(ctx context.Context, body []byte) ([]byte, error) {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if body == nil {
+		body = []byte{}
+	}
+	wrapped, err := json.Marshal(envelope{TraceContext: carrier, Payload: body})
+	if err != nil {
+		return nil, fmt.Errorf("[tracing] Error wrapping message in a trace envelope: %s", err)
+	}
+	return wrapped, nil
+}
+
+// ExtractMessage unwraps a message built by InjectMessage, returning a context holding the remote
+// span context and the original body. Messages that weren't wrapped are returned as is, with an
+// empty context, so that producers and consumers can be upgraded independently.
+func ExtractMessage(ctx context.Context, message []byte) (context.Context, []byte) {
+	var env envelope
+	if err := json.Unmarshal(message, &env); err != nil || env.TraceContext == nil || env.Payload == nil {
+		return ctx, message
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(env.TraceContext)), env.Payload
+}
+
+// Push sends a message on a topic, carrying the trace context of ctx, within a producer span
+func Push(ctx context.Context, producer common.Producer, topic string, body []byte) (err error) {
+	ctx, span := Tracer().Start(ctx, fmt.Sprintf("%s publish", topic),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(AttrTopic.String(topic)),
+	)
+	defer func() { End(span, err) }()
+
+	wrapped, err := InjectMessage(ctx, body)
+	if err != nil {
+		return err
+	}
+	return producer.Push(topic, wrapped)
+}
+
+// WrapHandler turns a common.ContextHandler into a common.Handler that extracts the trace context
+// from incoming messages and processes them within a consumer span
+func WrapHandler(topic string, handler common.ContextHandler) common.Handler {
+	return func(message []byte) (err error) {
+		ctx, body := ExtractMessage(context.Background(), message)
+		ctx, span := Tracer().Start(ctx, fmt.Sprintf("%s receive", topic),
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(AttrTopic.String(topic)),
+		)
+		defer func() { End(span, err) }()
+
+		return handler(ctx, body)
+	}
+}
+
+// Producer is a common.Producer pushing messages within producer spans, wrapped in envelopes that
+// carry their trace context (see InjectMessage). Wrap the producer of every component pushing
+// messages, e.g. Producer{&common.SigningProducer{...}}, for consumers to continue its traces.
+type Producer struct {
+	common.Producer
+}
+
+// Push pushes a message starting a new trace. Use PushContext to continue the trace of a request
+// or an uplet.
+func (p Producer) Push(topic string, body []byte) error {
+	return Push(context.Background(), p.Producer, topic, body)
+}
+
+// PushContext pushes a message carrying the trace context of ctx
+func (p Producer) PushContext(ctx context.Context, topic string, body []byte) error {
+	return Push(ctx, p.Producer, topic, body)
+}
+
+// ContextPusher is implemented by the producers that carry a context along their messages (see
+// Producer)
+type ContextPusher interface {
+	PushContext(ctx context.Context, topic string, body []byte) error
+}
+
+// PushContext pushes a message carrying the trace context of ctx if the producer supports it (see
+// Producer), as a plain message otherwise
+func PushContext(ctx context.Context, producer common.Producer, topic string, body []byte) error {
+	if pusher, ok := producer.(ContextPusher); ok {
+		return pusher.PushContext(ctx, topic, body)
+	}
+	return producer.Push(topic, body)
+}
+
+// Consumer is a common.Consumer handling messages within consumer spans, children of the trace
+// context their producer wrapped them with (see Producer). Messages that weren't wrapped are handled
+// as is, in new traces.
+type Consumer struct {
+	common.Consumer
+}
+
+// AddHandler adds a handler to the consumer, fed with the original body of the messages of a topic
+func (c Consumer) AddHandler(topic string, handler common.Handler, concurrency int, timeout time.Duration) error {
+	return c.Consumer.AddHandler(topic, WrapHandler(topic, ignoreContext(handler)), concurrency, timeout)
+}
+
+// AddBroadcastHandler adds a broadcast handler to the consumer (see common.Consumer), fed with the
+// original body of the messages of a topic
+func (c Consumer) AddBroadcastHandler(topic string, handler common.Handler, concurrency int, timeout time.Duration) error {
+	return c.Consumer.AddBroadcastHandler(topic, WrapHandler(topic, ignoreContext(handler)), concurrency, timeout)
+}
+
+// AddContextHandler adds a handler receiving the trace context of the messages of a topic, with a
+// deadline of timeout (see common.ContextHandler.WithTimeout), so that the spans of their processing
+// belong to the trace of their producer
+func (c Consumer) AddContextHandler(topic string, handler common.ContextHandler, concurrency int, timeout time.Duration) error {
+	return c.Consumer.AddHandler(topic, WrapHandler(topic, withTimeout(handler, timeout)), concurrency, timeout)
+}
+
+func ignoreContext(handler common.Handler) common.ContextHandler {
+	return func(_ context.Context, message []byte) error {
+		return handler(message)
+	}
+}
+
+func withTimeout(handler common.ContextHandler, timeout time.Duration) common.ContextHandler {
+	if timeout <= 0 {
+		return handler
+	}
+	return func(ctx context.Context, message []byte) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return handler(ctx, message)
+	}
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+// Package tracing wires OpenTelemetry tracing across the Morpheo components: it sets up the OTLP
+// exporter, propagates trace contexts through broker messages, and provides a root span per uplet
+// with child spans for each of its processing phases.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+)
+
+// InstrumentationName identifies the spans created by this package
+const InstrumentationName = "github.com/MorpheoOrg/morpheo-go-packages"
+
+// Uplet processing phases, each one traced in its own child span
+const (
+	PhaseDownload = "download"
+	PhaseTrain    = "train"
+	PhasePredict  = "predict"
+	PhaseUpload   = "upload"
+	PhaseReport   = "report"
+)
+
+// Span attributes
+const (
+	AttrUpletType = attribute.Key("morpheo.uplet.type")
+	AttrUpletKey  = attribute.Key("morpheo.uplet.key")
+	AttrPhase     = attribute.Key("morpheo.phase")
+	AttrTopic     = attribute.Key("messaging.destination")
+)
+
+// Config describes where and how traces are exported
+type Config struct {
+	Enabled     bool
+	ServiceName string
+	Endpoint    string // host:port of the OTLP/HTTP collector
+	Insecure    bool
+	SampleRatio float64
+}
+
+// Setup installs the global tracer provider and propagator described by cfg. The returned function
+// flushes pending spans and must be called before the program exits. If tracing is disabled, spans
+// are still created (and propagated) but never exported.
+func Setup(ctx context.Context, cfg Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("[tracing] Error creating OTLP exporter for %s: %s", cfg.Endpoint, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer used by all Morpheo components
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+// StartUplet starts the root span of the processing of an uplet. If ctx carries a remote span
+// context (see ExtractMessage), the uplet span is its child.
+func StartUplet(ctx context.Context, upletType, upletKey string) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, upletType,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(AttrUpletType.String(upletType), AttrUpletKey.String(upletKey)),
+	)
+}
+
+// StartPhase starts the span of one of the processing phases of an uplet (PhaseDownload,
+// PhaseTrain...), as a child of the span held by ctx
+func StartPhase(ctx context.Context, phase string) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, phase, trace.WithAttributes(AttrPhase.String(phase)))
+}
+
+// End records err (if any) on a span and ends it. It is handy in a defer statement:
+//
+//	ctx, span := tracing.StartPhase(ctx, tracing.PhaseTrain)
+//	defer func() { tracing.End(span, err) }()
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Transport wraps an HTTP transport so that every request gets a client span and carries the
+// trace context in its headers. A nil base means the shared transport (see
+// httpclient.SharedTransport).
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = httpclient.SharedTransport()
+	}
+	return otelhttp.NewTransport(base)
+}
+
+// Middleware wraps an HTTP handler so that every request gets a server span, child of the trace
+// context sent by the client
+func Middleware(handler http.Handler, operation string) http.Handler {
+	return otelhttp.NewHandler(handler, operation)
+}
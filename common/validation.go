@@ -0,0 +1,101 @@
+/*
+ * Copyright Morpheo Org. 2017
+ *
+ * contact@morpheo.co
+ *
+ * This software is part of the Morpheo project, an open-source machine
+ * learning platform.
+ *
+ * This software is governed by the CeCILL license, compatible with the
+ * GNU GPL, under French law and abiding by the rules of distribution of
+ * free software. You can  use, modify and/ or redistribute the software
+ * under the terms of the CeCILL license as circulated by CEA, CNRS and
+ * INRIA at the following URL "http://www.cecill.info".
+ *
+ * As a counterpart to the access to the source code and  rights to copy,
+ * modify and redistribute granted by the license, users are provided only
+ * with a limited warranty  and the software's author,  the holder of the
+ * economic rights,  and the successive licensors  have only  limited
+ * liability.
+ *
+ * In this respect, the user's attention is drawn to the risks associated
+ * with loading,  using,  modifying and/or developing or reproducing the
+ * software by the user in light of its specific status of free software,
+ * that may mean  that it is complicated to manipulate,  and  that  also
+ * therefore means  that it is reserved for developers  and  experienced
+ * professionals having in-depth computer knowledge. Users are therefore
+ * encouraged to load and test the software's suitability as regards their
+ * requirements in conditions enabling the security of their systems and/or
+ * data to be ensured and,  more generally, to use and operate it in the
+ * same conditions as regards security.
+ *
+ * The fact that you are presently reading this means that you have had
+ * knowledge of the CeCILL license and that you accept its terms.
+ */
+
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/MorpheoOrg/morpheo-go-packages/common/errors"
+	"github.com/MorpheoOrg/morpheo-go-packages/common/httpclient"
+)
+
+// Validatable is implemented by structures that can list all their invalid fields at once
+type Validatable interface {
+	Validate() error
+}
+
+// FieldError describes why a field is invalid. It is the type HTTP clients decode the fields of
+// rejected payloads into (see httpclient.StatusError), so that both sides share one definition.
+type FieldError = httpclient.FieldError
+
+// ValidationError lists every invalid field of a structure
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+// Add records an invalid field
+func (e *ValidationError) Add(field, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// OrNil returns e if it holds invalid fields, nil otherwise
+func (e *ValidationError) OrNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Kind returns errors.Validation
+func (e *ValidationError) Kind() errors.Kind {
+	return errors.Validation
+}
+
+// Is makes errors.Is(err, errors.ErrValidation) work on validation errors
+func (e *ValidationError) Is(target error) bool {
+	return errors.MatchKind(e.Kind(), target)
+}
+
+// NewValidationAPIError creates the 400 APIError an HTTP API sends back when a payload is invalid,
+// listing every invalid field so that clients can fix it at once (httpapi.WriteError sends it for
+// any *ValidationError)
+func NewValidationAPIError(err *ValidationError) *APIError {
+	return &APIError{
+		Message: fmt.Sprintf("Invalid payload: %s", err),
+		Status:  http.StatusBadRequest,
+		Fields:  err.Fields,
+	}
+}